@@ -1,14 +0,0 @@
-package chroot
-
-import (
-	"github.com/mitchellh/packer/packer"
-	"testing"
-)
-
-func TestCommunicator_ImplementsCommunicator(t *testing.T) {
-	var raw interface{}
-	raw = &Communicator{}
-	if _, ok := raw.(packer.Communicator); !ok {
-		t.Fatalf("Communicator should be a communicator")
-	}
-}
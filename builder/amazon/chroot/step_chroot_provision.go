@@ -2,6 +2,7 @@ package chroot
 
 import (
 	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/local"
 	"github.com/mitchellh/packer/packer"
 	"log"
 )
@@ -18,9 +19,9 @@ func (s *StepChrootProvision) Run(state multistep.StateBag) multistep.StepAction
 	wrappedCommand := state.Get("wrappedCommand").(CommandWrapper)
 
 	// Create our communicator
-	comm := &Communicator{
+	comm := &local.Communicator{
 		Chroot:     mountPath,
-		CmdWrapper: wrappedCommand,
+		CmdWrapper: local.CommandWrapper(wrappedCommand),
 	}
 
 	// Provision
@@ -19,6 +19,10 @@ type Artifact struct {
 
 	// EC2 connection for performing API stuff.
 	Conn *ec2.EC2
+
+	// StateData is builder specific state about the artifact, such as
+	// the trusted SSH host key.
+	StateData map[string]interface{}
 }
 
 func (a *Artifact) BuilderId() string {
@@ -49,6 +53,10 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("AMIs were created:\n\n%s", strings.Join(amiStrings, "\n"))
 }
 
+func (a *Artifact) State(name string) interface{} {
+	return a.StateData[name]
+}
+
 func (a *Artifact) Destroy() error {
 	errors := make([]error, 0)
 
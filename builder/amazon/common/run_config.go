@@ -3,6 +3,7 @@ package common
 import (
 	"errors"
 	"fmt"
+	"github.com/mitchellh/packer/common"
 	"github.com/mitchellh/packer/packer"
 	"os"
 	"time"
@@ -11,6 +12,8 @@ import (
 // RunConfig contains configuration for running an instance from a source
 // AMI and details on how to access that launched image.
 type RunConfig struct {
+	common.SSHConnectConfig `mapstructure:",squash"`
+
 	AssociatePublicIpAddress bool              `mapstructure:"associate_public_ip_address"`
 	AvailabilityZone         string            `mapstructure:"availability_zone"`
 	IamInstanceProfile       string            `mapstructure:"iam_instance_profile"`
@@ -143,6 +146,8 @@ func (c *RunConfig) Prepare(t *packer.ConfigTemplate) []error {
 
 	c.RunTags = newTags
 
+	errs = append(errs, c.SSHConnectConfig.Prepare(t)...)
+
 	c.sshTimeout, err = time.ParseDuration(c.RawSSHTimeout)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("Failed parsing ssh_timeout: %s", err))
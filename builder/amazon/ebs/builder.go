@@ -39,11 +39,10 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, error) {
 		return nil, err
 	}
 
-	b.config.tpl, err = packer.NewConfigTemplate()
+	b.config.tpl, err = b.config.NewConfigTemplate()
 	if err != nil {
 		return nil, err
 	}
-	b.config.tpl.UserVars = b.config.PackerUserVars
 	b.config.tpl.Funcs(awscommon.TemplateFuncs)
 
 	// Accumulate any errors
@@ -107,9 +106,13 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 			Tags:                     b.config.RunTags,
 		},
 		&common.StepConnectSSH{
-			SSHAddress:     awscommon.SSHAddress(ec2conn, b.config.SSHPort),
-			SSHConfig:      awscommon.SSHConfig(b.config.SSHUsername),
-			SSHWaitTimeout: b.config.SSHTimeout(),
+			SSHAddress:        awscommon.SSHAddress(ec2conn, b.config.SSHPort),
+			SSHConfig:         awscommon.SSHConfig(b.config.SSHUsername),
+			SSHWaitTimeout:    b.config.SSHTimeout(),
+			UseSftp:           b.config.UseSftp(),
+			SSHBastionAddress: b.config.SSHBastionAddress(),
+			SSHBastionConfig:  common.SSHBastionConfigFunc(&b.config.SSHConnectConfig),
+			HostKeyChecker:    b.config.HostKeyChecker(),
 		},
 		&common.StepProvision{},
 		&stepStopInstance{},
@@ -128,14 +131,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	}
 
 	// Run!
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
-	}
+	b.runner = common.NewRunner(steps, b.config.PackerConfig, ui)
 
 	b.runner.Run(state)
 
@@ -154,6 +150,9 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		Amis:           state.Get("amis").(map[string]string),
 		BuilderIdValue: BuilderId,
 		Conn:           ec2conn,
+		StateData: map[string]interface{}{
+			"ssh_host_key": state.Get("ssh_host_key"),
+		},
 	}
 
 	return artifact, nil
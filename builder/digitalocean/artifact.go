@@ -20,6 +20,10 @@ type Artifact struct {
 
 	// The client for making API calls
 	client *DigitalOceanClient
+
+	// StateData is builder specific state about the artifact, such as
+	// the trusted SSH host key.
+	StateData map[string]interface{}
 }
 
 func (*Artifact) BuilderId() string {
@@ -40,6 +44,10 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("A snapshot was created: '%v' in region '%v'", a.snapshotName, a.regionName)
 }
 
+func (a *Artifact) State(name string) interface{} {
+	return a.StateData[name]
+}
+
 func (a *Artifact) Destroy() error {
 	log.Printf("Destroying image: %d (%s)", a.snapshotId, a.snapshotName)
 	return a.client.DestroyImage(a.snapshotId)
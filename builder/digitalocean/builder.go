@@ -22,7 +22,8 @@ const BuilderId = "pearkes.digitalocean"
 // to use while communicating with DO and describes the image
 // you are creating
 type config struct {
-	common.PackerConfig `mapstructure:",squash"`
+	common.PackerConfig     `mapstructure:",squash"`
+	common.SSHConnectConfig `mapstructure:",squash"`
 
 	ClientID string `mapstructure:"client_id"`
 	APIKey   string `mapstructure:"api_key"`
@@ -58,11 +59,10 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, error) {
 		return nil, err
 	}
 
-	b.config.tpl, err = packer.NewConfigTemplate()
+	b.config.tpl, err = b.config.NewConfigTemplate()
 	if err != nil {
 		return nil, err
 	}
-	b.config.tpl.UserVars = b.config.PackerUserVars
 
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
@@ -169,6 +169,8 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, error) {
 	}
 	b.config.stateTimeout = stateTimeout
 
+	errs = packer.MultiErrorAppend(errs, b.config.SSHConnectConfig.Prepare(b.config.tpl)...)
+
 	if errs != nil && len(errs.Errors) > 0 {
 		return nil, errs
 	}
@@ -194,9 +196,13 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		new(stepCreateDroplet),
 		new(stepDropletInfo),
 		&common.StepConnectSSH{
-			SSHAddress:     sshAddress,
-			SSHConfig:      sshConfig,
-			SSHWaitTimeout: 5 * time.Minute,
+			SSHAddress:        sshAddress,
+			SSHConfig:         sshConfig,
+			SSHWaitTimeout:    5 * time.Minute,
+			UseSftp:           b.config.UseSftp(),
+			SSHBastionAddress: b.config.SSHBastionAddress(),
+			SSHBastionConfig:  common.SSHBastionConfigFunc(&b.config.SSHConnectConfig),
+			HostKeyChecker:    b.config.HostKeyChecker(),
 		},
 		new(common.StepProvision),
 		new(stepShutdown),
@@ -205,14 +211,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	}
 
 	// Run the steps
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
-	}
+	b.runner = common.NewRunner(steps, b.config.PackerConfig, ui)
 
 	b.runner.Run(state)
 
@@ -239,6 +238,9 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		regionId:     region_id,
 		regionName:   regionName,
 		client:       client,
+		StateData: map[string]interface{}{
+			"ssh_host_key": state.Get("ssh_host_key"),
+		},
 	}
 
 	return artifact, nil
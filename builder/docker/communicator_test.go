@@ -1,10 +1,240 @@
 package docker
 
 import (
+	"bytes"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
+func testCommunicator(t *testing.T) (*Communicator, *MockDriver) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	driver := new(MockDriver)
+	comm := &Communicator{
+		ContainerId:  "foo",
+		HostDir:      td,
+		ContainerDir: "/packer-files",
+		Driver:       driver,
+	}
+
+	return comm, driver
+}
+
 func TestCommunicator_impl(t *testing.T) {
 	var _ packer.Communicator = new(Communicator)
 }
+
+func TestCommunicatorStart(t *testing.T) {
+	comm, driver := testCommunicator(t)
+	defer os.RemoveAll(comm.HostDir)
+	driver.ExecStdout = "hello\n"
+	driver.ExecExitStatus = 42
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "echo hello",
+		Stdout:  &stdout,
+	}
+	if err := comm.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd.Wait()
+	if cmd.ExitStatus != 42 {
+		t.Fatalf("bad: %d", cmd.ExitStatus)
+	}
+
+	if driver.ExecId != "foo" {
+		t.Fatalf("bad: %s", driver.ExecId)
+	}
+
+	if len(driver.ExecCommands) != 1 || driver.ExecCommands[0] != "echo hello" {
+		t.Fatalf("bad: %#v", driver.ExecCommands)
+	}
+
+	if stdout.String() != "hello\n" {
+		t.Fatalf("bad: %s", stdout.String())
+	}
+}
+
+func TestCommunicatorStart_concurrent(t *testing.T) {
+	comm, driver := testCommunicator(t)
+	defer os.RemoveAll(comm.HostDir)
+
+	cmds := make([]*packer.RemoteCmd, 5)
+	for i := range cmds {
+		cmds[i] = &packer.RemoteCmd{Command: "true"}
+		if err := comm.Start(cmds[i]); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	for _, cmd := range cmds {
+		cmd.Wait()
+	}
+
+	if len(driver.ExecCommands) != len(cmds) {
+		t.Fatalf("bad: %#v", driver.ExecCommands)
+	}
+}
+
+func TestCommunicatorStart_execError(t *testing.T) {
+	comm, driver := testCommunicator(t)
+	defer os.RemoveAll(comm.HostDir)
+	driver.ExecErr = os.ErrNotExist
+
+	cmd := &packer.RemoteCmd{Command: "true"}
+	if err := comm.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd.Wait()
+	if cmd.ExitStatus != 254 {
+		t.Fatalf("bad: %d", cmd.ExitStatus)
+	}
+}
+
+func TestCommunicatorUpload(t *testing.T) {
+	comm, driver := testCommunicator(t)
+	defer os.RemoveAll(comm.HostDir)
+
+	if err := comm.Upload("/tmp/foo", strings.NewReader("bar")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(driver.ExecCommands) != 1 {
+		t.Fatalf("bad: %#v", driver.ExecCommands)
+	}
+
+	command := driver.ExecCommands[0]
+	if !strings.HasPrefix(command, "cp '/packer-files/upload") ||
+		!strings.HasSuffix(command, " '/tmp/foo'") {
+		t.Fatalf("bad: %s", command)
+	}
+
+	driver.ExecExitStatus = 1
+	if err := comm.Upload("/tmp/foo", strings.NewReader("bar")); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestCommunicatorUploadDir(t *testing.T) {
+	comm, driver := testCommunicator(t)
+	defer os.RemoveAll(comm.HostDir)
+
+	src, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(src)
+	ioutil.WriteFile(filepath.Join(src, "run.sh"), []byte("foo"), 0755)
+
+	if err := comm.UploadDir("/my dst", src, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(driver.ExecCommands) != 1 {
+		t.Fatalf("bad: %#v", driver.ExecCommands)
+	}
+
+	command := driver.ExecCommands[0]
+	dst := "'/my dst/" + filepath.Base(src) + "'"
+	if !strings.Contains(command, "mkdir -p "+dst+";") ||
+		!strings.Contains(command, "tar -C "+dst+" -xopf -") ||
+		!strings.Contains(command, "cd '/packer-files/dirupload") {
+		t.Fatalf("bad: %s", command)
+	}
+
+	// The staging directory is removed after the upload
+	entries, err := ioutil.ReadDir(comm.HostDir)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("bad: %#v", entries)
+	}
+}
+
+func TestCommunicatorDownload(t *testing.T) {
+	comm, driver := testCommunicator(t)
+	defer os.RemoveAll(comm.HostDir)
+	driver.CopyFiles = map[string]string{"foo.txt": "hello"}
+
+	var buf bytes.Buffer
+	if err := comm.Download("/etc/foo.txt", &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if driver.CopyId != "foo" || driver.CopySrc != "/etc/foo.txt" {
+		t.Fatalf("bad: %s %s", driver.CopyId, driver.CopySrc)
+	}
+
+	if buf.String() != "hello" {
+		t.Fatalf("bad: %s", buf.String())
+	}
+
+	// The temporary directory should be cleaned up
+	if _, err := os.Stat(driver.CopyDst); !os.IsNotExist(err) {
+		t.Fatalf("bad: %s", err)
+	}
+
+	driver.CopyErr = os.ErrNotExist
+	if err := comm.Download("/etc/foo.txt", &buf); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestCommunicatorDownloadDir(t *testing.T) {
+	cases := []struct {
+		Src      string
+		Expected []string
+	}{
+		{"/etc/app", []string{"app", "app/a.conf", "app/sub", "app/sub/b.conf"}},
+		{"/etc/app/", []string{"a.conf", "sub", "sub/b.conf"}},
+	}
+
+	for _, tc := range cases {
+		comm, driver := testCommunicator(t)
+		defer os.RemoveAll(comm.HostDir)
+		driver.CopyFiles = map[string]string{
+			"app/a.conf":     "a",
+			"app/skip.tmp":   "skip",
+			"app/sub/b.conf": "b",
+		}
+
+		dst, err := ioutil.TempDir("", "packer")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		defer os.RemoveAll(dst)
+
+		if err := comm.DownloadDir(tc.Src, dst, []string{"*.tmp"}); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if driver.CopySrc != "/etc/app" {
+			t.Fatalf("bad: %s", driver.CopySrc)
+		}
+
+		actual := make([]string, 0)
+		filepath.Walk(dst, func(path string, info os.FileInfo, err error) error {
+			if path != dst {
+				rel, _ := filepath.Rel(dst, path)
+				actual = append(actual, filepath.ToSlash(rel))
+			}
+
+			return nil
+		})
+
+		if strings.Join(actual, ",") != strings.Join(tc.Expected, ",") {
+			t.Fatalf("%s: bad: %#v", tc.Src, actual)
+		}
+	}
+}
@@ -8,9 +8,19 @@ import (
 // Docker. The Driver interface also allows the steps to be tested since
 // a mock driver can be shimmed in.
 type Driver interface {
+	// Copy copies the file or directory at src within the container with
+	// the given ID into the directory dst on the host.
+	Copy(id string, src string, dst string) error
+
 	// Delete an image that is imported into Docker
 	DeleteImage(id string) error
 
+	// Exec executes a shell command within the running container with the
+	// given ID, and blocks until it completes. The exit status of the
+	// command is returned. An error is only returned if the command
+	// couldn't be executed at all.
+	Exec(id string, command string, stdin io.Reader, stdout, stderr io.Writer) (int, error)
+
 	// Export exports the container with the given ID to the given writer.
 	Export(id string, dst io.Writer) error
 
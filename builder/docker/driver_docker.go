@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/exec"
 	"strings"
+	"syscall"
 )
 
 type DockerDriver struct {
@@ -16,6 +17,21 @@ type DockerDriver struct {
 	Tpl *packer.ConfigTemplate
 }
 
+func (d *DockerDriver) Copy(id string, src string, dst string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("docker", "cp", fmt.Sprintf("%s:%s", id, src), dst)
+	cmd.Stderr = &stderr
+
+	log.Printf("Copying from container %s: %s to %s", id, src, dst)
+	if err := cmd.Run(); err != nil {
+		err = fmt.Errorf("Error copying from container: %s\nStderr: %s",
+			err, stderr.String())
+		return err
+	}
+
+	return nil
+}
+
 func (d *DockerDriver) DeleteImage(id string) error {
 	var stderr bytes.Buffer
 	cmd := exec.Command("docker", "rmi", id)
@@ -35,6 +51,36 @@ func (d *DockerDriver) DeleteImage(id string) error {
 	return nil
 }
 
+func (d *DockerDriver) Exec(id string, command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	cmd := exec.Command("docker", "exec", "-i", id, "/bin/sh", "-c", command)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	log.Printf("Executing in container %s: %#v", id, command)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	exitStatus := 0
+	if err := cmd.Wait(); err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return 0, err
+		}
+
+		exitStatus = 1
+
+		// There is no process-independent way to get the REAL
+		// exit status so we just try to go deeper.
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			exitStatus = status.ExitStatus()
+		}
+	}
+
+	return exitStatus, nil
+}
+
 func (d *DockerDriver) Export(id string, dst io.Writer) error {
 	var stderr bytes.Buffer
 	cmd := exec.Command("docker", "export", id)
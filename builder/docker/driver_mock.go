@@ -2,14 +2,35 @@ package docker
 
 import (
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 )
 
 // MockDriver is a driver implementation that can be used for tests.
 type MockDriver struct {
+	CopyCalled bool
+	CopyId     string
+	CopySrc    string
+	CopyDst    string
+	CopyErr    error
+
+	// CopyFiles are the files that Copy creates, relative to the
+	// destination directory, mapped to their contents.
+	CopyFiles map[string]string
+
 	DeleteImageCalled bool
 	DeleteImageId     string
 	DeleteImageErr    error
 
+	ExecCalled     bool
+	ExecId         string
+	ExecCommands   []string
+	ExecStdout     string
+	ExecExitStatus int
+	ExecErr        error
+
 	ImportCalled bool
 	ImportPath   string
 	ImportRepo   string
@@ -37,6 +58,28 @@ type MockDriver struct {
 	StopCalled   bool
 	StopID       string
 	VerifyCalled bool
+
+	lock sync.Mutex
+}
+
+func (d *MockDriver) Copy(id string, src string, dst string) error {
+	d.CopyCalled = true
+	d.CopyId = id
+	d.CopySrc = src
+	d.CopyDst = dst
+
+	for path, contents := range d.CopyFiles {
+		path = filepath.Join(dst, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			return err
+		}
+	}
+
+	return d.CopyErr
 }
 
 func (d *MockDriver) DeleteImage(id string) error {
@@ -45,6 +88,24 @@ func (d *MockDriver) DeleteImage(id string) error {
 	return d.DeleteImageErr
 }
 
+func (d *MockDriver) Exec(id string, command string, stdin io.Reader, stdout, stderr io.Writer) (int, error) {
+	// Commands may be executed concurrently
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.ExecCalled = true
+	d.ExecId = id
+	d.ExecCommands = append(d.ExecCommands, command)
+
+	if stdout != nil {
+		if _, err := stdout.Write([]byte(d.ExecStdout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return d.ExecExitStatus, d.ExecErr
+}
+
 func (d *MockDriver) Export(id string, dst io.Writer) error {
 	d.ExportCalled = true
 	d.ExportID = id
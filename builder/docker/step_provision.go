@@ -9,14 +9,16 @@ type StepProvision struct{}
 
 func (s *StepProvision) Run(state multistep.StateBag) multistep.StepAction {
 	containerId := state.Get("container_id").(string)
+	driver := state.Get("driver").(Driver)
 	tempDir := state.Get("temp_dir").(string)
 
-	// Create the communicator that talks to Docker via various
-	// os/exec tricks.
+	// Create the communicator that talks to Docker via "docker exec"
+	// and "docker cp".
 	comm := &Communicator{
 		ContainerId:  containerId,
 		HostDir:      tempDir,
 		ContainerDir: "/packer-files",
+		Driver:       driver,
 	}
 
 	prov := common.StepProvision{Comm: comm}
@@ -9,6 +9,10 @@ import (
 type Artifact struct {
 	imageName string
 	driver    Driver
+
+	// StateData is builder specific state about the artifact, such as
+	// the trusted SSH host key.
+	StateData map[string]interface{}
 }
 
 // BuilderId returns the builder Id.
@@ -16,6 +20,11 @@ func (*Artifact) BuilderId() string {
 	return BuilderId
 }
 
+// State returns builder specific state about the artifact.
+func (a *Artifact) State(name string) interface{} {
+	return a.StateData[name]
+}
+
 // Destroy destroys the GCE image represented by the artifact.
 func (a *Artifact) Destroy() error {
 	log.Printf("Destroying image: %s", a.imageName)
@@ -53,9 +53,13 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		new(StepCreateInstance),
 		new(StepInstanceInfo),
 		&common.StepConnectSSH{
-			SSHAddress:     sshAddress,
-			SSHConfig:      sshConfig,
-			SSHWaitTimeout: 5 * time.Minute,
+			SSHAddress:        sshAddress,
+			SSHConfig:         sshConfig,
+			SSHWaitTimeout:    5 * time.Minute,
+			UseSftp:           b.config.UseSftp(),
+			SSHBastionAddress: b.config.SSHBastionAddress(),
+			SSHBastionConfig:  common.SSHBastionConfigFunc(&b.config.SSHConnectConfig),
+			HostKeyChecker:    b.config.HostKeyChecker(),
 		},
 		new(common.StepProvision),
 		new(StepUpdateGsutil),
@@ -65,14 +69,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	}
 
 	// Run the steps.
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
-	}
+	b.runner = common.NewRunner(steps, b.config.PackerConfig, ui)
 	b.runner.Run(state)
 
 	// Report any errors.
@@ -87,6 +84,9 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	artifact := &Artifact{
 		imageName: state.Get("image_name").(string),
 		driver:    driver,
+		StateData: map[string]interface{}{
+			"ssh_host_key": state.Get("ssh_host_key"),
+		},
 	}
 	return artifact, nil
 }
@@ -13,7 +13,8 @@ import (
 // both the publicly settable state as well as the privately generated
 // state of the config object.
 type Config struct {
-	common.PackerConfig `mapstructure:",squash"`
+	common.PackerConfig     `mapstructure:",squash"`
+	common.SSHConnectConfig `mapstructure:",squash"`
 
 	BucketName        string            `mapstructure:"bucket_name"`
 	ClientSecretsFile string            `mapstructure:"client_secrets_file"`
@@ -48,11 +49,10 @@ func NewConfig(raws ...interface{}) (*Config, []string, error) {
 		return nil, nil, err
 	}
 
-	c.tpl, err = packer.NewConfigTemplate()
+	c.tpl, err = c.NewConfigTemplate()
 	if err != nil {
 		return nil, nil, err
 	}
-	c.tpl.UserVars = c.PackerUserVars
 
 	// Prepare the errors
 	errs := common.CheckUnusedConfig(md)
@@ -182,6 +182,8 @@ func NewConfig(raws ...interface{}) (*Config, []string, error) {
 		}
 	}
 
+	errs = packer.MultiErrorAppend(errs, c.SSHConnectConfig.Prepare(c.tpl)...)
+
 	// Check for any errors.
 	if errs != nil && len(errs.Errors) > 0 {
 		return nil, nil, errs
@@ -16,6 +16,10 @@ type Artifact struct {
 
 	// OpenStack connection for performing API stuff.
 	Conn gophercloud.CloudServersProvider
+
+	// StateData is builder specific state about the artifact, such as
+	// the trusted SSH host key.
+	StateData map[string]interface{}
 }
 
 func (a *Artifact) BuilderId() string {
@@ -35,6 +39,10 @@ func (a *Artifact) String() string {
 	return fmt.Sprintf("An image was created: %v", a.ImageId)
 }
 
+func (a *Artifact) State(name string) interface{} {
+	return a.StateData[name]
+}
+
 func (a *Artifact) Destroy() error {
 	log.Printf("Destroying image: %d", a.ImageId)
 	return a.Conn.DeleteImageById(a.ImageId)
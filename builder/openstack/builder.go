@@ -35,11 +35,10 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, error) {
 		return nil, err
 	}
 
-	b.config.tpl, err = packer.NewConfigTemplate()
+	b.config.tpl, err = b.config.NewConfigTemplate()
 	if err != nil {
 		return nil, err
 	}
-	b.config.tpl.UserVars = b.config.PackerUserVars
 
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
@@ -91,23 +90,20 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 			SourceImage: b.config.SourceImage,
 		},
 		&common.StepConnectSSH{
-			SSHAddress:     SSHAddress(csp, b.config.SSHPort),
-			SSHConfig:      SSHConfig(b.config.SSHUsername),
-			SSHWaitTimeout: b.config.SSHTimeout(),
+			SSHAddress:        SSHAddress(csp, b.config.SSHPort),
+			SSHConfig:         SSHConfig(b.config.SSHUsername),
+			SSHWaitTimeout:    b.config.SSHTimeout(),
+			UseSftp:           b.config.UseSftp(),
+			SSHBastionAddress: b.config.SSHBastionAddress(),
+			SSHBastionConfig:  common.SSHBastionConfigFunc(&b.config.SSHConnectConfig),
+			HostKeyChecker:    b.config.HostKeyChecker(),
 		},
 		&common.StepProvision{},
 		&stepCreateImage{},
 	}
 
 	// Run!
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
-	}
+	b.runner = common.NewRunner(steps, b.config.PackerConfig, ui)
 
 	b.runner.Run(state)
 
@@ -126,6 +122,9 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 		ImageId:        state.Get("image").(string),
 		BuilderIdValue: BuilderId,
 		Conn:           csp,
+		StateData: map[string]interface{}{
+			"ssh_host_key": state.Get("ssh_host_key"),
+		},
 	}
 
 	return artifact, nil
@@ -3,6 +3,7 @@ package openstack
 import (
 	"errors"
 	"fmt"
+	"github.com/mitchellh/packer/common"
 	"github.com/mitchellh/packer/packer"
 	"time"
 )
@@ -10,6 +11,8 @@ import (
 // RunConfig contains configuration for running an instance from a source
 // image and details on how to access that launched image.
 type RunConfig struct {
+	common.SSHConnectConfig `mapstructure:",squash"`
+
 	SourceImage   string `mapstructure:"source_image"`
 	Flavor        string `mapstructure:"flavor"`
 	RawSSHTimeout string `mapstructure:"ssh_timeout"`
@@ -73,6 +76,8 @@ func (c *RunConfig) Prepare(t *packer.ConfigTemplate) []error {
 		}
 	}
 
+	errs = append(errs, c.SSHConnectConfig.Prepare(t)...)
+
 	c.sshTimeout, err = time.ParseDuration(c.RawSSHTimeout)
 	if err != nil {
 		errs = append(errs, fmt.Errorf("Failed parsing ssh_timeout: %s", err))
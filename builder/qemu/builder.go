@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/mitchellh/multistep"
 	"github.com/mitchellh/packer/common"
+	"github.com/mitchellh/packer/communicator/ssh"
 	"github.com/mitchellh/packer/packer"
 	"log"
 	"os"
@@ -52,35 +53,38 @@ type Builder struct {
 }
 
 type config struct {
-	common.PackerConfig `mapstructure:",squash"`
-
-	Accelerator     string     `mapstructure:"accelerator"`
-	BootCommand     []string   `mapstructure:"boot_command"`
-	DiskInterface   string     `mapstructure:"disk_interface"`
-	DiskSize        uint       `mapstructure:"disk_size"`
-	FloppyFiles     []string   `mapstructure:"floppy_files"`
-	Format          string     `mapstructure:"format"`
-	Headless        bool       `mapstructure:"headless"`
-	HTTPDir         string     `mapstructure:"http_directory"`
-	HTTPPortMin     uint       `mapstructure:"http_port_min"`
-	HTTPPortMax     uint       `mapstructure:"http_port_max"`
-	ISOChecksum     string     `mapstructure:"iso_checksum"`
-	ISOChecksumType string     `mapstructure:"iso_checksum_type"`
-	ISOUrls         []string   `mapstructure:"iso_urls"`
-	NetDevice       string     `mapstructure:"net_device"`
-	OutputDir       string     `mapstructure:"output_directory"`
-	QemuArgs        [][]string `mapstructure:"qemuargs"`
-	ShutdownCommand string     `mapstructure:"shutdown_command"`
-	SSHHostPortMin  uint       `mapstructure:"ssh_host_port_min"`
-	SSHHostPortMax  uint       `mapstructure:"ssh_host_port_max"`
-	SSHPassword     string     `mapstructure:"ssh_password"`
-	SSHPort         uint       `mapstructure:"ssh_port"`
-	SSHUser         string     `mapstructure:"ssh_username"`
-	SSHKeyPath      string     `mapstructure:"ssh_key_path"`
-	VNCPortMin      uint       `mapstructure:"vnc_port_min"`
-	VNCPortMax      uint       `mapstructure:"vnc_port_max"`
-	VMName          string     `mapstructure:"vm_name"`
-	RunOnce         bool       `mapstructure:"run_once"`
+	common.PackerConfig     `mapstructure:",squash"`
+	common.SSHConnectConfig `mapstructure:",squash"`
+
+	Accelerator      string     `mapstructure:"accelerator"`
+	BootCommand      []string   `mapstructure:"boot_command"`
+	DiskInterface    string     `mapstructure:"disk_interface"`
+	DiskSize         uint       `mapstructure:"disk_size"`
+	FloppyFiles      []string   `mapstructure:"floppy_files"`
+	Format           string     `mapstructure:"format"`
+	Headless         bool       `mapstructure:"headless"`
+	HTTPDir          string     `mapstructure:"http_directory"`
+	HTTPPortMin      uint       `mapstructure:"http_port_min"`
+	HTTPPortMax      uint       `mapstructure:"http_port_max"`
+	ISOChecksum      string     `mapstructure:"iso_checksum"`
+	ISOChecksumType  string     `mapstructure:"iso_checksum_type"`
+	ISOUrls          []string   `mapstructure:"iso_urls"`
+	NetDevice        string     `mapstructure:"net_device"`
+	OutputDir        string     `mapstructure:"output_directory"`
+	QemuArgs         [][]string `mapstructure:"qemuargs"`
+	ShutdownCommand  string     `mapstructure:"shutdown_command"`
+	SSHHostPortMin   uint       `mapstructure:"ssh_host_port_min"`
+	SSHHostPortMax   uint       `mapstructure:"ssh_host_port_max"`
+	SSHPassword      string     `mapstructure:"ssh_password"`
+	SSHPort          uint       `mapstructure:"ssh_port"`
+	SSHUser          string     `mapstructure:"ssh_username"`
+	SSHKeyPath       string     `mapstructure:"ssh_key_path"`
+	SSHKeyPassphrase string     `mapstructure:"ssh_private_key_passphrase"`
+	SSHAgentAuth     bool       `mapstructure:"ssh_agent_auth"`
+	VNCPortMin       uint       `mapstructure:"vnc_port_min"`
+	VNCPortMax       uint       `mapstructure:"vnc_port_max"`
+	VMName           string     `mapstructure:"vm_name"`
+	RunOnce          bool       `mapstructure:"run_once"`
 
 	RawBootWait        string `mapstructure:"boot_wait"`
 	RawSingleISOUrl    string `mapstructure:"iso_url"`
@@ -99,11 +103,10 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, error) {
 		return nil, err
 	}
 
-	b.config.tpl, err = packer.NewConfigTemplate()
+	b.config.tpl, err = b.config.NewConfigTemplate()
 	if err != nil {
 		return nil, err
 	}
-	b.config.tpl.UserVars = b.config.PackerUserVars
 
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
@@ -183,22 +186,23 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, error) {
 
 	// Errors
 	templates := map[string]*string{
-		"http_directory":    &b.config.HTTPDir,
-		"iso_checksum":      &b.config.ISOChecksum,
-		"iso_checksum_type": &b.config.ISOChecksumType,
-		"iso_url":           &b.config.RawSingleISOUrl,
-		"output_directory":  &b.config.OutputDir,
-		"shutdown_command":  &b.config.ShutdownCommand,
-		"ssh_password":      &b.config.SSHPassword,
-		"ssh_username":      &b.config.SSHUser,
-		"vm_name":           &b.config.VMName,
-		"format":            &b.config.Format,
-		"boot_wait":         &b.config.RawBootWait,
-		"shutdown_timeout":  &b.config.RawShutdownTimeout,
-		"ssh_wait_timeout":  &b.config.RawSSHWaitTimeout,
-		"accelerator":       &b.config.Accelerator,
-		"net_device":        &b.config.NetDevice,
-		"disk_interface":    &b.config.DiskInterface,
+		"http_directory":             &b.config.HTTPDir,
+		"iso_checksum":               &b.config.ISOChecksum,
+		"iso_checksum_type":          &b.config.ISOChecksumType,
+		"iso_url":                    &b.config.RawSingleISOUrl,
+		"output_directory":           &b.config.OutputDir,
+		"shutdown_command":           &b.config.ShutdownCommand,
+		"ssh_password":               &b.config.SSHPassword,
+		"ssh_private_key_passphrase": &b.config.SSHKeyPassphrase,
+		"ssh_username":               &b.config.SSHUser,
+		"vm_name":                    &b.config.VMName,
+		"format":                     &b.config.Format,
+		"boot_wait":                  &b.config.RawBootWait,
+		"shutdown_timeout":           &b.config.RawShutdownTimeout,
+		"ssh_wait_timeout":           &b.config.RawSSHWaitTimeout,
+		"accelerator":                &b.config.Accelerator,
+		"net_device":                 &b.config.NetDevice,
+		"disk_interface":             &b.config.DiskInterface,
 	}
 
 	for n, ptr := range templates {
@@ -330,12 +334,19 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, error) {
 		if _, err := os.Stat(b.config.SSHKeyPath); err != nil {
 			errs = packer.MultiErrorAppend(
 				errs, fmt.Errorf("ssh_key_path is invalid: %s", err))
-		} else if _, err := sshKeyToKeyring(b.config.SSHKeyPath); err != nil {
+		} else if _, err := sshKeyToKeyring(b.config.SSHKeyPath, b.config.SSHKeyPassphrase); err != nil {
 			errs = packer.MultiErrorAppend(
 				errs, fmt.Errorf("ssh_key_path is invalid: %s", err))
 		}
 	}
 
+	if b.config.SSHAgentAuth {
+		if _, err := ssh.AgentAuth(); err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("ssh_agent_auth can't be used: %s", err))
+		}
+	}
+
 	if b.config.SSHHostPortMin > b.config.SSHHostPortMax {
 		errs = packer.MultiErrorAppend(
 			errs, errors.New("ssh_host_port_min must be less than ssh_host_port_max"))
@@ -361,6 +372,8 @@ func (b *Builder) Prepare(raws ...interface{}) ([]string, error) {
 		b.config.QemuArgs = make([][]string, 0)
 	}
 
+	errs = packer.MultiErrorAppend(errs, b.config.SSHConnectConfig.Prepare(b.config.tpl)...)
+
 	if errs != nil && len(errs.Errors) > 0 {
 		return nil, errs
 	}
@@ -413,9 +426,13 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 
 	steps = append(steps,
 		&common.StepConnectSSH{
-			SSHAddress:     sshAddress,
-			SSHConfig:      sshConfig,
-			SSHWaitTimeout: b.config.sshWaitTimeout,
+			SSHAddress:        sshAddress,
+			SSHConfig:         sshConfig,
+			SSHWaitTimeout:    b.config.sshWaitTimeout,
+			UseSftp:           b.config.UseSftp(),
+			SSHBastionAddress: b.config.SSHBastionAddress(),
+			SSHBastionConfig:  common.SSHBastionConfigFunc(&b.config.SSHConnectConfig),
+			HostKeyChecker:    b.config.HostKeyChecker(),
 		},
 		new(common.StepProvision),
 		new(stepShutdown),
@@ -430,14 +447,7 @@ func (b *Builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packe
 	state.Put("ui", ui)
 
 	// Run
-	if b.config.PackerDebug {
-		b.runner = &multistep.DebugRunner{
-			Steps:   steps,
-			PauseFn: common.MultistepDebugFn(ui),
-		}
-	} else {
-		b.runner = &multistep.BasicRunner{Steps: steps}
-	}
+	b.runner = common.NewRunner(steps, b.config.PackerConfig, ui)
 
 	b.runner.Run(state)
 
@@ -23,7 +23,7 @@ func SSHConfigFunc(config SSHConfig) func(multistep.StateBag) (*gossh.ClientConf
 		}
 
 		if config.SSHKeyPath != "" {
-			keyring, err := sshKeyToKeyring(config.SSHKeyPath)
+			keyring, err := sshKeyToKeyring(config.SSHKeyPath, config.SSHKeyPassphrase)
 			if err != nil {
 				return nil, err
 			}
@@ -31,6 +31,15 @@ func SSHConfigFunc(config SSHConfig) func(multistep.StateBag) (*gossh.ClientConf
 			auth = append(auth, gossh.ClientAuthKeyring(keyring))
 		}
 
+		if config.SSHAgentAuth {
+			agentAuth, err := ssh.AgentAuth()
+			if err != nil {
+				return nil, err
+			}
+
+			auth = append(auth, agentAuth)
+		}
+
 		return &gossh.ClientConfig{
 			User: config.SSHUser,
 			Auth: auth,
@@ -38,7 +47,7 @@ func SSHConfigFunc(config SSHConfig) func(multistep.StateBag) (*gossh.ClientConf
 	}
 }
 
-func sshKeyToKeyring(path string) (gossh.ClientKeyring, error) {
+func sshKeyToKeyring(path string, passphrase string) (gossh.ClientKeyring, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -51,7 +60,7 @@ func sshKeyToKeyring(path string) (gossh.ClientKeyring, error) {
 	}
 
 	keyring := new(ssh.SimpleKeychain)
-	if err := keyring.AddPEMKey(string(keyBytes)); err != nil {
+	if err := keyring.AddPEMKeyPassword(string(keyBytes), passphrase); err != nil {
 		return nil, err
 	}
 
@@ -3,15 +3,21 @@ package common
 import (
 	"errors"
 	"fmt"
+	"github.com/mitchellh/packer/common"
+	"github.com/mitchellh/packer/communicator/ssh"
 	"github.com/mitchellh/packer/packer"
 	"os"
 	"time"
 )
 
 type SSHConfig struct {
+	common.SSHConnectConfig `mapstructure:",squash"`
+
 	SSHHostPortMin    uint   `mapstructure:"ssh_host_port_min"`
 	SSHHostPortMax    uint   `mapstructure:"ssh_host_port_max"`
+	SSHAgentAuth      bool   `mapstructure:"ssh_agent_auth"`
 	SSHKeyPath        string `mapstructure:"ssh_key_path"`
+	SSHKeyPassphrase  string `mapstructure:"ssh_private_key_passphrase"`
 	SSHPassword       string `mapstructure:"ssh_password"`
 	SSHPort           uint   `mapstructure:"ssh_port"`
 	SSHUser           string `mapstructure:"ssh_username"`
@@ -38,10 +44,11 @@ func (c *SSHConfig) Prepare(t *packer.ConfigTemplate) []error {
 	}
 
 	templates := map[string]*string{
-		"ssh_key_path":     &c.SSHKeyPath,
-		"ssh_password":     &c.SSHPassword,
-		"ssh_username":     &c.SSHUser,
-		"ssh_wait_timeout": &c.RawSSHWaitTimeout,
+		"ssh_key_path":               &c.SSHKeyPath,
+		"ssh_password":               &c.SSHPassword,
+		"ssh_private_key_passphrase": &c.SSHKeyPassphrase,
+		"ssh_username":               &c.SSHUser,
+		"ssh_wait_timeout":           &c.RawSSHWaitTimeout,
 	}
 
 	errs := make([]error, 0)
@@ -56,11 +63,17 @@ func (c *SSHConfig) Prepare(t *packer.ConfigTemplate) []error {
 	if c.SSHKeyPath != "" {
 		if _, err := os.Stat(c.SSHKeyPath); err != nil {
 			errs = append(errs, fmt.Errorf("ssh_key_path is invalid: %s", err))
-		} else if _, err := sshKeyToKeyring(c.SSHKeyPath); err != nil {
+		} else if _, err := sshKeyToKeyring(c.SSHKeyPath, c.SSHKeyPassphrase); err != nil {
 			errs = append(errs, fmt.Errorf("ssh_key_path is invalid: %s", err))
 		}
 	}
 
+	if c.SSHAgentAuth {
+		if _, err := ssh.AgentAuth(); err != nil {
+			errs = append(errs, fmt.Errorf("ssh_agent_auth can't be used: %s", err))
+		}
+	}
+
 	if c.SSHHostPortMin > c.SSHHostPortMax {
 		errs = append(errs,
 			errors.New("ssh_host_port_min must be less than ssh_host_port_max"))
@@ -70,6 +83,8 @@ func (c *SSHConfig) Prepare(t *packer.ConfigTemplate) []error {
 		errs = append(errs, errors.New("An ssh_username must be specified."))
 	}
 
+	errs = append(errs, c.SSHConnectConfig.Prepare(t)...)
+
 	var err error
 	c.SSHWaitTimeout, err = time.ParseDuration(c.RawSSHWaitTimeout)
 	if err != nil {
@@ -70,7 +70,7 @@ func SSHConfigFunc(config *SSHConfig) func(multistep.StateBag) (*gossh.ClientCon
 		}
 
 		if config.SSHKeyPath != "" {
-			keyring, err := sshKeyToKeyring(config.SSHKeyPath)
+			keyring, err := sshKeyToKeyring(config.SSHKeyPath, config.SSHKeyPassphrase)
 			if err != nil {
 				return nil, err
 			}
@@ -78,6 +78,15 @@ func SSHConfigFunc(config *SSHConfig) func(multistep.StateBag) (*gossh.ClientCon
 			auth = append(auth, gossh.ClientAuthKeyring(keyring))
 		}
 
+		if config.SSHAgentAuth {
+			agentAuth, err := ssh.AgentAuth()
+			if err != nil {
+				return nil, err
+			}
+
+			auth = append(auth, agentAuth)
+		}
+
 		return &gossh.ClientConfig{
 			User: config.SSHUser,
 			Auth: auth,
@@ -85,7 +94,7 @@ func SSHConfigFunc(config *SSHConfig) func(multistep.StateBag) (*gossh.ClientCon
 	}
 }
 
-func sshKeyToKeyring(path string) (gossh.ClientKeyring, error) {
+func sshKeyToKeyring(path string, passphrase string) (gossh.ClientKeyring, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -98,7 +107,7 @@ func sshKeyToKeyring(path string) (gossh.ClientKeyring, error) {
 	}
 
 	keyring := new(ssh.SimpleKeychain)
-	if err := keyring.AddPEMKey(string(keyBytes)); err != nil {
+	if err := keyring.AddPEMKeyPassword(string(keyBytes), passphrase); err != nil {
 		return nil, err
 	}
 
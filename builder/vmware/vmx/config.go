@@ -33,11 +33,10 @@ func NewConfig(raws ...interface{}) (*Config, []string, error) {
 		return nil, nil, err
 	}
 
-	c.tpl, err = packer.NewConfigTemplate()
+	c.tpl, err = c.NewConfigTemplate()
 	if err != nil {
 		return nil, nil, err
 	}
-	c.tpl.UserVars = c.PackerUserVars
 
 	// Defaults
 	if c.VMName == "" {
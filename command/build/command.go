@@ -5,13 +5,13 @@ import (
 	"flag"
 	"fmt"
 	cmdcommon "github.com/mitchellh/packer/common/command"
+	"github.com/mitchellh/packer/common/secret"
 	"github.com/mitchellh/packer/packer"
 	"log"
 	"os"
 	"os/signal"
 	"strconv"
 	"strings"
-	"sync"
 )
 
 type Command byte
@@ -23,12 +23,18 @@ func (Command) Help() string {
 func (c Command) Run(env packer.Environment, args []string) int {
 	var cfgDebug bool
 	var cfgForce bool
+	var cfgManifest string
+	var cfgOnError string
+	var cfgParallel int
 	buildOptions := new(cmdcommon.BuildOptions)
 
 	cmdFlags := flag.NewFlagSet("build", flag.ContinueOnError)
 	cmdFlags.Usage = func() { env.Ui().Say(c.Help()) }
 	cmdFlags.BoolVar(&cfgDebug, "debug", false, "debug mode for builds")
 	cmdFlags.BoolVar(&cfgForce, "force", false, "force a build if artifacts exist")
+	cmdFlags.StringVar(&cfgManifest, "manifest", "", "file to append a record of the builds to")
+	cmdFlags.StringVar(&cfgOnError, "on-error", packer.OnErrorCleanup, "what to do when a build fails")
+	cmdFlags.IntVar(&cfgParallel, "parallel", 0, "number of builds to run at once")
 	cmdcommon.BuildOptionFlags(cmdFlags, buildOptions)
 	if err := cmdFlags.Parse(args); err != nil {
 		return 1
@@ -47,7 +53,7 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		return 1
 	}
 
-	userVars, err := buildOptions.AllUserVars()
+	userVars, err := buildOptions.UserVarSources(args[0])
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Error compiling user variables: %s", err))
 		env.Ui().Error("")
@@ -57,18 +63,24 @@ func (c Command) Run(env packer.Environment, args []string) int {
 
 	// Read the file into a byte array so that we can parse the template
 	log.Printf("Reading template: %s", args[0])
-	tpl, err := packer.ParseTemplateFile(args[0], userVars)
+	tpl, err := buildOptions.ParseTemplate(args[0], userVars)
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
 		return 1
 	}
 
+	if buildOptions.PrintVars {
+		cmdcommon.PrintVars(env.Ui(), tpl, userVars)
+		return 0
+	}
+
 	// The component finder for our builds
 	components := &packer.ComponentFinder{
 		Builder:       env.Builder,
 		Hook:          env.Hook,
 		PostProcessor: env.PostProcessor,
 		Provisioner:   env.Provisioner,
+		Secret:        secret.Lookup,
 	}
 
 	// Go through each builder and compile the builds that we care about
@@ -78,6 +90,19 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		return 1
 	}
 
+	if cfgParallel < 0 {
+		env.Ui().Error("-parallel can't be negative")
+		return 1
+	}
+
+	switch cfgOnError {
+	case packer.OnErrorCleanup, packer.OnErrorAbort, packer.OnErrorAsk:
+	default:
+		env.Ui().Error(fmt.Sprintf(
+			"-on-error must be one of cleanup, abort or ask, got: %s", cfgOnError))
+		return 1
+	}
+
 	if cfgDebug {
 		env.Ui().Say("Debug mode enabled. Builds will not be parallelized.")
 	}
@@ -91,14 +116,19 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		packer.UiColorBlue,
 	}
 
+	// The UIs also keep track of how long each part of the builds took
 	buildUis := make(map[string]packer.Ui)
+	timingUis := make(map[string]*timingUi)
 	for i, b := range builds {
-		ui := &packer.ColoredUi{
-			Color: colors[i%len(colors)],
-			Ui:    env.Ui(),
+		ui := &timingUi{
+			Ui: &packer.ColoredUi{
+				Color: colors[i%len(colors)],
+				Ui:    env.Ui(),
+			},
 		}
 
 		buildUis[b.Name()] = ui
+		timingUis[b.Name()] = ui
 		ui.Say(fmt.Sprintf("%s output will be in this color.", b.Name()))
 	}
 
@@ -107,12 +137,15 @@ func (c Command) Run(env packer.Environment, args []string) int {
 
 	log.Printf("Build debug mode: %v", cfgDebug)
 	log.Printf("Force build: %v", cfgForce)
+	log.Printf("On error: %s", cfgOnError)
+	log.Printf("Parallel builds: %d", cfgParallel)
 
-	// Set the debug and force mode and prepare all the builds
+	// Set the debug, force and on-error modes and prepare all the builds
 	for _, b := range builds {
 		log.Printf("Preparing build: %s", b.Name())
 		b.SetDebug(cfgDebug)
 		b.SetForce(cfgForce)
+		b.SetOnError(cfgOnError)
 
 		warnings, err := b.Prepare()
 		if err != nil {
@@ -129,72 +162,32 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		}
 	}
 
-	// Run all the builds in parallel and wait for them to complete
-	var interruptWg, wg sync.WaitGroup
-	interrupted := false
-	artifacts := make(map[string][]packer.Artifact)
-	errors := make(map[string]error)
-	for _, b := range builds {
-		// Increment the waitgroup so we wait for this item to finish properly
-		wg.Add(1)
-
-		// Handle interrupts for this build
-		sigCh := make(chan os.Signal, 1)
-		signal.Notify(sigCh, os.Interrupt)
-		defer signal.Stop(sigCh)
-		go func(b packer.Build) {
-			<-sigCh
-			interruptWg.Add(1)
-			defer interruptWg.Done()
-			interrupted = true
-
-			log.Printf("Stopping build: %s", b.Name())
-			b.Cancel()
-			log.Printf("Build cancelled: %s", b.Name())
-		}(b)
-
-		// Run the build in a goroutine
-		go func(b packer.Build) {
-			defer wg.Done()
-
-			name := b.Name()
-			log.Printf("Starting build run: %s", name)
-			ui := buildUis[name]
-			runArtifacts, err := b.Run(ui, env.Cache())
-
-			if err != nil {
-				ui.Error(fmt.Sprintf("Build '%s' errored: %s", name, err))
-				errors[name] = err
-			} else {
-				ui.Say(fmt.Sprintf("Build '%s' finished.", name))
-				artifacts[name] = runArtifacts
-			}
-		}(b)
-
-		if cfgDebug {
-			log.Printf("Debug enabled, so waiting for build to finish: %s", b.Name())
-			wg.Wait()
-		}
-
-		if interrupted {
-			log.Println("Interrupted, not going to start any more builds.")
-			break
-		}
+	// Run the builds in parallel, at most cfgParallel at once, and wait
+	// for them to complete.
+	if cfgDebug {
+		cfgParallel = 1
 	}
 
-	// Wait for both the builds to complete and the interrupt handler,
-	// if it is interrupted.
-	log.Printf("Waiting on builds to complete...")
-	wg.Wait()
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	defer signal.Stop(sigCh)
 
-	log.Printf("Builds completed. Waiting on interrupt barrier...")
-	interruptWg.Wait()
+	runner := &buildRunner{
+		Parallel: cfgParallel,
+		Ui:       env.Ui(),
+		Uis:      buildUis,
+		Cache:    env.Cache(),
+	}
+	runner.Run(builds, sigCh)
 
-	if interrupted {
+	if runner.Interrupted() {
 		env.Ui().Say("Cleanly cancelled builds after being interrupted.")
 		return 1
 	}
 
+	artifacts := runner.Artifacts()
+	errors := runner.Errors()
+
 	if len(errors) > 0 {
 		env.Ui().Machine("error-count", strconv.FormatInt(int64(len(errors)), 10))
 
@@ -260,6 +253,23 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		env.Ui().Say("\n==> Builds finished but no artifacts were created.")
 	}
 
+	env.Ui().Say("\n==> Time taken by each build:")
+	times := runner.Times()
+	for _, b := range builds {
+		if t, ok := times[b.Name()]; ok {
+			reportTimings(env.Ui(), b.Name(), t.End.Sub(t.Start), timingUis[b.Name()].Timings())
+		}
+	}
+
+	if cfgManifest != "" {
+		if err := writeManifest(cfgManifest, args[0], builds, runner); err != nil {
+			env.Ui().Error(fmt.Sprintf("Error writing manifest: %s", err))
+			return 1
+		}
+
+		env.Ui().Say(fmt.Sprintf("\n==> Wrote manifest: %s", cfgManifest))
+	}
+
 	if len(errors) > 0 {
 		// If any errors occurred, exit with a non-zero exit status
 		return 1
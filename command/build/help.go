@@ -10,9 +10,13 @@ Options:
 
   -debug                     Debug mode enabled for builds
   -force                     Force a build to continue if artifacts exist, deletes existing artifacts
+  -on-error=cleanup          If a build fails: cleanup, abort without cleanup, or ask
+  -manifest=path             Append a JSON record of the builds and their artifacts to path
   -machine-readable          Machine-readable output
+  -parallel=N                Run at most N builds at once, queueing the rest
   -except=foo,bar,baz        Build all builds other than these
   -only=foo,bar,baz          Only build the given builds by name
   -var 'key=value'           Variable for templates, can be used multiple times.
-  -var-file=path             JSON file containing user variables.
+  -var-file=path             JSON or YAML file containing user variables.
+  -print-vars                Show the user variables and where they were set, then exit.
 `
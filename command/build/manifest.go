@@ -0,0 +1,206 @@
+package build
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Manifest is the contents of the file written with the -manifest flag.
+// Each run of "packer build" appends a ManifestRun to it, so that tools
+// can read what was built without scraping the output of Packer.
+type Manifest struct {
+	Runs []*ManifestRun `json:"runs"`
+}
+
+// ManifestRun is a single run of "packer build".
+type ManifestRun struct {
+	TemplatePath string           `json:"template_path"`
+	Builds       []*ManifestBuild `json:"builds"`
+}
+
+// ManifestBuild is a build that ran. Builds that failed have an error
+// and no artifacts.
+type ManifestBuild struct {
+	Name        string              `json:"name"`
+	BuilderType string              `json:"builder_type"`
+	StartTime   time.Time           `json:"start_time"`
+	EndTime     time.Time           `json:"end_time"`
+	Error       string              `json:"error,omitempty"`
+	Artifacts   []*ManifestArtifact `json:"artifacts"`
+
+	// UserVariablesSha256 is the SHA-256 of the user variables of the
+	// build as a JSON object with sorted keys, so that builds with the
+	// same variables can be found without storing their values. The
+	// values of sensitive variables are left out, since a plain hash of
+	// them could be used to guess them.
+	UserVariablesSha256 string `json:"user_variables_sha256"`
+}
+
+// ManifestArtifact is an artifact of a build.
+type ManifestArtifact struct {
+	BuilderId string          `json:"builder_id"`
+	Id        string          `json:"id"`
+	Files     []*ManifestFile `json:"files"`
+}
+
+// ManifestFile is a file of an artifact.
+type ManifestFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256"`
+}
+
+// newManifestRun returns the manifest entry for the builds that ran.
+func newManifestRun(templatePath string, builds []packer.Build, r *buildRunner) (*ManifestRun, error) {
+	artifacts := r.Artifacts()
+	errors := r.Errors()
+	times := r.Times()
+
+	run := &ManifestRun{
+		TemplatePath: templatePath,
+		Builds:       make([]*ManifestBuild, 0, len(builds)),
+	}
+
+	for _, b := range builds {
+		name := b.Name()
+		t, ok := times[name]
+		if !ok {
+			// The build never ran
+			continue
+		}
+
+		varsHash, err := userVariablesHash(b.UserVariables())
+		if err != nil {
+			return nil, err
+		}
+
+		build := &ManifestBuild{
+			Name:                name,
+			BuilderType:         b.BuilderType(),
+			StartTime:           t.Start.UTC(),
+			EndTime:             t.End.UTC(),
+			Artifacts:           make([]*ManifestArtifact, 0, len(artifacts[name])),
+			UserVariablesSha256: varsHash,
+		}
+
+		if err := errors[name]; err != nil {
+			build.Error = err.Error()
+		}
+
+		for _, artifact := range artifacts[name] {
+			if artifact == nil {
+				continue
+			}
+
+			a, err := newManifestArtifact(artifact)
+			if err != nil {
+				return nil, fmt.Errorf("Error reading artifact of '%s': %s", name, err)
+			}
+
+			build.Artifacts = append(build.Artifacts, a)
+		}
+
+		run.Builds = append(run.Builds, build)
+	}
+
+	return run, nil
+}
+
+func newManifestArtifact(artifact packer.Artifact) (*ManifestArtifact, error) {
+	result := &ManifestArtifact{
+		BuilderId: artifact.BuilderId(),
+		Id:        artifact.Id(),
+		Files:     make([]*ManifestFile, 0, len(artifact.Files())),
+	}
+
+	for _, path := range artifact.Files() {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+
+		h := sha256.New()
+		size, err := io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		result.Files = append(result.Files, &ManifestFile{
+			Name:   path,
+			Size:   size,
+			Sha256: hex.EncodeToString(h.Sum(nil)),
+		})
+	}
+
+	return result, nil
+}
+
+// userVariablesHash returns the hex encoded SHA-256 of the variables as
+// a JSON object. encoding/json sorts the keys of maps, so the same
+// variables always have the same hash. Values that contain sensitive
+// values are replaced with packer.RedactedValue first.
+func userVariablesHash(vars map[string]string) (string, error) {
+	hashed := make(map[string]string)
+	for k, v := range vars {
+		if packer.IsSensitive(v) {
+			v = packer.RedactedValue
+		}
+
+		hashed[k] = v
+	}
+
+	data, err := json.Marshal(hashed)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:]), nil
+}
+
+// writeManifest appends an entry for the builds that the runner ran to
+// the manifest at the path.
+func writeManifest(path string, templatePath string, builds []packer.Build, r *buildRunner) error {
+	templatePath, err := filepath.Abs(templatePath)
+	if err != nil {
+		return err
+	}
+
+	run, err := newManifestRun(templatePath, builds, r)
+	if err != nil {
+		return err
+	}
+
+	return appendManifest(path, run)
+}
+
+// appendManifest appends the run to the manifest at the path, creating
+// the manifest if it doesn't exist.
+func appendManifest(path string, run *ManifestRun) error {
+	var manifest Manifest
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return fmt.Errorf("Error reading manifest %s: %s", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	manifest.Runs = append(manifest.Runs, run)
+	data, err = json.MarshalIndent(&manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
@@ -0,0 +1,138 @@
+package build
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteManifest(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	artifactPath := filepath.Join(td, "image")
+	if err := ioutil.WriteFile(artifactPath, []byte("hello"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	stats := new(testBuildStats)
+	good := newTestBuild("good", stats)
+	good.artifacts = []packer.Artifact{
+		&packer.MockArtifact{IdValue: "image-id", FilesValue: []string{artifactPath}},
+	}
+
+	failed := newTestBuild("failed", stats)
+	failed.runFunc = func() error { return errors.New("broken") }
+
+	builds := []packer.Build{good, failed}
+	runner, _ := testRunner(0, builds)
+	runner.Run(builds, make(chan os.Signal))
+
+	manifestPath := filepath.Join(td, "manifest.json")
+	for i := 0; i < 2; i++ {
+		if err := writeManifest(manifestPath, "template.json", builds, runner); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	data, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(manifest.Runs) != 2 {
+		t.Fatalf("bad: %d", len(manifest.Runs))
+	}
+
+	run := manifest.Runs[0]
+	if !filepath.IsAbs(run.TemplatePath) || filepath.Base(run.TemplatePath) != "template.json" {
+		t.Fatalf("bad: %s", run.TemplatePath)
+	}
+	if len(run.Builds) != 2 {
+		t.Fatalf("bad: %#v", run.Builds)
+	}
+
+	b := run.Builds[0]
+	if b.Name != "good" || b.BuilderType != "test" || b.Error != "" {
+		t.Fatalf("bad: %#v", b)
+	}
+	if b.StartTime.IsZero() || b.EndTime.Before(b.StartTime) {
+		t.Fatalf("bad: %s %s", b.StartTime, b.EndTime)
+	}
+	if b.UserVariablesSha256 != "44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a" {
+		t.Fatalf("bad: %s", b.UserVariablesSha256)
+	}
+	if len(b.Artifacts) != 1 || b.Artifacts[0].Id != "image-id" {
+		t.Fatalf("bad: %#v", b.Artifacts)
+	}
+
+	files := b.Artifacts[0].Files
+	if len(files) != 1 {
+		t.Fatalf("bad: %#v", files)
+	}
+	if files[0].Name != artifactPath || files[0].Size != 5 {
+		t.Fatalf("bad: %#v", files[0])
+	}
+	if files[0].Sha256 != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Fatalf("bad: %s", files[0].Sha256)
+	}
+
+	b = run.Builds[1]
+	if b.Name != "failed" || b.Error != "broken" || len(b.Artifacts) != 0 {
+		t.Fatalf("bad: %#v", b)
+	}
+}
+
+func TestUserVariablesHash(t *testing.T) {
+	a, err := userVariablesHash(map[string]string{"a": "1", "b": "2"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	b, err := userVariablesHash(map[string]string{"b": "2", "a": "1"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	c, err := userVariablesHash(map[string]string{"a": "1", "b": "3"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if a != b {
+		t.Fatalf("bad: %s != %s", a, b)
+	}
+	if a == c {
+		t.Fatal("different variables should have different hashes")
+	}
+}
+
+func TestUserVariablesHash_sensitive(t *testing.T) {
+	packer.AddSensitiveValue("manifest-hash-secret")
+
+	a, err := userVariablesHash(map[string]string{"a": "manifest-hash-secret"})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	b, err := userVariablesHash(map[string]string{"a": packer.RedactedValue})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if a != b {
+		t.Fatal("sensitive values should not be hashed")
+	}
+}
@@ -0,0 +1,199 @@
+package build
+
+import (
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// buildRunner runs builds with at most Parallel of them running at once.
+// The rest are queued and started as the running builds finish.
+type buildRunner struct {
+	// Parallel is the most builds that run at once. Zero means that
+	// there is no limit.
+	Parallel int
+
+	// Ui is the UI of the command, and Uis are the UIs of each build
+	// by name.
+	Ui    packer.Ui
+	Uis   map[string]packer.Ui
+	Cache packer.Cache
+
+	l           sync.Mutex
+	artifacts   map[string][]packer.Artifact
+	errors      map[string]error
+	times       map[string]buildTime
+	interrupted bool
+	started     []packer.Build
+}
+
+// buildTime is when a build started and ended.
+type buildTime struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Run runs the builds and waits for them to complete. If a signal is
+// received on sigCh, the running builds are cancelled and no more builds
+// are started. The results are then available from the runner.
+func (r *buildRunner) Run(builds []packer.Build, sigCh <-chan os.Signal) {
+	r.artifacts = make(map[string][]packer.Artifact)
+	r.errors = make(map[string]error)
+	r.times = make(map[string]buildTime)
+
+	parallel := r.Parallel
+	if parallel <= 0 || parallel > len(builds) {
+		parallel = len(builds)
+	}
+
+	// Queue all of the builds, letting the user know which of them have
+	// to wait for others to finish.
+	buildCh := make(chan packer.Build, len(builds))
+	for i, b := range builds {
+		if i >= parallel {
+			ui := &packer.TargettedUi{Target: b.Name(), Ui: r.Ui}
+			ui.Machine("build-queued", strconv.Itoa(parallel))
+			r.Uis[b.Name()].Say(fmt.Sprintf(
+				"Build '%s' is queued until one of the %d running builds finishes.",
+				b.Name(), parallel))
+		}
+
+		buildCh <- b
+	}
+	close(buildCh)
+
+	// Handle interrupts by cancelling the builds that have started
+	doneCh := make(chan struct{})
+	cancelledCh := make(chan struct{})
+	go func() {
+		defer close(cancelledCh)
+
+		select {
+		case <-sigCh:
+		case <-doneCh:
+			return
+		}
+
+		r.l.Lock()
+		r.interrupted = true
+		started := make([]packer.Build, len(r.started))
+		copy(started, r.started)
+		r.l.Unlock()
+
+		var wg sync.WaitGroup
+		for _, b := range started {
+			wg.Add(1)
+			go func(b packer.Build) {
+				defer wg.Done()
+
+				log.Printf("Stopping build: %s", b.Name())
+				b.Cancel()
+				log.Printf("Build cancelled: %s", b.Name())
+			}(b)
+		}
+
+		wg.Wait()
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range buildCh {
+				r.run(b)
+			}
+		}()
+	}
+
+	log.Printf("Waiting on builds to complete...")
+	wg.Wait()
+	close(doneCh)
+
+	log.Printf("Builds completed. Waiting on interrupt barrier...")
+	<-cancelledCh
+}
+
+// run runs a single build, unless the runner was interrupted.
+func (r *buildRunner) run(b packer.Build) {
+	name := b.Name()
+
+	r.l.Lock()
+	if r.interrupted {
+		r.l.Unlock()
+		log.Printf("Interrupted, not starting build: %s", name)
+		return
+	}
+	r.started = append(r.started, b)
+	r.l.Unlock()
+
+	log.Printf("Starting build run: %s", name)
+	ui := r.Uis[name]
+	eventUi := &packer.TargettedUi{Target: name, Ui: ui}
+	start := time.Now()
+	eventUi.Event(packer.NewEvent(packer.EventBuildStart, name))
+	runArtifacts, err := b.Run(ui, r.Cache)
+	end := time.Now()
+
+	r.l.Lock()
+	interrupted := r.interrupted
+	r.times[name] = buildTime{Start: start, End: end}
+	if err != nil {
+		r.errors[name] = err
+	} else {
+		r.artifacts[name] = runArtifacts
+	}
+	r.l.Unlock()
+
+	if err != nil {
+		if interrupted {
+			eventUi.Event(packer.NewEndEvent(packer.EventCancelled, name, start, err))
+		} else {
+			eventUi.Event(packer.NewEndEvent(packer.EventError, name, start, err))
+		}
+
+		ui.Error(fmt.Sprintf("Build '%s' errored: %s", name, err))
+		return
+	}
+
+	for _, artifact := range runArtifacts {
+		if artifact != nil {
+			eventUi.Event(packer.NewArtifactEvent(artifact))
+		}
+	}
+
+	eventUi.Event(packer.NewEndEvent(packer.EventBuildEnd, name, start, nil))
+	ui.Say(fmt.Sprintf("Build '%s' finished.", name))
+}
+
+// Artifacts returns the artifacts of the builds that succeeded.
+func (r *buildRunner) Artifacts() map[string][]packer.Artifact {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.artifacts
+}
+
+// Errors returns the errors of the builds that failed.
+func (r *buildRunner) Errors() map[string]error {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.errors
+}
+
+// Times returns when the builds that ran started and ended.
+func (r *buildRunner) Times() map[string]buildTime {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.times
+}
+
+// Interrupted returns true if the builds were interrupted.
+func (r *buildRunner) Interrupted() bool {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.interrupted
+}
@@ -0,0 +1,260 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/mitchellh/packer/packer"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testBuild is a packer.Build that runs a function, keeping track of how
+// many test builds are running at once.
+type testBuild struct {
+	name      string
+	runFunc   func() error
+	stats     *testBuildStats
+	artifacts []packer.Artifact
+
+	cancelCh   chan struct{}
+	cancelOnce sync.Once
+	cancelled  bool
+}
+
+type testBuildStats struct {
+	sync.Mutex
+	running    int
+	maxRunning int
+	runs       int
+}
+
+func newTestBuild(name string, stats *testBuildStats) *testBuild {
+	return &testBuild{name: name, stats: stats, cancelCh: make(chan struct{})}
+}
+
+func (b *testBuild) Name() string                     { return b.name }
+func (b *testBuild) BuilderType() string              { return "test" }
+func (b *testBuild) UserVariables() map[string]string { return nil }
+func (b *testBuild) Prepare() ([]string, error)       { return nil, nil }
+func (b *testBuild) SetDebug(bool)                    {}
+func (b *testBuild) SetForce(bool)                    {}
+func (b *testBuild) SetOnError(string)                {}
+
+func (b *testBuild) Run(packer.Ui, packer.Cache) ([]packer.Artifact, error) {
+	b.stats.Lock()
+	b.stats.running++
+	b.stats.runs++
+	if b.stats.running > b.stats.maxRunning {
+		b.stats.maxRunning = b.stats.running
+	}
+	b.stats.Unlock()
+
+	defer func() {
+		b.stats.Lock()
+		defer b.stats.Unlock()
+		b.stats.running--
+	}()
+
+	var err error
+	if b.runFunc != nil {
+		err = b.runFunc()
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if b.artifacts != nil {
+		return b.artifacts, nil
+	}
+
+	return []packer.Artifact{new(packer.MockArtifact)}, nil
+}
+
+func (b *testBuild) Cancel() {
+	b.cancelOnce.Do(func() {
+		b.cancelled = true
+		close(b.cancelCh)
+	})
+}
+
+func testRunner(parallel int, builds []packer.Build) (*buildRunner, *bytes.Buffer) {
+	out := new(bytes.Buffer)
+	ui := &packer.BasicUi{Reader: new(bytes.Buffer), Writer: out}
+	uis := make(map[string]packer.Ui)
+	for _, b := range builds {
+		uis[b.Name()] = ui
+	}
+
+	return &buildRunner{Parallel: parallel, Ui: ui, Uis: uis}, out
+}
+
+func TestBuildRunner(t *testing.T) {
+	stats := new(testBuildStats)
+	builds := make([]packer.Build, 0)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		b := newTestBuild(name, stats)
+		b.runFunc = func() error {
+			time.Sleep(10 * time.Millisecond)
+			return nil
+		}
+
+		builds = append(builds, b)
+	}
+
+	failed := newTestBuild("f", stats)
+	failed.runFunc = func() error { return errors.New("failed") }
+	builds = append(builds, failed)
+
+	runner, out := testRunner(2, builds)
+	runner.Run(builds, make(chan os.Signal))
+
+	if stats.runs != 6 {
+		t.Fatalf("bad: %d", stats.runs)
+	}
+	if stats.maxRunning != 2 {
+		t.Fatalf("bad: %d", stats.maxRunning)
+	}
+	if len(runner.Artifacts()) != 5 {
+		t.Fatalf("bad: %#v", runner.Artifacts())
+	}
+	if len(runner.Errors()) != 1 || runner.Errors()["f"] == nil {
+		t.Fatalf("bad: %#v", runner.Errors())
+	}
+	if runner.Interrupted() {
+		t.Fatal("should not be interrupted")
+	}
+
+	if strings.Count(out.String(), "is queued") != 4 {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestBuildRunner_unlimited(t *testing.T) {
+	stats := new(testBuildStats)
+	startCh := make(chan struct{})
+	builds := make([]packer.Build, 0)
+	for _, name := range []string{"a", "b", "c"} {
+		b := newTestBuild(name, stats)
+		b.runFunc = func() error {
+			<-startCh
+			return nil
+		}
+
+		builds = append(builds, b)
+	}
+
+	// All of the builds must be running at once for them to finish
+	go func() {
+		for {
+			stats.Lock()
+			running := stats.running
+			stats.Unlock()
+			if running == 3 {
+				close(startCh)
+				return
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	runner, out := testRunner(0, builds)
+	runner.Run(builds, make(chan os.Signal))
+
+	if len(runner.Artifacts()) != 3 {
+		t.Fatalf("bad: %#v", runner.Artifacts())
+	}
+	if strings.Contains(out.String(), "is queued") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestBuildRunner_interrupt(t *testing.T) {
+	stats := new(testBuildStats)
+	first := newTestBuild("a", stats)
+	first.runFunc = func() error {
+		<-first.cancelCh
+		return errors.New("cancelled")
+	}
+
+	queued := newTestBuild("b", stats)
+	builds := []packer.Build{first, queued}
+
+	sigCh := make(chan os.Signal, 1)
+	go func() {
+		for {
+			stats.Lock()
+			running := stats.running
+			stats.Unlock()
+			if running == 1 {
+				sigCh <- os.Interrupt
+				return
+			}
+
+			time.Sleep(time.Millisecond)
+		}
+	}()
+
+	runner, _ := testRunner(1, builds)
+	runner.Run(builds, sigCh)
+
+	if !runner.Interrupted() {
+		t.Fatal("should be interrupted")
+	}
+	if !first.cancelled {
+		t.Fatal("running build should be cancelled")
+	}
+	if stats.runs != 1 {
+		t.Fatalf("queued build should not run: %d", stats.runs)
+	}
+}
+
+func TestBuildRunner_events(t *testing.T) {
+	stats := new(testBuildStats)
+	good := newTestBuild("good", stats)
+	failed := newTestBuild("failed", stats)
+	failed.runFunc = func() error { return errors.New("broken") }
+	builds := []packer.Build{good, failed}
+
+	out := new(bytes.Buffer)
+	ui := &packer.JsonUi{Writer: out}
+	runner := &buildRunner{
+		Parallel: 1,
+		Ui:       ui,
+		Uis:      map[string]packer.Ui{"good": ui, "failed": ui},
+	}
+	runner.Run(builds, make(chan os.Signal))
+
+	var events []string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var event struct {
+			Type  string
+			Build string
+			Error string
+		}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if event.Type != "ui" && event.Type != "machine" {
+			events = append(events, event.Build+" "+event.Type+" "+event.Error)
+		}
+	}
+
+	expected := []string{
+		"good build-start ",
+		"good artifact ",
+		"good build-end ",
+		"failed build-start ",
+		"failed error broken",
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Fatalf("bad: %#v", events)
+	}
+}
@@ -0,0 +1,99 @@
+package build
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// timing is how long a part of a build took.
+type timing struct {
+	Kind     string
+	Name     string
+	Duration time.Duration
+	Failed   bool
+}
+
+// The kinds of timings for each of the events that end something.
+var timingKinds = map[packer.EventType]string{
+	packer.EventStepEnd:          "step",
+	packer.EventProvisionerEnd:   "provisioner",
+	packer.EventPostProcessorEnd: "post-processor",
+}
+
+// timingUi is a Ui that keeps track of how long the steps, provisioners
+// and post-processors of a build took, from their events.
+type timingUi struct {
+	packer.Ui
+
+	l       sync.Mutex
+	timings []timing
+}
+
+func (u *timingUi) Event(e *packer.Event) {
+	if kind, ok := timingKinds[e.Type]; ok {
+		u.l.Lock()
+		u.timings = append(u.timings, timing{
+			Kind:     kind,
+			Name:     e.Name,
+			Duration: e.Duration,
+			Failed:   e.Error != "",
+		})
+		u.l.Unlock()
+	}
+
+	u.Ui.Event(e)
+}
+
+// Timings returns the timings in the order that they ended.
+func (u *timingUi) Timings() []timing {
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	result := make([]timing, len(u.timings))
+	copy(result, u.timings)
+	return result
+}
+
+// reportTimings shows how long each part of the build took as a table,
+// along with machine-readable "timing" messages.
+func reportTimings(ui packer.Ui, name string, total time.Duration, timings []timing) {
+	machineUi := &packer.TargettedUi{Target: name, Ui: ui}
+
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "--> %s:\n", name)
+	for _, t := range timings {
+		machineUi.Machine("timing", t.Kind, t.Name,
+			strconv.FormatFloat(t.Duration.Seconds(), 'f', 3, 64))
+
+		percent := 0.0
+		if total > 0 {
+			percent = 100 * float64(t.Duration) / float64(total)
+		}
+
+		fmt.Fprintf(w, "    %s\t%s\t%s\t%.1f%%",
+			t.Kind, t.Name, formatDuration(t.Duration), percent)
+		if t.Failed {
+			fmt.Fprint(w, "\tfailed")
+		}
+		fmt.Fprint(w, "\n")
+	}
+
+	machineUi.Machine("timing", "build", name,
+		strconv.FormatFloat(total.Seconds(), 'f', 3, 64))
+	fmt.Fprintf(w, "    total\t\t%s\n", formatDuration(total))
+	w.Flush()
+
+	ui.Say(strings.TrimRight(buf.String(), "\n"))
+}
+
+// formatDuration formats a duration to a tenth of a second.
+func formatDuration(d time.Duration) string {
+	return (d - d%(100*time.Millisecond)).String()
+}
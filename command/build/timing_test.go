@@ -0,0 +1,96 @@
+package build
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/mitchellh/packer/packer"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTimingUi(t *testing.T) {
+	out := new(bytes.Buffer)
+	ui := &timingUi{Ui: &packer.JsonUi{Writer: out}}
+
+	start := time.Now().Add(-time.Second)
+	ui.Event(packer.NewEvent(packer.EventStepStart, "StepCreateVM"))
+	ui.Event(packer.NewEndEvent(packer.EventStepEnd, "StepCreateVM", start, nil))
+	ui.Event(packer.NewEndEvent(packer.EventProvisionerEnd, "shell", start, errors.New("failed")))
+	ui.Event(packer.NewEndEvent(packer.EventPostProcessorEnd, "vagrant", start, nil))
+	ui.Event(packer.NewEndEvent(packer.EventBuildEnd, "vbox", start, nil))
+
+	timings := ui.Timings()
+	if len(timings) != 3 {
+		t.Fatalf("bad: %#v", timings)
+	}
+
+	kinds := []string{timings[0].Kind, timings[1].Kind, timings[2].Kind}
+	if !reflect.DeepEqual(kinds, []string{"step", "provisioner", "post-processor"}) {
+		t.Fatalf("bad: %#v", kinds)
+	}
+	if timings[0].Name != "StepCreateVM" || timings[0].Duration < time.Second {
+		t.Fatalf("bad: %#v", timings[0])
+	}
+	if timings[0].Failed || !timings[1].Failed {
+		t.Fatalf("bad: %#v", timings)
+	}
+
+	// All of the events are still passed through
+	if n := strings.Count(out.String(), "\n"); n != 5 {
+		t.Fatalf("bad: %d", n)
+	}
+}
+
+func TestReportTimings(t *testing.T) {
+	timings := []timing{
+		{Kind: "step", Name: "StepDownload", Duration: 90 * time.Second},
+		{Kind: "provisioner", Name: "shell", Duration: 30*time.Second + 123*time.Millisecond, Failed: true},
+	}
+
+	out := new(bytes.Buffer)
+	reportTimings(&packer.BasicUi{Writer: out}, "vbox", 3*time.Minute, timings)
+
+	expected := "--> vbox:\n" +
+		"    step         StepDownload  1m30s  50.0%\n" +
+		"    provisioner  shell         30.1s  16.7%  failed\n" +
+		"    total                      3m0s\n"
+	if out.String() != expected {
+		t.Fatalf("bad: %q", out.String())
+	}
+
+	out.Reset()
+	reportTimings(&packer.JsonUi{Writer: out}, "vbox", 3*time.Minute, timings)
+
+	var machine [][]string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var v struct {
+			Type     string
+			Build    string
+			Category string
+			Args     []string
+		}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if v.Type == "machine" {
+			if v.Build != "vbox" || v.Category != "timing" {
+				t.Fatalf("bad: %s", line)
+			}
+
+			machine = append(machine, v.Args)
+		}
+	}
+
+	expectedMachine := [][]string{
+		{"step", "StepDownload", "90.000"},
+		{"provisioner", "shell", "30.123"},
+		{"build", "vbox", "180.000"},
+	}
+	if !reflect.DeepEqual(machine, expectedMachine) {
+		t.Fatalf("bad: %#v", machine)
+	}
+}
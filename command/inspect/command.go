@@ -3,9 +3,12 @@ package inspect
 import (
 	"flag"
 	"fmt"
+	cmdcommon "github.com/mitchellh/packer/common/command"
+	"github.com/mitchellh/packer/common/secret"
 	"github.com/mitchellh/packer/packer"
 	"log"
 	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -20,8 +23,11 @@ func (c Command) Synopsis() string {
 }
 
 func (c Command) Run(env packer.Environment, args []string) int {
+	buildOptions := new(cmdcommon.BuildOptions)
+
 	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
 	flags.Usage = func() { env.Ui().Say(c.Help()) }
+	cmdcommon.UserVarFlags(flags, buildOptions)
 	if err := flags.Parse(args); err != nil {
 		return 1
 	}
@@ -32,14 +38,30 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		return 1
 	}
 
+	if err := buildOptions.Validate(); err != nil {
+		env.Ui().Error(err.Error())
+		return 1
+	}
+
+	userVars, err := buildOptions.UserVarSources(args[0])
+	if err != nil {
+		env.Ui().Error(fmt.Sprintf("Error compiling user variables: %s", err))
+		return 1
+	}
+
 	// Read the file into a byte array so that we can parse the template
 	log.Printf("Reading template: %#v", args[0])
-	tpl, err := packer.ParseTemplateFile(args[0], nil)
+	tpl, err := buildOptions.ParseTemplate(args[0], userVars)
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
 		return 1
 	}
 
+	if buildOptions.PrintVars {
+		cmdcommon.PrintVars(env.Ui(), tpl, userVars)
+		return 0
+	}
+
 	// Convenience...
 	ui := env.Ui()
 
@@ -89,10 +111,15 @@ func (c Command) Run(env packer.Environment, args []string) int {
 				continue
 			}
 
+			defaultValue := v.Default
+			if v.Sensitive {
+				defaultValue = packer.RedactedValue
+			}
+
 			padding := strings.Repeat(" ", max-len(k))
-			output := fmt.Sprintf("  %s%s = %s", k, padding, v.Default)
+			output := fmt.Sprintf("  %s%s = %s", k, padding, defaultValue)
 
-			ui.Machine("template-variable", k, v.Default, "0")
+			ui.Machine("template-variable", k, defaultValue, "0")
 			ui.Say(output)
 		}
 	}
@@ -100,22 +127,21 @@ func (c Command) Run(env packer.Environment, args []string) int {
 	ui.Say("")
 
 	// Builders
+	buildNames := tpl.BuildNames()
+	sort.Strings(buildNames)
+
 	ui.Say("Builders:\n")
 	if len(tpl.Builders) == 0 {
 		ui.Say("  <No builders>")
 	} else {
-		keys := make([]string, 0, len(tpl.Builders))
 		max := 0
-		for k, _ := range tpl.Builders {
-			keys = append(keys, k)
+		for _, k := range buildNames {
 			if len(k) > max {
 				max = len(k)
 			}
 		}
 
-		sort.Strings(keys)
-
-		for _, k := range keys {
+		for _, k := range buildNames {
 			v := tpl.Builders[k]
 			padding := strings.Repeat(" ", max-len(k))
 			output := fmt.Sprintf("  %s%s", k, padding)
@@ -131,20 +157,111 @@ func (c Command) Run(env packer.Environment, args []string) int {
 
 	ui.Say("")
 
+	// Find out which builds skip each provisioner and post-processor,
+	// which is only needed if any of them can be skipped.
+	skips := make(map[string]*packer.BuildSkips)
+	skipErrors := make([]string, 0)
+	if canSkip(tpl) {
+		components := &packer.ComponentFinder{Secret: secret.Lookup}
+		for _, name := range buildNames {
+			buildSkips, err := tpl.BuildSkips(name, components)
+			if err != nil {
+				skipErrors = append(skipErrors,
+					fmt.Sprintf("  %s: %s", name, err))
+				continue
+			}
+
+			skips[name] = buildSkips
+		}
+	}
+
 	// Provisioners
 	ui.Say("Provisioners:\n")
 	if len(tpl.Provisioners) == 0 {
 		ui.Say("  <No provisioners>")
 	} else {
-		for _, v := range tpl.Provisioners {
+		for i, v := range tpl.Provisioners {
+			skipped := make([]string, 0)
+			for _, name := range buildNames {
+				if s, ok := skips[name]; ok && s.Provisioners[i] {
+					skipped = append(skipped, name)
+					ui.Machine("template-provisioner-skip",
+						strconv.Itoa(i), v.Type, name)
+				}
+			}
+
 			ui.Machine("template-provisioner", v.Type)
-			ui.Say(fmt.Sprintf("  %s", v.Type))
+			ui.Say(fmt.Sprintf("  %s%s", v.Type, skippedOutput(skipped)))
 		}
 	}
 
+	ui.Say("")
+
+	// Post-processors
+	ui.Say("Post-processors:\n")
+	if len(tpl.PostProcessors) == 0 {
+		ui.Say("  <No post-processors>")
+	} else {
+		for i, chain := range tpl.PostProcessors {
+			parts := make([]string, len(chain))
+			for j, v := range chain {
+				skipped := make([]string, 0)
+				for _, name := range buildNames {
+					if s, ok := skips[name]; ok && s.PostProcessors[i][j] {
+						skipped = append(skipped, name)
+						ui.Machine("template-post-processor-skip",
+							strconv.Itoa(i), strconv.Itoa(j), v.Type, name)
+					}
+				}
+
+				ui.Machine("template-post-processor",
+					strconv.Itoa(i), strconv.Itoa(j), v.Type)
+				parts[j] = v.Type + skippedOutput(skipped)
+			}
+
+			ui.Say("  " + strings.Join(parts, " -> "))
+		}
+	}
+
+	if len(skipErrors) > 0 {
+		ui.Say("\nWhat is skipped couldn't be determined for these builds:\n")
+		ui.Say(strings.Join(skipErrors, "\n"))
+	}
+
 	ui.Say("\nNote: If your build names contain user variables or template\n" +
 		"functions such as 'timestamp', these are processed at build time,\n" +
 		"and therefore only show in their raw form here.")
 
 	return 0
 }
+
+// canSkip returns true if any provisioner or post-processor of the
+// template is only run for some builds.
+func canSkip(tpl *packer.Template) bool {
+	conditions := make([]packer.TemplateOnlyExcept, 0)
+	for _, v := range tpl.Provisioners {
+		conditions = append(conditions, v.TemplateOnlyExcept)
+	}
+
+	for _, chain := range tpl.PostProcessors {
+		for _, v := range chain {
+			conditions = append(conditions, v.TemplateOnlyExcept)
+		}
+	}
+
+	for _, c := range conditions {
+		if len(c.Only) > 0 || len(c.Except) > 0 || c.OnlyIf != "" || c.SkipIf != "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func skippedOutput(skipped []string) string {
+	if len(skipped) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" (skipped for: %s)", strings.Join(skipped, ", "))
+}
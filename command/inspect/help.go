@@ -7,7 +7,14 @@ Usage: packer inspect TEMPLATE
   defines. This does not validate the contents of a template (other than
   basic syntax by necessity).
 
+  Provisioners and post-processors that are skipped for some builds, because
+  of "only", "except", "only_if" or "skip_if", list the builds they're
+  skipped for. User variables can be set for this.
+
 Options:
 
   -machine-readable  Machine-readable output
+  -var 'key=value'   Variable for templates, can be used multiple times.
+  -var-file=path     JSON or YAML file containing user variables.
+  -print-vars        Show the user variables and where they were set, then exit.
 `
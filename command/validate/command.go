@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	cmdcommon "github.com/mitchellh/packer/common/command"
+	"github.com/mitchellh/packer/common/secret"
 	"github.com/mitchellh/packer/packer"
 	"log"
 	"strings"
@@ -40,7 +41,7 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		return 1
 	}
 
-	userVars, err := buildOptions.AllUserVars()
+	userVars, err := buildOptions.UserVarSources(args[0])
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Error compiling user variables: %s", err))
 		env.Ui().Error("")
@@ -50,12 +51,17 @@ func (c Command) Run(env packer.Environment, args []string) int {
 
 	// Parse the template into a machine-usable format
 	log.Printf("Reading template: %s", args[0])
-	tpl, err := packer.ParseTemplateFile(args[0], userVars)
+	tpl, err := buildOptions.ParseTemplate(args[0], userVars)
 	if err != nil {
 		env.Ui().Error(fmt.Sprintf("Failed to parse template: %s", err))
 		return 1
 	}
 
+	if buildOptions.PrintVars {
+		cmdcommon.PrintVars(env.Ui(), tpl, userVars)
+		return 0
+	}
+
 	if cfgSyntaxOnly {
 		env.Ui().Say("Syntax-only check passed. Everything looks okay.")
 		return 0
@@ -70,6 +76,7 @@ func (c Command) Run(env packer.Environment, args []string) int {
 		Hook:          env.Hook,
 		PostProcessor: env.PostProcessor,
 		Provisioner:   env.Provisioner,
+		Secret:        secret.Lookup,
 	}
 
 	// Otherwise, get all the builds
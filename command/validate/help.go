@@ -16,5 +16,6 @@ Options:
   -except=foo,bar,baz    Validate all builds other than these
   -only=foo,bar,baz      Validate only these builds
   -var 'key=value'       Variable for templates, can be used multiple times.
-  -var-file=path         JSON file containing user variables.
+  -var-file=path         JSON or YAML file containing user variables.
+  -print-vars            Show the user variables and where they were set, then exit.
 `
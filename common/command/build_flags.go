@@ -11,8 +11,15 @@ import (
 func BuildOptionFlags(fs *flag.FlagSet, f *BuildOptions) {
 	fs.Var((*SliceValue)(&f.Except), "except", "build all builds except these")
 	fs.Var((*SliceValue)(&f.Only), "only", "only build the given builds by name")
+	UserVarFlags(fs, f)
+}
+
+// UserVarFlags sets only the command line flags for user variables, for
+// commands that don't run builds.
+func UserVarFlags(fs *flag.FlagSet, f *BuildOptions) {
 	fs.Var((*userVarValue)(&f.UserVars), "var", "specify a user variable")
 	fs.Var((*AppendSliceValue)(&f.UserVarFiles), "var-file", "file with user variables")
+	fs.BoolVar(&f.PrintVars, "print-vars", false, "print the user variables and their sources")
 }
 
 // userVarValue is a flag.Value that parses out user variables in
@@ -1,15 +1,28 @@
 package command
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	jsonutil "github.com/mitchellh/packer/common/json"
+	yamlutil "github.com/mitchellh/packer/common/yaml"
 	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
+// UserVarEnvPrefix starts the names of the environmental variables that
+// set user variables, such as PACKER_VAR_aws_region.
+const UserVarEnvPrefix = "PACKER_VAR_"
+
+// userVarAutoFiles are the patterns of the variable files next to a
+// template that are loaded automatically.
+var userVarAutoFiles = []string{"*.auto.json", "*.auto.yaml", "*.auto.yml"}
+
 // BuildOptions is a set of options related to builds that can be set
 // from the command line.
 type BuildOptions struct {
@@ -17,6 +30,22 @@ type BuildOptions struct {
 	UserVars     map[string]string
 	Except       []string
 	Only         []string
+	PrintVars    bool
+}
+
+// UserVarSource is the value of a user variable along with where it was
+// set.
+type UserVarSource struct {
+	Value string
+
+	// Source is where the value was set, such as "-var", the path of a
+	// variable file, or "env PACKER_VAR_aws_region".
+	Source string
+
+	// Optional is true if the value comes from a source that is shared by
+	// many templates, so that it is only used if the template defines the
+	// variable.
+	Optional bool
 }
 
 // Validate validates the options
@@ -61,6 +90,150 @@ func (f *BuildOptions) AllUserVars() (map[string]string, error) {
 	return all, nil
 }
 
+// UserVarSources returns the user variables for the template at the given
+// path along with where they were set. From lowest to highest precedence,
+// the sources are:
+//
+//  1. Environmental variables starting with UserVarEnvPrefix.
+//  2. Variable files next to the template ending in ".auto.json",
+//     ".auto.yaml" or ".auto.yml", in alphabetical order.
+//  3. Variable files from -var-file, in the order given.
+//  4. Variables from -var.
+//
+// Variables from the first two sources are optional.
+func (f *BuildOptions) UserVarSources(templatePath string) (map[string]UserVarSource, error) {
+	result := make(map[string]UserVarSource)
+
+	for _, env := range os.Environ() {
+		if !strings.HasPrefix(env, UserVarEnvPrefix) {
+			continue
+		}
+
+		idx := strings.Index(env, "=")
+		name := env[len(UserVarEnvPrefix):idx]
+		if name == "" {
+			continue
+		}
+
+		result[name] = UserVarSource{
+			Value:    env[idx+1:],
+			Source:   "env " + env[:idx],
+			Optional: true,
+		}
+	}
+
+	autoFiles, err := autoVarFiles(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := append(autoFiles, f.UserVarFiles...)
+	for i, path := range files {
+		fileVars, err := readFileVars(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+
+		for k, v := range fileVars {
+			result[k] = UserVarSource{
+				Value:    v,
+				Source:   path,
+				Optional: i < len(autoFiles),
+			}
+		}
+	}
+
+	for k, v := range f.UserVars {
+		result[k] = UserVarSource{Value: v, Source: "-var"}
+	}
+
+	return result, nil
+}
+
+// ParseTemplate parses the template at the given path with the user
+// variables from UserVarSources.
+func (f *BuildOptions) ParseTemplate(path string, sources map[string]UserVarSource) (*packer.Template, error) {
+	vars := make(map[string]string)
+	optionalVars := make(map[string]string)
+	for k, v := range sources {
+		if v.Optional {
+			optionalVars[k] = v.Value
+		} else {
+			vars[k] = v.Value
+		}
+	}
+
+	return packer.ParseTemplateFileOptionalVars(path, vars, optionalVars)
+}
+
+// PrintVars outputs the final value of each user variable of the template
+// and where it was set, for -print-vars. Values of sensitive variables
+// aren't shown.
+func PrintVars(ui packer.Ui, tpl *packer.Template, sources map[string]UserVarSource) {
+	keys := make([]string, 0, len(tpl.Variables))
+	max := 0
+	for k, _ := range tpl.Variables {
+		keys = append(keys, k)
+		if len(k) > max {
+			max = len(k)
+		}
+	}
+
+	sort.Strings(keys)
+
+	ui.Say("User variables:\n")
+	if len(keys) == 0 {
+		ui.Say("  <No variables>")
+		return
+	}
+
+	for _, k := range keys {
+		v := tpl.Variables[k]
+
+		value, source := v.Default, "default"
+		if s, ok := sources[k]; ok && v.HasValue {
+			value, source = s.Value, s.Source
+		} else if v.Required {
+			value, source = "", "required, not set"
+		}
+
+		if v.Sensitive {
+			value = packer.RedactedValue
+		}
+
+		padding := strings.Repeat(" ", max-len(k))
+		ui.Machine("user-variable", k, value, source)
+		ui.Say(fmt.Sprintf("  %s%s = %s (%s)", k, padding, value, source))
+	}
+}
+
+// autoVarFiles returns the variable files next to the template that are
+// loaded automatically.
+func autoVarFiles(templatePath string) ([]string, error) {
+	if templatePath == "" || templatePath == "-" {
+		return nil, nil
+	}
+
+	dir := filepath.Dir(templatePath)
+	result := make([]string, 0)
+	for _, pattern := range userVarAutoFiles {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, match := range matches {
+			// The template itself is never a variable file
+			if filepath.Clean(match) != filepath.Clean(templatePath) {
+				result = append(result, match)
+			}
+		}
+	}
+
+	sort.Strings(result)
+	return result, nil
+}
+
 // Builds returns the builds out of the given template that pass the
 // configured options.
 func (f *BuildOptions) Builds(t *packer.Template, cf *packer.ComponentFinder) ([]packer.Build, error) {
@@ -136,11 +309,35 @@ func readFileVars(path string) (map[string]string, error) {
 		return nil, err
 	}
 
-	vars := make(map[string]string)
-	err = jsonutil.Unmarshal(bytes, &vars)
+	unmarshal := jsonutil.Unmarshal
+	if yamlutil.IsYAML(path, bytes) {
+		unmarshal = yamlutil.Unmarshal
+	}
+
+	var rawVars map[string]interface{}
+	err = unmarshal(bytes, &rawVars)
 	if err != nil {
 		return nil, err
 	}
 
+	// Variables are always strings, so values of other types such as
+	// lists and maps are passed on as JSON for typed variables to parse.
+	vars := make(map[string]string)
+	for k, v := range rawVars {
+		switch v := v.(type) {
+		case nil:
+			vars[k] = ""
+		case string:
+			vars[k] = v
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+
+			vars[k] = string(data)
+		}
+	}
+
 	return vars, nil
 }
@@ -1,7 +1,12 @@
 package command
 
 import (
+	"bytes"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -144,3 +149,138 @@ func TestBuildOptionsValidate_userVarFiles(t *testing.T) {
 		t.Fatal("should error")
 	}
 }
+
+func TestBuildOptionsAllUserVars(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	jsonPath := filepath.Join(td, "vars.json")
+	yamlPath := filepath.Join(td, "vars.yml")
+	ioutil.WriteFile(jsonPath, []byte(`{"a": "json", "b": "json"}`), 0644)
+	ioutil.WriteFile(yamlPath, []byte("# Comment\nb: yaml\nc: yaml\nd: [1, x]\ne: {f: true}\n"), 0644)
+
+	bf := &BuildOptions{
+		UserVarFiles: []string{jsonPath, yamlPath},
+		UserVars:     map[string]string{"c": "flag"},
+	}
+
+	vars, err := bf.AllUserVars()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		"a": "json",
+		"b": "yaml",
+		"c": "flag",
+		"d": `[1,"x"]`,
+		"e": `{"f":true}`,
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("bad: %#v", vars)
+	}
+}
+
+func TestBuildOptionsUserVarSources(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	tplPath := filepath.Join(td, "template.json")
+	autoPath := filepath.Join(td, "packer.auto.json")
+	varPath := filepath.Join(td, "vars.json")
+	ioutil.WriteFile(tplPath, []byte(`{}`), 0644)
+	ioutil.WriteFile(autoPath, []byte(`{"b": "auto", "c": "auto"}`), 0644)
+	ioutil.WriteFile(varPath, []byte(`{"c": "file", "d": "file"}`), 0644)
+
+	defer os.Setenv(UserVarEnvPrefix+"a", os.Getenv(UserVarEnvPrefix+"a"))
+	defer os.Setenv(UserVarEnvPrefix+"b", os.Getenv(UserVarEnvPrefix+"b"))
+	os.Setenv(UserVarEnvPrefix+"a", "env")
+	os.Setenv(UserVarEnvPrefix+"b", "env")
+
+	bf := &BuildOptions{
+		UserVarFiles: []string{varPath},
+		UserVars:     map[string]string{"d": "flag"},
+	}
+
+	sources, err := bf.UserVarSources(tplPath)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]UserVarSource{
+		"a": UserVarSource{"env", "env PACKER_VAR_a", true},
+		"b": UserVarSource{"auto", autoPath, true},
+		"c": UserVarSource{"file", varPath, false},
+		"d": UserVarSource{"flag", "-var", false},
+	}
+	for k, v := range expected {
+		if sources[k] != v {
+			t.Fatalf("bad: %s: %#v", k, sources[k])
+		}
+	}
+
+	// Templates from stdin have no variable files next to them
+	sources, err = bf.UserVarSources("-")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if sources["b"].Value != "env" {
+		t.Fatalf("bad: %#v", sources["b"])
+	}
+}
+
+func TestBuildOptionsParseTemplate(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	tplPath := filepath.Join(td, "template.json")
+	ioutil.WriteFile(tplPath, []byte(`{
+		"variables": {"a": "default", "b": "default", "password": {"sensitive": true}},
+		"builders": [{"type": "foo"}]
+	}`), 0644)
+
+	bf := new(BuildOptions)
+	sources := map[string]UserVarSource{
+		"a":        UserVarSource{"env", "env PACKER_VAR_a", true},
+		"password": UserVarSource{"hunter2", "-var", false},
+		"other":    UserVarSource{"env", "env PACKER_VAR_other", true},
+	}
+
+	tpl, err := bf.ParseTemplate(tplPath, sources)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if tpl.Variables["a"].Value != "env" {
+		t.Fatalf("bad: %#v", tpl.Variables["a"])
+	}
+
+	out := new(bytes.Buffer)
+	PrintVars(&packer.BasicUi{Reader: new(bytes.Buffer), Writer: out}, tpl, sources)
+
+	expected := `User variables:
+
+  a        = env (env PACKER_VAR_a)
+  b        = default (default)
+  password = <sensitive> (-var)
+`
+	if out.String() != expected {
+		t.Fatalf("bad: %s", out.String())
+	}
+
+	// Variables that the template doesn't define must be set optionally
+	sources["other"] = UserVarSource{"flag", "-var", false}
+	if _, err := bf.ParseTemplate(tplPath, sources); err == nil {
+		t.Fatal("should error")
+	}
+}
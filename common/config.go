@@ -174,11 +174,10 @@ func decodeConfigHook(raws []interface{}) (mapstructure.DecodeHookFunc, error) {
 		}
 	}
 
-	tpl, err := packer.NewConfigTemplate()
+	tpl, err := pc.NewConfigTemplate()
 	if err != nil {
 		return nil, err
 	}
-	tpl.UserVars = pc.PackerUserVars
 
 	return func(f reflect.Kind, t reflect.Kind, v interface{}) (interface{}, error) {
 		if t != reflect.String {
@@ -0,0 +1,217 @@
+package json
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Position is a location within a document. Line and Column start at 1.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Positions returns the position of every value in the JSON document in
+// data. The positions are keyed by the path to the value, such as
+// "builders[0].type", or "" for the document itself. The position of a
+// value in an object is the position of its key.
+func Positions(data []byte) (map[string]Position, error) {
+	s := &positionScanner{
+		data:   data,
+		result: make(map[string]Position),
+	}
+
+	s.lines = append(s.lines, 0)
+	for i, c := range data {
+		if c == '\n' {
+			s.lines = append(s.lines, i+1)
+		}
+	}
+
+	if err := s.value(""); err != nil {
+		return nil, err
+	}
+
+	return s.result, nil
+}
+
+// KeyPath returns the path of the key within the object at path.
+func KeyPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// IndexPath returns the path of the element i of the array at path.
+func IndexPath(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+type positionScanner struct {
+	data   []byte
+	pos    int
+	lines  []int // The offset of the start of each line
+	result map[string]Position
+}
+
+func (s *positionScanner) value(path string) error {
+	s.space()
+	if s.pos >= len(s.data) {
+		return fmt.Errorf("unexpected end of JSON input")
+	}
+
+	s.record(path, s.pos)
+	switch s.data[s.pos] {
+	case '{':
+		s.pos++
+		for {
+			s.space()
+			if s.pos < len(s.data) && s.data[s.pos] == '}' {
+				s.pos++
+				return nil
+			}
+
+			start := s.pos
+			key, err := s.key()
+			if err != nil {
+				return err
+			}
+
+			keyPath := KeyPath(path, key)
+			s.record(keyPath, start)
+
+			s.space()
+			if s.pos >= len(s.data) || s.data[s.pos] != ':' {
+				return s.errorf("expected ':'")
+			}
+
+			s.pos++
+			if err := s.value(keyPath); err != nil {
+				return err
+			}
+
+			if err := s.next('}'); err != nil {
+				return err
+			}
+		}
+	case '[':
+		s.pos++
+		for i := 0; ; i++ {
+			s.space()
+			if s.pos < len(s.data) && s.data[s.pos] == ']' {
+				s.pos++
+				return nil
+			}
+
+			if err := s.value(IndexPath(path, i)); err != nil {
+				return err
+			}
+
+			if err := s.next(']'); err != nil {
+				return err
+			}
+		}
+	case '"':
+		_, err := s.key()
+		return err
+	}
+
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ',', ']', '}', ' ', '\t', '\r', '\n':
+			return nil
+		}
+
+		s.pos++
+	}
+
+	return nil
+}
+
+// key reads a string and returns its value.
+func (s *positionScanner) key() (string, error) {
+	if s.pos >= len(s.data) || s.data[s.pos] != '"' {
+		return "", s.errorf("expected string")
+	}
+
+	start := s.pos
+	for s.pos++; s.pos < len(s.data); s.pos++ {
+		switch s.data[s.pos] {
+		case '\\':
+			s.pos++
+		case '"':
+			s.pos++
+
+			var result string
+			if err := json.Unmarshal(s.data[start:s.pos], &result); err != nil {
+				return "", err
+			}
+
+			return result, nil
+		}
+	}
+
+	return "", s.errorf("unterminated string")
+}
+
+// next skips the "," between values, or stops at the end of the
+// object or array.
+func (s *positionScanner) next(end byte) error {
+	s.space()
+	if s.pos >= len(s.data) {
+		return s.errorf("unexpected end of JSON input")
+	}
+
+	switch s.data[s.pos] {
+	case ',':
+		s.pos++
+		return nil
+	case end:
+		return nil
+	}
+
+	return s.errorf("expected ',' or '%c'", end)
+}
+
+func (s *positionScanner) space() {
+	for s.pos < len(s.data) {
+		switch s.data[s.pos] {
+		case ' ', '\t', '\r', '\n':
+			s.pos++
+		default:
+			return
+		}
+	}
+}
+
+// record stores the position of offset for path, unless a position was
+// already recorded for it.
+func (s *positionScanner) record(path string, offset int) {
+	if _, ok := s.result[path]; !ok {
+		s.result[path] = s.position(offset)
+	}
+}
+
+func (s *positionScanner) position(offset int) Position {
+	line := sort.Search(len(s.lines), func(i int) bool {
+		return s.lines[i] > offset
+	})
+
+	return Position{
+		Line:   line,
+		Column: offset - s.lines[line-1] + 1,
+	}
+}
+
+func (s *positionScanner) errorf(format string, args ...interface{}) error {
+	pos := s.position(s.pos)
+	return fmt.Errorf("Error in line %d, char %d: %s",
+		pos.Line, pos.Column, fmt.Sprintf(format, args...))
+}
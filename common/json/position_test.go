@@ -0,0 +1,51 @@
+package json
+
+import (
+	"testing"
+)
+
+func TestPositions(t *testing.T) {
+	data := `{
+  "builders": [
+    {"type": "foo"},
+    {
+      "type": "bar",
+      "tags": {"a\"b": [1, 2]}
+    }
+  ]
+}`
+
+	positions, err := Positions([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]Position{
+		"":                         {1, 1},
+		"builders":                 {2, 3},
+		"builders[0]":              {3, 5},
+		"builders[0].type":         {3, 6},
+		"builders[1]":              {4, 5},
+		"builders[1].type":         {5, 7},
+		"builders[1].tags":         {6, 7},
+		"builders[1].tags.a\"b":    {6, 16},
+		"builders[1].tags.a\"b[1]": {6, 28},
+	}
+
+	for path, pos := range expected {
+		if positions[path] != pos {
+			t.Fatalf("bad %s: %s", path, positions[path])
+		}
+	}
+}
+
+func TestPositions_error(t *testing.T) {
+	_, err := Positions([]byte("{\n  \"a\" 1\n}"))
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if err.Error() != "Error in line 2, char 7: expected ':'" {
+		t.Fatalf("bad: %s", err)
+	}
+}
@@ -0,0 +1,210 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// The key in the state bag that is set once the user chose to abort
+// after a step failed, so that no more steps are cleaned up.
+const stateAborted = "packer_aborted"
+
+// NewRunner returns a multistep.Runner for the steps of a builder that
+// sends an event to the UI when each step starts and ends, pauses between
+// steps in debug mode, and handles a failing step the way the user asked
+// for with the "packer_on_error" configuration key.
+func NewRunner(steps []multistep.Step, config PackerConfig, ui packer.Ui) multistep.Runner {
+	var pauseFn multistep.DebugPauseFn
+	if config.PackerDebug {
+		pauseFn = MultistepDebugFn(ui)
+	}
+
+	// The steps are wrapped rather than run by a multistep.DebugRunner,
+	// since its pauses would be named after the wrapper.
+	wrapped := make([]multistep.Step, len(steps))
+	for i, step := range steps {
+		wrapped[i] = &runnerStep{
+			Step:    step,
+			Name:    reflect.Indirect(reflect.ValueOf(step)).Type().Name(),
+			OnError: config.PackerOnError,
+			PauseFn: pauseFn,
+			Ui:      ui,
+		}
+	}
+
+	return &multistep.BasicRunner{Steps: wrapped}
+}
+
+// runnerStep wraps a step for NewRunner. If the step fails, it either
+// cleans up as usual, aborts without cleaning up or asks the user whether
+// to retry the step, clean up or abort.
+type runnerStep struct {
+	Step    multistep.Step
+	Name    string
+	OnError string
+	PauseFn multistep.DebugPauseFn
+	Ui      packer.Ui
+
+	paused bool
+}
+
+func (s *runnerStep) Run(state multistep.StateBag) multistep.StepAction {
+	for {
+		// The step is given its own error so that a failed attempt
+		// doesn't fail the build if a retry succeeds.
+		attemptState := &errorStateBag{StateBag: state}
+		action := s.run(attemptState)
+		if action == multistep.ActionContinue {
+			if s.PauseFn != nil {
+				s.PauseFn(multistep.DebugLocationAfterRun, s.Name, state)
+				s.paused = true
+			}
+
+			return action
+		}
+
+		err := attemptState.err
+		if _, ok := state.GetOk(multistep.StateCancelled); ok {
+			// Cancelled builds are always cleaned up
+			s.putError(state, err)
+			return action
+		}
+
+		choice := s.OnError
+		if choice == packer.OnErrorAsk {
+			choice = s.ask(err)
+		}
+
+		switch choice {
+		case "retry":
+			log.Printf("Retrying step '%s'", s.Name)
+			continue
+		case packer.OnErrorAbort:
+			s.abort(state)
+		}
+
+		s.putError(state, err)
+		return action
+	}
+}
+
+// run runs the step once, sending events for its start and end.
+func (s *runnerStep) run(state *errorStateBag) multistep.StepAction {
+	start := time.Now()
+	s.Ui.Event(packer.NewEvent(packer.EventStepStart, s.Name))
+
+	action := s.Step.Run(state)
+
+	err := state.err
+	if action == multistep.ActionHalt && err == nil {
+		err = errors.New("Step halted")
+		if _, ok := state.GetOk(multistep.StateCancelled); ok {
+			err = errors.New("Step cancelled")
+		}
+	}
+
+	s.Ui.Event(packer.NewEndEvent(packer.EventStepEnd, s.Name, start, err))
+	return action
+}
+
+func (s *runnerStep) Cleanup(state multistep.StateBag) {
+	if _, ok := state.GetOk(stateAborted); ok {
+		log.Printf("Aborted, skipping cleanup of step '%s'", s.Name)
+		return
+	}
+
+	if s.paused {
+		s.PauseFn(multistep.DebugLocationBeforeCleanup, s.Name, state)
+	}
+
+	s.Step.Cleanup(state)
+}
+
+// ask asks the user what to do about the failed step until a valid
+// choice is made. If the user can't be asked, everything is cleaned up.
+func (s *runnerStep) ask(err error) string {
+	message := fmt.Sprintf("Step '%s' failed", s.Name)
+	if err != nil {
+		message = fmt.Sprintf("%s: %s", message, err)
+	}
+	s.Ui.Error(message)
+
+	for {
+		line, err := s.Ui.Ask(
+			"[c] Clean up and exit, [a] abort without cleanup, or [r] retry step (default: c):")
+		if err != nil {
+			log.Printf("Error asking for input, cleaning up: %s", err)
+			return packer.OnErrorCleanup
+		}
+
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "", "c":
+			return packer.OnErrorCleanup
+		case "a":
+			return packer.OnErrorAbort
+		case "r":
+			return "retry"
+		}
+
+		s.Ui.Error(fmt.Sprintf("Invalid choice: %s", line))
+	}
+}
+
+// abort marks the build as aborted so that nothing is cleaned up, and
+// shows what the user needs to inspect the machine.
+func (s *runnerStep) abort(state multistep.StateBag) {
+	state.Put(stateAborted, true)
+
+	s.Ui.Error(fmt.Sprintf(
+		"Step '%s' failed. Aborting without cleanup, so anything that was "+
+			"created by the build must be cleaned up by hand.", s.Name))
+
+	if address, ok := state.GetOk("ssh_address"); ok {
+		s.Ui.Message(fmt.Sprintf("SSH address: %s", address))
+		if username, ok := state.GetOk("ssh_username"); ok {
+			s.Ui.Message(fmt.Sprintf("SSH username: %s", username))
+		}
+	}
+}
+
+func (s *runnerStep) putError(state multistep.StateBag, err error) {
+	if err != nil {
+		state.Put("error", err)
+	}
+}
+
+// errorStateBag is a multistep.StateBag that keeps the error of a step
+// to itself, passing everything else through to the real state bag.
+type errorStateBag struct {
+	multistep.StateBag
+
+	err error
+}
+
+func (b *errorStateBag) Get(k string) interface{} {
+	result, _ := b.GetOk(k)
+	return result
+}
+
+func (b *errorStateBag) GetOk(k string) (interface{}, bool) {
+	if k == "error" {
+		return b.err, b.err != nil
+	}
+
+	return b.StateBag.GetOk(k)
+}
+
+func (b *errorStateBag) Put(k string, v interface{}) {
+	if k == "error" {
+		b.err, _ = v.(error)
+		return
+	}
+
+	b.StateBag.Put(k, v)
+}
@@ -0,0 +1,185 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/packer"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testRunnerStep is a step that fails the given number of times before
+// it succeeds.
+type testRunnerStep struct {
+	Failures int
+
+	runs     int
+	cleanups int
+}
+
+func (s *testRunnerStep) Run(state multistep.StateBag) multistep.StepAction {
+	s.runs++
+	if s.runs <= s.Failures {
+		state.Put("error", errors.New("failed"))
+		return multistep.ActionHalt
+	}
+
+	return multistep.ActionContinue
+}
+
+func (s *testRunnerStep) Cleanup(multistep.StateBag) {
+	s.cleanups++
+}
+
+func testRunnerUi(input string) (packer.Ui, *bytes.Buffer) {
+	out := new(bytes.Buffer)
+	return &packer.BasicUi{
+		Reader: strings.NewReader(input),
+		Writer: out,
+	}, out
+}
+
+func testRunnerRun(onError string, ui packer.Ui, steps ...multistep.Step) multistep.StateBag {
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", ui)
+
+	config := PackerConfig{PackerOnError: onError}
+	NewRunner(steps, config, ui).Run(state)
+	return state
+}
+
+func TestNewRunner_cleanup(t *testing.T) {
+	first := new(testRunnerStep)
+	failed := &testRunnerStep{Failures: 1}
+	ui, _ := testRunnerUi("")
+
+	state := testRunnerRun(packer.OnErrorCleanup, ui, first, failed)
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+	if first.cleanups != 1 || failed.cleanups != 1 {
+		t.Fatalf("bad: %d %d", first.cleanups, failed.cleanups)
+	}
+}
+
+func TestNewRunner_abort(t *testing.T) {
+	first := new(testRunnerStep)
+	failed := &testRunnerStep{Failures: 1}
+	ui, out := testRunnerUi("")
+
+	state := testRunnerRun(packer.OnErrorAbort, ui, first, failed)
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+	if first.cleanups != 0 || failed.cleanups != 0 {
+		t.Fatalf("bad: %d %d", first.cleanups, failed.cleanups)
+	}
+	if !strings.Contains(out.String(), "Aborting without cleanup") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestNewRunner_abortConnectionInfo(t *testing.T) {
+	ui, out := testRunnerUi("")
+
+	state := new(multistep.BasicStateBag)
+	state.Put("ssh_address", "127.0.0.1:22")
+	state.Put("ssh_username", "root")
+	config := PackerConfig{PackerOnError: packer.OnErrorAbort}
+	NewRunner([]multistep.Step{&testRunnerStep{Failures: 1}}, config, ui).Run(state)
+
+	if !strings.Contains(out.String(), "SSH address: 127.0.0.1:22") {
+		t.Fatalf("bad: %s", out.String())
+	}
+	if !strings.Contains(out.String(), "SSH username: root") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestNewRunner_askRetry(t *testing.T) {
+	first := new(testRunnerStep)
+	failed := &testRunnerStep{Failures: 2}
+	ui, _ := testRunnerUi("r\nr\n")
+
+	state := testRunnerRun(packer.OnErrorAsk, ui, first, failed)
+	if err, ok := state.GetOk("error"); ok {
+		t.Fatalf("err: %s", err)
+	}
+	if failed.runs != 3 {
+		t.Fatalf("bad: %d", failed.runs)
+	}
+	if first.cleanups != 1 || failed.cleanups != 1 {
+		t.Fatalf("bad: %d %d", first.cleanups, failed.cleanups)
+	}
+}
+
+func TestNewRunner_askCleanup(t *testing.T) {
+	first := new(testRunnerStep)
+	failed := &testRunnerStep{Failures: 1}
+	ui, out := testRunnerUi("nope\nc\n")
+
+	state := testRunnerRun(packer.OnErrorAsk, ui, first, failed)
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+	if first.cleanups != 1 || failed.cleanups != 1 {
+		t.Fatalf("bad: %d %d", first.cleanups, failed.cleanups)
+	}
+	if !strings.Contains(out.String(), "Invalid choice: nope") {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestNewRunner_askAbort(t *testing.T) {
+	first := new(testRunnerStep)
+	failed := &testRunnerStep{Failures: 1}
+	ui, _ := testRunnerUi("a\n")
+
+	state := testRunnerRun(packer.OnErrorAsk, ui, first, failed)
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+	if first.cleanups != 0 || failed.cleanups != 0 {
+		t.Fatalf("bad: %d %d", first.cleanups, failed.cleanups)
+	}
+}
+
+func TestNewRunner_events(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ui := &packer.JsonUi{Writer: buf}
+
+	state := testRunnerRun(packer.OnErrorCleanup, ui,
+		new(testRunnerStep), &testRunnerStep{Failures: 1})
+	if _, ok := state.GetOk("error"); !ok {
+		t.Fatal("should have error")
+	}
+
+	var types, errs []string
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event struct {
+			Type  string
+			Name  string
+			Error string
+		}
+		if err := json.Unmarshal([]byte(line), &event); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if event.Name != "testRunnerStep" {
+			t.Fatalf("bad: %s", line)
+		}
+
+		types = append(types, event.Type)
+		errs = append(errs, event.Error)
+	}
+
+	expected := []string{"step-start", "step-end", "step-start", "step-end"}
+	if !reflect.DeepEqual(types, expected) {
+		t.Fatalf("bad: %#v", types)
+	}
+	if !reflect.DeepEqual(errs, []string{"", "", "", "failed"}) {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
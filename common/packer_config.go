@@ -1,12 +1,37 @@
 package common
 
+import (
+	"github.com/mitchellh/packer/packer"
+	"path/filepath"
+)
+
 // PackerConfig is a struct that contains the configuration keys that
 // are sent by packer, properly tagged already so mapstructure can load
 // them. Embed this structure into your configuration class to get it.
 type PackerConfig struct {
-	PackerBuildName   string            `mapstructure:"packer_build_name"`
-	PackerBuilderType string            `mapstructure:"packer_builder_type"`
-	PackerDebug       bool              `mapstructure:"packer_debug"`
-	PackerForce       bool              `mapstructure:"packer_force"`
-	PackerUserVars    map[string]string `mapstructure:"packer_user_variables"`
+	PackerBuildName    string            `mapstructure:"packer_build_name"`
+	PackerBuilderType  string            `mapstructure:"packer_builder_type"`
+	PackerDebug        bool              `mapstructure:"packer_debug"`
+	PackerForce        bool              `mapstructure:"packer_force"`
+	PackerOnError      string            `mapstructure:"packer_on_error"`
+	PackerTemplatePath string            `mapstructure:"packer_template_path"`
+	PackerUserVars     map[string]string `mapstructure:"packer_user_variables"`
+}
+
+// NewConfigTemplate returns a new configuration template processor with
+// the user variables and build information from this configuration.
+func (c *PackerConfig) NewConfigTemplate() (*packer.ConfigTemplate, error) {
+	tpl, err := packer.NewConfigTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	tpl.UserVars = c.PackerUserVars
+	tpl.BuildName = c.PackerBuildName
+	tpl.BuildType = c.PackerBuilderType
+	if c.PackerTemplatePath != "" {
+		tpl.TemplateDir = filepath.Dir(c.PackerTemplatePath)
+	}
+
+	return tpl, nil
 }
@@ -0,0 +1,30 @@
+package common
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestPackerConfigNewConfigTemplate(t *testing.T) {
+	c := &PackerConfig{
+		PackerBuildName:    "web",
+		PackerBuilderType:  "amazon-ebs",
+		PackerTemplatePath: filepath.Join("templates", "web.json"),
+		PackerUserVars:     map[string]string{"foo": "bar"},
+	}
+
+	tpl, err := c.NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := tpl.Process(
+		`{{build_name}} {{build_type}} {{template_dir}} {{user "foo"}}`, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "web amazon-ebs templates bar" {
+		t.Fatalf("bad: %s", result)
+	}
+}
@@ -0,0 +1,187 @@
+package secret
+
+import (
+	"bytes"
+	"code.google.com/p/go.crypto/pbkdf2"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+// The environmental variables that hold the password for encrypted files,
+// either directly or in a file.
+const (
+	PasswordEnvVar     = "PACKER_SECRET_PASSWORD"
+	PasswordFileEnvVar = "PACKER_SECRET_PASSWORD_FILE"
+)
+
+// The format of encrypted files is the one written by
+// "openssl enc -aes-256-cbc -pbkdf2 -salt": a header, an 8 byte salt and
+// the ciphertext. The key and IV are derived from the password with
+// PBKDF2 using SHA-256.
+//
+// The format isn't authenticated, so a wrong password is only noticed by
+// the padding being invalid, which random data passes about 1 in 256
+// times. Secrets are text, so lookupFile also rejects contents that
+// aren't valid UTF-8, which catches nearly all of the rest.
+const (
+	saltHeader       = "Salted__"
+	saltLen          = 8
+	pbkdf2Iterations = 10000
+)
+
+// lookupFile returns a secret from a local encrypted file. The path is the
+// file, optionally followed by "#" and the key of the secret within the
+// JSON object in the file, with "/" between nested keys. Without a key,
+// the whole file is the secret.
+func lookupFile(path string) (string, error) {
+	file, key := path, ""
+	if idx := strings.LastIndex(path, "#"); idx > -1 {
+		file, key = path[:idx], path[idx+1:]
+	}
+
+	password, err := filePassword()
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	data, err = Decrypt(data, password)
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", file, err)
+	}
+
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("%s: wrong password or corrupt file", file)
+	}
+
+	if key == "" {
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return "", fmt.Errorf("%s: contents aren't a JSON object: %s", file, err)
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("%s: '%s' not found", file, key)
+		}
+
+		if value, ok = m[part]; !ok {
+			return "", fmt.Errorf("%s: '%s' not found", file, key)
+		}
+	}
+
+	// Values other than strings are returned as JSON so that they can be
+	// used for typed variables, such as lists.
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+
+	result, err := json.Marshal(value)
+	return string(result), err
+}
+
+func filePassword() (string, error) {
+	if password := os.Getenv(PasswordEnvVar); password != "" {
+		return password, nil
+	}
+
+	if path := os.Getenv(PasswordFileEnvVar); path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	return "", fmt.Errorf(
+		"a password is needed for encrypted files. Set %s or %s.",
+		PasswordEnvVar, PasswordFileEnvVar)
+}
+
+// Decrypt decrypts the contents of an encrypted file with the password.
+// A wrong password isn't always detected, see the format above.
+func Decrypt(data []byte, password string) ([]byte, error) {
+	if len(data) < len(saltHeader)+saltLen || string(data[:len(saltHeader)]) != saltHeader {
+		return nil, errors.New("not an encrypted file")
+	}
+
+	salt := data[len(saltHeader) : len(saltHeader)+saltLen]
+	data = data[len(saltHeader)+saltLen:]
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("encrypted data has a bad length")
+	}
+
+	mode, err := fileCipher(password, salt, false)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]byte, len(data))
+	mode.CryptBlocks(result, data)
+
+	// Remove the PKCS#7 padding, which is also the only way that a wrong
+	// password can be noticed here.
+	padding := int(result[len(result)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(result[len(result)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, errors.New("wrong password or corrupt file")
+	}
+
+	return result[:len(result)-padding], nil
+}
+
+// Encrypt encrypts data with the password into the format that Decrypt
+// reads.
+func Encrypt(data []byte, password string) ([]byte, error) {
+	salt := make([]byte, saltLen)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+
+	mode, err := fileCipher(password, salt, true)
+	if err != nil {
+		return nil, err
+	}
+
+	padding := aes.BlockSize - len(data)%aes.BlockSize
+	plain := append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+
+	result := make([]byte, len(saltHeader)+saltLen+len(plain))
+	copy(result, saltHeader)
+	copy(result[len(saltHeader):], salt)
+	mode.CryptBlocks(result[len(saltHeader)+saltLen:], plain)
+	return result, nil
+}
+
+func fileCipher(password string, salt []byte, encrypt bool) (cipher.BlockMode, error) {
+	keyIv := pbkdf2.Key([]byte(password), salt, pbkdf2Iterations, 32+aes.BlockSize, sha256.New)
+	block, err := aes.NewCipher(keyIv[:32])
+	if err != nil {
+		return nil, err
+	}
+
+	if encrypt {
+		return cipher.NewCBCEncrypter(block, keyIv[32:]), nil
+	}
+
+	return cipher.NewCBCDecrypter(block, keyIv[32:]), nil
+}
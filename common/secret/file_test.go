@@ -0,0 +1,108 @@
+package secret
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testOpenSSLFile is the JSON below, encrypted by "openssl enc -aes-256-cbc
+// -pbkdf2 -salt" with the password "hunter2" and encoded as base64.
+const testOpenSSLFile = "U2FsdGVkX184a+Ma/Hvu9G83O7k44UJYBARlsDbz5lnVnxYAfmq8kh03HeYOsKq5qiFkC138lmC44bWizJIqQzOtniM9NhF50gc7w28vD0c="
+
+func TestDecrypt_openssl(t *testing.T) {
+	data, err := base64.StdEncoding.DecodeString(testOpenSSLFile)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := Decrypt(data, "hunter2")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := `{"aws": {"secret_key": "s3cr3t"}, "token": "abc"}` + "\n"
+	if string(result) != expected {
+		t.Fatalf("bad: %q", result)
+	}
+
+	if _, err := Decrypt(data, "wrong"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	for _, input := range []string{"", "foo", "exactly 16 bytes"} {
+		data, err := Encrypt([]byte(input), "hunter2")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		result, err := Decrypt(data, "hunter2")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if string(result) != input {
+			t.Fatalf("bad: %q", result)
+		}
+	}
+}
+
+func TestLookupFile(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	files := map[string]string{
+		"secrets.enc": `{"aws": {"secret_key": "s3cr3t"}, "ports": [22, 80]}`,
+		"token.enc":   "abc\n",
+		"binary.enc":  "\xff\xfe",
+	}
+
+	for name, contents := range files {
+		data, err := Encrypt([]byte(contents), "hunter2")
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(td, name), data, 0600); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	defer os.Setenv(PasswordEnvVar, os.Getenv(PasswordEnvVar))
+	os.Setenv(PasswordEnvVar, "hunter2")
+
+	cases := map[string]string{
+		"secrets.enc#aws/secret_key": "s3cr3t",
+		"secrets.enc#ports":          "[22,80]",
+		"token.enc":                  "abc",
+	}
+
+	for path, expected := range cases {
+		actual, err := lookupFile(filepath.Join(td, path))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if actual != expected {
+			t.Fatalf("bad %s: %s", path, actual)
+		}
+	}
+
+	for _, path := range []string{"secrets.enc#aws/nope", "secrets.enc#ports/a", "nope.enc", "binary.enc"} {
+		if _, err := lookupFile(filepath.Join(td, path)); err == nil {
+			t.Fatalf("should have error: %s", path)
+		}
+	}
+
+	os.Setenv(PasswordEnvVar, "wrong")
+	if _, err := lookupFile(filepath.Join(td, "token.enc")); err == nil {
+		t.Fatal("should have error")
+	}
+}
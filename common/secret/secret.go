@@ -0,0 +1,119 @@
+// Package secret looks up the values for the "secret" function that can
+// be used in the default values of user variables. Secrets come either
+// from local encrypted files or from an external helper command.
+package secret
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// HelperEnvVar is the environmental variable that holds the helper
+// command that is used to look up secrets that aren't in encrypted files.
+// The command is run by the shell, so it can have quoted arguments.
+const HelperEnvVar = "PACKER_SECRET_HELPER"
+
+// FilePrefix starts the paths of secrets in local encrypted files, such
+// as "file:secrets.json.enc#aws/secret_key".
+const FilePrefix = "file:"
+
+// HelperRequest is what a helper command is given on stdin.
+type HelperRequest struct {
+	Path string `json:"path"`
+}
+
+// HelperResponse is what a helper command writes to stdout.
+type HelperResponse struct {
+	Value string `json:"value"`
+	Error string `json:"error"`
+}
+
+var cache struct {
+	sync.Mutex
+	values map[string]string
+}
+
+// Lookup is a packer.SecretFunc that returns the secret at the given
+// path. Paths that start with FilePrefix are read from local encrypted
+// files and all others are looked up with the helper command. Secrets
+// are only looked up once for each path.
+func Lookup(path string) (string, error) {
+	cache.Lock()
+	defer cache.Unlock()
+
+	if v, ok := cache.values[path]; ok {
+		return v, nil
+	}
+
+	var v string
+	var err error
+	if strings.HasPrefix(path, FilePrefix) {
+		v, err = lookupFile(path[len(FilePrefix):])
+	} else {
+		v, err = lookupHelper(os.Getenv(HelperEnvVar), path)
+	}
+
+	if err != nil {
+		return "", fmt.Errorf("Error looking up secret '%s': %s", path, err)
+	}
+
+	if cache.values == nil {
+		cache.values = make(map[string]string)
+	}
+
+	cache.values[path] = v
+	return v, nil
+}
+
+// lookupHelper runs the helper command, giving it a HelperRequest for the
+// path on stdin and reading a HelperResponse from stdout.
+func lookupHelper(helper string, path string) (string, error) {
+	if strings.TrimSpace(helper) == "" {
+		return "", fmt.Errorf(
+			"no secret helper is configured. Set %s or use a '%s' path.",
+			HelperEnvVar, FilePrefix)
+	}
+
+	input, err := json.Marshal(&HelperRequest{Path: path})
+	if err != nil {
+		return "", err
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := helperCommand(helper)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("helper failed: %s\n\nStderr: %s",
+			err, strings.TrimSpace(stderr.String()))
+	}
+
+	var response HelperResponse
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return "", fmt.Errorf("helper output is invalid: %s", err)
+	}
+
+	if response.Error != "" {
+		return "", errors.New(response.Error)
+	}
+
+	return response.Value, nil
+}
+
+// helperCommand returns the *exec.Cmd that runs the helper command with
+// the shell of the host.
+func helperCommand(helper string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", helper)
+	}
+
+	return exec.Command("/bin/sh", "-c", helper)
+}
@@ -0,0 +1,119 @@
+package secret
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testHelper(t *testing.T, script string) string {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh isn't available")
+	}
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	path := filepath.Join(td, "helper")
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return path
+}
+
+func TestLookupHelper(t *testing.T) {
+	helper := testHelper(t, `
+read input
+case "$input" in
+  *'"path":"db/password"'*) echo '{"value": "s3cr3t"}' ;;
+  *) echo '{"error": "not found"}' ;;
+esac
+`)
+	defer os.RemoveAll(filepath.Dir(helper))
+
+	value, err := lookupHelper(helper, "db/password")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value != "s3cr3t" {
+		t.Fatalf("bad: %s", value)
+	}
+
+	_, err = lookupHelper(helper, "db/other")
+	if err == nil || err.Error() != "not found" {
+		t.Fatalf("bad: %s", err)
+	}
+
+	if _, err := lookupHelper("", "db/password"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestLookupHelper_quoted(t *testing.T) {
+	helper := testHelper(t, "cat >/dev/null\necho '{\"value\": \"'\"$1\"'\"}'\n")
+	defer os.RemoveAll(filepath.Dir(helper))
+
+	// Move the helper to a directory with a space in its name
+	dir := filepath.Join(filepath.Dir(helper), "my helpers")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := os.Rename(helper, filepath.Join(dir, "helper")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	command := fmt.Sprintf("'%s' 'first arg'", filepath.Join(dir, "helper"))
+	value, err := lookupHelper(command, "foo")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if value != "first arg" {
+		t.Fatalf("bad: %s", value)
+	}
+}
+
+func TestLookupHelper_fail(t *testing.T) {
+	helper := testHelper(t, "echo 'access denied' >&2\nexit 1\n")
+	defer os.RemoveAll(filepath.Dir(helper))
+
+	_, err := lookupHelper(helper, "foo")
+	if err == nil || !strings.Contains(err.Error(), "access denied") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	helper := testHelper(t, "cat >/dev/null\necho '{\"value\": \"'$$'\"}'\n")
+	defer os.RemoveAll(filepath.Dir(helper))
+
+	defer os.Setenv(HelperEnvVar, os.Getenv(HelperEnvVar))
+	os.Setenv(HelperEnvVar, helper)
+
+	// The helper returns its process ID, so a second lookup for the same
+	// path should come from the cache.
+	first, err := Lookup("lookup/cached")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	second, err := Lookup("lookup/cached")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if first == "" || first != second {
+		t.Fatalf("bad: %s %s", first, second)
+	}
+
+	_, err = Lookup("file:/nonexistent.enc")
+	if err == nil || !strings.Contains(err.Error(), "file:/nonexistent.enc") {
+		t.Fatalf("bad: %s", err)
+	}
+}
@@ -0,0 +1,11 @@
+package common
+
+import (
+	"strings"
+)
+
+// ShellQuote quotes a string so that it is a single word for sh, with no
+// characters in it that are special to the shell.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
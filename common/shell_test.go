@@ -0,0 +1,22 @@
+package common
+
+import (
+	"testing"
+)
+
+func TestShellQuote(t *testing.T) {
+	cases := map[string]string{
+		"":             "''",
+		"foo":          "'foo'",
+		"/tmp/a b":     "'/tmp/a b'",
+		"$(rm -rf /)":  "'$(rm -rf /)'",
+		"it's":         `'it'\''s'`,
+		"a\"b`c`;d\\e": "'a\"b`c`;d\\e'",
+	}
+
+	for input, expected := range cases {
+		if actual := ShellQuote(input); actual != expected {
+			t.Fatalf("bad %q: %s", input, actual)
+		}
+	}
+}
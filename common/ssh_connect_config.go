@@ -0,0 +1,248 @@
+package common
+
+import (
+	gossh "code.google.com/p/go.crypto/ssh"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/ssh"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+)
+
+// SSHConnectConfig contains the configuration keys that control how the
+// SSH communicator connects to a machine and transfers files to it. Embed
+// this structure into the configuration of a builder that uses
+// StepConnectSSH.
+type SSHConnectConfig struct {
+	SSHFileTransferMethod string `mapstructure:"ssh_file_transfer_method"`
+
+	SSHBastionHost           string `mapstructure:"ssh_bastion_host"`
+	SSHBastionPort           int    `mapstructure:"ssh_bastion_port"`
+	SSHBastionUsername       string `mapstructure:"ssh_bastion_username"`
+	SSHBastionPassword       string `mapstructure:"ssh_bastion_password"`
+	SSHBastionPrivateKeyFile string `mapstructure:"ssh_bastion_private_key_file"`
+	SSHBastionHostKey        string `mapstructure:"ssh_bastion_host_key"`
+
+	SSHHostKey         string `mapstructure:"ssh_host_key"`
+	SSHKnownHostsFile  string `mapstructure:"ssh_known_hosts_file"`
+	SSHTrustOnFirstUse bool   `mapstructure:"ssh_trust_on_first_use"`
+
+	hostKey        *ssh.FixedHostKeyChecker
+	bastionHostKey *ssh.FixedHostKeyChecker
+	knownHosts     *ssh.KnownHostsChecker
+}
+
+func (c *SSHConnectConfig) Prepare(t *packer.ConfigTemplate) []error {
+	if c.SSHFileTransferMethod == "" {
+		c.SSHFileTransferMethod = "scp"
+	}
+
+	templates := map[string]*string{
+		"ssh_file_transfer_method":     &c.SSHFileTransferMethod,
+		"ssh_bastion_host":             &c.SSHBastionHost,
+		"ssh_bastion_username":         &c.SSHBastionUsername,
+		"ssh_bastion_password":         &c.SSHBastionPassword,
+		"ssh_bastion_private_key_file": &c.SSHBastionPrivateKeyFile,
+		"ssh_bastion_host_key":         &c.SSHBastionHostKey,
+		"ssh_host_key":                 &c.SSHHostKey,
+		"ssh_known_hosts_file":         &c.SSHKnownHostsFile,
+	}
+
+	errs := make([]error, 0)
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = t.Process(*ptr, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	if c.SSHFileTransferMethod != "scp" && c.SSHFileTransferMethod != "sftp" {
+		errs = append(errs, fmt.Errorf(
+			"ssh_file_transfer_method must be 'scp' or 'sftp', got '%s'",
+			c.SSHFileTransferMethod))
+	}
+
+	if c.SSHBastionHost != "" {
+		if c.SSHBastionPort == 0 {
+			c.SSHBastionPort = 22
+		}
+
+		if c.SSHBastionUsername == "" {
+			errs = append(errs, errors.New(
+				"ssh_bastion_username must be specified with ssh_bastion_host."))
+		}
+
+		if c.SSHBastionPassword == "" && c.SSHBastionPrivateKeyFile == "" {
+			errs = append(errs, errors.New(
+				"ssh_bastion_password or ssh_bastion_private_key_file must be "+
+					"specified with ssh_bastion_host."))
+		}
+
+		if c.SSHBastionPrivateKeyFile != "" {
+			if _, err := sshKeyFileToKeyring(c.SSHBastionPrivateKeyFile); err != nil {
+				errs = append(errs, fmt.Errorf(
+					"ssh_bastion_private_key_file is invalid: %s", err))
+			}
+		}
+
+		if c.SSHBastionHostKey != "" {
+			var err error
+			c.bastionHostKey, err = ssh.ParseHostKey(c.SSHBastionHostKey)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("ssh_bastion_host_key is invalid: %s", err))
+			}
+		}
+	} else if c.SSHBastionHostKey != "" {
+		errs = append(errs, errors.New(
+			"ssh_bastion_host_key can only be specified with ssh_bastion_host."))
+	}
+
+	hostKeySettings := 0
+	if c.SSHHostKey != "" {
+		hostKeySettings++
+
+		var err error
+		c.hostKey, err = ssh.ParseHostKey(c.SSHHostKey)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ssh_host_key is invalid: %s", err))
+		}
+	}
+
+	if c.SSHKnownHostsFile != "" {
+		hostKeySettings++
+
+		data, err := ioutil.ReadFile(c.SSHKnownHostsFile)
+		if err == nil {
+			c.knownHosts, err = ssh.ParseKnownHosts(data)
+		}
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("ssh_known_hosts_file is invalid: %s", err))
+		}
+	}
+
+	if c.SSHTrustOnFirstUse {
+		hostKeySettings++
+	}
+
+	if hostKeySettings > 1 {
+		errs = append(errs, errors.New(
+			"Only one of ssh_host_key, ssh_known_hosts_file or "+
+				"ssh_trust_on_first_use can be specified."))
+	}
+
+	return errs
+}
+
+// HostKeyChecker returns the checker that verifies the host key of the
+// machine, or nil if host keys aren't verified. A new checker is returned
+// for every call, so that keys trusted on first use aren't shared between
+// builds.
+func (c *SSHConnectConfig) HostKeyChecker() gossh.HostKeyChecker {
+	switch {
+	case c.SSHTrustOnFirstUse:
+		return new(ssh.TOFUHostKeyChecker)
+	case c.hostKey != nil:
+		return c.hostKey
+	case c.knownHosts != nil:
+		return c.knownHosts
+	}
+
+	return nil
+}
+
+// BastionHostKeyChecker returns the checker that verifies the host key of
+// the bastion host, or nil if it isn't verified. The bastion host is
+// verified with ssh_bastion_host_key if it is set, and otherwise with the
+// known hosts file or trust on first use, like the machine. A new checker
+// is returned for every call, like HostKeyChecker.
+func (c *SSHConnectConfig) BastionHostKeyChecker() gossh.HostKeyChecker {
+	switch {
+	case c.bastionHostKey != nil:
+		return c.bastionHostKey
+	case c.knownHosts != nil:
+		return c.knownHosts
+	case c.SSHTrustOnFirstUse:
+		return new(ssh.TOFUHostKeyChecker)
+	}
+
+	return nil
+}
+
+// UseSftp returns true if files should be transferred with SFTP.
+func (c *SSHConnectConfig) UseSftp() bool {
+	return c.SSHFileTransferMethod == "sftp"
+}
+
+// SSHBastionAddress returns the TCP address of the bastion host, or an
+// empty string if the machine is connected to directly.
+func (c *SSHConnectConfig) SSHBastionAddress() string {
+	if c.SSHBastionHost == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%d", c.SSHBastionHost, c.SSHBastionPort)
+}
+
+// SSHBastionConfigFunc returns a function that can be given to
+// StepConnectSSH for authenticating with the bastion host. The host key
+// of the bastion host is verified with the checker from
+// BastionHostKeyChecker, which is kept for all of the connections.
+func SSHBastionConfigFunc(c *SSHConnectConfig) func(multistep.StateBag) (*gossh.ClientConfig, error) {
+	hostKeyChecker := c.BastionHostKeyChecker()
+	warned := false
+
+	return func(state multistep.StateBag) (*gossh.ClientConfig, error) {
+		auth := make([]gossh.ClientAuth, 0, 3)
+		if c.SSHBastionPassword != "" {
+			auth = append(auth,
+				gossh.ClientAuthPassword(ssh.Password(c.SSHBastionPassword)),
+				gossh.ClientAuthKeyboardInteractive(
+					ssh.PasswordKeyboardInteractive(c.SSHBastionPassword)))
+		}
+
+		if c.SSHBastionPrivateKeyFile != "" {
+			keyring, err := sshKeyFileToKeyring(c.SSHBastionPrivateKeyFile)
+			if err != nil {
+				return nil, err
+			}
+
+			auth = append(auth, gossh.ClientAuthKeyring(keyring))
+		}
+
+		config := &gossh.ClientConfig{
+			User:           c.SSHBastionUsername,
+			Auth:           auth,
+			HostKeyChecker: hostKeyChecker,
+		}
+
+		// ssh_host_key is the key of the machine only, so make sure
+		// that it isn't mistaken for verifying the bastion host too.
+		if hostKeyChecker == nil && c.hostKey != nil && !warned && state != nil {
+			if ui, ok := state.GetOk("ui"); ok {
+				ui.(packer.Ui).Error(
+					"Warning: The host key of the bastion host isn't verified. " +
+						"Set ssh_bastion_host_key to verify it.")
+				warned = true
+			}
+		}
+
+		return config, nil
+	}
+}
+
+func sshKeyFileToKeyring(path string) (gossh.ClientKeyring, error) {
+	keyBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	keyring := new(ssh.SimpleKeychain)
+	if err := keyring.AddPEMKey(string(keyBytes)); err != nil {
+		return nil, err
+	}
+
+	return keyring, nil
+}
@@ -0,0 +1,284 @@
+package common
+
+import (
+	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/ssh"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func testSSHConnectConfigTemplate(t *testing.T) *packer.ConfigTemplate {
+	result, err := packer.NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return result
+}
+
+func TestSSHConnectConfigPrepare_FileTransferMethod(t *testing.T) {
+	var c SSHConnectConfig
+	errs := c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if c.SSHFileTransferMethod != "scp" {
+		t.Fatalf("bad: %s", c.SSHFileTransferMethod)
+	}
+
+	if c.UseSftp() {
+		t.Fatal("should not use sftp by default")
+	}
+
+	c.SSHFileTransferMethod = "sftp"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if !c.UseSftp() {
+		t.Fatal("should use sftp")
+	}
+
+	c.SSHFileTransferMethod = "ftp"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) == 0 {
+		t.Fatal("should have error")
+	}
+}
+
+func TestSSHConnectConfigPrepare_Bastion(t *testing.T) {
+	var c SSHConnectConfig
+	errs := c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if c.SSHBastionAddress() != "" {
+		t.Fatalf("bad: %s", c.SSHBastionAddress())
+	}
+
+	// Missing username and credentials
+	c.SSHBastionHost = "bastion.example.com"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) != 2 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	c.SSHBastionUsername = "bastion"
+	c.SSHBastionPassword = "secret"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if c.SSHBastionAddress() != "bastion.example.com:22" {
+		t.Fatalf("bad: %s", c.SSHBastionAddress())
+	}
+
+	config, err := SSHBastionConfigFunc(&c)(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if config.User != "bastion" {
+		t.Fatalf("bad: %s", config.User)
+	}
+
+	if len(config.Auth) != 2 {
+		t.Fatalf("bad: %#v", config.Auth)
+	}
+
+	// Bad private key file
+	c.SSHBastionPrivateKeyFile = "/i/dont/exist"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
+
+func TestSSHConnectConfigPrepare_BastionHostKey(t *testing.T) {
+	var c SSHConnectConfig
+	c.SSHBastionHostKey = "ssh-rsa AAAA"
+	errs := c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	c.SSHBastionHost = "bastion.example.com"
+	c.SSHBastionUsername = "bastion"
+	c.SSHBastionPassword = "secret"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	config, err := SSHBastionConfigFunc(&c)(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, ok := config.HostKeyChecker.(*ssh.FixedHostKeyChecker); !ok {
+		t.Fatalf("bad: %#v", config.HostKeyChecker)
+	}
+
+	c.SSHBastionHostKey = "ssh-rsa"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
+
+func TestSSHConnectConfigPrepare_BastionTrustOnFirstUse(t *testing.T) {
+	var c SSHConnectConfig
+	c.SSHBastionHost = "bastion.example.com"
+	c.SSHBastionUsername = "bastion"
+	c.SSHBastionPassword = "secret"
+	c.SSHTrustOnFirstUse = true
+	errs := c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	configFunc := SSHBastionConfigFunc(&c)
+	first, err := configFunc(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	checker, ok := first.HostKeyChecker.(*ssh.TOFUHostKeyChecker)
+	if !ok {
+		t.Fatalf("bad: %#v", first.HostKeyChecker)
+	}
+
+	if checker == c.HostKeyChecker() {
+		t.Fatal("the bastion host should have its own checker")
+	}
+
+	// The key trusted on first use is kept between connections
+	second, err := configFunc(nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if second.HostKeyChecker != checker {
+		t.Fatalf("bad: %#v", second.HostKeyChecker)
+	}
+}
+
+func TestSSHBastionConfigFunc_unverifiedWarning(t *testing.T) {
+	var c SSHConnectConfig
+	c.SSHBastionHost = "bastion.example.com"
+	c.SSHBastionUsername = "bastion"
+	c.SSHBastionPassword = "secret"
+	c.SSHHostKey = "ssh-rsa AAAA"
+	errs := c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	ui, out := testRunnerUi("")
+	state := new(multistep.BasicStateBag)
+	state.Put("ui", ui)
+
+	configFunc := SSHBastionConfigFunc(&c)
+	for i := 0; i < 2; i++ {
+		config, err := configFunc(state)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if config.HostKeyChecker != nil {
+			t.Fatalf("bad: %#v", config.HostKeyChecker)
+		}
+	}
+
+	if strings.Count(out.String(), "ssh_bastion_host_key") != 1 {
+		t.Fatalf("bad: %s", out.String())
+	}
+}
+
+func TestSSHConnectConfigPrepare_HostKey(t *testing.T) {
+	var c SSHConnectConfig
+	errs := c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if c.HostKeyChecker() != nil {
+		t.Fatal("should not check host keys by default")
+	}
+
+	c.SSHHostKey = "ssh-rsa AAAA"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if _, ok := c.HostKeyChecker().(*ssh.FixedHostKeyChecker); !ok {
+		t.Fatalf("bad: %#v", c.HostKeyChecker())
+	}
+
+	c.SSHHostKey = "ssh-rsa"
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
+
+func TestSSHConnectConfigPrepare_KnownHostsFile(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write([]byte("example.com ssh-rsa AAAA\n"))
+	tf.Close()
+
+	var c SSHConnectConfig
+	c.SSHKnownHostsFile = tf.Name()
+	errs := c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	if _, ok := c.HostKeyChecker().(*ssh.KnownHostsChecker); !ok {
+		t.Fatalf("bad: %#v", c.HostKeyChecker())
+	}
+
+	// Can't be combined with trusting on first use
+	c.SSHTrustOnFirstUse = true
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+
+	c.SSHKnownHostsFile = "/i/dont/exist"
+	c.SSHTrustOnFirstUse = false
+	errs = c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) != 1 {
+		t.Fatalf("bad: %#v", errs)
+	}
+}
+
+func TestSSHConnectConfigPrepare_TrustOnFirstUse(t *testing.T) {
+	var c SSHConnectConfig
+	c.SSHTrustOnFirstUse = true
+	errs := c.Prepare(testSSHConnectConfigTemplate(t))
+	if len(errs) > 0 {
+		t.Fatalf("err: %#v", errs)
+	}
+
+	first, ok := c.HostKeyChecker().(*ssh.TOFUHostKeyChecker)
+	if !ok {
+		t.Fatalf("bad: %#v", c.HostKeyChecker())
+	}
+
+	if first == c.HostKeyChecker() {
+		t.Fatal("each call should return a new checker")
+	}
+}
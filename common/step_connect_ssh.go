@@ -8,6 +8,7 @@ import (
 	"github.com/mitchellh/packer/communicator/ssh"
 	"github.com/mitchellh/packer/packer"
 	"log"
+	"net"
 	"strings"
 	"time"
 )
@@ -21,6 +22,8 @@ import (
 //
 // Produces:
 //   communicator packer.Communicator
+//   ssh_address string - The address that SSH connected to.
+//   ssh_username string - The user that SSH connected as.
 type StepConnectSSH struct {
 	// SSHAddress is a function that returns the TCP address to connect to
 	// for SSH. This is a function so that you can query information
@@ -37,6 +40,22 @@ type StepConnectSSH struct {
 	// NoPty, if true, will not request a Pty from the remote end.
 	NoPty bool
 
+	// UseSftp, if true, will transfer files with SFTP instead of SCP.
+	UseSftp bool
+
+	// SSHBastionAddress, if set, is the TCP address of a bastion host
+	// that the SSH connection is tunnelled through.
+	SSHBastionAddress string
+
+	// SSHBastionConfig is a function that returns the client configuration
+	// for the bastion host. It is only used if SSHBastionAddress is set.
+	SSHBastionConfig func(multistep.StateBag) (*gossh.ClientConfig, error)
+
+	// HostKeyChecker, if set, verifies the host key of the machine. If it
+	// trusts the key on first use, the key is stored in the state bag as
+	// "ssh_host_key" once connected.
+	HostKeyChecker gossh.HostKeyChecker
+
 	comm packer.Communicator
 }
 
@@ -70,6 +89,12 @@ WaitLoop:
 			ui.Say("Connected to SSH!")
 			s.comm = comm
 			state.Put("communicator", comm)
+
+			if tofu, ok := s.HostKeyChecker.(*ssh.TOFUHostKeyChecker); ok {
+				hostKey := tofu.HostKey()
+				ui.Message(fmt.Sprintf("Trusted SSH host key: %s", hostKey))
+				state.Put("ssh_host_key", hostKey)
+			}
 			break WaitLoop
 		case <-timeout:
 			err := fmt.Errorf("Timeout waiting for SSH.")
@@ -120,11 +145,45 @@ func (s *StepConnectSSH) waitForSSH(state multistep.StateBag, cancel <-chan stru
 			continue
 		}
 
-		// Attempt to connect to SSH port
+		var hostKeyChecker *hostKeyErrorChecker
+		if s.HostKeyChecker != nil {
+			hostKeyChecker = &hostKeyErrorChecker{
+				HostKeyChecker: ssh.HostKeyCheckerForAddress(s.HostKeyChecker, address),
+			}
+			sshConfig.HostKeyChecker = hostKeyChecker
+		}
+
+		// Attempt to connect to SSH port, going through the bastion
+		// host if there is one.
 		connFunc := ssh.ConnectFunc("tcp", address)
+		var bastionHostKeyChecker *hostKeyErrorChecker
+		if s.SSHBastionAddress != "" {
+			bastionConfig, err := s.SSHBastionConfig(state)
+			if err != nil {
+				log.Printf("Error getting SSH bastion config: %s", err)
+				continue
+			}
+
+			if bastionConfig.HostKeyChecker != nil {
+				bastionHostKeyChecker = &hostKeyErrorChecker{
+					HostKeyChecker: bastionConfig.HostKeyChecker,
+				}
+				bastionConfig.HostKeyChecker = bastionHostKeyChecker
+			}
+
+			connFunc = ssh.BastionConnectFunc(
+				"tcp", s.SSHBastionAddress, bastionConfig, "tcp", address)
+		}
+
 		nc, err := connFunc()
 		if err != nil {
 			log.Printf("TCP connection to SSH ip/port failed: %s", err)
+
+			// The host key of the bastion host is verified here
+			if bastionHostKeyChecker != nil && bastionHostKeyChecker.err != nil {
+				return nil, bastionHostKeyChecker.err
+			}
+
 			continue
 		}
 		nc.Close()
@@ -134,6 +193,7 @@ func (s *StepConnectSSH) waitForSSH(state multistep.StateBag, cancel <-chan stru
 			Connection: connFunc,
 			SSHConfig:  sshConfig,
 			NoPty:      s.NoPty,
+			UseSftp:    s.UseSftp,
 		}
 
 		log.Println("Attempting SSH connection...")
@@ -141,6 +201,18 @@ func (s *StepConnectSSH) waitForSSH(state multistep.StateBag, cancel <-chan stru
 		if err != nil {
 			log.Printf("SSH handshake err: %s", err)
 
+			// A host key that doesn't verify won't get any better by
+			// retrying, so fail right away.
+			if hostKeyErr, ok := err.(*ssh.HostKeyError); ok {
+				return nil, hostKeyErr
+			}
+			if hostKeyChecker != nil && hostKeyChecker.err != nil {
+				return nil, hostKeyChecker.err
+			}
+			if bastionHostKeyChecker != nil && bastionHostKeyChecker.err != nil {
+				return nil, bastionHostKeyChecker.err
+			}
+
 			// Only count this as an attempt if we were able to attempt
 			// to authenticate. Note this is very brittle since it depends
 			// on the string of the error... but I don't see any other way.
@@ -157,8 +229,28 @@ func (s *StepConnectSSH) waitForSSH(state multistep.StateBag, cancel <-chan stru
 			return nil, err
 		}
 
+		state.Put("ssh_address", address)
+		state.Put("ssh_username", sshConfig.User)
 		break
 	}
 
 	return comm, nil
 }
+
+// hostKeyErrorChecker is a gossh.HostKeyChecker that remembers the
+// *ssh.HostKeyError of the checker it wraps, since the SSH library wraps
+// the errors of checkers in its own handshake error.
+type hostKeyErrorChecker struct {
+	gossh.HostKeyChecker
+
+	err *ssh.HostKeyError
+}
+
+func (c *hostKeyErrorChecker) Check(addr string, remote net.Addr, algorithm string, hostKey []byte) error {
+	err := c.HostKeyChecker.Check(addr, remote, algorithm, hostKey)
+	if hostKeyErr, ok := err.(*ssh.HostKeyError); ok {
+		c.err = hostKeyErr
+	}
+
+	return err
+}
@@ -2,6 +2,7 @@ package common
 
 import (
 	"github.com/mitchellh/multistep"
+	"github.com/mitchellh/packer/communicator/ssh"
 	"testing"
 )
 
@@ -12,3 +13,22 @@ func TestStepConnectSSH_Impl(t *testing.T) {
 		t.Fatalf("connect ssh should be a step")
 	}
 }
+
+func TestHostKeyErrorChecker(t *testing.T) {
+	c := &hostKeyErrorChecker{HostKeyChecker: new(ssh.TOFUHostKeyChecker)}
+
+	if err := c.Check("host:22", nil, "ssh-rsa", []byte("key-1")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if c.err != nil {
+		t.Fatalf("bad: %#v", c.err)
+	}
+
+	err := c.Check("host:22", nil, "ssh-rsa", []byte("key-2"))
+	if err == nil {
+		t.Fatal("should have error")
+	}
+	if c.err == nil || c.err.Addr != "host:22" {
+		t.Fatalf("bad: %#v", c.err)
+	}
+}
@@ -0,0 +1,263 @@
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// tarEOFMarker is the line that ends the archive sent to the remote end.
+// The archive is read up to this line rather than until the end of stdin,
+// because closing stdin doesn't end the input of commands run in a pty.
+const tarEOFMarker = "PACKER-TAR-EOF"
+
+// UploadDir uploads the local directory src to the directory dst on the
+// remote machine, with the same trailing slash and exclude rules as
+// packer.Communicator.UploadDir. The directory is streamed as a single tar
+// archive to "tar -x" on the remote end, which is much faster than
+// uploading many small files one by one. If tar isn't available on the
+// remote machine, comm.UploadDir is used instead.
+func UploadDir(comm packer.Communicator, dst string, src string, exclude []string) error {
+	ok, err := remoteHasTar(comm)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		log.Println("tar isn't available on the remote machine, uploading files one by one")
+		return comm.UploadDir(dst, src, exclude)
+	}
+
+	// The archive is base64 encoded, since binary data doesn't survive
+	// being sent through a pty.
+	pr, pw := io.Pipe()
+	errCh := make(chan error, 1)
+	go func() {
+		err := writeTarArchive(pw, src, exclude)
+
+		// Always end the archive, so that the remote command finishes
+		// even if the archive is incomplete.
+		fmt.Fprintf(pw, "\n%s\n", tarEOFMarker)
+		pw.Close()
+		errCh <- err
+	}()
+
+	var output bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: fmt.Sprintf(
+			"mkdir -p %s && awk '/^%s$/ { exit } { print }' | base64 -d | tar -C %s -xopf -",
+			ShellQuote(dst), tarEOFMarker, ShellQuote(dst)),
+		Stdin:  pr,
+		Stdout: &output,
+		Stderr: &output,
+	}
+
+	log.Printf("Uploading directory '%s' to '%s' with tar", src, dst)
+	if err := comm.Start(cmd); err != nil {
+		pr.Close()
+		return err
+	}
+
+	cmd.Wait()
+
+	// Unblock the archive writer in case the remote end stopped reading
+	pr.Close()
+	if err := <-errCh; err != nil {
+		return fmt.Errorf("Error archiving %s: %s", src, err)
+	}
+
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf(
+			"Extracting %s failed with exit status %d: %s",
+			src, cmd.ExitStatus, strings.TrimSpace(output.String()))
+	}
+
+	return nil
+}
+
+// remoteHasTar returns true if the commands used to extract an archive
+// are available on the remote machine.
+func remoteHasTar(comm packer.Communicator) (bool, error) {
+	cmd := &packer.RemoteCmd{
+		Command: "command -v tar && command -v base64 && command -v awk",
+		Stdout:  new(bytes.Buffer),
+		Stderr:  new(bytes.Buffer),
+	}
+
+	if err := comm.Start(cmd); err != nil {
+		return false, err
+	}
+
+	cmd.Wait()
+	return cmd.ExitStatus == 0, nil
+}
+
+// writeTarArchive writes a base64 encoded tar archive of the directory src
+// to w. If src doesn't end in a slash, the directory itself is added to
+// the archive, otherwise only its contents are.
+func writeTarArchive(w io.Writer, src string, exclude []string) error {
+	root := filepath.Clean(src)
+	prefix := ""
+	if !strings.HasSuffix(src, "/") {
+		prefix = filepath.Base(root)
+	}
+
+	lw := &lineWriter{Writer: w, Width: 76}
+	enc := base64.NewEncoder(base64.StdEncoding, lw)
+	tw := tar.NewWriter(enc)
+
+	walkFn := func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+
+		if rel == "." {
+			rel = ""
+		} else if uploadExcluded(rel, exclude) {
+			log.Printf("Skipping excluded path: %s", p)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		name := path.Join(prefix, filepath.ToSlash(rel))
+		if name == "" {
+			return nil
+		}
+
+		link := ""
+		if info.Mode()&os.ModeSymlink != 0 {
+			link, err = os.Readlink(p)
+			if err != nil {
+				return err
+			}
+		} else if !info.IsDir() && !info.Mode().IsRegular() {
+			log.Printf("Skipping special file: %s", p)
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+
+		hdr.Name = name
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+
+		// The files are owned by the user extracting them
+		hdr.Uid, hdr.Gid = 0, 0
+		hdr.Uname, hdr.Gname = "", ""
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	}
+
+	if err := filepath.Walk(root, walkFn); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	if err := enc.Close(); err != nil {
+		return err
+	}
+
+	return lw.Close()
+}
+
+// uploadExcluded returns true if the relative path, or its base name,
+// matches any of the exclude patterns.
+func uploadExcluded(rel string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if matched, _ := filepath.Match(pattern, rel); matched {
+			return true
+		}
+
+		if matched, _ := filepath.Match(pattern, filepath.Base(rel)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// lineWriter is an io.Writer that breaks the data written to it into
+// lines of at most Width bytes.
+type lineWriter struct {
+	Writer io.Writer
+	Width  int
+
+	col int
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := w.Width - w.col
+		if n > len(p) {
+			n = len(p)
+		}
+
+		if _, err := w.Writer.Write(p[:n]); err != nil {
+			return written, err
+		}
+
+		written += n
+		w.col += n
+		p = p[n:]
+
+		if w.col == w.Width {
+			if _, err := w.Writer.Write([]byte("\n")); err != nil {
+				return written, err
+			}
+
+			w.col = 0
+		}
+	}
+
+	return written, nil
+}
+
+// Close ends the last line.
+func (w *lineWriter) Close() error {
+	if w.col == 0 {
+		return nil
+	}
+
+	w.col = 0
+	_, err := w.Writer.Write([]byte("\n"))
+	return err
+}
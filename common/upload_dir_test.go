@@ -0,0 +1,235 @@
+package common
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/base64"
+	"github.com/mitchellh/packer/communicator/local"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testUploadDir(t *testing.T) string {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	os.MkdirAll(filepath.Join(td, "recipes"), 0755)
+	ioutil.WriteFile(filepath.Join(td, "recipes", "default.rb"), []byte("foo"), 0644)
+	ioutil.WriteFile(filepath.Join(td, "run.sh"), []byte("bar"), 0755)
+	ioutil.WriteFile(filepath.Join(td, "skip.tmp"), []byte("baz"), 0644)
+	os.Symlink("run.sh", filepath.Join(td, "link"))
+	return td
+}
+
+// testReadArchive decodes an archive written by writeTarArchive and
+// returns the names of the entries along with their contents.
+func testReadArchive(t *testing.T, data string) map[string]string {
+	idx := strings.Index(data, tarEOFMarker)
+	if idx == -1 {
+		t.Fatalf("archive is missing the end marker")
+	}
+
+	for _, line := range strings.Split(data[:idx], "\n") {
+		if len(line) > 76 {
+			t.Fatalf("line too long: %d", len(line))
+		}
+	}
+
+	encoded := strings.Replace(data[:idx], "\n", "", -1)
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result := make(map[string]string)
+	tr := tar.NewReader(bytes.NewReader(raw))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		var buf bytes.Buffer
+		io.Copy(&buf, tr)
+		switch hdr.Typeflag {
+		case tar.TypeSymlink:
+			result[hdr.Name] = "-> " + hdr.Linkname
+		case tar.TypeDir:
+			result[hdr.Name] = ""
+		default:
+			result[hdr.Name] = buf.String()
+		}
+	}
+
+	return result
+}
+
+func TestUploadDir(t *testing.T) {
+	src := testUploadDir(t)
+	defer os.RemoveAll(src)
+
+	comm := new(packer.MockCommunicator)
+	if err := UploadDir(comm, "/tmp/cookbooks", src+"/", []string{"*.tmp"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.UploadDirSrc != "" {
+		t.Fatalf("should not fall back: %s", comm.UploadDirSrc)
+	}
+
+	if !strings.Contains(comm.StartCmd.Command, "tar -C '/tmp/cookbooks' -x") {
+		t.Fatalf("bad: %s", comm.StartCmd.Command)
+	}
+
+	entries := testReadArchive(t, comm.StartStdin)
+	expected := map[string]string{
+		"link":               "-> run.sh",
+		"recipes/":           "",
+		"recipes/default.rb": "foo",
+		"run.sh":             "bar",
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("bad: %#v", entries)
+	}
+
+	for name, contents := range expected {
+		if actual, ok := entries[name]; !ok || actual != contents {
+			t.Fatalf("bad %s: %#v", name, entries)
+		}
+	}
+}
+
+func TestUploadDir_noTrailingSlash(t *testing.T) {
+	src := testUploadDir(t)
+	defer os.RemoveAll(src)
+
+	comm := new(packer.MockCommunicator)
+	if err := UploadDir(comm, "/tmp", src, nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	entries := testReadArchive(t, comm.StartStdin)
+	base := filepath.Base(src)
+	for _, name := range []string{base + "/", base + "/run.sh", base + "/skip.tmp"} {
+		if _, ok := entries[name]; !ok {
+			t.Fatalf("missing %s: %#v", name, entries)
+		}
+	}
+}
+
+func TestUploadDir_fallback(t *testing.T) {
+	src := testUploadDir(t)
+	defer os.RemoveAll(src)
+
+	comm := &packer.MockCommunicator{StartExitStatus: 1}
+	if err := UploadDir(comm, "/tmp", src, []string{"*.tmp"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.UploadDirDst != "/tmp" || comm.UploadDirSrc != src {
+		t.Fatalf("bad: %s %s", comm.UploadDirDst, comm.UploadDirSrc)
+	}
+
+	if len(comm.UploadDirExclude) != 1 {
+		t.Fatalf("bad: %#v", comm.UploadDirExclude)
+	}
+}
+
+func TestUploadDir_local(t *testing.T) {
+	src := testUploadDir(t)
+	defer os.RemoveAll(src)
+
+	dst, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(dst)
+
+	comm := new(local.Communicator)
+	if ok, _ := remoteHasTar(comm); !ok {
+		t.Skip("tar, base64 or awk isn't available")
+	}
+
+	if err := UploadDir(comm, dst, src+"/", []string{"*.tmp"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "recipes", "default.rb"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != "foo" {
+		t.Fatalf("bad: %s", data)
+	}
+
+	fi, err := os.Stat(filepath.Join(dst, "run.sh"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("bad mode: %s", fi.Mode())
+	}
+
+	if target, _ := os.Readlink(filepath.Join(dst, "link")); target != "run.sh" {
+		t.Fatalf("bad link target: %s", target)
+	}
+
+	if _, err := os.Stat(filepath.Join(dst, "skip.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("excluded file should not exist: %s", err)
+	}
+}
+
+func TestUploadDir_localSpace(t *testing.T) {
+	src := testUploadDir(t)
+	defer os.RemoveAll(src)
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	comm := new(local.Communicator)
+	if ok, _ := remoteHasTar(comm); !ok {
+		t.Skip("tar, base64 or awk isn't available")
+	}
+
+	// The directory doesn't exist yet, and has characters in it that
+	// the shell would otherwise interpret.
+	dst := filepath.Join(td, "staging dir", "it's $HOME")
+	if err := UploadDir(comm, dst, src+"/", nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "recipes", "default.rb"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != "foo" {
+		t.Fatalf("bad: %s", data)
+	}
+}
+
+func TestLineWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &lineWriter{Writer: &buf, Width: 4}
+	w.Write([]byte("abcdef"))
+	w.Write([]byte("gh"))
+	w.Write([]byte("i"))
+	w.Close()
+
+	if buf.String() != "abcd\nefgh\ni\n" {
+		t.Fatalf("bad: %q", buf.String())
+	}
+}
@@ -0,0 +1,318 @@
+// Package yaml reads YAML templates and variable files with the go-yaml
+// library, and decodes them into the same values encoding/json produces,
+// so that YAML and JSON files can be processed by the same code.
+package yaml
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	jsonutil "github.com/mitchellh/packer/common/json"
+	"gopkg.in/yaml.v3"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SyntaxError is returned when a document can't be parsed. Line and
+// Column are the position of the error, starting at 1. Column is 0 if
+// the parser only reported the line, and both are 0 if it reported
+// neither.
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+	Text   string // The line that the error is on
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line == 0 {
+		return e.Msg
+	}
+
+	if e.Column == 0 {
+		return fmt.Sprintf("Error in line %d: %s\n%s", e.Line, e.Msg, e.Text)
+	}
+
+	return fmt.Sprintf("Error in line %d, char %d: %s\n%s",
+		e.Line, e.Column, e.Msg, e.Text)
+}
+
+// IsYAML returns true if the data read from path should be parsed as YAML
+// rather than JSON. Files ending in ".yml" or ".yaml" are YAML and files
+// ending in ".json" are JSON. Anything else is JSON if it starts with a
+// "{" or "[", and YAML otherwise.
+func IsYAML(path string, data []byte) bool {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return true
+	case ".json":
+		return false
+	}
+
+	data = bytes.TrimSpace(data)
+	return len(data) == 0 || (data[0] != '{' && data[0] != '[')
+}
+
+// Unmarshal parses the YAML document in data and stores the result in the
+// value pointed to by i, following the same rules as json.Unmarshal.
+func Unmarshal(data []byte, i interface{}) error {
+	node, err := parse(data)
+	if err != nil {
+		return err
+	}
+
+	c := &converter{data: data}
+	v, err := c.value(node, "")
+	if err != nil {
+		return err
+	}
+
+	// The values are the same types that encoding/json uses, so the
+	// simplest way to store them in i is to let it do the work.
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, i)
+}
+
+// Positions returns the position of every value in the YAML document in
+// data, keyed by path in the same way as the json package's Positions.
+func Positions(data []byte) (map[string]jsonutil.Position, error) {
+	node, err := parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &converter{
+		data:      data,
+		positions: make(map[string]jsonutil.Position),
+	}
+	if _, err := c.value(node, ""); err != nil {
+		return nil, err
+	}
+
+	return c.positions, nil
+}
+
+// The go-yaml library only reports the line of syntax errors, in its
+// error message.
+var errorLineRe = regexp.MustCompile(`^yaml: line (\d+): (.*)$`)
+
+// parse parses the single YAML document in data, returning the node of
+// its contents, or nil if the document is empty.
+func parse(data []byte) (*yaml.Node, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+
+	var doc yaml.Node
+	if err := dec.Decode(&doc); err != nil {
+		if err == io.EOF {
+			return nil, nil
+		}
+
+		return nil, syntaxError(data, err)
+	}
+
+	// Anything after the first document is an error, rather than being
+	// silently ignored.
+	var next yaml.Node
+	if err := dec.Decode(&next); err != io.EOF {
+		if err != nil {
+			return nil, syntaxError(data, err)
+		}
+
+		return nil, newSyntaxError(data, next.Line, next.Column,
+			"multiple documents are not supported")
+	}
+
+	if len(doc.Content) == 0 {
+		return nil, nil
+	}
+
+	return doc.Content[0], nil
+}
+
+func syntaxError(data []byte, err error) error {
+	match := errorLineRe.FindStringSubmatch(err.Error())
+	if match == nil {
+		if msg := strings.TrimPrefix(err.Error(), "yaml: "); msg != err.Error() {
+			return &SyntaxError{Msg: msg}
+		}
+
+		return err
+	}
+
+	line, _ := strconv.Atoi(match[1])
+	return newSyntaxError(data, line, 0, match[2])
+}
+
+func newSyntaxError(data []byte, line int, column int, msg string) *SyntaxError {
+	text := ""
+	lines := strings.Split(string(data), "\n")
+	if line > 0 && line <= len(lines) {
+		text = strings.TrimRight(lines[line-1], "\r")
+	}
+
+	return &SyntaxError{
+		Line:   line,
+		Column: column,
+		Msg:    msg,
+		Text:   text,
+	}
+}
+
+// converter turns YAML nodes into the values that encoding/json produces,
+// optionally recording the position of every value.
+type converter struct {
+	data []byte
+
+	// If not nil, the position of every value is recorded here
+	positions map[string]jsonutil.Position
+
+	// The anchors being expanded, to catch aliases that contain themselves
+	expanding map[*yaml.Node]bool
+}
+
+func (c *converter) value(n *yaml.Node, path string) (interface{}, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	c.record(path, n)
+
+	switch n.Kind {
+	case yaml.AliasNode:
+		if c.expanding[n.Alias] {
+			return nil, c.errorf(n, "alias '%s' contains itself", n.Value)
+		}
+
+		if c.expanding == nil {
+			c.expanding = make(map[*yaml.Node]bool)
+		}
+
+		c.expanding[n.Alias] = true
+		defer delete(c.expanding, n.Alias)
+
+		// Positions within the anchor are recorded where it is defined
+		positions := c.positions
+		c.positions = nil
+		defer func() { c.positions = positions }()
+
+		return c.value(n.Alias, path)
+	case yaml.MappingNode:
+		result := make(map[string]interface{})
+		if err := c.mapping(n, path, result); err != nil {
+			return nil, err
+		}
+
+		return result, nil
+	case yaml.SequenceNode:
+		result := make([]interface{}, len(n.Content))
+		for i, item := range n.Content {
+			v, err := c.value(item, jsonutil.IndexPath(path, i))
+			if err != nil {
+				return nil, err
+			}
+
+			result[i] = v
+		}
+
+		return result, nil
+	case yaml.ScalarNode:
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, c.errorf(n, "%s", err)
+		}
+
+		// Timestamps are left as they were written, like other strings
+		if _, ok := v.(time.Time); ok {
+			v = n.Value
+		}
+
+		return v, nil
+	}
+
+	return nil, c.errorf(n, "unexpected YAML node")
+}
+
+// mapping adds the keys of the mapping node n to result.
+func (c *converter) mapping(n *yaml.Node, path string, result map[string]interface{}) error {
+	seen := make(map[string]bool)
+	var merges []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		keyNode, valueNode := n.Content[i], n.Content[i+1]
+		if keyNode.Kind != yaml.ScalarNode {
+			return c.errorf(keyNode, "keys must be strings")
+		}
+
+		if keyNode.Tag == "!!merge" {
+			merges = append(merges, valueNode)
+			continue
+		}
+
+		key := keyNode.Value
+		if seen[key] {
+			return c.errorf(keyNode, "duplicate key '%s'", key)
+		}
+		seen[key] = true
+
+		keyPath := jsonutil.KeyPath(path, key)
+		c.record(keyPath, keyNode)
+
+		v, err := c.value(valueNode, keyPath)
+		if err != nil {
+			return err
+		}
+
+		result[key] = v
+	}
+
+	// Merged keys ("<<") don't override the keys of the mapping itself
+	for _, merge := range merges {
+		sources := []*yaml.Node{merge}
+		if merge.Kind == yaml.SequenceNode {
+			sources = merge.Content
+		}
+
+		for _, source := range sources {
+			v, err := c.value(source, path)
+			if err != nil {
+				return err
+			}
+
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return c.errorf(source, "only mappings can be merged")
+			}
+
+			for k, v := range m {
+				if _, ok := result[k]; !ok {
+					result[k] = v
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// record stores the position of n for path, unless a position was
+// already recorded for it.
+func (c *converter) record(path string, n *yaml.Node) {
+	if c.positions == nil {
+		return
+	}
+
+	if _, ok := c.positions[path]; !ok {
+		c.positions[path] = jsonutil.Position{Line: n.Line, Column: n.Column}
+	}
+}
+
+func (c *converter) errorf(n *yaml.Node, format string, args ...interface{}) error {
+	return newSyntaxError(c.data, n.Line, n.Column, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,189 @@
+package yaml
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestIsYAML(t *testing.T) {
+	cases := []struct {
+		Path     string
+		Data     string
+		Expected bool
+	}{
+		{"template.yml", `{"builders": []}`, true},
+		{"template.YAML", "builders: []", true},
+		{"template.json", "builders: []", false},
+		{"-", `  {"builders": []}`, false},
+		{"-", "# comment\nbuilders: []", true},
+		{"vars", "[]", false},
+	}
+
+	for _, tc := range cases {
+		if IsYAML(tc.Path, []byte(tc.Data)) != tc.Expected {
+			t.Fatalf("bad: %s %q", tc.Path, tc.Data)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	cases := []struct {
+		YAML string
+		JSON string
+	}{
+		{
+			"foo: bar\nbaz: 42\n",
+			`{"foo": "bar", "baz": 42}`,
+		},
+		{
+			"# A comment\n---\nfoo: bar # trailing comment\n...\n",
+			`{"foo": "bar"}`,
+		},
+		{
+			"a: true\nb: null\nc: ~\nd:\ne: 1.5\nf: \"0755\"\ng: -3\nh: 1.2.3\ni: yes\n",
+			`{"a": true, "b": null, "c": null, "d": null, "e": 1.5,
+			  "f": "0755", "g": -3, "h": "1.2.3", "i": "yes"}`,
+		},
+		{
+			"list:\n  - a\n  - b\nsame:\n- c\n- d\n",
+			`{"list": ["a", "b"], "same": ["c", "d"]}`,
+		},
+		{
+			"builders:\n  - type: amazon-ebs\n    tags:\n      Name: web\n  - type: docker\n",
+			`{"builders": [{"type": "amazon-ebs", "tags": {"Name": "web"}}, {"type": "docker"}]}`,
+		},
+		{
+			"- - a\n  - b\n- c\n",
+			`[["a", "b"], "c"]`,
+		},
+		{
+			"flow: [a, \"b\", {c: d, 'e': 1}]\nmulti: {\n  a: [1,\n    2],\n}\n",
+			`{"flow": ["a", "b", {"c": "d", "e": 1}], "multi": {"a": [1, 2]}}`,
+		},
+		{
+			"double: \"a\\tb\\n\\u00e9 \\\"q\\\"\"\nsingle: 'it''s # not a comment'\n",
+			`{"double": "a\tb\né \"q\"", "single": "it's # not a comment"}`,
+		},
+		{
+			"url: http://example.com/a#b\nname: packer {{timestamp}}\nquoted: \"{{user `x`}}\"\n",
+			`{"url": "http://example.com/a#b", "name": "packer {{timestamp}}", "quoted": "{{user ` + "`x`" + `}}"}`,
+		},
+		{
+			"text: this is\n  folded onto\n\n  two lines\nnext: x\n",
+			`{"text": "this is folded onto\ntwo lines", "next": "x"}`,
+		},
+		{
+			"script: |\n  echo a\n    indented\n\n  echo b\nnext: x\n",
+			`{"script": "echo a\n  indented\n\necho b\n", "next": "x"}`,
+		},
+		{
+			"strip: |-\n  a\n  b\n\nkeep: |+\n  a\n\nfolded: >\n  a\n  b\n\n  c\n    d\n",
+			`{"strip": "a\nb", "keep": "a\n\n", "folded": "a b\nc\n  d\n"}`,
+		},
+		{
+			"inline:\n  - |\n    #!/bin/sh\n    echo hi\n  - echo bye\n",
+			`{"inline": ["#!/bin/sh\necho hi\n", "echo bye"]}`,
+		},
+		{
+			"\"quoted key\": 1\n'other': 2\n",
+			`{"quoted key": 1, "other": 2}`,
+		},
+		{
+			"base: &base\n  type: docker\n  image: ubuntu\nbuilders:\n  - <<: *base\n    image: centos\n  - *base\n",
+			`{"base": {"type": "docker", "image": "ubuntu"},
+			  "builders": [{"type": "docker", "image": "centos"}, {"type": "docker", "image": "ubuntu"}]}`,
+		},
+		{
+			"",
+			`null`,
+		},
+	}
+
+	for _, tc := range cases {
+		var actual, expected interface{}
+		if err := json.Unmarshal([]byte(tc.JSON), &expected); err != nil {
+			t.Fatalf("bad test case %q: %s", tc.JSON, err)
+		}
+
+		if err := Unmarshal([]byte(tc.YAML), &actual); err != nil {
+			t.Fatalf("err: %s\n\n%s", err, tc.YAML)
+		}
+
+		if !reflect.DeepEqual(actual, expected) {
+			t.Fatalf("bad:\n\n%s\n\n%#v", tc.YAML, actual)
+		}
+	}
+}
+
+func TestUnmarshal_struct(t *testing.T) {
+	var vars map[string]string
+	if err := Unmarshal([]byte("foo: bar\nbaz: \"\"\n"), &vars); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{"foo": "bar", "baz": ""}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("bad: %#v", vars)
+	}
+}
+
+func TestUnmarshal_errors(t *testing.T) {
+	cases := []struct {
+		YAML   string
+		Line   int
+		Column int
+	}{
+		{"foo: bar\n  baz: 1\n", 2, 0},
+		{"foo:\n  - a\n - b\n", 2, 0},
+		{"foo: bar\nfoo: baz\n", 2, 1},
+		{"foo:\n\t- a\n", 2, 0},
+		{"foo: [a, b\n", 1, 0},
+		{"foo: {{user `x`}}\n", 1, 7},
+		{"foo: &a [*a]\n", 1, 10},
+		{"[1]: x\n", 1, 1},
+		{"a: 1\n---\nb: 2\n", 2, 1},
+		{"k: - x\n", 0, 0},
+	}
+
+	for _, tc := range cases {
+		var v interface{}
+		err := Unmarshal([]byte(tc.YAML), &v)
+		if err == nil {
+			t.Fatalf("should have error: %q", tc.YAML)
+		}
+
+		serr, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("bad error type: %#v", err)
+		}
+
+		if serr.Line != tc.Line || serr.Column != tc.Column {
+			t.Fatalf("bad position %d:%d for %q: %s",
+				serr.Line, serr.Column, tc.YAML, err)
+		}
+	}
+}
+
+func TestPositions(t *testing.T) {
+	data := "builders:\n  - type: foo\n    tags: {a: [1, 2]}\n  - type: bar\n"
+	positions, err := Positions([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{
+		"builders":           "1:1",
+		"builders[0]":        "2:5",
+		"builders[0].type":   "2:5",
+		"builders[0].tags":   "3:5",
+		"builders[0].tags.a": "3:12",
+		"builders[1].type":   "4:5",
+	}
+
+	for path, pos := range expected {
+		if positions[path].String() != pos {
+			t.Fatalf("bad %s: %s", path, positions[path])
+		}
+	}
+}
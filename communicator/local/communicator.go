@@ -0,0 +1,356 @@
+// Package local implements a packer.Communicator that runs commands and
+// transfers files on the machine running Packer, optionally within a
+// chroot.
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// CommandWrapper is a type that given a command, will possibly modify that
+// command in-flight. This might return an error.
+type CommandWrapper func(string) (string, error)
+
+// Communicator is a packer.Communicator that executes commands locally
+// with /bin/sh.
+type Communicator struct {
+	// Chroot, if set, is the directory that commands are executed in with
+	// chroot(8). The paths given to the communicator are then relative
+	// to this directory.
+	Chroot string
+
+	// CmdWrapper, if set, wraps every command before it is executed,
+	// including the commands used to copy files. This can be used to run
+	// commands with sudo, for example.
+	CmdWrapper CommandWrapper
+
+	// Env is a list of extra environment variables, in the form
+	// "key=value", that commands are executed with.
+	Env []string
+}
+
+// ShellCommand takes a command string and returns an *exec.Cmd to execute
+// it within the context of a shell (/bin/sh).
+func ShellCommand(command string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+func (c *Communicator) Start(cmd *packer.RemoteCmd) error {
+	args := []string{"/bin/sh", "-c", cmd.Command}
+	if c.Chroot != "" {
+		args = append([]string{"chroot", c.Chroot}, args...)
+	}
+
+	localCmd, err := c.command(args)
+	if err != nil {
+		return err
+	}
+
+	localCmd.Env = append(os.Environ(), c.Env...)
+	localCmd.Stdin = cmd.Stdin
+	localCmd.Stdout = cmd.Stdout
+	localCmd.Stderr = cmd.Stderr
+	log.Printf("Executing: %s %#v", localCmd.Path, localCmd.Args)
+	if err := localCmd.Start(); err != nil {
+		return err
+	}
+
+	go func() {
+		exitStatus := 0
+		if err := localCmd.Wait(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				exitStatus = 1
+
+				// There is no process-independent way to get the REAL
+				// exit status so we just try to go deeper.
+				if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+					exitStatus = status.ExitStatus()
+				}
+			}
+		}
+
+		log.Printf(
+			"Local execution exited with '%d': '%s'",
+			exitStatus, cmd.Command)
+		cmd.SetExited(exitStatus)
+	}()
+
+	return nil
+}
+
+func (c *Communicator) Upload(dst string, r io.Reader) error {
+	dst = c.path(dst)
+	log.Printf("Uploading to local path: %s", dst)
+	tf, err := ioutil.TempFile("", "packer-local")
+	if err != nil {
+		return fmt.Errorf("Error preparing upload: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	_, err = io.Copy(tf, r)
+	tf.Close()
+	if err != nil {
+		return fmt.Errorf("Error preparing upload: %s", err)
+	}
+
+	return c.run("cp", tf.Name(), dst)
+}
+
+func (c *Communicator) UploadDir(dst string, src string, exclude []string) error {
+	dst = c.path(dst)
+	log.Printf("Uploading directory '%s' to '%s'", src, dst)
+	return c.copyDir(dst, src, exclude)
+}
+
+func (c *Communicator) Download(src string, w io.Writer) error {
+	src = c.path(src)
+	log.Printf("Downloading from local path: %s", src)
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(w, f); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *Communicator) DownloadDir(src string, dst string, exclude []string) error {
+	src = c.path(src) + trailingSlash(src)
+	log.Printf("Downloading directory '%s' to '%s'", src, dst)
+	return c.copyDir(dst, src, exclude)
+}
+
+// copyDir copies the directory src to dst with the same trailing slash
+// rules as UploadDir. The files are archived and extracted with tar, so
+// modes, modification times and symlinks are preserved but files are
+// owned by the user running the commands. If there are excluded paths,
+// the remaining files are first copied to a temporary directory.
+func (c *Communicator) copyDir(dst string, src string, exclude []string) error {
+	root := filepath.Clean(src)
+	if !strings.HasSuffix(src, "/") {
+		dst = filepath.Join(dst, filepath.Base(root))
+	}
+
+	if err := c.run("mkdir", "-p", dst); err != nil {
+		return err
+	}
+
+	if len(exclude) > 0 {
+		td, err := ioutil.TempDir("", "packer-local")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(td)
+
+		if err := CopyDir(td, root, exclude); err != nil {
+			return err
+		}
+
+		root = td
+	}
+
+	tf, err := ioutil.TempFile("", "packer-local")
+	if err != nil {
+		return err
+	}
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	if err := c.run("tar", "-C", root, "-cf", tf.Name(), "."); err != nil {
+		return err
+	}
+
+	return c.run("tar", "-C", dst, "-xopf", tf.Name())
+}
+
+// path returns the local path for a path given to the communicator.
+func (c *Communicator) path(p string) string {
+	if c.Chroot == "" {
+		return p
+	}
+
+	return filepath.Join(c.Chroot, p)
+}
+
+// run runs a command used to transfer files, with the command wrapper.
+func (c *Communicator) run(args ...string) error {
+	cmd, err := c.command(args)
+	if err != nil {
+		return err
+	}
+
+	var stderr bytes.Buffer
+	cmd.Env = append(os.Environ(), "LANG=C")
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf(
+			"Error executing '%s': %s\nStderr: %s",
+			strings.Join(cmd.Args, " "), err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+// command returns the *exec.Cmd that runs args. The arguments are passed
+// directly to the process, unless there is a command wrapper, in which
+// case they are quoted into a command for the wrapper and run with sh.
+func (c *Communicator) command(args []string) (*exec.Cmd, error) {
+	if c.CmdWrapper == nil {
+		return exec.Command(args[0], args[1:]...), nil
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(arg)
+	}
+
+	command, err := c.CmdWrapper(strings.Join(quoted, " "))
+	if err != nil {
+		return nil, err
+	}
+
+	return ShellCommand(command), nil
+}
+
+// CopyDir copies the contents of the directory src into the existing
+// directory dst, preserving file modes, modification times and symlinks.
+// The mode and modification time of src are applied to dst as well. Paths
+// relative to src that match any of the exclude patterns, or whose base
+// name does, are skipped.
+func CopyDir(dst string, src string, exclude []string) error {
+	type dirInfo struct {
+		Path string
+		Info os.FileInfo
+	}
+
+	// The attributes of directories are set once they are filled, since
+	// writing to them changes their modification time.
+	dirs := make([]dirInfo, 0)
+	walkFn := func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+
+		if rel != "." && excluded(rel, exclude) {
+			log.Printf("Skipping excluded path: %s", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			os.Remove(target)
+			return os.Symlink(link, target)
+		case info.IsDir():
+			dirs = append(dirs, dirInfo{target, info})
+			return os.MkdirAll(target, 0700)
+		case info.Mode().IsRegular():
+			return copyFile(target, path, info)
+		default:
+			log.Printf("Skipping special file: %s", path)
+			return nil
+		}
+	}
+
+	if err := filepath.Walk(src, walkFn); err != nil {
+		return err
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		dir := dirs[i]
+		if err := os.Chmod(dir.Path, dir.Info.Mode().Perm()); err != nil {
+			return err
+		}
+
+		mtime := dir.Info.ModTime()
+		if err := os.Chtimes(dir.Path, mtime, mtime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func copyFile(dst string, src string, info os.FileInfo) error {
+	srcF, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer srcF.Close()
+
+	dstF, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(dstF, srcF)
+	dstF.Close()
+	if err != nil {
+		return err
+	}
+
+	// The mode is set explicitly since it is subject to the umask
+	if err := os.Chmod(dst, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	mtime := info.ModTime()
+	return os.Chtimes(dst, mtime, mtime)
+}
+
+// excluded returns true if the relative path, or its base name, matches
+// any of the exclude patterns.
+func excluded(rel string, exclude []string) bool {
+	for _, pattern := range exclude {
+		if matched, _ := filepath.Match(pattern, rel); matched {
+			return true
+		}
+
+		if matched, _ := filepath.Match(pattern, filepath.Base(rel)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// shellQuote quotes a string so that it is a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func trailingSlash(p string) string {
+	if strings.HasSuffix(p, "/") {
+		return "/"
+	}
+
+	return ""
+}
@@ -0,0 +1,355 @@
+package local
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommunicator_ImplementsCommunicator(t *testing.T) {
+	var raw interface{}
+	raw = &Communicator{}
+	if _, ok := raw.(packer.Communicator); !ok {
+		t.Fatalf("Communicator should be a communicator")
+	}
+}
+
+func TestCommunicatorStart(t *testing.T) {
+	c := &Communicator{
+		Env: []string{"PACKER_TEST=bar"},
+	}
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "echo foo $PACKER_TEST; exit 3",
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd.Wait()
+	if cmd.ExitStatus != 3 {
+		t.Fatalf("bad: %d", cmd.ExitStatus)
+	}
+
+	if strings.TrimSpace(stdout.String()) != "foo bar" {
+		t.Fatalf("bad: %s", stdout.String())
+	}
+}
+
+func TestCommunicatorStart_wrapper(t *testing.T) {
+	c := &Communicator{
+		CmdWrapper: func(command string) (string, error) {
+			return "echo wrapped && " + command, nil
+		},
+	}
+
+	var stdout bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: "echo foo",
+		Stdout:  &stdout,
+	}
+
+	if err := c.Start(cmd); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cmd.Wait()
+	if stdout.String() != "wrapped\nfoo\n" {
+		t.Fatalf("bad: %s", stdout.String())
+	}
+}
+
+func TestCommunicatorStart_quotes(t *testing.T) {
+	wrappers := []CommandWrapper{
+		nil,
+		func(command string) (string, error) {
+			return "env PACKER_TEST=bar " + command, nil
+		},
+	}
+
+	for _, wrapper := range wrappers {
+		c := &Communicator{CmdWrapper: wrapper}
+
+		var stdout bytes.Buffer
+		cmd := &packer.RemoteCmd{
+			Command: `echo "it's \"quoted\""`,
+			Stdout:  &stdout,
+		}
+
+		if err := c.Start(cmd); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		cmd.Wait()
+		if stdout.String() != "it's \"quoted\"\n" {
+			t.Fatalf("bad: %s", stdout.String())
+		}
+	}
+}
+
+func TestCommunicatorUploadDownload(t *testing.T) {
+	td := testDir(t)
+	defer os.RemoveAll(td)
+
+	c := &Communicator{Chroot: td}
+	if err := c.Upload("/foo.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(td, "foo.txt"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "hello" {
+		t.Fatalf("bad: %s", data)
+	}
+
+	var buf bytes.Buffer
+	if err := c.Download("/foo.txt", &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if buf.String() != "hello" {
+		t.Fatalf("bad: %s", buf.String())
+	}
+}
+
+func TestCommunicatorUpload_spaces(t *testing.T) {
+	td := testDir(t)
+	defer os.RemoveAll(td)
+
+	os.MkdirAll(filepath.Join(td, "my dir"), 0755)
+	c := &Communicator{
+		Chroot: td,
+		CmdWrapper: func(command string) (string, error) {
+			return command, nil
+		},
+	}
+
+	if err := c.Upload("/my dir/foo.txt", strings.NewReader("hello")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(td, "my dir", "foo.txt"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "hello" {
+		t.Fatalf("bad: %s", data)
+	}
+}
+
+func TestCommunicatorUploadDir(t *testing.T) {
+	src := testDir(t)
+	defer os.RemoveAll(src)
+	testWriteFile(t, filepath.Join(src, "a.txt"))
+	testWriteFile(t, filepath.Join(src, ".hidden"))
+	testWriteFile(t, filepath.Join(src, "sub", "b.txt"))
+	testWriteFile(t, filepath.Join(src, "sub", "skip.tmp"))
+
+	cases := []struct {
+		Src      string
+		Exclude  []string
+		Expected []string
+	}{
+		{
+			src + "/",
+			nil,
+			[]string{".hidden", "a.txt", "sub", "sub/b.txt", "sub/skip.tmp"},
+		},
+		{
+			src,
+			nil,
+			[]string{
+				filepath.Base(src),
+				filepath.Base(src) + "/.hidden",
+				filepath.Base(src) + "/a.txt",
+				filepath.Base(src) + "/sub",
+				filepath.Base(src) + "/sub/b.txt",
+				filepath.Base(src) + "/sub/skip.tmp",
+			},
+		},
+		{
+			src + "/",
+			[]string{"*.tmp", ".hidden"},
+			[]string{"a.txt", "sub", "sub/b.txt"},
+		},
+	}
+
+	for _, tc := range cases {
+		dst := testDir(t)
+		defer os.RemoveAll(dst)
+
+		c := &Communicator{}
+		if err := c.UploadDir(dst, tc.Src, tc.Exclude); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		actual := testListDir(t, dst)
+		if strings.Join(actual, ",") != strings.Join(tc.Expected, ",") {
+			t.Fatalf("%s %#v: bad: %#v", tc.Src, tc.Exclude, actual)
+		}
+	}
+}
+
+func TestCommunicatorUploadDir_attributes(t *testing.T) {
+	src := testDir(t)
+	defer os.RemoveAll(src)
+	testWriteFile(t, filepath.Join(src, "hooks", "run.sh"))
+	testWriteFile(t, filepath.Join(src, "skip.tmp"))
+	os.Chmod(filepath.Join(src, "hooks", "run.sh"), 0755)
+	os.Symlink("hooks/run.sh", filepath.Join(src, "link"))
+
+	mtime := time.Unix(1388534400, 0)
+	os.Chtimes(filepath.Join(src, "hooks", "run.sh"), mtime, mtime)
+	os.Chtimes(filepath.Join(src, "hooks"), mtime, mtime)
+
+	for _, exclude := range [][]string{nil, []string{"*.tmp"}} {
+		dst := testDir(t)
+		defer os.RemoveAll(dst)
+
+		c := &Communicator{}
+		if err := c.UploadDir(dst, src+"/", exclude); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		fi, err := os.Stat(filepath.Join(dst, "hooks", "run.sh"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if fi.Mode().Perm() != 0755 {
+			t.Fatalf("bad mode: %s", fi.Mode())
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Fatalf("bad mtime: %s", fi.ModTime())
+		}
+
+		fi, err = os.Stat(filepath.Join(dst, "hooks"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if !fi.ModTime().Equal(mtime) {
+			t.Fatalf("bad dir mtime: %s", fi.ModTime())
+		}
+
+		target, err := os.Readlink(filepath.Join(dst, "link"))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+		if target != "hooks/run.sh" {
+			t.Fatalf("bad link target: %s", target)
+		}
+	}
+}
+
+func TestCommunicatorDownloadDir(t *testing.T) {
+	chroot := testDir(t)
+	defer os.RemoveAll(chroot)
+	testWriteFile(t, filepath.Join(chroot, "etc", "a.conf"))
+	testWriteFile(t, filepath.Join(chroot, "etc", "b.tmp"))
+
+	dst := testDir(t)
+	defer os.RemoveAll(dst)
+
+	c := &Communicator{Chroot: chroot}
+	if err := c.DownloadDir("/etc", dst, []string{"*.tmp"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := testListDir(t, dst)
+	if strings.Join(actual, ",") != "etc,etc/a.conf" {
+		t.Fatalf("bad: %#v", actual)
+	}
+}
+
+func TestCopyDir(t *testing.T) {
+	src := testDir(t)
+	defer os.RemoveAll(src)
+	testWriteFile(t, filepath.Join(src, "a.txt"))
+	testWriteFile(t, filepath.Join(src, "ro", "b.txt"))
+	testWriteFile(t, filepath.Join(src, "skip", "c.txt"))
+	os.Chmod(filepath.Join(src, "a.txt"), 0600)
+	os.Chmod(filepath.Join(src, "ro"), 0555)
+	defer os.Chmod(filepath.Join(src, "ro"), 0755)
+	os.Chmod(src, 0750)
+
+	dst := testDir(t)
+	defer os.RemoveAll(dst)
+	defer os.Chmod(filepath.Join(dst, "ro"), 0755)
+
+	if err := CopyDir(dst, src, []string{"skip"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual := testListDir(t, dst)
+	if strings.Join(actual, ",") != "a.txt,ro,ro/b.txt" {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	modes := map[string]os.FileMode{
+		"":      0750,
+		"a.txt": 0600,
+		"ro":    0555,
+	}
+
+	for path, mode := range modes {
+		fi, err := os.Stat(filepath.Join(dst, path))
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if fi.Mode().Perm() != mode {
+			t.Fatalf("%s: bad mode: %s", path, fi.Mode())
+		}
+	}
+}
+
+func testDir(t *testing.T) string {
+	td, err := ioutil.TempDir("", "packer-local")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return td
+}
+
+func testWriteFile(t *testing.T, path string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(path), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func testListDir(t *testing.T, dir string) []string {
+	result := make([]string, 0)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if path != dir {
+			rel, _ := filepath.Rel(dir, path)
+			result = append(result, filepath.ToSlash(rel))
+		}
+
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return result
+}
@@ -0,0 +1,102 @@
+package ssh
+
+import (
+	"code.google.com/p/go.crypto/ssh"
+	"errors"
+	"net"
+	"os"
+	"sync"
+)
+
+// AgentAuth returns an ssh.ClientAuth that authenticates with the keys
+// held by the ssh-agent listening on SSH_AUTH_SOCK, or an error if no
+// agent can be reached.
+func AgentAuth() (ssh.ClientAuth, error) {
+	conn := new(agentConn)
+	if err := conn.check(); err != nil {
+		return nil, err
+	}
+
+	return ssh.ClientAuthAgent(ssh.NewAgentClient(conn)), nil
+}
+
+// agentConn is a connection to the ssh-agent that is only made once it
+// is used, and made again after an error, so that nothing is left open
+// if the agent is never asked for anything.
+type agentConn struct {
+	conn net.Conn
+	l    sync.Mutex
+}
+
+func (c *agentConn) Read(p []byte) (int, error) {
+	conn, err := c.get()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := conn.Read(p)
+	if err != nil {
+		c.reset()
+	}
+
+	return n, err
+}
+
+func (c *agentConn) Write(p []byte) (int, error) {
+	conn, err := c.get()
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := conn.Write(p)
+	if err != nil {
+		c.reset()
+	}
+
+	return n, err
+}
+
+// check makes sure that the agent can be reached.
+func (c *agentConn) check() error {
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+func (c *agentConn) get() (net.Conn, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.conn == nil {
+		conn, err := c.dial()
+		if err != nil {
+			return nil, err
+		}
+
+		c.conn = conn
+	}
+
+	return c.conn, nil
+}
+
+func (c *agentConn) reset() {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+func (c *agentConn) dial() (net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, errors.New("SSH_AUTH_SOCK is not set, is ssh-agent running?")
+	}
+
+	return net.Dial("unix", sock)
+}
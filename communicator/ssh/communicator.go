@@ -12,7 +12,11 @@ import (
 	"log"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -35,6 +39,10 @@ type Config struct {
 
 	// NoPty, if true, will not request a pty from the remote end.
 	NoPty bool
+
+	// UseSftp, if true, transfers files with the SFTP subsystem rather
+	// than by running scp on the remote end.
+	UseSftp bool
 }
 
 // Creates a new packer.Communicator implementation over SSH. This takes
@@ -158,6 +166,12 @@ func (c *comm) Start(cmd *packer.RemoteCmd) (err error) {
 }
 
 func (c *comm) Upload(path string, input io.Reader) error {
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			return sftpUploadFile(client, filepath.ToSlash(path), input, 0644)
+		})
+	}
+
 	// The target directory and file for talking the SCP protocol
 	target_dir := filepath.Dir(path)
 	target_file := filepath.Base(path)
@@ -168,7 +182,7 @@ func (c *comm) Upload(path string, input io.Reader) error {
 	target_dir = filepath.ToSlash(target_dir)
 
 	scpFunc := func(w io.Writer, stdoutR *bufio.Reader) error {
-		return scpUploadFile(target_file, input, w, stdoutR)
+		return scpUploadFile(target_file, input, nil, w, stdoutR)
 	}
 
 	return c.scpSession("scp -vt "+target_dir, scpFunc)
@@ -176,6 +190,27 @@ func (c *comm) Upload(path string, input io.Reader) error {
 
 func (c *comm) UploadDir(dst string, src string, excl []string) error {
 	log.Printf("Upload dir '%s' to '%s'", src, dst)
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			dst = filepath.ToSlash(dst)
+			if !strings.HasSuffix(src, "/") {
+				log.Printf("No trailing slash, creating the source directory name")
+				fi, err := os.Stat(src)
+				if err != nil {
+					return err
+				}
+
+				dst = path.Join(dst, filepath.Base(src))
+				if err := sftpMkdirAll(client, dst, fi.Mode()); err != nil {
+					return err
+				}
+			}
+
+			return sftpUploadDir(client, src, dst, src, excl)
+		})
+	}
+
+	links := make(map[string]string)
 	scpFunc := func(w io.Writer, r *bufio.Reader) error {
 		uploadEntries := func() error {
 			f, err := os.Open(src)
@@ -189,23 +224,125 @@ func (c *comm) UploadDir(dst string, src string, excl []string) error {
 				return err
 			}
 
-			return scpUploadDir(src, entries, w, r)
+			return scpUploadDir(src, src, entries, excl, links, w, r)
 		}
 
-		if src[len(src)-1] != '/' {
+		if !strings.HasSuffix(src, "/") {
 			log.Printf("No trailing slash, creating the source directory name")
-			return scpUploadDirProtocol(filepath.Base(src), w, r, uploadEntries)
+			fi, err := os.Stat(src)
+			if err != nil {
+				return err
+			}
+
+			return scpUploadDirProtocol(filepath.Base(src), fi, w, r, uploadEntries)
 		} else {
 			// Trailing slash, so only upload the contents
 			return uploadEntries()
 		}
 	}
 
-	return c.scpSession("scp -rvt "+dst, scpFunc)
+	if err := c.scpSession("scp -prvt "+dst, scpFunc); err != nil {
+		return err
+	}
+
+	// SCP can't transfer symlinks, so they are created afterwards
+	linkDst := filepath.ToSlash(dst)
+	if !strings.HasSuffix(src, "/") {
+		linkDst = path.Join(linkDst, filepath.Base(src))
+	}
+
+	return c.createSymlinks(linkDst, links)
 }
 
-func (c *comm) Download(string, io.Writer) error {
-	panic("not implemented yet")
+func (c *comm) Download(path string, output io.Writer) error {
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			handle, err := client.Open(filepath.ToSlash(path))
+			if err != nil {
+				return err
+			}
+
+			if err := client.Read(handle, output); err != nil {
+				client.Close(handle)
+				return err
+			}
+
+			return client.Close(handle)
+		})
+	}
+
+	scpFunc := func(w io.Writer, stdoutR *bufio.Reader) error {
+		return scpDownloadFile(output, w, stdoutR)
+	}
+
+	return c.scpSession(scpSourceCommand("-vf", path), scpFunc)
+}
+
+func (c *comm) DownloadDir(src string, dst string, excl []string) error {
+	log.Printf("Download dir '%s' to '%s'", src, dst)
+	if c.config.UseSftp {
+		return c.sftpSession(func(client *sftpClient) error {
+			root := dst
+			if !strings.HasSuffix(src, "/") {
+				log.Printf("No trailing slash, creating the source directory name")
+				dst = filepath.Join(dst, path.Base(src))
+			}
+
+			if err := os.MkdirAll(dst, 0755); err != nil {
+				return err
+			}
+
+			return sftpDownloadDir(client, root, dst, filepath.ToSlash(src), excl)
+		})
+	}
+
+	scpFunc := func(w io.Writer, r *bufio.Reader) error {
+		// Without a trailing slash the remote directory itself is
+		// created locally, otherwise only its contents are.
+		skipRoot := strings.HasSuffix(src, "/")
+		return scpDownloadDir(dst, skipRoot, excl, w, r)
+	}
+
+	return c.scpSession(scpSourceCommand("-rvf", src), scpFunc)
+}
+
+// createSymlinks creates the given symlinks, mapping paths relative to
+// dst to their targets, by running ln on the remote end.
+func (c *comm) createSymlinks(dst string, links map[string]string) error {
+	if len(links) == 0 {
+		return nil
+	}
+
+	paths := make([]string, 0, len(links))
+	for p := range links {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	commands := make([]string, len(paths))
+	for i, p := range paths {
+		log.Printf("SCP: creating symlink: %s -> %s", p, links[p])
+		commands[i] = fmt.Sprintf("ln -sfn %s %s",
+			shellQuote(links[p]), shellQuote(path.Join(dst, p)))
+	}
+
+	var stderr bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: strings.Join(commands, " && "),
+		Stderr:  &stderr,
+	}
+	if err := c.Start(cmd); err != nil {
+		return err
+	}
+
+	cmd.Wait()
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf(
+			"Creating symlinks failed with exit status %d: %s",
+			cmd.ExitStatus, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
 }
 
 func (c *comm) newSession() (session *ssh.Session, err error) {
@@ -261,6 +398,39 @@ func (c *comm) reconnect() (err error) {
 	return
 }
 
+func (c *comm) sftpSession(f func(*sftpClient) error) error {
+	session, err := c.newSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	stdinW, err := session.StdinPipe()
+	if err != nil {
+		return err
+	}
+	defer stdinW.Close()
+
+	stdoutR, err := session.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	log.Println("Starting remote sftp subsystem")
+	if err := session.RequestSubsystem("sftp"); err != nil {
+		return fmt.Errorf(
+			"SFTP failed to start: %s. This usually means that the SFTP\n"+
+				"subsystem is not enabled on the remote system.", err)
+	}
+
+	client, err := newSftpClient(stdoutR, stdinW)
+	if err != nil {
+		return err
+	}
+
+	return f(client)
+}
+
 func (c *comm) scpSession(scpCommand string, f func(io.Writer, *bufio.Reader) error) error {
 	session, err := c.newSession()
 	if err != nil {
@@ -363,7 +533,238 @@ func checkSCPStatus(r *bufio.Reader) error {
 	return nil
 }
 
-func scpUploadFile(dst string, src io.Reader, w io.Writer, r *bufio.Reader) error {
+// scpHeader is a single control message sent by an SCP source.
+type scpHeader struct {
+	// Kind is the control character: 'C' for a file, 'D' to enter
+	// a directory, 'E' to leave it and 'T' for timestamps.
+	Kind byte
+	Mode os.FileMode
+	Size int64
+	Name string
+}
+
+// readSCPHeader reads the next control message from an SCP source. If
+// the source reports an error, it is returned as an error.
+func readSCPHeader(r *bufio.Reader) (*scpHeader, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			err = io.ErrUnexpectedEOF
+		}
+
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	if line == "" {
+		return nil, errors.New("Empty SCP message")
+	}
+
+	h := &scpHeader{Kind: line[0]}
+	switch h.Kind {
+	case '\x01', '\x02':
+		return nil, errors.New(line[1:])
+	case 'E', 'T':
+		return h, nil
+	case 'C', 'D':
+	default:
+		return nil, fmt.Errorf("Unknown SCP message: %q", line)
+	}
+
+	parts := strings.SplitN(line[1:], " ", 3)
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Malformed SCP message: %q", line)
+	}
+
+	mode, err := strconv.ParseUint(parts[0], 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed SCP mode in %q: %s", line, err)
+	}
+
+	size, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("Malformed SCP size in %q: %s", line, err)
+	}
+
+	h.Mode = os.FileMode(mode) & os.ModePerm
+	h.Size = size
+	h.Name = parts[2]
+
+	// Never allow the remote side to escape the destination directory
+	if h.Name == "." || h.Name == ".." || strings.ContainsAny(h.Name, "/\\") {
+		return nil, fmt.Errorf("Invalid SCP file name: %q", h.Name)
+	}
+
+	return h, nil
+}
+
+// scpDownloadFile runs the sink side of the SCP protocol for a single
+// file, writing its contents to dst.
+func scpDownloadFile(dst io.Writer, w io.Writer, r *bufio.Reader) error {
+	// Tell the source we're ready
+	fmt.Fprint(w, "\x00")
+
+	for {
+		h, err := readSCPHeader(r)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return err
+		}
+
+		switch h.Kind {
+		case 'T':
+			// Timestamps aren't preserved, just acknowledge them
+			fmt.Fprint(w, "\x00")
+			continue
+		case 'C':
+		default:
+			return fmt.Errorf("Expected a file from SCP, got '%c'", h.Kind)
+		}
+
+		log.Printf("SCP: downloading file %s (%d bytes)", h.Name, h.Size)
+		return scpDownloadData(dst, h.Size, w, r)
+	}
+}
+
+// scpDownloadData receives the contents of a single file after its
+// header has been read, acknowledging it once it is complete.
+func scpDownloadData(dst io.Writer, size int64, w io.Writer, r *bufio.Reader) error {
+	fmt.Fprint(w, "\x00")
+	if _, err := io.CopyN(dst, r, size); err != nil {
+		return err
+	}
+
+	if err := checkSCPStatus(r); err != nil {
+		return err
+	}
+
+	fmt.Fprint(w, "\x00")
+	return nil
+}
+
+// scpDownloadDir runs the sink side of a recursive SCP transfer, recreating
+// the received tree underneath root. If skipRoot is true, the top-level
+// directory sent by the source is not created and its contents are placed
+// directly into root. Paths relative to root that match an exclude pattern
+// are received but not written.
+func scpDownloadDir(root string, skipRoot bool, exclude []string, w io.Writer, r *bufio.Reader) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	// Tell the source we're ready
+	fmt.Fprint(w, "\x00")
+
+	// dirs is the stack of local directories we've entered. An empty
+	// string means the directory was skipped.
+	dirs := []string{root}
+	for {
+		h, err := readSCPHeader(r)
+		if err != nil {
+			if err == io.EOF {
+				if len(dirs) > 1 {
+					return io.ErrUnexpectedEOF
+				}
+
+				return nil
+			}
+
+			return err
+		}
+
+		current := dirs[len(dirs)-1]
+		switch h.Kind {
+		case 'T':
+			// Timestamps aren't preserved, just acknowledge them
+		case 'D':
+			next := ""
+			if current != "" {
+				if skipRoot && len(dirs) == 1 {
+					next = current
+				} else {
+					next = filepath.Join(current, h.Name)
+					if scpExcluded(root, next, exclude) {
+						log.Printf("SCP: skipping excluded directory: %s", next)
+						next = ""
+					} else if err := os.MkdirAll(next, h.Mode|0700); err != nil {
+						return err
+					}
+				}
+			}
+
+			dirs = append(dirs, next)
+		case 'E':
+			if len(dirs) == 1 {
+				return errors.New("SCP: unexpected end of directory")
+			}
+
+			dirs = dirs[:len(dirs)-1]
+		case 'C':
+			path := ""
+			if current != "" {
+				path = filepath.Join(current, h.Name)
+				if scpExcluded(root, path, exclude) {
+					log.Printf("SCP: skipping excluded file: %s", path)
+					path = ""
+				}
+			}
+
+			if path == "" {
+				if err := scpDownloadData(ioutil.Discard, h.Size, w, r); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			log.Printf("SCP: downloading file %s (%d bytes)", path, h.Size)
+			err := func() error {
+				f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, h.Mode)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				return scpDownloadData(f, h.Size, w, r)
+			}()
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		fmt.Fprint(w, "\x00")
+	}
+}
+
+// scpExcluded returns true if the path relative to root, or its base
+// name, matches any of the given exclude patterns.
+func scpExcluded(root string, path string, exclude []string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		rel = path
+	}
+
+	for _, pattern := range exclude {
+		if matched, _ := filepath.Match(pattern, rel); matched {
+			return true
+		}
+
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// scpUploadFile uploads the contents of src as the file dst. The mode and
+// modification time are taken from fi if it is given, otherwise the file
+// is created with mode 0644.
+func scpUploadFile(dst string, src io.Reader, fi os.FileInfo, w io.Writer, r *bufio.Reader) error {
 	// Create a temporary file where we can copy the contents of the src
 	// so that we can determine the length, since SCP is length-prefixed.
 	tf, err := ioutil.TempFile("", "packer-upload")
@@ -389,14 +790,22 @@ func scpUploadFile(dst string, src io.Reader, w io.Writer, r *bufio.Reader) erro
 		return fmt.Errorf("Error creating temporary file for upload: %s", err)
 	}
 
-	fi, err := tf.Stat()
+	tfi, err := tf.Stat()
 	if err != nil {
 		return fmt.Errorf("Error creating temporary file for upload: %s", err)
 	}
 
+	mode := os.FileMode(0644)
+	if fi != nil {
+		mode = fi.Mode().Perm()
+		if err := scpUploadTimes(fi, w, r); err != nil {
+			return err
+		}
+	}
+
 	// Start the protocol
 	log.Println("Beginning file upload...")
-	fmt.Fprintln(w, "C0644", fi.Size(), dst)
+	fmt.Fprintf(w, "C%04o %d %s\n", mode, tfi.Size(), dst)
 	if err := checkSCPStatus(r); err != nil {
 		return err
 	}
@@ -413,9 +822,28 @@ func scpUploadFile(dst string, src io.Reader, w io.Writer, r *bufio.Reader) erro
 	return nil
 }
 
-func scpUploadDirProtocol(name string, w io.Writer, r *bufio.Reader, f func() error) error {
+// scpUploadTimes sends the modification time of fi, which the remote end
+// applies to the next file or directory.
+func scpUploadTimes(fi os.FileInfo, w io.Writer, r *bufio.Reader) error {
+	mtime := fi.ModTime().Unix()
+	fmt.Fprintf(w, "T%d 0 %d 0\n", mtime, mtime)
+	return checkSCPStatus(r)
+}
+
+// scpUploadDirProtocol uploads a directory with the given name, whose
+// contents are uploaded by f. The mode and modification time are taken
+// from fi if it is given, otherwise the directory has mode 0755.
+func scpUploadDirProtocol(name string, fi os.FileInfo, w io.Writer, r *bufio.Reader, f func() error) error {
+	mode := os.FileMode(0755)
+	if fi != nil {
+		mode = fi.Mode().Perm()
+		if err := scpUploadTimes(fi, w, r); err != nil {
+			return err
+		}
+	}
+
 	log.Printf("SCP: starting directory upload: %s", name)
-	fmt.Fprintln(w, "D0755 0", name)
+	fmt.Fprintf(w, "D%04o 0 %s\n", mode, name)
 	err := checkSCPStatus(r)
 	if err != nil {
 		return err
@@ -433,31 +861,36 @@ func scpUploadDirProtocol(name string, w io.Writer, r *bufio.Reader, f func() er
 	return nil
 }
 
-func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) error {
+// scpUploadDir uploads the given entries of the root directory, with their
+// modes and modification times. Entries whose path relative to src matches
+// an exclude pattern are skipped. Symlinks can't be sent with SCP, so they
+// are added to links instead, mapping their path relative to src to their
+// target.
+func scpUploadDir(src string, root string, fs []os.FileInfo, exclude []string, links map[string]string, w io.Writer, r *bufio.Reader) error {
 	for _, fi := range fs {
 		realPath := filepath.Join(root, fi.Name())
+		if scpExcluded(src, realPath, exclude) {
+			log.Printf("SCP: skipping excluded path: %s", realPath)
+			continue
+		}
 
-		// Track if this is actually a symlink to a directory. If it is
-		// a symlink to a file we don't do any special behavior because uploading
-		// a file just works. If it is a directory, we need to know so we
-		// treat it as such.
-		isSymlinkToDir := false
 		if fi.Mode()&os.ModeSymlink == os.ModeSymlink {
-			symPath, err := filepath.EvalSymlinks(realPath)
+			target, err := os.Readlink(realPath)
 			if err != nil {
 				return err
 			}
 
-			symFi, err := os.Lstat(symPath)
+			rel, err := filepath.Rel(src, realPath)
 			if err != nil {
 				return err
 			}
 
-			isSymlinkToDir = symFi.IsDir()
+			links[filepath.ToSlash(rel)] = target
+			continue
 		}
 
-		if !fi.IsDir() && !isSymlinkToDir {
-			// It is a regular file (or symlink to a file), just upload it
+		if !fi.IsDir() {
+			// It is a regular file, just upload it
 			f, err := os.Open(realPath)
 			if err != nil {
 				return err
@@ -465,7 +898,7 @@ func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) e
 
 			err = func() error {
 				defer f.Close()
-				return scpUploadFile(fi.Name(), f, w, r)
+				return scpUploadFile(fi.Name(), f, fi, w, r)
 			}()
 
 			if err != nil {
@@ -476,7 +909,7 @@ func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) e
 		}
 
 		// It is a directory, recursively upload
-		err := scpUploadDirProtocol(fi.Name(), w, r, func() error {
+		err := scpUploadDirProtocol(fi.Name(), fi, w, r, func() error {
 			f, err := os.Open(realPath)
 			if err != nil {
 				return err
@@ -488,7 +921,7 @@ func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) e
 				return err
 			}
 
-			return scpUploadDir(realPath, entries, w, r)
+			return scpUploadDir(src, realPath, entries, exclude, links, w, r)
 		})
 		if err != nil {
 			return err
@@ -497,3 +930,15 @@ func scpUploadDir(root string, fs []os.FileInfo, w io.Writer, r *bufio.Reader) e
 
 	return nil
 }
+
+// scpSourceCommand returns the remote scp command that sends the path.
+// The path is quoted so that the remote shell uses it literally, as
+// SFTP does.
+func scpSourceCommand(flags string, path string) string {
+	return fmt.Sprintf("scp %s %s", flags, shellQuote(filepath.ToSlash(path)))
+}
+
+// shellQuote quotes a string so that it is a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
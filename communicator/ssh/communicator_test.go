@@ -3,11 +3,17 @@
 package ssh
 
 import (
+	"bufio"
 	"bytes"
 	"code.google.com/p/go.crypto/ssh"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 )
 
 // private key for mock server
@@ -169,3 +175,210 @@ func TestStart(t *testing.T) {
 
 	client.Start(&cmd)
 }
+
+func TestScpDownloadFile(t *testing.T) {
+	source := "C0644 6 foo.txt\nhello\n\x00"
+	r := bufio.NewReader(strings.NewReader(source))
+	w := new(bytes.Buffer)
+	dst := new(bytes.Buffer)
+
+	if err := scpDownloadFile(dst, w, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if dst.String() != "hello\n" {
+		t.Fatalf("bad: %q", dst.String())
+	}
+
+	if w.String() != "\x00\x00\x00" {
+		t.Fatalf("bad acks: %q", w.String())
+	}
+}
+
+func TestScpDownloadFile_error(t *testing.T) {
+	source := "\x01scp: /tmp/foo: No such file or directory\n"
+	r := bufio.NewReader(strings.NewReader(source))
+
+	err := scpDownloadFile(new(bytes.Buffer), new(bytes.Buffer), r)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if !strings.Contains(err.Error(), "No such file") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestScpDownloadDir(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer-scp")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	source := "D0755 0 src\n" +
+		"C0644 3 a.txt\nfoo\x00" +
+		"C0600 3 skip.log\nbar\x00" +
+		"D0700 0 sub\n" +
+		"C0755 3 b.sh\nbaz\x00" +
+		"E\n" +
+		"E\n"
+
+	r := bufio.NewReader(strings.NewReader(source))
+	w := new(bytes.Buffer)
+	if err := scpDownloadDir(td, false, []string{"src/*.log"}, w, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(td, "src", "a.txt"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != "foo" {
+		t.Fatalf("bad: %q", data)
+	}
+
+	data, err = ioutil.ReadFile(filepath.Join(td, "src", "sub", "b.sh"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != "baz" {
+		t.Fatalf("bad: %q", data)
+	}
+
+	if _, err := os.Stat(filepath.Join(td, "src", "skip.log")); !os.IsNotExist(err) {
+		t.Fatalf("excluded file should not exist: %s", err)
+	}
+}
+
+func TestScpDownloadDir_skipRoot(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer-scp")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	source := "D0755 0 src\nC0644 3 a.txt\nfoo\x00E\n"
+	r := bufio.NewReader(strings.NewReader(source))
+	if err := scpDownloadDir(td, true, nil, new(bytes.Buffer), r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(td, "a.txt")); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestScpDownloadDir_badName(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer-scp")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	source := "C0644 3 ../a.txt\nfoo\x00"
+	r := bufio.NewReader(strings.NewReader(source))
+	if err := scpDownloadDir(td, false, nil, new(bytes.Buffer), r); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestScpExcluded(t *testing.T) {
+	cases := []struct {
+		Path     string
+		Exclude  []string
+		Excluded bool
+	}{
+		{"/src/a.txt", nil, false},
+		{"/src/a.txt", []string{"*.log"}, false},
+		{"/src/sub/b.log", []string{"*.log"}, true},
+		{"/src/sub/b.log", []string{"sub/*"}, true},
+		{"/src/other/b.log", []string{"sub/*"}, false},
+	}
+
+	for _, tc := range cases {
+		if scpExcluded("/src", tc.Path, tc.Exclude) != tc.Excluded {
+			t.Fatalf("bad: %s %#v", tc.Path, tc.Exclude)
+		}
+	}
+}
+
+func TestScpUploadDir(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer-scp")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	mtime := time.Unix(1388534400, 0)
+	os.MkdirAll(filepath.Join(td, "sub"), 0700)
+	ioutil.WriteFile(filepath.Join(td, "sub", "run.sh"), []byte("foo"), 0755)
+	os.Symlink("sub/run.sh", filepath.Join(td, "link"))
+	os.Chtimes(filepath.Join(td, "sub", "run.sh"), mtime, mtime)
+	os.Chtimes(filepath.Join(td, "sub"), mtime, mtime)
+
+	f, err := os.Open(td)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	entries, err := f.Readdir(-1)
+	f.Close()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	links := make(map[string]string)
+	r := bufio.NewReader(strings.NewReader(strings.Repeat("\x00", 10)))
+	w := new(bytes.Buffer)
+	if err := scpUploadDir(td, td, entries, nil, links, w, r); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := "T1388534400 0 1388534400 0\n" +
+		"D0700 0 sub\n" +
+		"T1388534400 0 1388534400 0\n" +
+		"C0755 3 run.sh\nfoo\x00" +
+		"E\n"
+	if w.String() != expected {
+		t.Fatalf("bad: %q", w.String())
+	}
+
+	if len(links) != 1 || links["link"] != "sub/run.sh" {
+		t.Fatalf("bad: %#v", links)
+	}
+}
+
+func TestScpSourceCommand(t *testing.T) {
+	cases := []struct {
+		Flags    string
+		Path     string
+		Expected string
+	}{
+		{"-vf", "/var/log/build.log", "scp -vf '/var/log/build.log'"},
+		{"-vf", "/tmp/my file.txt", "scp -vf '/tmp/my file.txt'"},
+		{"-rvf", "/tmp/$(id)/*", "scp -rvf '/tmp/$(id)/*'"},
+	}
+
+	for _, tc := range cases {
+		if actual := scpSourceCommand(tc.Flags, tc.Path); actual != tc.Expected {
+			t.Fatalf("bad: %s", actual)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"foo", "'foo'"},
+		{"foo bar", "'foo bar'"},
+		{"it's", `'it'\''s'`},
+	}
+
+	for _, tc := range cases {
+		if actual := shellQuote(tc.Input); actual != tc.Expected {
+			t.Fatalf("bad: %s", actual)
+		}
+	}
+}
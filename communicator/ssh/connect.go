@@ -1,6 +1,7 @@
 package ssh
 
 import (
+	"code.google.com/p/go.crypto/ssh"
 	"net"
 	"time"
 )
@@ -13,3 +14,56 @@ func ConnectFunc(network, addr string) func() (net.Conn, error) {
 		return net.DialTimeout(network, addr, 15*time.Second)
 	}
 }
+
+// BastionConnectFunc is a convenience method for returning a function
+// that connects to a host through a bastion host. The connection to the
+// bastion is made with its own SSH client, which is closed along with
+// the returned connection.
+func BastionConnectFunc(
+	bNetwork string,
+	bAddr string,
+	bConf *ssh.ClientConfig,
+	network string,
+	addr string) func() (net.Conn, error) {
+	if bConf.HostKeyChecker != nil {
+		conf := *bConf
+		conf.HostKeyChecker = HostKeyCheckerForAddress(bConf.HostKeyChecker, bAddr)
+		bConf = &conf
+	}
+
+	return func() (net.Conn, error) {
+		nc, err := net.DialTimeout(bNetwork, bAddr, 15*time.Second)
+		if err != nil {
+			return nil, err
+		}
+
+		bastion, err := ssh.Client(nc, bConf)
+		if err != nil {
+			nc.Close()
+			return nil, err
+		}
+
+		conn, err := bastion.Dial(network, addr)
+		if err != nil {
+			bastion.Close()
+			return nil, err
+		}
+
+		return &bastionConn{
+			Conn:    conn,
+			bastion: bastion,
+		}, nil
+	}
+}
+
+// bastionConn is a connection tunnelled through a bastion host that
+// also closes the bastion's SSH client when it is closed.
+type bastionConn struct {
+	net.Conn
+	bastion *ssh.ClientConn
+}
+
+func (c *bastionConn) Close() error {
+	c.Conn.Close()
+	return c.bastion.Close()
+}
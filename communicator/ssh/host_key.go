@@ -0,0 +1,322 @@
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"code.google.com/p/go.crypto/ssh"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// HostKeyError is the error returned by the host key checkers in this
+// package when a host key isn't trusted. Unlike other handshake errors,
+// retrying the connection won't make it go away.
+type HostKeyError struct {
+	// Addr is the address of the host.
+	Addr string
+
+	// Reason is why the key isn't trusted, such as "is revoked".
+	Reason string
+
+	// HostKey is the key of the host in authorized_keys format.
+	HostKey string
+}
+
+func newHostKeyError(addr, reason, algorithm string, hostKey []byte) *HostKeyError {
+	return &HostKeyError{
+		Addr:    addr,
+		Reason:  reason,
+		HostKey: FormatHostKey(algorithm, hostKey),
+	}
+}
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("host key for %s %s: %s", e.Addr, e.Reason, e.HostKey)
+}
+
+// FixedHostKeyChecker is an implementation of ssh.HostKeyChecker that
+// only accepts a single, known host key.
+type FixedHostKeyChecker struct {
+	Key []byte
+}
+
+// ParseHostKey parses a host key in the format used by authorized_keys
+// and .pub files, such as "ssh-rsa AAAA... comment".
+func ParseHostKey(line string) (*FixedHostKeyChecker, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 {
+		return nil, errors.New("host key must be in the form 'type base64-key'")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("error decoding host key: %s", err)
+	}
+
+	return &FixedHostKeyChecker{Key: key}, nil
+}
+
+func (c *FixedHostKeyChecker) Check(addr string, remote net.Addr, algorithm string, hostKey []byte) error {
+	if !bytes.Equal(c.Key, hostKey) {
+		return newHostKeyError(addr, "doesn't match the expected key", algorithm, hostKey)
+	}
+
+	return nil
+}
+
+// TOFUHostKeyChecker is an implementation of ssh.HostKeyChecker that
+// trusts the first host key it sees, and only accepts that key from then
+// on. This protects reconnections to the same machine.
+type TOFUHostKeyChecker struct {
+	algorithm string
+	key       []byte
+	l         sync.Mutex
+}
+
+func (c *TOFUHostKeyChecker) Check(addr string, remote net.Addr, algorithm string, hostKey []byte) error {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.key == nil {
+		c.algorithm = algorithm
+		c.key = append([]byte(nil), hostKey...)
+		return nil
+	}
+
+	if !bytes.Equal(c.key, hostKey) {
+		return newHostKeyError(
+			addr, "changed since it was first trusted", algorithm, hostKey)
+	}
+
+	return nil
+}
+
+// HostKey returns the trusted host key in authorized_keys format, or an
+// empty string if no key has been seen yet.
+func (c *TOFUHostKeyChecker) HostKey() string {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	if c.key == nil {
+		return ""
+	}
+
+	return FormatHostKey(c.algorithm, c.key)
+}
+
+// KnownHostsChecker is an implementation of ssh.HostKeyChecker that
+// verifies host keys against the entries of an OpenSSH known_hosts file.
+type KnownHostsChecker struct {
+	entries []knownHostsEntry
+}
+
+type knownHostsEntry struct {
+	Patterns []string
+	Revoked  bool
+	Key      []byte
+}
+
+// ParseKnownHosts parses the contents of a known_hosts file. Hashed
+// host names and wildcard patterns are supported. Certificate authority
+// entries are ignored.
+func ParseKnownHosts(data []byte) (*KnownHostsChecker, error) {
+	result := new(KnownHostsChecker)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		var entry knownHostsEntry
+		if strings.HasPrefix(fields[0], "@") {
+			if fields[0] == "@cert-authority" {
+				continue
+			}
+
+			if fields[0] != "@revoked" {
+				return nil, fmt.Errorf("line %d: unknown marker %s", lineNum, fields[0])
+			}
+
+			entry.Revoked = true
+			fields = fields[1:]
+		}
+
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected host, key type and key", lineNum)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: error decoding key: %s", lineNum, err)
+		}
+
+		entry.Patterns = strings.Split(fields[0], ",")
+		entry.Key = key
+		result.entries = append(result.entries, entry)
+	}
+
+	return result, scanner.Err()
+}
+
+func (c *KnownHostsChecker) Check(addr string, remote net.Addr, algorithm string, hostKey []byte) error {
+	if addr == "" && remote != nil {
+		addr = remote.String()
+	}
+
+	host := knownHostsName(addr)
+	found := false
+	for _, entry := range c.entries {
+		if !entry.matches(host) {
+			continue
+		}
+
+		if entry.Revoked {
+			if bytes.Equal(entry.Key, hostKey) {
+				return newHostKeyError(addr, "is revoked", algorithm, hostKey)
+			}
+
+			continue
+		}
+
+		if bytes.Equal(entry.Key, hostKey) {
+			found = true
+		}
+	}
+
+	if !found {
+		return newHostKeyError(
+			addr, "was not found in known hosts", algorithm, hostKey)
+	}
+
+	return nil
+}
+
+// FormatHostKey formats a host key in the format used by authorized_keys
+// and known_hosts files.
+func FormatHostKey(algorithm string, key []byte) string {
+	return fmt.Sprintf("%s %s", algorithm, base64.StdEncoding.EncodeToString(key))
+}
+
+// HostKeyCheckerForAddress returns a checker that passes addr on to the
+// given checker. The SSH library doesn't know the address of connections
+// that it didn't dial itself.
+func HostKeyCheckerForAddress(checker ssh.HostKeyChecker, addr string) ssh.HostKeyChecker {
+	return &addressHostKeyChecker{
+		checker: checker,
+		addr:    addr,
+	}
+}
+
+type addressHostKeyChecker struct {
+	checker ssh.HostKeyChecker
+	addr    string
+}
+
+func (c *addressHostKeyChecker) Check(addr string, remote net.Addr, algorithm string, hostKey []byte) error {
+	return c.checker.Check(c.addr, remote, algorithm, hostKey)
+}
+
+// knownHostsName returns the name a host is listed under in known_hosts,
+// which includes the port only if it isn't the default.
+func knownHostsName(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return strings.ToLower(addr)
+	}
+
+	host = strings.ToLower(host)
+	if port == "22" {
+		return host
+	}
+
+	return fmt.Sprintf("[%s]:%s", host, port)
+}
+
+func (e *knownHostsEntry) matches(host string) bool {
+	matched := false
+	for _, pattern := range e.Patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if negated {
+			pattern = pattern[1:]
+		}
+
+		if !knownHostsMatch(pattern, host) {
+			continue
+		}
+
+		// A negated pattern means the entry never matches the host
+		if negated {
+			return false
+		}
+
+		matched = true
+	}
+
+	return matched
+}
+
+func knownHostsMatch(pattern string, host string) bool {
+	if strings.HasPrefix(pattern, "|1|") {
+		parts := strings.Split(pattern[3:], "|")
+		if len(parts) != 2 {
+			return false
+		}
+
+		salt, err := base64.StdEncoding.DecodeString(parts[0])
+		if err != nil {
+			return false
+		}
+
+		hash, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return false
+		}
+
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(host))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+
+	return wildcardMatch(pattern, host)
+}
+
+// wildcardMatch matches a host against a pattern where "*" matches any
+// number of characters and "?" matches exactly one.
+func wildcardMatch(pattern string, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
@@ -0,0 +1,163 @@
+package ssh
+
+import (
+	"code.google.com/p/go.crypto/ssh"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"testing"
+)
+
+var testHostKey = []byte("\x00\x00\x00\x0bssh-ed25519host-key-1")
+var testOtherHostKey = []byte("\x00\x00\x00\x0bssh-ed25519host-key-2")
+
+func TestFixedHostKeyChecker_Impl(t *testing.T) {
+	var _ ssh.HostKeyChecker = new(FixedHostKeyChecker)
+}
+
+func TestParseHostKey(t *testing.T) {
+	c, err := ParseHostKey(FormatHostKey("ssh-ed25519", testHostKey) + " root@host")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := c.Check("host:22", nil, "ssh-ed25519", testHostKey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err = c.Check("host:22", nil, "ssh-ed25519", testOtherHostKey)
+	hostKeyErr, ok := err.(*HostKeyError)
+	if !ok {
+		t.Fatalf("bad: %#v", err)
+	}
+	if hostKeyErr.Addr != "host:22" || hostKeyErr.HostKey != FormatHostKey("ssh-ed25519", testOtherHostKey) {
+		t.Fatalf("bad: %#v", hostKeyErr)
+	}
+
+	if _, err := ParseHostKey("ssh-ed25519"); err == nil {
+		t.Fatal("should have error")
+	}
+
+	if _, err := ParseHostKey("ssh-ed25519 !!!"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestTOFUHostKeyChecker(t *testing.T) {
+	c := new(TOFUHostKeyChecker)
+	if c.HostKey() != "" {
+		t.Fatalf("bad: %s", c.HostKey())
+	}
+
+	if err := c.Check("host:22", nil, "ssh-ed25519", testHostKey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if c.HostKey() != FormatHostKey("ssh-ed25519", testHostKey) {
+		t.Fatalf("bad: %s", c.HostKey())
+	}
+
+	if err := c.Check("host:22", nil, "ssh-ed25519", testHostKey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := c.Check("host:22", nil, "ssh-ed25519", testOtherHostKey); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestKnownHostsChecker(t *testing.T) {
+	key := FormatHostKey("ssh-ed25519", testHostKey)
+	otherKey := FormatHostKey("ssh-ed25519", testOtherHostKey)
+
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte("hashed.example.com"))
+	hashed := fmt.Sprintf("|1|%s|%s",
+		base64.StdEncoding.EncodeToString(salt),
+		base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	data := fmt.Sprintf(`# comment
+example.com,10.0.0.1 %s
+[example.com]:2222 %s
+*.internal,!bad.internal %s
+%s %s
+@cert-authority *.example.com %s
+@revoked revoked.example.com %s
+revoked.example.com %s
+`, key, otherKey, key, hashed, key, otherKey, key, key)
+
+	c, err := ParseKnownHosts([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		Addr  string
+		Key   []byte
+		Valid bool
+	}{
+		{"example.com:22", testHostKey, true},
+		{"EXAMPLE.com:22", testHostKey, true},
+		{"10.0.0.1:22", testHostKey, true},
+		{"example.com:22", testOtherHostKey, false},
+		{"example.com:2222", testOtherHostKey, true},
+		{"example.com:2222", testHostKey, false},
+		{"foo.internal:22", testHostKey, true},
+		{"bad.internal:22", testHostKey, false},
+		{"hashed.example.com:22", testHostKey, true},
+		{"other.example.com:22", testHostKey, false},
+		{"revoked.example.com:22", testHostKey, false},
+	}
+
+	for _, tc := range cases {
+		err := c.Check(tc.Addr, nil, "ssh-ed25519", tc.Key)
+		if (err == nil) != tc.Valid {
+			t.Fatalf("%s: bad: %#v", tc.Addr, err)
+		}
+		if _, ok := err.(*HostKeyError); err != nil && !ok {
+			t.Fatalf("%s: bad: %#v", tc.Addr, err)
+		}
+	}
+
+	if _, err := ParseKnownHosts([]byte("example.com ssh-rsa")); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestHostKeyCheckerForAddress(t *testing.T) {
+	c, err := ParseKnownHosts([]byte(
+		"example.com " + FormatHostKey("ssh-ed25519", testHostKey)))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	checker := HostKeyCheckerForAddress(c, "example.com:22")
+	if err := checker.Check("", nil, "ssh-ed25519", testHostKey); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	cases := []struct {
+		Pattern string
+		Input   string
+		Match   bool
+	}{
+		{"foo", "foo", true},
+		{"foo", "foobar", false},
+		{"*", "", true},
+		{"*.com", "example.com", true},
+		{"*.com", "example.org", false},
+		{"10.0.0.?", "10.0.0.1", true},
+		{"10.0.0.?", "10.0.0.10", false},
+		{"[*]:2222", "[foo]:2222", true},
+	}
+
+	for _, tc := range cases {
+		if wildcardMatch(tc.Pattern, tc.Input) != tc.Match {
+			t.Fatalf("bad: %s %s", tc.Pattern, tc.Input)
+		}
+	}
+}
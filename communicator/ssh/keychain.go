@@ -2,55 +2,65 @@ package ssh
 
 import (
 	"code.google.com/p/go.crypto/ssh"
-	"crypto"
 	"crypto/dsa"
-	"crypto/rsa"
 	"crypto/x509"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"io"
+	"math/big"
 )
 
+// ErrKeyPassphraseRequired is returned when adding an encrypted private
+// key without a passphrase.
+var ErrKeyPassphraseRequired = errors.New("key is encrypted, a passphrase is required")
+
 // SimpleKeychain makes it easy to use private keys in order to connect
 // via SSH, since the interface exposed by Go isn't the easiest to use
 // right away.
+//
+// RSA, DSA and ECDSA keys are supported, in PKCS1, PKCS8 and SEC1 PEM
+// formats. The SSH library can't parse keys in the newer OpenSSH format
+// or ed25519 keys, so they aren't supported.
 type SimpleKeychain struct {
-	keys []interface{}
+	keys []ssh.Signer
 }
 
 // AddPEMKey adds a simple PEM encoded private key to the keychain.
-func (k *SimpleKeychain) AddPEMKey(key string) (err error) {
+func (k *SimpleKeychain) AddPEMKey(key string) error {
+	return k.AddPEMKeyPassword(key, "")
+}
+
+// AddPEMKeyPassword adds a PEM encoded private key that is protected by
+// a password to the keychain. If the key isn't encrypted, the password
+// is ignored.
+func (k *SimpleKeychain) AddPEMKeyPassword(key string, password string) error {
 	block, _ := pem.Decode([]byte(key))
 	if block == nil {
 		return errors.New("no block in key")
 	}
 
-	var rsakey interface{}
-	rsakey, err = x509.ParsePKCS1PrivateKey(block.Bytes)
-	if err != nil {
-		rsakey, err = x509.ParsePKCS8PrivateKey(block.Bytes)
-	}
+	if x509.IsEncryptedPEMBlock(block) {
+		if password == "" {
+			return ErrKeyPassphraseRequired
+		}
 
-	if err != nil {
-		return
-	}
+		der, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err != nil {
+			return fmt.Errorf("error decrypting key: %s", err)
+		}
 
-	k.keys = append(k.keys, rsakey)
-	return
-}
+		block = &pem.Block{Type: block.Type, Bytes: der}
+	}
 
-// AddPEMKeyPassword adds a PEM encoded private key that is protected by
-// a password to the keychain.
-func (k *SimpleKeychain) AddPEMKeyPassword(key string, password string) (err error) {
-	block, _ := pem.Decode([]byte(key))
-	bytes, _ := x509.DecryptPEMBlock(block, []byte(password))
-	rsakey, err := x509.ParsePKCS1PrivateKey(bytes)
+	signer, err := parsePEMBlock(block)
 	if err != nil {
-		return
+		return err
 	}
 
-	k.keys = append(k.keys, rsakey)
-	return
+	k.keys = append(k.keys, signer)
+	return nil
 }
 
 // Key method for ssh.ClientKeyring interface
@@ -58,24 +68,87 @@ func (k *SimpleKeychain) Key(i int) (ssh.PublicKey, error) {
 	if i < 0 || i >= len(k.keys) {
 		return nil, nil
 	}
-	switch key := k.keys[i].(type) {
-	case *rsa.PrivateKey:
-		return ssh.NewPublicKey(&key.PublicKey)
-	case *dsa.PrivateKey:
-		return ssh.NewPublicKey(&key.PublicKey)
-	}
-	panic("unknown key type")
+
+	return k.keys[i].PublicKey(), nil
 }
 
 // Sign method for ssh.ClientKeyring interface
 func (k *SimpleKeychain) Sign(i int, rand io.Reader, data []byte) (sig []byte, err error) {
-	hashFunc := crypto.SHA1
-	h := hashFunc.New()
-	h.Write(data)
-	digest := h.Sum(nil)
-	switch key := k.keys[i].(type) {
-	case *rsa.PrivateKey:
-		return rsa.SignPKCS1v15(rand, key, hashFunc, digest)
+	if i < 0 || i >= len(k.keys) {
+		return nil, errors.New("ssh: key index out of range")
 	}
-	return nil, errors.New("ssh: unknown key type")
+
+	return k.keys[i].Sign(rand, data)
+}
+
+// parsePEMBlock parses the unencrypted private key in a PEM block.
+func parsePEMBlock(block *pem.Block) (ssh.Signer, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY", "EC PRIVATE KEY":
+		return ssh.ParsePrivateKey(pem.EncodeToMemory(block))
+	case "DSA PRIVATE KEY":
+		key, err := parseDSAPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		return ssh.NewSignerFromKey(key)
+	case "OPENSSH PRIVATE KEY":
+		return nil, errors.New(
+			"OpenSSH format keys are not supported. RSA, DSA and ECDSA " +
+				"keys can be converted to PEM with 'ssh-keygen -p -m PEM', " +
+				"ed25519 keys can only be used through ssh-agent")
+	case "ENCRYPTED PRIVATE KEY":
+		return nil, errors.New("encrypted PKCS8 keys are not supported")
+	}
+
+	// Older keys don't always have a meaningful block type, so fall
+	// back to trying each of the formats.
+	var key interface{}
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		// Such as ed25519 keys in PKCS8
+		return nil, fmt.Errorf(
+			"unsupported key type %T, only RSA, DSA and ECDSA keys "+
+				"are supported", key)
+	}
+
+	return signer, nil
+}
+
+// parseDSAPrivateKey parses a DSA private key in the ASN.1 format that
+// OpenSSL uses, which the SSH library can't parse itself.
+func parseDSAPrivateKey(der []byte) (*dsa.PrivateKey, error) {
+	var k struct {
+		Version int
+		P       *big.Int
+		Q       *big.Int
+		G       *big.Int
+		Pub     *big.Int
+		Priv    *big.Int
+	}
+
+	if _, err := asn1.Unmarshal(der, &k); err != nil {
+		return nil, fmt.Errorf("error parsing DSA key: %s", err)
+	}
+
+	return &dsa.PrivateKey{
+		PublicKey: dsa.PublicKey{
+			Parameters: dsa.Parameters{
+				P: k.P,
+				Q: k.Q,
+				G: k.G,
+			},
+			Y: k.Pub,
+		},
+		X: k.Priv,
+	}, nil
 }
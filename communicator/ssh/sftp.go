@@ -0,0 +1,929 @@
+package ssh
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// This file implements the small subset of version 3 of the SSH File
+// Transfer Protocol that the communicator needs to transfer files. See
+// draft-ietf-secsh-filexfer-02 for the full protocol.
+
+const sftpProtocolVersion = 3
+
+// The maximum amount of data sent or requested in a single packet. Most
+// servers reject reads and writes larger than this.
+const sftpChunkSize = 32 * 1024
+
+// The largest packet the client accepts, which matches OpenSSH.
+const sftpMaxPacket = 256 * 1024
+
+const (
+	sftpPacketInit     = 1
+	sftpPacketVersion  = 2
+	sftpPacketOpen     = 3
+	sftpPacketClose    = 4
+	sftpPacketRead     = 5
+	sftpPacketWrite    = 6
+	sftpPacketLstat    = 7
+	sftpPacketSetstat  = 9
+	sftpPacketOpendir  = 11
+	sftpPacketReaddir  = 12
+	sftpPacketRemove   = 13
+	sftpPacketMkdir    = 14
+	sftpPacketStat     = 17
+	sftpPacketReadlink = 19
+	sftpPacketSymlink  = 20
+	sftpPacketStatus   = 101
+	sftpPacketHandle   = 102
+	sftpPacketData     = 103
+	sftpPacketName     = 104
+	sftpPacketAttrs    = 105
+)
+
+const (
+	sftpOpenRead  = 0x01
+	sftpOpenWrite = 0x02
+	sftpOpenCreat = 0x08
+	sftpOpenTrunc = 0x10
+)
+
+const (
+	sftpAttrSize        = 0x01
+	sftpAttrUidGid      = 0x02
+	sftpAttrPermissions = 0x04
+	sftpAttrAcModTime   = 0x08
+	sftpAttrExtended    = 0x80000000
+)
+
+const (
+	sftpStatusOK  = 0
+	sftpStatusEOF = 1
+)
+
+// POSIX file type bits as sent in the permissions attribute.
+const (
+	sftpModeType    = 0170000
+	sftpModeDir     = 0040000
+	sftpModeSymlink = 0120000
+)
+
+// sftpStatusError is returned when the server responds to a request
+// with a non-OK status.
+type sftpStatusError struct {
+	Code    uint32
+	Message string
+}
+
+func (e *sftpStatusError) Error() string {
+	return fmt.Sprintf("sftp: %s (code %d)", e.Message, e.Code)
+}
+
+// sftpAttrs are the file attributes understood by the client.
+type sftpAttrs struct {
+	Flags uint32
+	Size  uint64
+	Mode  uint32
+	Atime uint32
+	Mtime uint32
+}
+
+func (a *sftpAttrs) IsDir() bool {
+	return a.Flags&sftpAttrPermissions != 0 && a.Mode&sftpModeType == sftpModeDir
+}
+
+func (a *sftpAttrs) IsSymlink() bool {
+	return a.Flags&sftpAttrPermissions != 0 && a.Mode&sftpModeType == sftpModeSymlink
+}
+
+// FileMode returns the permission bits of the attributes.
+func (a *sftpAttrs) FileMode() os.FileMode {
+	return os.FileMode(a.Mode) & os.ModePerm
+}
+
+// sftpNameEntry is a single entry returned from reading a directory.
+type sftpNameEntry struct {
+	Name  string
+	Attrs *sftpAttrs
+}
+
+// sftpClient is a minimal, synchronous SFTP client. Requests are sent one
+// at a time, so it is safe but not particularly fast to use concurrently.
+type sftpClient struct {
+	r      *bufio.Reader
+	w      io.Writer
+	nextId uint32
+	l      sync.Mutex
+}
+
+// newSftpClient initializes the SFTP protocol over the given reader and
+// writer, which are usually the stdout and stdin of an "sftp" subsystem.
+func newSftpClient(r io.Reader, w io.Writer) (*sftpClient, error) {
+	c := &sftpClient{
+		r: bufio.NewReader(r),
+		w: w,
+	}
+
+	var buf sftpBuffer
+	buf.byte(sftpPacketInit)
+	buf.uint32(sftpProtocolVersion)
+	if err := c.send(buf); err != nil {
+		return nil, err
+	}
+
+	kind, data, err := c.recv()
+	if err != nil {
+		return nil, err
+	}
+
+	if kind != sftpPacketVersion {
+		return nil, fmt.Errorf("sftp: expected version packet, got %d", kind)
+	}
+
+	version, _, err := sftpUint32(data)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("SFTP: server protocol version %d", version)
+	if version < sftpProtocolVersion {
+		return nil, fmt.Errorf("sftp: unsupported protocol version %d", version)
+	}
+
+	return c, nil
+}
+
+// Create opens a remote file for writing, creating or truncating it.
+func (c *sftpClient) Create(p string, mode os.FileMode) (string, error) {
+	attrs := &sftpAttrs{
+		Flags: sftpAttrPermissions,
+		Mode:  uint32(mode & os.ModePerm),
+	}
+
+	return c.open(p, sftpOpenWrite|sftpOpenCreat|sftpOpenTrunc, attrs)
+}
+
+// Open opens a remote file for reading.
+func (c *sftpClient) Open(p string) (string, error) {
+	return c.open(p, sftpOpenRead, new(sftpAttrs))
+}
+
+func (c *sftpClient) open(p string, pflags uint32, attrs *sftpAttrs) (string, error) {
+	var buf sftpBuffer
+	buf.string(p)
+	buf.uint32(pflags)
+	buf.attrs(attrs)
+	return c.requestHandle(sftpPacketOpen, buf)
+}
+
+// Close closes a handle returned by Create, Open or OpenDir.
+func (c *sftpClient) Close(handle string) error {
+	var buf sftpBuffer
+	buf.string(handle)
+	return c.requestStatus(sftpPacketClose, buf)
+}
+
+// Write writes all the data from the reader to the handle.
+func (c *sftpClient) Write(handle string, r io.Reader) error {
+	chunk := make([]byte, sftpChunkSize)
+	var offset uint64
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			var buf sftpBuffer
+			buf.string(handle)
+			buf.uint64(offset)
+			buf.bytes(chunk[:n])
+			if err := c.requestStatus(sftpPacketWrite, buf); err != nil {
+				return err
+			}
+
+			offset += uint64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Read copies the contents of the handle into the writer.
+func (c *sftpClient) Read(handle string, w io.Writer) error {
+	var offset uint64
+	for {
+		var buf sftpBuffer
+		buf.string(handle)
+		buf.uint64(offset)
+		buf.uint32(sftpChunkSize)
+
+		kind, data, err := c.request(sftpPacketRead, buf)
+		if err != nil {
+			return err
+		}
+
+		switch kind {
+		case sftpPacketData:
+			chunk, _, err := sftpString(data)
+			if err != nil {
+				return err
+			}
+
+			if _, err := w.Write([]byte(chunk)); err != nil {
+				return err
+			}
+
+			offset += uint64(len(chunk))
+		case sftpPacketStatus:
+			err := sftpStatus(data)
+			if serr, ok := err.(*sftpStatusError); ok && serr.Code == sftpStatusEOF {
+				return nil
+			}
+
+			if err == nil {
+				err = errors.New("sftp: unexpected OK status while reading")
+			}
+
+			return err
+		default:
+			return fmt.Errorf("sftp: unexpected packet %d while reading", kind)
+		}
+	}
+}
+
+// Mkdir creates a remote directory.
+func (c *sftpClient) Mkdir(p string, mode os.FileMode) error {
+	var buf sftpBuffer
+	buf.string(p)
+	buf.attrs(&sftpAttrs{
+		Flags: sftpAttrPermissions,
+		Mode:  uint32(mode & os.ModePerm),
+	})
+	return c.requestStatus(sftpPacketMkdir, buf)
+}
+
+// Stat returns the attributes of a remote path, following symlinks.
+func (c *sftpClient) Stat(p string) (*sftpAttrs, error) {
+	return c.stat(sftpPacketStat, p)
+}
+
+// Lstat returns the attributes of a remote path without following symlinks.
+func (c *sftpClient) Lstat(p string) (*sftpAttrs, error) {
+	return c.stat(sftpPacketLstat, p)
+}
+
+func (c *sftpClient) stat(kind byte, p string) (*sftpAttrs, error) {
+	var buf sftpBuffer
+	buf.string(p)
+
+	rkind, data, err := c.request(kind, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	switch rkind {
+	case sftpPacketAttrs:
+		attrs, _, err := sftpReadAttrs(data)
+		return attrs, err
+	case sftpPacketStatus:
+		return nil, sftpStatus(data)
+	default:
+		return nil, fmt.Errorf("sftp: unexpected packet %d for stat", rkind)
+	}
+}
+
+// Chmod sets the permission bits of a remote path.
+func (c *sftpClient) Chmod(p string, mode os.FileMode) error {
+	return c.setstat(p, &sftpAttrs{
+		Flags: sftpAttrPermissions,
+		Mode:  uint32(mode & os.ModePerm),
+	})
+}
+
+// Chtimes sets the access and modification times of a remote path.
+func (c *sftpClient) Chtimes(p string, atime time.Time, mtime time.Time) error {
+	return c.setstat(p, &sftpAttrs{
+		Flags: sftpAttrAcModTime,
+		Atime: uint32(atime.Unix()),
+		Mtime: uint32(mtime.Unix()),
+	})
+}
+
+func (c *sftpClient) setstat(p string, attrs *sftpAttrs) error {
+	var buf sftpBuffer
+	buf.string(p)
+	buf.attrs(attrs)
+	return c.requestStatus(sftpPacketSetstat, buf)
+}
+
+// ReadDir returns all entries of a remote directory except "." and "..".
+func (c *sftpClient) ReadDir(p string) ([]sftpNameEntry, error) {
+	var buf sftpBuffer
+	buf.string(p)
+	handle, err := c.requestHandle(sftpPacketOpendir, buf)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close(handle)
+
+	result := make([]sftpNameEntry, 0)
+	for {
+		var buf sftpBuffer
+		buf.string(handle)
+
+		kind, data, err := c.request(sftpPacketReaddir, buf)
+		if err != nil {
+			return nil, err
+		}
+
+		switch kind {
+		case sftpPacketName:
+			entries, err := sftpReadNames(data)
+			if err != nil {
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				if entry.Name == "." || entry.Name == ".." {
+					continue
+				}
+
+				result = append(result, entry)
+			}
+		case sftpPacketStatus:
+			err := sftpStatus(data)
+			if serr, ok := err.(*sftpStatusError); ok && serr.Code == sftpStatusEOF {
+				return result, nil
+			}
+
+			if err == nil {
+				err = errors.New("sftp: unexpected OK status while reading directory")
+			}
+
+			return nil, err
+		default:
+			return nil, fmt.Errorf("sftp: unexpected packet %d while reading directory", kind)
+		}
+	}
+}
+
+// Readlink returns the target of a remote symlink.
+func (c *sftpClient) Readlink(p string) (string, error) {
+	var buf sftpBuffer
+	buf.string(p)
+
+	kind, data, err := c.request(sftpPacketReadlink, buf)
+	if err != nil {
+		return "", err
+	}
+
+	switch kind {
+	case sftpPacketName:
+		entries, err := sftpReadNames(data)
+		if err != nil {
+			return "", err
+		}
+
+		if len(entries) != 1 {
+			return "", fmt.Errorf("sftp: expected one name for readlink, got %d", len(entries))
+		}
+
+		return entries[0].Name, nil
+	case sftpPacketStatus:
+		return "", sftpStatus(data)
+	default:
+		return "", fmt.Errorf("sftp: unexpected packet %d for readlink", kind)
+	}
+}
+
+// Remove removes a remote file or symlink.
+func (c *sftpClient) Remove(p string) error {
+	var buf sftpBuffer
+	buf.string(p)
+	return c.requestStatus(sftpPacketRemove, buf)
+}
+
+// Symlink creates a remote symlink at p pointing to target.
+func (c *sftpClient) Symlink(target string, p string) error {
+	// OpenSSH sends the arguments in the reverse order of the draft,
+	// and since it is by far the most common server, we do the same.
+	var buf sftpBuffer
+	buf.string(target)
+	buf.string(p)
+	return c.requestStatus(sftpPacketSymlink, buf)
+}
+
+func (c *sftpClient) requestHandle(kind byte, payload sftpBuffer) (string, error) {
+	rkind, data, err := c.request(kind, payload)
+	if err != nil {
+		return "", err
+	}
+
+	switch rkind {
+	case sftpPacketHandle:
+		handle, _, err := sftpString(data)
+		return handle, err
+	case sftpPacketStatus:
+		if err := sftpStatus(data); err != nil {
+			return "", err
+		}
+
+		return "", errors.New("sftp: expected a handle, got OK status")
+	default:
+		return "", fmt.Errorf("sftp: unexpected packet %d, expected handle", rkind)
+	}
+}
+
+func (c *sftpClient) requestStatus(kind byte, payload sftpBuffer) error {
+	rkind, data, err := c.request(kind, payload)
+	if err != nil {
+		return err
+	}
+
+	if rkind != sftpPacketStatus {
+		return fmt.Errorf("sftp: unexpected packet %d, expected status", rkind)
+	}
+
+	return sftpStatus(data)
+}
+
+// request sends a single request and waits for its response, returning
+// the response type and its payload after the request ID.
+func (c *sftpClient) request(kind byte, payload sftpBuffer) (byte, []byte, error) {
+	c.l.Lock()
+	defer c.l.Unlock()
+
+	c.nextId++
+	id := c.nextId
+
+	var buf sftpBuffer
+	buf.byte(kind)
+	buf.uint32(id)
+	buf = append(buf, payload...)
+	if err := c.send(buf); err != nil {
+		return 0, nil, err
+	}
+
+	rkind, data, err := c.recv()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	rid, data, err := sftpUint32(data)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if rid != id {
+		return 0, nil, fmt.Errorf("sftp: response id %d doesn't match request id %d", rid, id)
+	}
+
+	return rkind, data, nil
+}
+
+func (c *sftpClient) send(packet sftpBuffer) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(packet)))
+	if _, err := c.w.Write(length[:]); err != nil {
+		return err
+	}
+
+	_, err := c.w.Write(packet)
+	return err
+}
+
+func (c *sftpClient) recv() (byte, []byte, error) {
+	return sftpReadPacket(c.r)
+}
+
+// sftpReadPacket reads a single length-prefixed packet, returning its
+// type and payload.
+func sftpReadPacket(r io.Reader) (byte, []byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(length[:])
+	if size == 0 || size > sftpMaxPacket {
+		return 0, nil, fmt.Errorf("sftp: invalid packet length %d", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return 0, nil, err
+	}
+
+	return data[0], data[1:], nil
+}
+
+// sftpBuffer builds the payload of a packet.
+type sftpBuffer []byte
+
+func (b *sftpBuffer) byte(v byte) {
+	*b = append(*b, v)
+}
+
+func (b *sftpBuffer) uint32(v uint32) {
+	var tmp [4]byte
+	binary.BigEndian.PutUint32(tmp[:], v)
+	*b = append(*b, tmp[:]...)
+}
+
+func (b *sftpBuffer) uint64(v uint64) {
+	var tmp [8]byte
+	binary.BigEndian.PutUint64(tmp[:], v)
+	*b = append(*b, tmp[:]...)
+}
+
+func (b *sftpBuffer) string(v string) {
+	b.uint32(uint32(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *sftpBuffer) bytes(v []byte) {
+	b.uint32(uint32(len(v)))
+	*b = append(*b, v...)
+}
+
+func (b *sftpBuffer) attrs(a *sftpAttrs) {
+	b.uint32(a.Flags &^ sftpAttrExtended)
+	if a.Flags&sftpAttrSize != 0 {
+		b.uint64(a.Size)
+	}
+
+	if a.Flags&sftpAttrPermissions != 0 {
+		b.uint32(a.Mode)
+	}
+
+	if a.Flags&sftpAttrAcModTime != 0 {
+		b.uint32(a.Atime)
+		b.uint32(a.Mtime)
+	}
+}
+
+var errSftpShortPacket = errors.New("sftp: packet too short")
+
+func sftpUint32(data []byte) (uint32, []byte, error) {
+	if len(data) < 4 {
+		return 0, nil, errSftpShortPacket
+	}
+
+	return binary.BigEndian.Uint32(data), data[4:], nil
+}
+
+func sftpUint64(data []byte) (uint64, []byte, error) {
+	if len(data) < 8 {
+		return 0, nil, errSftpShortPacket
+	}
+
+	return binary.BigEndian.Uint64(data), data[8:], nil
+}
+
+func sftpString(data []byte) (string, []byte, error) {
+	length, data, err := sftpUint32(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if uint32(len(data)) < length {
+		return "", nil, errSftpShortPacket
+	}
+
+	return string(data[:length]), data[length:], nil
+}
+
+func sftpReadAttrs(data []byte) (*sftpAttrs, []byte, error) {
+	var err error
+	attrs := new(sftpAttrs)
+	if attrs.Flags, data, err = sftpUint32(data); err != nil {
+		return nil, nil, err
+	}
+
+	if attrs.Flags&sftpAttrSize != 0 {
+		if attrs.Size, data, err = sftpUint64(data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if attrs.Flags&sftpAttrUidGid != 0 {
+		// The owner isn't used, just skip it
+		if _, data, err = sftpUint64(data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if attrs.Flags&sftpAttrPermissions != 0 {
+		if attrs.Mode, data, err = sftpUint32(data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if attrs.Flags&sftpAttrAcModTime != 0 {
+		if attrs.Atime, data, err = sftpUint32(data); err != nil {
+			return nil, nil, err
+		}
+
+		if attrs.Mtime, data, err = sftpUint32(data); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if attrs.Flags&sftpAttrExtended != 0 {
+		var count uint32
+		if count, data, err = sftpUint32(data); err != nil {
+			return nil, nil, err
+		}
+
+		for i := uint32(0); i < count*2; i++ {
+			if _, data, err = sftpString(data); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return attrs, data, nil
+}
+
+func sftpReadNames(data []byte) ([]sftpNameEntry, error) {
+	count, data, err := sftpUint32(data)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]sftpNameEntry, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var entry sftpNameEntry
+		if entry.Name, data, err = sftpString(data); err != nil {
+			return nil, err
+		}
+
+		// Skip the long name, it is only meant for humans
+		if _, data, err = sftpString(data); err != nil {
+			return nil, err
+		}
+
+		if entry.Attrs, data, err = sftpReadAttrs(data); err != nil {
+			return nil, err
+		}
+
+		result = append(result, entry)
+	}
+
+	return result, nil
+}
+
+// sftpStatus turns a status response into an error, or nil if the
+// status is OK.
+func sftpStatus(data []byte) error {
+	code, data, err := sftpUint32(data)
+	if err != nil {
+		return err
+	}
+
+	if code == sftpStatusOK {
+		return nil
+	}
+
+	message, _, err := sftpString(data)
+	if err != nil {
+		message = "unknown error"
+	}
+
+	return &sftpStatusError{Code: code, Message: message}
+}
+
+// sftpUploadFile uploads the contents of the reader to the remote path.
+func sftpUploadFile(c *sftpClient, dst string, src io.Reader, mode os.FileMode) error {
+	log.Printf("SFTP: uploading file: %s", dst)
+	handle, err := c.Create(dst, mode)
+	if err != nil {
+		return err
+	}
+
+	if err := c.Write(handle, src); err != nil {
+		c.Close(handle)
+		return err
+	}
+
+	return c.Close(handle)
+}
+
+// sftpUploadDir recursively uploads the local directory src into the
+// remote directory dst, preserving file modes, modification times and
+// symlinks. Paths whose path relative to root matches an exclude pattern
+// are skipped.
+func sftpUploadDir(c *sftpClient, root string, dst string, src string, exclude []string) error {
+	entries, err := readDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, fi := range entries {
+		localPath := filepath.Join(src, fi.Name())
+		remotePath := path.Join(dst, fi.Name())
+		if scpExcluded(root, localPath, exclude) {
+			log.Printf("SFTP: skipping excluded path: %s", localPath)
+			continue
+		}
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(localPath)
+			if err != nil {
+				return err
+			}
+
+			log.Printf("SFTP: creating symlink: %s -> %s", remotePath, target)
+			if _, err := c.Lstat(remotePath); err == nil {
+				if err := c.Remove(remotePath); err != nil {
+					return err
+				}
+			}
+
+			if err := c.Symlink(target, remotePath); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if fi.IsDir() {
+			if err := sftpMkdirAll(c, remotePath, fi.Mode()); err != nil {
+				return err
+			}
+
+			if err := sftpUploadDir(c, root, remotePath, localPath, exclude); err != nil {
+				return err
+			}
+		} else {
+			err := func() error {
+				f, err := os.Open(localPath)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				return sftpUploadFile(c, remotePath, f, fi.Mode())
+			}()
+			if err != nil {
+				return err
+			}
+
+			// The mode given at creation is subject to the remote umask
+			if err := c.Chmod(remotePath, fi.Mode()); err != nil {
+				return err
+			}
+		}
+
+		if err := c.Chtimes(remotePath, fi.ModTime(), fi.ModTime()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sftpMkdirAll creates the remote directory and any missing parents, like
+// "mkdir -p". The directory gets the given mode and the parents 0755.
+func sftpMkdirAll(c *sftpClient, dir string, mode os.FileMode) error {
+	attrs, err := c.Stat(dir)
+	if err == nil {
+		if !attrs.IsDir() {
+			return fmt.Errorf("Remote path exists and is not a directory: %s", dir)
+		}
+
+		return nil
+	}
+
+	if parent := path.Dir(dir); parent != dir && parent != "." {
+		if err := sftpMkdirAll(c, parent, 0755); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mkdir(dir, mode); err != nil {
+		// Something else may have created it in the meantime
+		if attrs, serr := c.Stat(dir); serr == nil && attrs.IsDir() {
+			return nil
+		}
+
+		return err
+	}
+
+	return c.Chmod(dir, mode)
+}
+
+// sftpDownloadDir recursively downloads the remote directory src into the
+// local directory dst, preserving file modes, modification times and
+// symlinks. Paths whose path relative to root matches an exclude pattern
+// are skipped.
+func sftpDownloadDir(c *sftpClient, root string, dst string, src string, exclude []string) error {
+	entries, err := c.ReadDir(src)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Name == "" || entry.Name == "." || entry.Name == ".." ||
+			filepath.Base(entry.Name) != entry.Name {
+			return fmt.Errorf("Invalid SFTP file name: %q", entry.Name)
+		}
+
+		localPath := filepath.Join(dst, entry.Name)
+		remotePath := path.Join(src, entry.Name)
+		if scpExcluded(root, localPath, exclude) {
+			log.Printf("SFTP: skipping excluded path: %s", remotePath)
+			continue
+		}
+
+		attrs := entry.Attrs
+		switch {
+		case attrs.IsSymlink():
+			target, err := c.Readlink(remotePath)
+			if err != nil {
+				return err
+			}
+
+			os.Remove(localPath)
+			if err := os.Symlink(target, localPath); err != nil {
+				return err
+			}
+
+			continue
+		case attrs.IsDir():
+			if err := os.MkdirAll(localPath, attrs.FileMode()|0700); err != nil {
+				return err
+			}
+
+			if err := sftpDownloadDir(c, root, localPath, remotePath, exclude); err != nil {
+				return err
+			}
+
+			if err := os.Chmod(localPath, attrs.FileMode()|0700); err != nil {
+				return err
+			}
+		default:
+			log.Printf("SFTP: downloading file: %s", remotePath)
+			err := func() error {
+				f, err := os.OpenFile(localPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				handle, err := c.Open(remotePath)
+				if err != nil {
+					return err
+				}
+
+				if err := c.Read(handle, f); err != nil {
+					c.Close(handle)
+					return err
+				}
+
+				return c.Close(handle)
+			}()
+			if err != nil {
+				return err
+			}
+
+			if attrs.Flags&sftpAttrPermissions != 0 {
+				if err := os.Chmod(localPath, attrs.FileMode()); err != nil {
+					return err
+				}
+			}
+		}
+
+		if attrs.Flags&sftpAttrAcModTime != 0 {
+			atime := time.Unix(int64(attrs.Atime), 0)
+			mtime := time.Unix(int64(attrs.Mtime), 0)
+			if err := os.Chtimes(localPath, atime, mtime); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func readDir(dir string) ([]os.FileInfo, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return f.Readdir(-1)
+}
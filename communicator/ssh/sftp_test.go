@@ -0,0 +1,482 @@
+package ssh
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSftpServer is a minimal in-process SFTP server that serves files
+// out of a local directory.
+type testSftpServer struct {
+	Root string
+
+	handles map[string]*testSftpHandle
+	next    int
+}
+
+type testSftpHandle struct {
+	file *os.File
+	dir  []os.FileInfo
+	sent bool
+}
+
+func (s *testSftpServer) serve(r io.Reader, w io.Writer) error {
+	s.handles = make(map[string]*testSftpHandle)
+	for {
+		kind, data, err := sftpReadPacket(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		if kind == sftpPacketInit {
+			var resp sftpBuffer
+			resp.byte(sftpPacketVersion)
+			resp.uint32(sftpProtocolVersion)
+			testSftpSend(w, resp)
+			continue
+		}
+
+		id, data, _ := sftpUint32(data)
+		var resp sftpBuffer
+		if err := s.handle(kind, data, &resp); err != nil {
+			resp = nil
+			resp.byte(sftpPacketStatus)
+			resp.uint32(id)
+			if err == io.EOF {
+				resp.uint32(sftpStatusEOF)
+			} else {
+				resp.uint32(4)
+			}
+			resp.string(err.Error())
+			resp.string("")
+		} else if len(resp) == 0 {
+			resp.byte(sftpPacketStatus)
+			resp.uint32(id)
+			resp.uint32(sftpStatusOK)
+			resp.string("")
+			resp.string("")
+		} else {
+			// Insert the ID after the packet type
+			resp = append(sftpBuffer{resp[0], 0, 0, 0, 0}, resp[1:]...)
+			binary.BigEndian.PutUint32(resp[1:5], id)
+		}
+
+		if err := testSftpSend(w, resp); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *testSftpServer) handle(kind byte, data []byte, resp *sftpBuffer) error {
+	switch kind {
+	case sftpPacketOpen:
+		p, data, _ := sftpString(data)
+		pflags, data, _ := sftpUint32(data)
+		attrs, _, _ := sftpReadAttrs(data)
+
+		flags := os.O_RDONLY
+		if pflags&sftpOpenWrite != 0 {
+			flags = os.O_WRONLY
+		}
+		if pflags&sftpOpenCreat != 0 {
+			flags |= os.O_CREATE
+		}
+		if pflags&sftpOpenTrunc != 0 {
+			flags |= os.O_TRUNC
+		}
+
+		f, err := os.OpenFile(s.path(p), flags, attrs.FileMode())
+		if err != nil {
+			return err
+		}
+
+		s.newHandle(&testSftpHandle{file: f}, resp)
+	case sftpPacketClose:
+		h, _, _ := sftpString(data)
+		if handle, ok := s.handles[h]; ok && handle.file != nil {
+			handle.file.Close()
+		}
+		delete(s.handles, h)
+	case sftpPacketRead:
+		h, data, _ := sftpString(data)
+		offset, data, _ := sftpUint64(data)
+		length, _, _ := sftpUint32(data)
+
+		buf := make([]byte, length)
+		n, err := s.handles[h].file.ReadAt(buf, int64(offset))
+		if n == 0 && err != nil {
+			return err
+		}
+
+		resp.byte(sftpPacketData)
+		resp.bytes(buf[:n])
+	case sftpPacketWrite:
+		h, data, _ := sftpString(data)
+		offset, data, _ := sftpUint64(data)
+		chunk, _, _ := sftpString(data)
+
+		_, err := s.handles[h].file.WriteAt([]byte(chunk), int64(offset))
+		return err
+	case sftpPacketStat, sftpPacketLstat:
+		p, _, _ := sftpString(data)
+		stat := os.Stat
+		if kind == sftpPacketLstat {
+			stat = os.Lstat
+		}
+
+		fi, err := stat(s.path(p))
+		if err != nil {
+			return err
+		}
+
+		resp.byte(sftpPacketAttrs)
+		resp.attrs(testSftpAttrs(fi))
+	case sftpPacketSetstat:
+		p, data, _ := sftpString(data)
+		attrs, _, _ := sftpReadAttrs(data)
+		if attrs.Flags&sftpAttrPermissions != 0 {
+			if err := os.Chmod(s.path(p), attrs.FileMode()); err != nil {
+				return err
+			}
+		}
+
+		if attrs.Flags&sftpAttrAcModTime != 0 {
+			err := os.Chtimes(s.path(p),
+				time.Unix(int64(attrs.Atime), 0), time.Unix(int64(attrs.Mtime), 0))
+			if err != nil {
+				return err
+			}
+		}
+	case sftpPacketRemove:
+		p, _, _ := sftpString(data)
+		return os.Remove(s.path(p))
+	case sftpPacketSymlink:
+		target, data, _ := sftpString(data)
+		p, _, _ := sftpString(data)
+		return os.Symlink(target, s.path(p))
+	case sftpPacketMkdir:
+		p, data, _ := sftpString(data)
+		attrs, _, _ := sftpReadAttrs(data)
+		return os.Mkdir(s.path(p), attrs.FileMode())
+	case sftpPacketOpendir:
+		p, _, _ := sftpString(data)
+		entries, err := readDir(s.path(p))
+		if err != nil {
+			return err
+		}
+
+		s.newHandle(&testSftpHandle{dir: entries}, resp)
+	case sftpPacketReaddir:
+		h, _, _ := sftpString(data)
+		handle := s.handles[h]
+		if handle.sent {
+			return io.EOF
+		}
+		handle.sent = true
+
+		resp.byte(sftpPacketName)
+		resp.uint32(uint32(len(handle.dir)))
+		for _, fi := range handle.dir {
+			resp.string(fi.Name())
+			resp.string(fi.Name())
+			resp.attrs(testSftpAttrs(fi))
+		}
+	case sftpPacketReadlink:
+		p, _, _ := sftpString(data)
+		target, err := os.Readlink(s.path(p))
+		if err != nil {
+			return err
+		}
+
+		resp.byte(sftpPacketName)
+		resp.uint32(1)
+		resp.string(target)
+		resp.string(target)
+		resp.attrs(new(sftpAttrs))
+	default:
+		return fmt.Errorf("unsupported packet %d", kind)
+	}
+
+	return nil
+}
+
+func (s *testSftpServer) path(p string) string {
+	return filepath.Join(s.Root, filepath.FromSlash(p))
+}
+
+func (s *testSftpServer) newHandle(h *testSftpHandle, resp *sftpBuffer) {
+	s.next++
+	id := fmt.Sprintf("%d", s.next)
+	s.handles[id] = h
+
+	resp.byte(sftpPacketHandle)
+	resp.string(id)
+}
+
+func testSftpAttrs(fi os.FileInfo) *sftpAttrs {
+	mode := uint32(fi.Mode() & os.ModePerm)
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		mode |= sftpModeSymlink
+	case fi.IsDir():
+		mode |= sftpModeDir
+	default:
+		mode |= 0100000
+	}
+
+	return &sftpAttrs{
+		Flags: sftpAttrSize | sftpAttrPermissions | sftpAttrAcModTime,
+		Size:  uint64(fi.Size()),
+		Mode:  mode,
+		Atime: uint32(fi.ModTime().Unix()),
+		Mtime: uint32(fi.ModTime().Unix()),
+	}
+}
+
+func testSftpSend(w io.Writer, packet sftpBuffer) error {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(packet)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(packet)
+	return err
+}
+
+// testSftpClient starts a server for the given root and returns a client
+// connected to it, along with a function to shut both down.
+func testSftpClient(t *testing.T, root string) (*sftpClient, func()) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer serverW.Close()
+
+		server := &testSftpServer{Root: root}
+		if err := server.serve(serverR, serverW); err != nil {
+			t.Errorf("server err: %s", err)
+		}
+	}()
+
+	client, err := newSftpClient(clientR, clientW)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return client, func() {
+		clientW.Close()
+		wg.Wait()
+	}
+}
+
+func testTempDir(t *testing.T) string {
+	td, err := ioutil.TempDir("", "packer-sftp")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return td
+}
+
+func TestSftpUploadDownloadFile(t *testing.T) {
+	root := testTempDir(t)
+	defer os.RemoveAll(root)
+
+	client, done := testSftpClient(t, root)
+	defer done()
+
+	// Large enough to need multiple chunks
+	data := strings.Repeat("packer", sftpChunkSize)
+	err := sftpUploadFile(client, "/foo.txt", strings.NewReader(data), 0600)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	actual, err := ioutil.ReadFile(filepath.Join(root, "foo.txt"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(actual) != data {
+		t.Fatalf("bad upload, length %d", len(actual))
+	}
+
+	handle, err := client.Open("/foo.txt")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := client.Read(handle, &buf); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := client.Close(handle); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if buf.String() != data {
+		t.Fatalf("bad download, length %d", buf.Len())
+	}
+}
+
+func TestSftpOpen_missing(t *testing.T) {
+	root := testTempDir(t)
+	defer os.RemoveAll(root)
+
+	client, done := testSftpClient(t, root)
+	defer done()
+
+	if _, err := client.Open("/nope"); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestSftpMkdirAll(t *testing.T) {
+	root := testTempDir(t)
+	defer os.RemoveAll(root)
+
+	client, done := testSftpClient(t, root)
+	defer done()
+
+	if err := sftpMkdirAll(client, "/a/b/c", 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(root, "a", "b", "c"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() != 0700 {
+		t.Fatalf("bad: %s", fi.Mode())
+	}
+
+	// Existing directories are left alone
+	if err := sftpMkdirAll(client, "/a/b", 0700); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ioutil.WriteFile(filepath.Join(root, "file"), []byte("data"), 0644)
+	if err := sftpMkdirAll(client, "/file/sub", 0755); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestSftpUploadDir(t *testing.T) {
+	src := testTempDir(t)
+	defer os.RemoveAll(src)
+	root := testTempDir(t)
+	defer os.RemoveAll(root)
+
+	mtime := time.Unix(1388534400, 0)
+	os.MkdirAll(filepath.Join(src, "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "run.sh"), []byte("#!/bin/sh"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "sub", "data"), []byte("data"), 0600)
+	ioutil.WriteFile(filepath.Join(src, "skip.tmp"), []byte("skip"), 0644)
+	os.Chtimes(filepath.Join(src, "run.sh"), mtime, mtime)
+	os.Symlink("sub/data", filepath.Join(src, "link"))
+
+	// An existing file is replaced by the symlink
+	ioutil.WriteFile(filepath.Join(root, "link"), []byte("old"), 0644)
+
+	client, done := testSftpClient(t, root)
+	defer done()
+
+	if err := sftpUploadDir(client, src, "/", src, []string{"*.tmp"}); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(root, "run.sh"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("bad mode: %s", fi.Mode())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Fatalf("bad mtime: %s", fi.ModTime())
+	}
+
+	fi, err = os.Stat(filepath.Join(root, "sub", "data"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Fatalf("bad mode: %s", fi.Mode())
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "skip.tmp")); !os.IsNotExist(err) {
+		t.Fatalf("excluded file should not exist: %s", err)
+	}
+
+	target, err := os.Readlink(filepath.Join(root, "link"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if target != "sub/data" {
+		t.Fatalf("bad link target: %s", target)
+	}
+}
+
+func TestSftpDownloadDir(t *testing.T) {
+	root := testTempDir(t)
+	defer os.RemoveAll(root)
+	dst := testTempDir(t)
+	defer os.RemoveAll(dst)
+
+	mtime := time.Unix(1388534400, 0)
+	os.MkdirAll(filepath.Join(root, "src", "sub"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "src", "run.sh"), []byte("#!/bin/sh"), 0755)
+	ioutil.WriteFile(filepath.Join(root, "src", "sub", "data"), []byte("data"), 0600)
+	os.Symlink("run.sh", filepath.Join(root, "src", "link"))
+	os.Chtimes(filepath.Join(root, "src", "run.sh"), mtime, mtime)
+
+	client, done := testSftpClient(t, root)
+	defer done()
+
+	if err := sftpDownloadDir(client, dst, dst, "/src", nil); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	fi, err := os.Stat(filepath.Join(dst, "run.sh"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if fi.Mode().Perm() != 0755 {
+		t.Fatalf("bad mode: %s", fi.Mode())
+	}
+	if !fi.ModTime().Equal(mtime) {
+		t.Fatalf("bad mtime: %s", fi.ModTime())
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dst, "sub", "data"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if string(data) != "data" {
+		t.Fatalf("bad: %q", data)
+	}
+
+	target, err := os.Readlink(filepath.Join(dst, "link"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if target != "run.sh" {
+		t.Fatalf("bad: %s", target)
+	}
+}
@@ -53,6 +53,7 @@ const defaultConfig = `
 		"file": "packer-provisioner-file",
 		"puppet-masterless": "packer-provisioner-puppet-masterless",
 		"shell": "packer-provisioner-shell",
+		"shell-local": "packer-provisioner-shell-local",
 		"salt-masterless": "packer-provisioner-salt-masterless"
 	}
 }
@@ -62,6 +63,10 @@ type config struct {
 	PluginMinPort uint
 	PluginMaxPort uint
 
+	// The helper command for the "secret" function. This is passed on to
+	// the command plugins in the environment, unless it is already set.
+	SecretHelper string `json:"secret_helper"`
+
 	Builders       map[string]string
 	Commands       map[string]string
 	PostProcessors map[string]string `json:"post-processors"`
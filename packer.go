@@ -4,6 +4,7 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"github.com/mitchellh/packer/common/secret"
 	"github.com/mitchellh/packer/packer"
 	"github.com/mitchellh/packer/packer/plugin"
 	"github.com/mitchellh/panicwrap"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 )
 
 func main() {
@@ -50,13 +52,18 @@ func realMain() int {
 	os.Setenv("PACKER_LOG", "")
 	os.Setenv("PACKER_LOG_FILE", "")
 
+	// Sensitive values are redacted from everything the wrapped process
+	// logs, which includes the logs of plugins and any crash.
+	filter := &sensitiveFilter{Writer: io.MultiWriter(logTempFile, logWriter)}
+
 	// Create the configuration for panicwrap and wrap our executable
 	wrapConfig := &panicwrap.WrapConfig{
-		Handler: panicHandler(logTempFile),
-		Writer:  io.MultiWriter(logTempFile, logWriter),
+		Handler: panicHandler(logTempFile, filter),
+		Writer:  filter,
 	}
 
 	exitStatus, err := panicwrap.Wrap(wrapConfig)
+	filter.Flush()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Couldn't start Packer: %s", err)
 		return 1
@@ -76,7 +83,8 @@ func realMain() int {
 // wrappedMain is called only when we're wrapped by panicwrap and
 // returns the exit status to exit with.
 func wrappedMain() int {
-	log.SetOutput(os.Stderr)
+	log.SetOutput(packer.NewRedactWriter(os.Stderr))
+	packer.AnnounceSensitiveValues(os.Stderr)
 
 	log.Printf(
 		"Packer Version: %s %s %s",
@@ -95,6 +103,10 @@ func wrappedMain() int {
 
 	log.Printf("Packer config: %+v", config)
 
+	if config.SecretHelper != "" && os.Getenv(secret.HelperEnvVar) == "" {
+		os.Setenv(secret.HelperEnvVar, config.SecretHelper)
+	}
+
 	cacheDir := os.Getenv("PACKER_CACHE_DIR")
 	if cacheDir == "" {
 		cacheDir = "packer_cache"
@@ -117,6 +129,10 @@ func wrappedMain() int {
 	// Determine if we're in machine-readable mode by mucking around with
 	// the arguments...
 	args, machineReadable := extractMachineReadable(os.Args[1:])
+	if machineReadable != "" && machineReadable != "csv" && machineReadable != "json" {
+		fmt.Fprintf(os.Stderr, "Unknown machine-readable format: %s\n", machineReadable)
+		return 1
+	}
 
 	defer plugin.CleanupClients()
 
@@ -129,9 +145,15 @@ func wrappedMain() int {
 	envConfig.Components.Hook = config.LoadHook
 	envConfig.Components.PostProcessor = config.LoadPostProcessor
 	envConfig.Components.Provisioner = config.LoadProvisioner
-	if machineReadable {
-		envConfig.Ui = &packer.MachineReadableUi{
-			Writer: os.Stdout,
+	if machineReadable != "" {
+		if machineReadable == "json" {
+			envConfig.Ui = &packer.JsonUi{
+				Writer: os.Stdout,
+			}
+		} else {
+			envConfig.Ui = &packer.MachineReadableUi{
+				Writer: os.Stdout,
+			}
 		}
 
 		// Set this so that we don't get colored output in our machine-
@@ -160,20 +182,25 @@ func wrappedMain() int {
 }
 
 // extractMachineReadable checks the args for the machine readable
-// flag and returns whether or not it is on. It modifies the args
-// to remove this flag.
-func extractMachineReadable(args []string) ([]string, bool) {
+// flag and returns the format of the output it asks for, or the empty
+// string if it isn't there. It modifies the args to remove this flag.
+func extractMachineReadable(args []string) ([]string, string) {
 	for i, arg := range args {
-		if arg == "-machine-readable" {
+		if arg == "-machine-readable" || strings.HasPrefix(arg, "-machine-readable=") {
+			format := "csv"
+			if idx := strings.Index(arg, "="); idx > -1 {
+				format = arg[idx+1:]
+			}
+
 			// We found it. Slice it out.
 			result := make([]string, len(args)-1)
 			copy(result, args[:i])
 			copy(result[i:], args[i+1:])
-			return result, true
+			return result, format
 		}
 	}
 
-	return args, false
+	return args, ""
 }
 
 func loadConfig() (*config, error) {
@@ -30,3 +30,14 @@ type Artifact interface {
 	// no longer needed.
 	Destroy() error
 }
+
+// A StateArtifact is an Artifact that has builder specific state about it,
+// such as the SSH host key that was trusted on first use. Artifacts don't
+// have to implement it, so check for it with a type assertion.
+type StateArtifact interface {
+	Artifact
+
+	// State returns the state with the given name, or nil if the
+	// artifact doesn't know about it.
+	State(name string) interface{}
+}
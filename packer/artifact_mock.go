@@ -5,6 +5,7 @@ type MockArtifact struct {
 	BuilderIdValue string
 	FilesValue     []string
 	IdValue        string
+	StateValues    map[string]interface{}
 	DestroyCalled  bool
 }
 
@@ -37,6 +38,10 @@ func (*MockArtifact) String() string {
 	return "string"
 }
 
+func (a *MockArtifact) State(name string) interface{} {
+	return a.StateValues[name]
+}
+
 func (a *MockArtifact) Destroy() error {
 	a.DestroyCalled = true
 	return nil
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 )
 
 const (
@@ -24,11 +25,30 @@ const (
 	// force build is enabled.
 	ForceConfigKey = "packer_force"
 
+	// This is the key in configurations that is set to what builders
+	// should do when a step fails. It is one of the OnError constants.
+	OnErrorConfigKey = "packer_on_error"
+
+	// This is the key in configurations that is set to the absolute path
+	// of the template file, or the empty string if the template wasn't
+	// read from a file.
+	TemplatePathConfigKey = "packer_template_path"
+
 	// This key contains a map[string]string of the user variables for
 	// template processing.
 	UserVariablesConfigKey = "packer_user_variables"
 )
 
+// These are the values for what builders do when a step fails. By default,
+// everything that was created is cleaned up. With abort, cleanup is skipped
+// so that the machine can be inspected. With ask, the user is asked whether
+// to retry the step, clean up or abort.
+const (
+	OnErrorCleanup = "cleanup"
+	OnErrorAbort   = "abort"
+	OnErrorAsk     = "ask"
+)
+
 // A Build represents a single job within Packer that is responsible for
 // building some machine image artifact. Builds are meant to be parallelized.
 type Build interface {
@@ -64,6 +84,18 @@ type Build interface {
 	// When SetForce is set to true, existing artifacts from the build are
 	// deleted prior to the build.
 	SetForce(bool)
+
+	// BuilderType is the type of the builder of the build.
+	BuilderType() string
+
+	// UserVariables are the values of the user variables that the build
+	// was created with, after defaults are applied.
+	UserVariables() map[string]string
+
+	// SetOnError sets what the builder does when a step fails. It is one
+	// of the OnError constants, and defaults to OnErrorCleanup. This must
+	// be called prior to Prepare.
+	SetOnError(string)
 }
 
 // A build struct represents a single build job, the result of which should
@@ -78,10 +110,19 @@ type coreBuild struct {
 	hooks          map[string][]Hook
 	postProcessors [][]coreBuildPostProcessor
 	provisioners   []coreBuildProvisioner
+	templatePath   string
 	variables      map[string]string
 
+	// Where the builder, provisioners and post-processors were defined in
+	// the template, so that errors from preparing them can point there.
+	// These are empty if the build didn't come from a template file.
+	builderLocation        configLocation
+	provisionerLocations   [][]configLocation
+	postProcessorLocations [][]configLocation
+
 	debug         bool
 	force         bool
+	onError       string
 	l             sync.Mutex
 	prepareCalled bool
 }
@@ -98,8 +139,12 @@ type coreBuildPostProcessor struct {
 // Keeps track of the provisioner and the configuration of the provisioner
 // within the build.
 type coreBuildProvisioner struct {
-	provisioner Provisioner
-	config      []interface{}
+	provisioner     Provisioner
+	provisionerType string
+	config          []interface{}
+
+	// The provisioner that is run if this one fails, if any
+	cleanup *coreBuildProvisioner
 }
 
 // Returns the name of the build.
@@ -107,6 +152,16 @@ func (b *coreBuild) Name() string {
 	return b.name
 }
 
+// Returns the type of the builder of the build.
+func (b *coreBuild) BuilderType() string {
+	return b.builderType
+}
+
+// Returns the values of the user variables of the build.
+func (b *coreBuild) UserVariables() map[string]string {
+	return b.variables
+}
+
 // Prepare prepares the build by doing some initialization for the builder
 // and any hooks. This _must_ be called prior to Run. The parameter is the
 // overrides for the variables within the template (if any).
@@ -120,37 +175,68 @@ func (b *coreBuild) Prepare() (warn []string, err error) {
 
 	b.prepareCalled = true
 
+	onError := b.onError
+	if onError == "" {
+		onError = OnErrorCleanup
+	}
+
 	packerConfig := map[string]interface{}{
 		BuildNameConfigKey:     b.name,
 		BuilderTypeConfigKey:   b.builderType,
 		DebugConfigKey:         b.debug,
 		ForceConfigKey:         b.force,
+		OnErrorConfigKey:       onError,
+		TemplatePathConfigKey:  b.templatePath,
 		UserVariablesConfigKey: b.variables,
 	}
 
 	// Prepare the builder
 	warn, err = b.builder.Prepare(b.builderConfig, packerConfig)
 	if err != nil {
+		err = wrapConfigErrors(err, b.builderLocation)
 		log.Printf("Build '%s' prepare failure: %s\n", b.name, err)
 		return
 	}
 
 	// Prepare the provisioners
-	for _, coreProv := range b.provisioners {
+	for i, coreProv := range b.provisioners {
 		configs := make([]interface{}, len(coreProv.config), len(coreProv.config)+1)
 		copy(configs, coreProv.config)
 		configs = append(configs, packerConfig)
 
 		if err = coreProv.provisioner.Prepare(configs...); err != nil {
+			if i < len(b.provisionerLocations) {
+				err = wrapConfigErrors(err, b.provisionerLocations[i]...)
+			}
+
 			return
 		}
+
+		if coreProv.cleanup != nil {
+			configs = make([]interface{}, len(coreProv.cleanup.config), len(coreProv.cleanup.config)+1)
+			copy(configs, coreProv.cleanup.config)
+			configs = append(configs, packerConfig)
+
+			if err = coreProv.cleanup.provisioner.Prepare(configs...); err != nil {
+				if i < len(b.provisionerLocations) {
+					err = wrapConfigErrors(err,
+						b.provisionerLocations[i][0].Key("error_cleanup"))
+				}
+
+				return
+			}
+		}
 	}
 
 	// Prepare the post-processors
-	for _, ppSeq := range b.postProcessors {
-		for _, corePP := range ppSeq {
+	for i, ppSeq := range b.postProcessors {
+		for j, corePP := range ppSeq {
 			err = corePP.processor.Configure(corePP.config, packerConfig)
 			if err != nil {
+				if i < len(b.postProcessorLocations) {
+					err = wrapConfigErrors(err, b.postProcessorLocations[i][j])
+				}
+
 				return
 			}
 		}
@@ -176,7 +262,10 @@ func (b *coreBuild) Run(originalUi Ui, cache Cache) ([]Artifact, error) {
 	if len(b.provisioners) > 0 {
 		provisioners := make([]Provisioner, len(b.provisioners))
 		for i, p := range b.provisioners {
-			provisioners[i] = p.provisioner
+			provisioners[i] = &EventProvisioner{
+				Type:        p.provisionerType,
+				Provisioner: p.provisioner,
+			}
 		}
 
 		if _, ok := hooks[HookProvision]; !ok {
@@ -223,7 +312,10 @@ PostProcessorRunSeqLoop:
 			}
 
 			builderUi.Say(fmt.Sprintf("Running post-processor: %s", corePP.processorType))
+			start := time.Now()
+			builderUi.Event(NewEvent(EventPostProcessorStart, corePP.processorType))
 			artifact, keep, err := corePP.processor.PostProcess(ppUi, priorArtifact)
+			builderUi.Event(NewEndEvent(EventPostProcessorEnd, corePP.processorType, start, err))
 			if err != nil {
 				errors = append(errors, fmt.Errorf("Post-processor failed: %s", err))
 				continue PostProcessorRunSeqLoop
@@ -301,6 +393,14 @@ func (b *coreBuild) SetForce(val bool) {
 	b.force = val
 }
 
+func (b *coreBuild) SetOnError(val string) {
+	if b.prepareCalled {
+		panic("prepare has already been called")
+	}
+
+	b.onError = val
+}
+
 // Cancels the build if it is running.
 func (b *coreBuild) Cancel() {
 	b.builder.Cancel()
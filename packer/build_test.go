@@ -15,14 +15,15 @@ func testBuild() *coreBuild {
 			"foo": []Hook{&MockHook{}},
 		},
 		provisioners: []coreBuildProvisioner{
-			coreBuildProvisioner{&MockProvisioner{}, []interface{}{42}},
+			coreBuildProvisioner{provisioner: &MockProvisioner{}, config: []interface{}{42}},
 		},
 		postProcessors: [][]coreBuildPostProcessor{
 			[]coreBuildPostProcessor{
 				coreBuildPostProcessor{&TestPostProcessor{artifactId: "pp"}, "testPP", make(map[string]interface{}), true},
 			},
 		},
-		variables: make(map[string]string),
+		templatePath: "/tmp/template.json",
+		variables:    make(map[string]string),
 	}
 }
 
@@ -32,6 +33,8 @@ func testDefaultPackerConfig() map[string]interface{} {
 		BuilderTypeConfigKey:   "foo",
 		DebugConfigKey:         false,
 		ForceConfigKey:         false,
+		OnErrorConfigKey:       OnErrorCleanup,
+		TemplatePathConfigKey:  "/tmp/template.json",
 		UserVariablesConfigKey: make(map[string]string),
 	}
 }
@@ -75,6 +78,28 @@ func TestBuild_Prepare(t *testing.T) {
 	}
 }
 
+func TestBuild_Prepare_errorCleanup(t *testing.T) {
+	packerConfig := testDefaultPackerConfig()
+
+	build := testBuild()
+	cleanup := new(MockProvisioner)
+	build.provisioners[0].cleanup = &coreBuildProvisioner{
+		provisioner: cleanup,
+		config:      []interface{}{24},
+	}
+
+	if _, err := build.Prepare(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !cleanup.PrepCalled {
+		t.Fatal("prep should be called")
+	}
+	if !reflect.DeepEqual(cleanup.PrepConfigs, []interface{}{24, packerConfig}) {
+		t.Fatalf("bad: %#v", cleanup.PrepConfigs)
+	}
+}
+
 func TestBuild_Prepare_Twice(t *testing.T) {
 	build := testBuild()
 	warn, err := build.Prepare()
@@ -141,6 +166,23 @@ func TestBuild_Prepare_Debug(t *testing.T) {
 	}
 }
 
+func TestBuild_Prepare_OnError(t *testing.T) {
+	packerConfig := testDefaultPackerConfig()
+	packerConfig[OnErrorConfigKey] = OnErrorAbort
+
+	build := testBuild()
+	builder := build.builder.(*MockBuilder)
+
+	build.SetOnError(OnErrorAbort)
+	build.Prepare()
+	if !builder.PrepareCalled {
+		t.Fatalf("should be called")
+	}
+	if !reflect.DeepEqual(builder.PrepareConfig, []interface{}{42, packerConfig}) {
+		t.Fatalf("bad: %#v", builder.PrepareConfig)
+	}
+}
+
 func TestBuildPrepare_variables_default(t *testing.T) {
 	packerConfig := testDefaultPackerConfig()
 	packerConfig[UserVariablesConfigKey] = map[string]string{
@@ -201,7 +243,7 @@ func TestBuild_Run(t *testing.T) {
 	}
 
 	// Verify provisioners run
-	dispatchHook.Run(HookProvision, nil, nil, 42)
+	dispatchHook.Run(HookProvision, testUi(), nil, 42)
 	prov := build.provisioners[0].provisioner.(*MockProvisioner)
 	if !prov.ProvCalled {
 		t.Fatal("should be called")
@@ -73,6 +73,15 @@ type Communicator interface {
 	// with the contents writing to the given writer. This method will
 	// block until it completes.
 	Download(string, io.Writer) error
+
+	// DownloadDir downloads the contents of a remote directory recursively
+	// to the local path. It also takes an optional slice of paths to
+	// ignore when downloading.
+	//
+	// The same trailing slash rules as UploadDir apply to the remote
+	// source: "/tmp/src" creates a "src" directory in the destination,
+	// while "/tmp/src/" only downloads the contents.
+	DownloadDir(src string, dst string, exclude []string) error
 }
 
 // StartWithUi runs the remote command and streams the output to any
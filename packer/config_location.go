@@ -0,0 +1,181 @@
+package packer
+
+import (
+	"fmt"
+	jsonutil "github.com/mitchellh/packer/common/json"
+	"strings"
+)
+
+// templateFile is one of the files that make up a template, along with the
+// position of every value within it.
+type templateFile struct {
+	Name      string // The path of the file, or "" if it wasn't read from one
+	Positions map[string]jsonutil.Position
+}
+
+// configLocation is the location of a value within the files that make up
+// a template, such as a builder or one of its keys. It is used to point
+// errors at the line that they are about.
+type configLocation struct {
+	File *templateFile
+	Path string // The path of the value, such as "builders[0].iso_url"
+}
+
+// Key returns the location of the key within the object at this location.
+func (l configLocation) Key(key string) configLocation {
+	return configLocation{l.File, jsonutil.KeyPath(l.Path, key)}
+}
+
+// Index returns the location of an element of the array at this location.
+func (l configLocation) Index(i int) configLocation {
+	return configLocation{l.File, jsonutil.IndexPath(l.Path, i)}
+}
+
+// Position returns the file, line and column of this location, such as
+// "template.json:12:5", or as much of it as is known.
+func (l configLocation) Position() string {
+	if l.File == nil {
+		return ""
+	}
+
+	pos, ok := l.File.Positions[l.Path]
+	if !ok {
+		return l.File.Name
+	}
+
+	if l.File.Name == "" {
+		return pos.String()
+	}
+
+	return fmt.Sprintf("%s:%s", l.File.Name, pos)
+}
+
+// String returns the position and path of this location, such as
+// "template.json:12:5: builders[0]".
+func (l configLocation) String() string {
+	parts := make([]string, 0, 2)
+	if pos := l.Position(); pos != "" {
+		parts = append(parts, pos)
+	}
+
+	if l.Path != "" {
+		parts = append(parts, l.Path)
+	}
+
+	return strings.Join(parts, ": ")
+}
+
+// Errorf returns an error prefixed with this location.
+func (l configLocation) Errorf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if prefix := l.String(); prefix != "" {
+		msg = prefix + ": " + msg
+	}
+
+	return fmt.Errorf("%s", msg)
+}
+
+// findKey returns the key of the object at this location that the error
+// message is most likely about, or "" if none of its keys are mentioned.
+// Keys in quotes win over keys that are just mentioned, and longer keys
+// win over shorter ones.
+func (l configLocation) findKey(msg string) string {
+	if l.File == nil {
+		return ""
+	}
+
+	prefix := jsonutil.KeyPath(l.Path, "")
+	quoted, mentioned := "", ""
+	for path, _ := range l.File.Positions {
+		if !strings.HasPrefix(path, prefix) || len(path) == len(prefix) {
+			continue
+		}
+
+		key := path[len(prefix):]
+		if strings.ContainsAny(key, ".[") {
+			continue
+		}
+
+		if len(key) > len(quoted) &&
+			(strings.Contains(msg, "'"+key+"'") || strings.Contains(msg, `"`+key+`"`)) {
+			quoted = key
+		}
+
+		// "type" is in every configuration and is a common word in
+		// messages, so it is only found in quotes.
+		if len(key) > len(mentioned) && key != "type" && containsWord(msg, key) {
+			mentioned = key
+		}
+	}
+
+	if quoted != "" {
+		return quoted
+	}
+
+	return mentioned
+}
+
+// wrapConfigErrors prefixes the error, or each error in a MultiError, with
+// the location it is about. The locations are the configurations that
+// caused the error, and the first one with a key that the error mentions
+// is used. Otherwise, the first location is used.
+func wrapConfigErrors(err error, locs ...configLocation) error {
+	if err == nil || len(locs) == 0 || locs[0].File == nil {
+		return err
+	}
+
+	wrap := func(err error) error {
+		msg := err.Error()
+		for _, loc := range locs {
+			if key := loc.findKey(msg); key != "" {
+				return loc.Key(key).Errorf("%s", msg)
+			}
+		}
+
+		return locs[0].Errorf("%s", msg)
+	}
+
+	merr, ok := err.(*MultiError)
+	if !ok {
+		return wrap(err)
+	}
+
+	result := new(MultiError)
+	for _, err := range merr.Errors {
+		if inner, ok := err.(*MultiError); ok {
+			inner = wrapConfigErrors(inner, locs...).(*MultiError)
+			result.Errors = append(result.Errors, inner.Errors...)
+		} else {
+			result.Errors = append(result.Errors, wrap(err))
+		}
+	}
+
+	return result
+}
+
+// containsWord returns true if word appears in s, not as part of a longer
+// word or key.
+func containsWord(s string, word string) bool {
+	isWordByte := func(c byte) bool {
+		return c == '_' || c == '-' ||
+			(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+	}
+
+	for i := 0; i <= len(s)-len(word); {
+		idx := strings.Index(s[i:], word)
+		if idx == -1 {
+			return false
+		}
+
+		start := i + idx
+		end := start + len(word)
+		if (start == 0 || !isWordByte(s[start-1])) &&
+			(end == len(s) || !isWordByte(s[end])) {
+			return true
+		}
+
+		i = start + 1
+	}
+
+	return false
+}
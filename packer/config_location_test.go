@@ -0,0 +1,88 @@
+package packer
+
+import (
+	"errors"
+	jsonutil "github.com/mitchellh/packer/common/json"
+	"testing"
+)
+
+func testConfigLocation(t *testing.T, name string, data string) configLocation {
+	positions, err := jsonutil.Positions([]byte(data))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	return configLocation{File: &templateFile{name, positions}}
+}
+
+func TestConfigLocation(t *testing.T) {
+	root := testConfigLocation(t, "t.json", `{"builders": [{"type": "foo"}]}`)
+
+	loc := root.Key("builders").Index(0).Key("type")
+	if loc.String() != "t.json:1:16: builders[0].type" {
+		t.Fatalf("bad: %s", loc)
+	}
+
+	loc = root.Key("builders").Index(1)
+	if loc.String() != "t.json: builders[1]" {
+		t.Fatalf("bad: %s", loc)
+	}
+
+	loc = configLocation{Path: "builders[0]"}
+	if loc.Errorf("foo").Error() != "builders[0]: foo" {
+		t.Fatalf("bad: %s", loc.Errorf("foo"))
+	}
+}
+
+func TestWrapConfigErrors(t *testing.T) {
+	root := testConfigLocation(t, "t.json", `{
+  "builders": [{
+    "type": "foo",
+    "iso_url": "bar",
+    "iso": "baz"
+  }],
+  "overrides": {"ssh_username": "root"}
+}`)
+
+	builder := root.Key("builders").Index(0)
+	override := root.Key("overrides")
+
+	err := &MultiError{
+		Errors: []error{
+			errors.New("iso_url must be specified"),
+			errors.New("An 'iso' is required"),
+			&MultiError{Errors: []error{errors.New("ssh_username is bad")}},
+			errors.New("unknown type"),
+		},
+	}
+
+	merr, ok := wrapConfigErrors(err, builder, override).(*MultiError)
+	if !ok {
+		t.Fatalf("bad: %#v", merr)
+	}
+
+	expected := []string{
+		"t.json:4:5: builders[0].iso_url: iso_url must be specified",
+		"t.json:5:5: builders[0].iso: An 'iso' is required",
+		"t.json:7:17: overrides.ssh_username: ssh_username is bad",
+		"t.json:2:16: builders[0]: unknown type",
+	}
+
+	if len(merr.Errors) != len(expected) {
+		t.Fatalf("bad: %#v", merr.Errors)
+	}
+
+	for i, e := range expected {
+		if merr.Errors[i].Error() != e {
+			t.Fatalf("bad %d: %s", i, merr.Errors[i])
+		}
+	}
+
+	if wrapConfigErrors(nil, builder) != nil {
+		t.Fatal("nil error should stay nil")
+	}
+
+	if wrapConfigErrors(errors.New("foo"), configLocation{}).Error() != "foo" {
+		t.Fatal("errors without a file should not be changed")
+	}
+}
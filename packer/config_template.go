@@ -2,10 +2,18 @@ package packer
 
 import (
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/mitchellh/packer/common/uuid"
+	"hash"
+	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -25,6 +33,13 @@ func init() {
 type ConfigTemplate struct {
 	UserVars map[string]string
 
+	// Information about the build for the "build_name", "build_type" and
+	// "template_dir" functions. These are set from the configuration that
+	// Packer sends to plugins.
+	BuildName   string
+	BuildType   string
+	TemplateDir string
+
 	root *template.Template
 	i    int
 }
@@ -37,12 +52,29 @@ func NewConfigTemplate() (*ConfigTemplate, error) {
 
 	result.root = template.New("configTemplateRoot")
 	result.root.Funcs(template.FuncMap{
-		"env":       templateDisableEnv,
-		"pwd":       templatePwd,
-		"isotime":   templateISOTime,
-		"timestamp": templateTimestamp,
-		"user":      result.templateUser,
-		"uuid":      templateUuid,
+		"build_name":   result.templateBuildName,
+		"build_type":   result.templateBuildType,
+		"env":          templateDisableEnv,
+		"file":         templateReadFile,
+		"isotime":      templateISOTime,
+		"join":         templateJoin,
+		"lower":        strings.ToLower,
+		"md5":          templateHash(md5.New),
+		"md5_file":     templateHashFile(md5.New),
+		"pwd":          templatePwd,
+		"replace":      templateReplace,
+		"secret":       templateDisableSecret,
+		"sha256":       templateHash(sha256.New),
+		"sha256_file":  templateHashFile(sha256.New),
+		"split":        templateSplit,
+		"template_dir": result.templateTemplateDir,
+		"timestamp":    templateTimestamp,
+		"trim":         strings.TrimSpace,
+		"upper":        strings.ToUpper,
+		"user":         result.templateUser,
+		"userlist":     result.templateUserList,
+		"usermap":      result.templateUserMap,
+		"uuid":         templateUuid,
 	})
 
 	return result, nil
@@ -96,11 +128,72 @@ func (t *ConfigTemplate) templateUser(n string) (string, error) {
 	return result, nil
 }
 
+// templateUserList is the function exposed as "userlist" within the
+// templates and returns the elements of a list user variable.
+func (t *ConfigTemplate) templateUserList(n string) ([]interface{}, error) {
+	value, err := t.templateUser(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []interface{}
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return nil, fmt.Errorf("user var is not a list: %s", n)
+	}
+
+	return result, nil
+}
+
+// templateUserMap is the function exposed as "usermap" within the
+// templates and returns a map user variable.
+func (t *ConfigTemplate) templateUserMap(n string) (map[string]interface{}, error) {
+	value, err := t.templateUser(n)
+	if err != nil {
+		return nil, err
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &result); err != nil {
+		return nil, fmt.Errorf("user var is not a map: %s", n)
+	}
+
+	return result, nil
+}
+
+func (t *ConfigTemplate) templateBuildName() (string, error) {
+	if t.BuildName == "" {
+		return "", fmt.Errorf("build_name is only available within a build")
+	}
+
+	return t.BuildName, nil
+}
+
+func (t *ConfigTemplate) templateBuildType() (string, error) {
+	if t.BuildType == "" {
+		return "", fmt.Errorf("build_type is only available within a build")
+	}
+
+	return t.BuildType, nil
+}
+
+func (t *ConfigTemplate) templateTemplateDir() (string, error) {
+	if t.TemplateDir == "" {
+		return "", fmt.Errorf("template_dir is only available for templates read from a file")
+	}
+
+	return t.TemplateDir, nil
+}
+
 func templateDisableEnv(n string) (string, error) {
 	return "", fmt.Errorf(
 		"Environmental variables can only be used as default values for user variables.")
 }
 
+func templateDisableSecret(n string) (string, error) {
+	return "", fmt.Errorf(
+		"Secrets can only be used as default values for user variables.")
+}
+
 func templateDisableUser(n string) (string, error) {
 	return "", fmt.Errorf(
 		"User variable can't be used within a default value for a user variable: %s", n)
@@ -110,8 +203,103 @@ func templateEnv(n string) string {
 	return os.Getenv(n)
 }
 
-func templateISOTime() string {
-	return time.Now().UTC().Format(time.RFC3339)
+// templateSecret returns the function exposed as "secret" within the
+// default values of user variables. Secrets are always sensitive.
+func templateSecret(f SecretFunc) func(string) (string, error) {
+	return func(path string) (string, error) {
+		if f == nil {
+			return "", fmt.Errorf("Secrets aren't available: %s", path)
+		}
+
+		value, err := f(path)
+		if err != nil {
+			return "", err
+		}
+
+		AddSensitiveValue(value)
+		return value, nil
+	}
+}
+
+func templateReadFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Error reading file for template: %s", err)
+	}
+
+	return string(data), nil
+}
+
+// templateHash returns a function that hashes a string, returning the
+// hash in hex.
+func templateHash(h func() hash.Hash) func(string) string {
+	return func(s string) string {
+		hash := h()
+		io.WriteString(hash, s)
+		return hex.EncodeToString(hash.Sum(nil))
+	}
+}
+
+// templateHashFile returns a function that hashes the contents of a file,
+// returning the hash in hex.
+func templateHashFile(h func() hash.Hash) func(string) (string, error) {
+	return func(path string) (string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return "", fmt.Errorf("Error hashing file for template: %s", err)
+		}
+		defer f.Close()
+
+		hash := h()
+		if _, err := io.Copy(hash, f); err != nil {
+			return "", fmt.Errorf("Error hashing file for template: %s", err)
+		}
+
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+}
+
+// templateISOTime returns the current UTC time formatted with the given
+// Go time layout, or in RFC-3339 format if there isn't one.
+func templateISOTime(layout ...string) (string, error) {
+	switch len(layout) {
+	case 0:
+		return time.Now().UTC().Format(time.RFC3339), nil
+	case 1:
+		return time.Now().UTC().Format(layout[0]), nil
+	}
+
+	return "", fmt.Errorf("isotime takes at most one layout, got %d", len(layout))
+}
+
+// templateJoin joins the elements of a list, such as from "split" or
+// "userlist", with sep between them.
+func templateJoin(sep string, list interface{}) (string, error) {
+	switch list := list.(type) {
+	case []string:
+		return strings.Join(list, sep), nil
+	case []interface{}:
+		parts := make([]string, len(list))
+		for i, v := range list {
+			parts[i] = fmt.Sprint(v)
+		}
+
+		return strings.Join(parts, sep), nil
+	}
+
+	return "", fmt.Errorf("join needs a list, got %T", list)
+}
+
+// templateReplace replaces all of old with new in s. The string is the
+// last argument so that it can be piped in.
+func templateReplace(old, new, s string) string {
+	return strings.Replace(s, old, new, -1)
+}
+
+// templateSplit splits s around each sep. The string is the last argument
+// so that it can be piped in.
+func templateSplit(sep, s string) []string {
+	return strings.Split(s, sep)
 }
 
 func templatePwd() (string, error) {
@@ -1,13 +1,41 @@
 package packer
 
 import (
+	"io/ioutil"
 	"math"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 	"time"
 )
 
+func TestConfigTemplateProcess_build(t *testing.T) {
+	tpl, err := NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for _, f := range []string{"build_name", "build_type", "template_dir"} {
+		if _, err := tpl.Process("{{"+f+"}}", nil); err == nil {
+			t.Fatalf("%s should error", f)
+		}
+	}
+
+	tpl.BuildName = "web"
+	tpl.BuildType = "amazon-ebs"
+	tpl.TemplateDir = "/tmp/templates"
+
+	result, err := tpl.Process(`{{build_name}} {{build_type}} {{template_dir}}`, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "web amazon-ebs /tmp/templates" {
+		t.Fatalf("bad: %s", result)
+	}
+}
+
 func TestConfigTemplateProcess_env(t *testing.T) {
 	tpl, err := NewConfigTemplate()
 	if err != nil {
@@ -20,6 +48,71 @@ func TestConfigTemplateProcess_env(t *testing.T) {
 	}
 }
 
+func TestConfigTemplateProcess_file(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "key.pub")
+	if err := ioutil.WriteFile(path, []byte("ssh-rsa AAAA\n"), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl, err := NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := map[string]string{
+		`{{file "` + path + `" | trim}}`: "ssh-rsa AAAA",
+		`{{md5_file "` + path + `"}}`:    "1b63f782950fbacaa4addbdfaef1ca0e",
+		`{{sha256_file "` + path + `"}}`: "edbcf9e8839226cec7d7c0ff2404eb09c89cbe384e6c818bfecaca9c8e635b0a",
+	}
+
+	for input, expected := range cases {
+		result, err := tpl.Process(input, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if result != expected {
+			t.Fatalf("bad: %s\n\n%s", input, result)
+		}
+	}
+
+	missing := filepath.Join(td, "missing")
+	for _, f := range []string{"file", "md5_file", "sha256_file"} {
+		if _, err := tpl.Process(`{{`+f+` "`+missing+`"}}`, nil); err == nil {
+			t.Fatalf("%s should error", f)
+		}
+	}
+}
+
+func TestConfigTemplateProcess_hash(t *testing.T) {
+	tpl, err := NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := map[string]string{
+		`{{md5 "foo"}}`:    "acbd18db4cc2f85cedef654fccc4a4d8",
+		`{{sha256 "foo"}}`: "2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae",
+	}
+
+	for input, expected := range cases {
+		result, err := tpl.Process(input, nil)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if result != expected {
+			t.Fatalf("bad: %s\n\n%s", input, result)
+		}
+	}
+}
+
 func TestConfigTemplateProcess_isotime(t *testing.T) {
 	tpl, err := NewConfigTemplate()
 	if err != nil {
@@ -42,6 +135,26 @@ func TestConfigTemplateProcess_isotime(t *testing.T) {
 	}
 }
 
+func TestConfigTemplateProcess_isotimeLayout(t *testing.T) {
+	tpl, err := NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := tpl.Process(`{{isotime "2006-01-02"}}`, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := time.Parse("2006-01-02", result); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := tpl.Process(`{{isotime "2006" "01"}}`, nil); err == nil {
+		t.Fatal("should error")
+	}
+}
+
 func TestConfigTemplateProcess_pwd(t *testing.T) {
 	tpl, err := NewConfigTemplate()
 	if err != nil {
@@ -63,6 +176,43 @@ func TestConfigTemplateProcess_pwd(t *testing.T) {
 	}
 }
 
+func TestConfigTemplateProcess_strings(t *testing.T) {
+	tpl, err := NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl.UserVars["packages"] = `["git", "curl"]`
+
+	cases := map[string]string{
+		`{{lower "FOO"}}`:                          "foo",
+		`{{upper "foo"}}`:                          "FOO",
+		`{{"a.b.c" | replace "." "-"}}`:            "a-b-c",
+		`{{"  foo  " | trim}}`:                     "foo",
+		`{{"a,b,c" | split "," | join " "}}`:       "a b c",
+		`{{userlist "packages" | join ","}}`:       "git,curl",
+		`{{index ("a,b" | split ",") 1}}`:          "b",
+		`{{build_name | upper | replace "-" "_"}}`: "WEB_1",
+	}
+
+	tpl.BuildName = "web-1"
+
+	for input, expected := range cases {
+		result, err := tpl.Process(input, nil)
+		if err != nil {
+			t.Fatalf("err: %s: %s", input, err)
+		}
+
+		if result != expected {
+			t.Fatalf("bad: %s\n\n%s", input, result)
+		}
+	}
+
+	if _, err := tpl.Process(`{{join "," "foo"}}`, nil); err == nil {
+		t.Fatal("should error")
+	}
+}
+
 func TestConfigTemplateProcess_timestamp(t *testing.T) {
 	tpl, err := NewConfigTemplate()
 	if err != nil {
@@ -96,6 +246,18 @@ func TestConfigTemplateProcess_timestamp(t *testing.T) {
 	}
 }
 
+func TestConfigTemplateProcess_secret(t *testing.T) {
+	tpl, err := NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	_, err = tpl.Process(`{{secret "foo"}}`, nil)
+	if err == nil {
+		t.Fatal("should error")
+	}
+}
+
 func TestConfigTemplateProcess_user(t *testing.T) {
 	tpl, err := NewConfigTemplate()
 	if err != nil {
@@ -114,6 +276,30 @@ func TestConfigTemplateProcess_user(t *testing.T) {
 	}
 }
 
+func TestConfigTemplateProcess_userList(t *testing.T) {
+	tpl, err := NewConfigTemplate()
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl.UserVars["packages"] = `["git", "curl"]`
+	tpl.UserVars["tags"] = `{"Name": "web"}`
+
+	result, err := tpl.Process(
+		`{{range userlist "packages"}}{{.}} {{end}}{{index (usermap "tags") "Name"}}`, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if result != "git curl web" {
+		t.Fatalf("bad: %s", result)
+	}
+
+	if _, err := tpl.Process(`{{usermap "packages"}}`, nil); err == nil {
+		t.Fatal("should error")
+	}
+}
+
 func TestConfigTemplateProcess_uuid(t *testing.T) {
 	tpl, err := NewConfigTemplate()
 	if err != nil {
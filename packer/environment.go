@@ -26,6 +26,10 @@ type PostProcessorFunc func(name string) (PostProcessor, error)
 // The function type used to lookup Provisioner implementations.
 type ProvisionerFunc func(name string) (Provisioner, error)
 
+// The function type used to lookup the secrets for the "secret" function
+// in the default values of user variables.
+type SecretFunc func(path string) (string, error)
+
 // ComponentFinder is a struct that contains the various function
 // pointers necessary to look up components of Packer such as builders,
 // commands, etc.
@@ -35,6 +39,7 @@ type ComponentFinder struct {
 	Hook          HookFunc
 	PostProcessor PostProcessorFunc
 	Provisioner   ProvisionerFunc
+	Secret        SecretFunc
 }
 
 // The environment interface provides access to the configuration and
@@ -349,6 +354,8 @@ func (e *coreEnvironment) printHelp() {
 
 	e.ui.Say("\nGlobally recognized options:")
 	e.ui.Say("    -machine-readable    Machine-readable output format.")
+	e.ui.Say("    -machine-readable=json")
+	e.ui.Say("                         Newline-delimited JSON output, with build events.")
 }
 
 // Returns the UI for the environment. The UI is the interface that should
@@ -0,0 +1,91 @@
+package packer
+
+import (
+	"time"
+)
+
+// EventType is the type of an Event.
+type EventType string
+
+// These are the types of events in the lifecycle of a build. Events that
+// end something have the Duration of it, and an Error if it failed.
+const (
+	EventBuildStart         EventType = "build-start"
+	EventBuildEnd           EventType = "build-end"
+	EventStepStart          EventType = "step-start"
+	EventStepEnd            EventType = "step-end"
+	EventProvisionerStart   EventType = "provisioner-start"
+	EventProvisionerEnd     EventType = "provisioner-end"
+	EventPostProcessorStart EventType = "post-processor-start"
+	EventPostProcessorEnd   EventType = "post-processor-end"
+	EventArtifact           EventType = "artifact"
+	EventCancelled          EventType = "cancelled"
+	EventError              EventType = "error"
+)
+
+// An Event is something that happened during a build. Events are sent to
+// the Ui with its Event method, so that they can be shown in a structured
+// form, such as by JsonUi.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Build is the name of the build. It is set by TargettedUi, so it is
+	// usually left empty by whatever sends the event.
+	Build string
+
+	// Name is what the event is about, such as the name of the step or
+	// the type of the provisioner or post-processor.
+	Name string
+
+	// Duration is how long it took, for the events that end something.
+	Duration time.Duration
+
+	// Error is the error, if it failed.
+	Error string
+
+	// Artifact is the artifact for EventArtifact.
+	Artifact *ArtifactInfo
+}
+
+// ArtifactInfo describes an artifact for an event.
+type ArtifactInfo struct {
+	BuilderId string
+	Id        string
+	String    string
+	Files     []string
+}
+
+// NewEvent returns a new event of the given type that happened now.
+func NewEvent(t EventType, name string) *Event {
+	return &Event{
+		Type: t,
+		Time: time.Now().UTC(),
+		Name: name,
+	}
+}
+
+// NewEndEvent returns a new event of the given type for something that
+// started at start and ended now, failing if err isn't nil.
+func NewEndEvent(t EventType, name string, start time.Time, err error) *Event {
+	e := NewEvent(t, name)
+	e.Duration = e.Time.Sub(start)
+	if err != nil {
+		e.Error = err.Error()
+	}
+
+	return e
+}
+
+// NewArtifactEvent returns a new EventArtifact event for the artifact.
+func NewArtifactEvent(a Artifact) *Event {
+	e := NewEvent(EventArtifact, a.BuilderId())
+	e.Artifact = &ArtifactInfo{
+		BuilderId: a.BuilderId(),
+		Id:        a.Id(),
+		String:    a.String(),
+		Files:     a.Files(),
+	}
+
+	return e
+}
@@ -351,7 +351,11 @@ func (c *Client) logStderr(r io.Reader) {
 	bufR := bufio.NewReader(r)
 	for {
 		line, err := bufR.ReadString('\n')
-		if line != "" {
+		if v, ok := packer.ParseSensitiveValueLine(line); ok {
+			// The plugin found a sensitive value, so redact it here too
+			// and pass it on to whoever reads our stderr.
+			packer.AddSensitiveValue(v)
+		} else if line != "" {
 			c.config.Stderr.Write([]byte(line))
 
 			line = strings.TrimRightFunc(line, unicode.IsSpace)
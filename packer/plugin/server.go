@@ -38,6 +38,12 @@ const APIVersion = "2"
 // Server waits for a connection to this plugin and returns a Packer
 // RPC server that you can use to register components and serve them.
 func Server() (*packrpc.Server, error) {
+	// Redact sensitive values from the logs and tell the process that
+	// started us about the ones we find, such as from the variables of a
+	// template that a command plugin parses.
+	log.SetOutput(packer.NewRedactWriter(os.Stderr))
+	packer.AnnounceSensitiveValues(os.Stderr)
+
 	log.Printf("Plugin build against Packer '%s'", packer.GitCommit)
 
 	if os.Getenv(MagicCookieKey) != MagicCookieValue {
@@ -2,6 +2,7 @@ package packer
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 )
@@ -68,6 +69,30 @@ func (h *ProvisionHook) Cancel() {
 	}
 }
 
+// EventProvisioner is a Provisioner implementation that sends events to
+// the UI when the provisioner starts and ends. Type is the type of the
+// provisioner that is named in the events.
+type EventProvisioner struct {
+	Type        string
+	Provisioner Provisioner
+}
+
+func (p *EventProvisioner) Prepare(raws ...interface{}) error {
+	return p.Provisioner.Prepare(raws...)
+}
+
+func (p *EventProvisioner) Provision(ui Ui, comm Communicator) error {
+	start := time.Now()
+	ui.Event(NewEvent(EventProvisionerStart, p.Type))
+	err := p.Provisioner.Provision(ui, comm)
+	ui.Event(NewEndEvent(EventProvisionerEnd, p.Type, start, err))
+	return err
+}
+
+func (p *EventProvisioner) Cancel() {
+	p.Provisioner.Cancel()
+}
+
 // PausedProvisioner is a Provisioner implementation that pauses before
 // the provisioner is actually run.
 type PausedProvisioner struct {
@@ -144,3 +169,200 @@ func (p *PausedProvisioner) Cancel() {
 func (p *PausedProvisioner) provision(result chan<- error, ui Ui, comm Communicator) {
 	result <- p.Provisioner.Provision(ui, comm)
 }
+
+// RetriedProvisioner is a Provisioner implementation that runs the
+// provisioner again when it fails, up to MaxRetries more times. It waits
+// RetryBackoff before the first retry, and twice as long before each
+// retry after that.
+type RetriedProvisioner struct {
+	MaxRetries   int
+	RetryBackoff time.Duration
+	Provisioner  Provisioner
+
+	cancelCh chan struct{}
+	lock     sync.Mutex
+}
+
+func (p *RetriedProvisioner) Prepare(raws ...interface{}) error {
+	return p.Provisioner.Prepare(raws...)
+}
+
+func (p *RetriedProvisioner) Provision(ui Ui, comm Communicator) error {
+	p.lock.Lock()
+	cancelCh := make(chan struct{})
+	p.cancelCh = cancelCh
+	p.lock.Unlock()
+
+	defer func() {
+		p.lock.Lock()
+		defer p.lock.Unlock()
+		if p.cancelCh == cancelCh {
+			p.cancelCh = nil
+		}
+	}()
+
+	backoff := p.RetryBackoff
+	for retry := 1; ; retry++ {
+		err := p.Provisioner.Provision(ui, comm)
+		if err == nil || retry > p.MaxRetries {
+			return err
+		}
+
+		// Don't retry if we were cancelled while provisioning
+		select {
+		case <-cancelCh:
+			return err
+		default:
+		}
+
+		ui.Error(fmt.Sprintf("Provisioner failed: %s", err))
+		ui.Say(fmt.Sprintf("Retrying in %s (retry %d of %d)...",
+			backoff, retry, p.MaxRetries))
+		ui.Machine("provisioner-retry",
+			strconv.Itoa(retry), strconv.Itoa(p.MaxRetries), err.Error())
+
+		select {
+		case <-time.After(backoff):
+		case <-cancelCh:
+			return err
+		}
+
+		backoff *= 2
+	}
+}
+
+func (p *RetriedProvisioner) Cancel() {
+	p.lock.Lock()
+	if p.cancelCh != nil {
+		close(p.cancelCh)
+		p.cancelCh = nil
+	}
+	p.lock.Unlock()
+
+	p.Provisioner.Cancel()
+}
+
+// TimeoutProvisioner is a Provisioner implementation that cancels the
+// provisioner and fails if it runs for longer than Timeout.
+type TimeoutProvisioner struct {
+	Timeout     time.Duration
+	Provisioner Provisioner
+}
+
+func (p *TimeoutProvisioner) Prepare(raws ...interface{}) error {
+	return p.Provisioner.Prepare(raws...)
+}
+
+func (p *TimeoutProvisioner) Provision(ui Ui, comm Communicator) error {
+	provDoneCh := make(chan error, 1)
+	go func() {
+		provDoneCh <- p.Provisioner.Provision(ui, comm)
+	}()
+
+	select {
+	case err := <-provDoneCh:
+		return err
+	case <-time.After(p.Timeout):
+	}
+
+	ui.Error(fmt.Sprintf(
+		"Provisioner timed out after %s, cancelling...", p.Timeout))
+	ui.Machine("provisioner-timeout", p.Timeout.String())
+	p.Provisioner.Cancel()
+	<-provDoneCh
+
+	return fmt.Errorf("Provisioner timed out after %s", p.Timeout)
+}
+
+func (p *TimeoutProvisioner) Cancel() {
+	p.Provisioner.Cancel()
+}
+
+// The policies for what happens when a provisioner fails.
+const (
+	// The build is stopped. This is the default.
+	ProvisionerOnErrorAbort = "abort"
+
+	// The error is shown and the build carries on.
+	ProvisionerOnErrorContinue = "continue"
+
+	// A cleanup provisioner is run, and then the build is stopped.
+	ProvisionerOnErrorCleanup = "cleanup"
+)
+
+// OnErrorProvisioner is a Provisioner implementation that applies one of
+// the ProvisionerOnError policies when the provisioner fails. Cleanup is
+// the provisioner that is run for the cleanup policy, which must already
+// be prepared.
+type OnErrorProvisioner struct {
+	OnError     string
+	Cleanup     Provisioner
+	Provisioner Provisioner
+
+	cancelled bool
+	running   Provisioner
+	lock      sync.Mutex
+}
+
+func (p *OnErrorProvisioner) Prepare(raws ...interface{}) error {
+	return p.Provisioner.Prepare(raws...)
+}
+
+func (p *OnErrorProvisioner) Provision(ui Ui, comm Communicator) error {
+	if !p.start(p.Provisioner) {
+		return nil
+	}
+	defer p.start(nil)
+
+	err := p.Provisioner.Provision(ui, comm)
+	if err == nil || p.isCancelled() {
+		return err
+	}
+
+	switch p.OnError {
+	case ProvisionerOnErrorContinue:
+		ui.Error(fmt.Sprintf("Provisioner failed, continuing: %s", err))
+		ui.Machine("provisioner-error", "continue", err.Error())
+		return nil
+	case ProvisionerOnErrorCleanup:
+		ui.Error(fmt.Sprintf("Provisioner failed: %s", err))
+		ui.Say("Running the error cleanup provisioner...")
+		ui.Machine("provisioner-error", "cleanup", err.Error())
+		if !p.start(p.Cleanup) {
+			return err
+		}
+
+		if cleanupErr := p.Cleanup.Provision(ui, comm); cleanupErr != nil {
+			ui.Error(fmt.Sprintf("Error cleanup provisioner failed: %s", cleanupErr))
+		}
+	}
+
+	return err
+}
+
+func (p *OnErrorProvisioner) Cancel() {
+	p.lock.Lock()
+	p.cancelled = true
+	running := p.running
+	p.lock.Unlock()
+
+	if running != nil {
+		running.Cancel()
+	}
+}
+
+// start sets the provisioner that is running, returning false if we've
+// been cancelled.
+func (p *OnErrorProvisioner) start(running Provisioner) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.running = running
+	return !p.cancelled
+}
+
+func (p *OnErrorProvisioner) isCancelled() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.cancelled
+}
@@ -1,6 +1,9 @@
 package packer
 
 import (
+	"bytes"
+	"errors"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -81,6 +84,38 @@ func TestProvisionHook_cancel(t *testing.T) {
 
 // TODO(mitchellh): Test that they're run in the proper order
 
+func TestEventProvisioner_impl(t *testing.T) {
+	var _ Provisioner = new(EventProvisioner)
+}
+
+func TestEventProvisionerProvision(t *testing.T) {
+	mock := &MockProvisioner{
+		ProvFunc: func() error { return errors.New("failed") },
+	}
+
+	prov := &EventProvisioner{
+		Type:        "shell",
+		Provisioner: mock,
+	}
+
+	buf := new(bytes.Buffer)
+	err := prov.Provision(&JsonUi{Writer: buf}, new(MockCommunicator))
+	if err == nil {
+		t.Fatal("should error")
+	}
+
+	lines := testJsonUiLines(t, buf)
+	if len(lines) != 2 {
+		t.Fatalf("bad: %#v", lines)
+	}
+	if lines[0]["type"] != "provisioner-start" || lines[0]["name"] != "shell" {
+		t.Fatalf("bad: %#v", lines[0])
+	}
+	if lines[1]["type"] != "provisioner-end" || lines[1]["error"] != "failed" {
+		t.Fatalf("bad: %#v", lines[1])
+	}
+}
+
 func TestPausedProvisioner_impl(t *testing.T) {
 	var _ Provisioner = new(PausedProvisioner)
 }
@@ -171,3 +206,241 @@ func TestPausedProvisionerCancel(t *testing.T) {
 		t.Fatal("cancel should be called")
 	}
 }
+
+func TestRetriedProvisioner_impl(t *testing.T) {
+	var _ Provisioner = new(RetriedProvisioner)
+}
+
+func TestRetriedProvisionerProvision(t *testing.T) {
+	attempts := 0
+	mock := &MockProvisioner{
+		ProvFunc: func() error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("failed")
+			}
+
+			return nil
+		},
+	}
+
+	prov := &RetriedProvisioner{
+		MaxRetries:   3,
+		RetryBackoff: time.Millisecond,
+		Provisioner:  mock,
+	}
+
+	ui := &MachineReadableUi{Writer: new(bytes.Buffer)}
+	if err := prov.Provision(ui, new(MockCommunicator)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if attempts != 3 {
+		t.Fatalf("bad: %d", attempts)
+	}
+
+	output := ui.Writer.(*bytes.Buffer).String()
+	if strings.Count(output, "provisioner-retry") != 2 {
+		t.Fatalf("bad: %s", output)
+	}
+}
+
+func TestRetriedProvisionerProvision_fails(t *testing.T) {
+	attempts := 0
+	mock := &MockProvisioner{
+		ProvFunc: func() error {
+			attempts++
+			return errors.New("failed")
+		},
+	}
+
+	prov := &RetriedProvisioner{
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+		Provisioner:  mock,
+	}
+
+	if err := prov.Provision(testUi(), new(MockCommunicator)); err == nil {
+		t.Fatal("should error")
+	}
+
+	if attempts != 3 {
+		t.Fatalf("bad: %d", attempts)
+	}
+}
+
+func TestRetriedProvisionerCancel(t *testing.T) {
+	provCh := make(chan struct{}, 1)
+	mock := &MockProvisioner{
+		ProvFunc: func() error {
+			provCh <- struct{}{}
+			return errors.New("failed")
+		},
+	}
+
+	prov := &RetriedProvisioner{
+		MaxRetries:   5,
+		RetryBackoff: time.Hour,
+		Provisioner:  mock,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- prov.Provision(testUi(), new(MockCommunicator))
+	}()
+
+	// Cancel while waiting for the first retry
+	<-provCh
+	time.Sleep(10 * time.Millisecond)
+	prov.Cancel()
+
+	select {
+	case err := <-errCh:
+		if err == nil {
+			t.Fatal("should error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("should stop retrying")
+	}
+}
+
+func TestTimeoutProvisioner_impl(t *testing.T) {
+	var _ Provisioner = new(TimeoutProvisioner)
+}
+
+func TestTimeoutProvisionerProvision(t *testing.T) {
+	mock := new(MockProvisioner)
+	prov := &TimeoutProvisioner{
+		Timeout:     time.Second,
+		Provisioner: mock,
+	}
+
+	if err := prov.Provision(testUi(), new(MockCommunicator)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !mock.ProvCalled {
+		t.Fatal("prov should be called")
+	}
+}
+
+func TestTimeoutProvisionerProvision_timeout(t *testing.T) {
+	cancelCh := make(chan struct{})
+	mock := &MockProvisioner{
+		ProvFunc: func() error {
+			<-cancelCh
+			return errors.New("cancelled")
+		},
+	}
+
+	prov := &TimeoutProvisioner{
+		Timeout: 10 * time.Millisecond,
+		Provisioner: &cancelProvisioner{
+			Provisioner: mock,
+			cancelCh:    cancelCh,
+		},
+	}
+
+	err := prov.Provision(testUi(), new(MockCommunicator))
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestOnErrorProvisioner_impl(t *testing.T) {
+	var _ Provisioner = new(OnErrorProvisioner)
+}
+
+func TestOnErrorProvisionerProvision_continue(t *testing.T) {
+	mock := &MockProvisioner{
+		ProvFunc: func() error { return errors.New("failed") },
+	}
+
+	prov := &OnErrorProvisioner{
+		OnError:     ProvisionerOnErrorContinue,
+		Provisioner: mock,
+	}
+
+	if err := prov.Provision(testUi(), new(MockCommunicator)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestOnErrorProvisionerProvision_cleanup(t *testing.T) {
+	mock := &MockProvisioner{
+		ProvFunc: func() error { return errors.New("failed") },
+	}
+	cleanup := new(MockProvisioner)
+
+	prov := &OnErrorProvisioner{
+		OnError:     ProvisionerOnErrorCleanup,
+		Cleanup:     cleanup,
+		Provisioner: mock,
+	}
+
+	if err := prov.Provision(testUi(), new(MockCommunicator)); err == nil {
+		t.Fatal("should error")
+	}
+
+	if !cleanup.ProvCalled {
+		t.Fatal("cleanup should be called")
+	}
+
+	// The cleanup isn't run if the provisioner succeeds
+	mock.ProvFunc = nil
+	cleanup.ProvCalled = false
+	if err := prov.Provision(testUi(), new(MockCommunicator)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if cleanup.ProvCalled {
+		t.Fatal("cleanup should not be called")
+	}
+}
+
+func TestOnErrorProvisionerCancel(t *testing.T) {
+	provCh := make(chan struct{})
+	cancelCh := make(chan struct{})
+	mock := &MockProvisioner{
+		ProvFunc: func() error {
+			close(provCh)
+			<-cancelCh
+			return errors.New("cancelled")
+		},
+	}
+	cleanup := new(MockProvisioner)
+
+	prov := &OnErrorProvisioner{
+		OnError: ProvisionerOnErrorContinue,
+		Cleanup: cleanup,
+		Provisioner: &cancelProvisioner{
+			Provisioner: mock,
+			cancelCh:    cancelCh,
+		},
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- prov.Provision(testUi(), new(MockCommunicator))
+	}()
+
+	<-provCh
+	prov.Cancel()
+
+	// Errors from cancelled provisioners are never ignored
+	if err := <-errCh; err == nil {
+		t.Fatal("should error")
+	}
+}
+
+// cancelProvisioner is a provisioner that closes a channel when it is
+// cancelled, so that the provisioner it wraps can wait for it.
+type cancelProvisioner struct {
+	Provisioner
+	cancelCh chan struct{}
+	once     sync.Once
+}
+
+func (p *cancelProvisioner) Cancel() {
+	p.once.Do(func() { close(p.cancelCh) })
+}
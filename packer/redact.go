@@ -0,0 +1,140 @@
+package packer
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RedactedValue is what sensitive values are replaced with in output.
+const RedactedValue = "<sensitive>"
+
+// sensitiveValuePrefix starts the lines that a process writes to stderr
+// to announce a new sensitive value to the process that reads its stderr.
+// Packer, its plugins and the process that wraps Packer to write crash
+// logs all pass these on so that each of them redacts the same values.
+const sensitiveValuePrefix = "[packer-sensitive-value] "
+
+var sensitiveValues struct {
+	sync.RWMutex
+	values   []string
+	announce io.Writer
+}
+
+// AnnounceSensitiveValues makes every new sensitive value be announced to
+// w, which is the stderr of this process. See ParseSensitiveValueLine.
+func AnnounceSensitiveValues(w io.Writer) {
+	sensitiveValues.Lock()
+	defer sensitiveValues.Unlock()
+	sensitiveValues.announce = w
+}
+
+// ParseSensitiveValueLine returns the sensitive value that a line read from
+// the stderr of another process announces, if it is such a line.
+func ParseSensitiveValueLine(line string) (string, bool) {
+	if !strings.HasPrefix(line, sensitiveValuePrefix) {
+		return "", false
+	}
+
+	encoded := strings.TrimSpace(line[len(sensitiveValuePrefix):])
+	v, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", false
+	}
+
+	return string(v), true
+}
+
+// AddSensitiveValue marks a value, such as the value of a user variable
+// that is marked sensitive, so that it is replaced by RedactedValue in all
+// UI, log and machine-readable output of this process.
+func AddSensitiveValue(v string) {
+	if v == "" {
+		return
+	}
+
+	sensitiveValues.Lock()
+	defer sensitiveValues.Unlock()
+
+	for _, existing := range sensitiveValues.values {
+		if existing == v {
+			return
+		}
+	}
+
+	// Longer values are replaced first so that a value that contains
+	// another value is replaced completely.
+	values := append(sensitiveValues.values, v)
+	sort.Sort(sort.Reverse(byLength(values)))
+	sensitiveValues.values = values
+
+	if sensitiveValues.announce != nil {
+		fmt.Fprintf(sensitiveValues.announce, "%s%s\n",
+			sensitiveValuePrefix, base64.StdEncoding.EncodeToString([]byte(v)))
+	}
+}
+
+// Redact returns s with all of the sensitive values replaced.
+func Redact(s string) string {
+	sensitiveValues.RLock()
+	defer sensitiveValues.RUnlock()
+
+	for _, v := range sensitiveValues.values {
+		s = strings.Replace(s, v, RedactedValue, -1)
+	}
+
+	return s
+}
+
+// IsSensitive returns true if s contains any of the sensitive values.
+func IsSensitive(s string) bool {
+	sensitiveValues.RLock()
+	defer sensitiveValues.RUnlock()
+
+	for _, v := range sensitiveValues.values {
+		if strings.Contains(s, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RedactArgs returns a copy of args with each of them redacted. The args
+// themselves are left alone, since callers may still use them.
+func RedactArgs(args []string) []string {
+	result := make([]string, len(args))
+	for i, arg := range args {
+		result[i] = Redact(arg)
+	}
+
+	return result
+}
+
+// NewRedactWriter returns a writer that redacts sensitive values before
+// writing to w. Values are only found within a single call to Write, which
+// is how the log package writes each line.
+func NewRedactWriter(w io.Writer) io.Writer {
+	return &redactWriter{w}
+}
+
+type redactWriter struct {
+	w io.Writer
+}
+
+func (w *redactWriter) Write(p []byte) (int, error) {
+	if _, err := io.WriteString(w.w, Redact(string(p))); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+type byLength []string
+
+func (s byLength) Len() int           { return len(s) }
+func (s byLength) Less(i, j int) bool { return len(s[i]) < len(s[j]) }
+func (s byLength) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
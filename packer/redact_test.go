@@ -0,0 +1,118 @@
+package packer
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// resetSensitiveValues forgets all sensitive values, so that tests don't
+// depend on what other tests or earlier runs added.
+func resetSensitiveValues() {
+	sensitiveValues.Lock()
+	defer sensitiveValues.Unlock()
+	sensitiveValues.values = nil
+	sensitiveValues.announce = nil
+}
+
+func TestRedact(t *testing.T) {
+	resetSensitiveValues()
+	defer resetSensitiveValues()
+
+	AddSensitiveValue("redact-secret")
+	AddSensitiveValue("redact-secret-longer")
+	AddSensitiveValue("")
+
+	cases := map[string]string{
+		"foo":                             "foo",
+		"key=redact-secret":               "key=<sensitive>",
+		"key=redact-secret-longer, again": "key=<sensitive>, again",
+		"redact-secret redact-secret":     "<sensitive> <sensitive>",
+	}
+
+	for input, expected := range cases {
+		if actual := Redact(input); actual != expected {
+			t.Fatalf("bad: %s", actual)
+		}
+	}
+}
+
+func TestIsSensitive(t *testing.T) {
+	resetSensitiveValues()
+	defer resetSensitiveValues()
+
+	AddSensitiveValue("sensitive-secret")
+
+	if !IsSensitive("key=sensitive-secret") {
+		t.Fatal("should be sensitive")
+	}
+
+	if IsSensitive("key=value") {
+		t.Fatal("should not be sensitive")
+	}
+}
+
+func TestRedactArgs(t *testing.T) {
+	resetSensitiveValues()
+	defer resetSensitiveValues()
+
+	AddSensitiveValue("redact-args-secret")
+
+	args := []string{"foo", "redact-args-secret"}
+	actual := RedactArgs(args)
+	if !reflect.DeepEqual(actual, []string{"foo", "<sensitive>"}) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	if args[1] != "redact-args-secret" {
+		t.Fatalf("args should be left alone: %#v", args)
+	}
+}
+
+func TestRedactWriter(t *testing.T) {
+	resetSensitiveValues()
+	defer resetSensitiveValues()
+
+	AddSensitiveValue("redact-writer-secret")
+
+	buf := new(bytes.Buffer)
+	w := NewRedactWriter(buf)
+
+	n, err := w.Write([]byte("password: redact-writer-secret\n"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if n != 31 {
+		t.Fatalf("bad: %d", n)
+	}
+
+	if buf.String() != "password: <sensitive>\n" {
+		t.Fatalf("bad: %s", buf.String())
+	}
+}
+
+func TestAnnounceSensitiveValues(t *testing.T) {
+	resetSensitiveValues()
+	defer resetSensitiveValues()
+
+	buf := new(bytes.Buffer)
+	AnnounceSensitiveValues(buf)
+
+	AddSensitiveValue("redact-announce\nsecret")
+	AddSensitiveValue("redact-announce\nsecret")
+
+	lines := strings.SplitAfter(buf.String(), "\n")
+	if len(lines) != 2 || lines[1] != "" {
+		t.Fatalf("bad: %#v", buf.String())
+	}
+
+	v, ok := ParseSensitiveValueLine(lines[0])
+	if !ok || v != "redact-announce\nsecret" {
+		t.Fatalf("bad: %#v", v)
+	}
+
+	if _, ok := ParseSensitiveValueLine("2014/01/01 foo\n"); ok {
+		t.Fatal("should not be a sensitive value line")
+	}
+}
@@ -5,8 +5,9 @@ import (
 	"net/rpc"
 )
 
-// An implementation of packer.Artifact where the artifact is actually
-// available over an RPC connection.
+// An implementation of packer.StateArtifact where the artifact is actually
+// available over an RPC connection. State is nil for artifacts that don't
+// implement packer.StateArtifact themselves.
 type artifact struct {
 	client   *rpc.Client
 	endpoint string
@@ -38,6 +39,11 @@ func (a *artifact) String() (result string) {
 	return
 }
 
+func (a *artifact) State(name string) (result interface{}) {
+	a.client.Call(a.endpoint+".State", name, &result)
+	return
+}
+
 func (a *artifact) Destroy() error {
 	var result error
 	if err := a.client.Call(a.endpoint+".Destroy", new(interface{}), &result); err != nil {
@@ -67,6 +73,14 @@ func (s *ArtifactServer) String(args *interface{}, reply *string) error {
 	return nil
 }
 
+func (s *ArtifactServer) State(name string, reply *interface{}) error {
+	if a, ok := s.artifact.(packer.StateArtifact); ok {
+		*reply = a.State(name)
+	}
+
+	return nil
+}
+
 func (s *ArtifactServer) Destroy(args *interface{}, reply *error) error {
 	err := s.artifact.Destroy()
 	if err != nil {
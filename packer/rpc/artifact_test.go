@@ -8,7 +8,11 @@ import (
 
 func TestArtifactRPC(t *testing.T) {
 	// Create the interface to test
-	a := new(packer.MockArtifact)
+	a := &packer.MockArtifact{
+		StateValues: map[string]interface{}{
+			"ssh_host_key": "ssh-rsa AAAA",
+		},
+	}
 
 	// Start the server
 	client, server := testClientServer(t)
@@ -34,8 +38,36 @@ func TestArtifactRPC(t *testing.T) {
 	if aClient.String() != "string" {
 		t.Fatalf("bad: %s", aClient.String())
 	}
+
+	stateClient, ok := aClient.(packer.StateArtifact)
+	if !ok {
+		t.Fatal("should be a StateArtifact")
+	}
+
+	if stateClient.State("ssh_host_key") != "ssh-rsa AAAA" {
+		t.Fatalf("bad: %#v", stateClient.State("ssh_host_key"))
+	}
+
+	if stateClient.State("unknown") != nil {
+		t.Fatalf("bad: %#v", stateClient.State("unknown"))
+	}
+}
+
+func TestArtifactRPC_noState(t *testing.T) {
+	// Embedding the interface hides the State method of the mock
+	a := struct{ packer.Artifact }{new(packer.MockArtifact)}
+
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterArtifact(a)
+
+	aClient := client.Artifact().(packer.StateArtifact)
+	if aClient.State("ssh_host_key") != nil {
+		t.Fatalf("bad: %#v", aClient.State("ssh_host_key"))
+	}
 }
 
 func TestArtifact_Implements(t *testing.T) {
-	var _ packer.Artifact = new(artifact)
+	var _ packer.StateArtifact = new(artifact)
 }
@@ -29,6 +29,16 @@ func (b *build) Name() (result string) {
 	return
 }
 
+func (b *build) BuilderType() (result string) {
+	b.client.Call("Build.BuilderType", new(interface{}), &result)
+	return
+}
+
+func (b *build) UserVariables() (result map[string]string) {
+	b.client.Call("Build.UserVariables", new(interface{}), &result)
+	return
+}
+
 func (b *build) Prepare() ([]string, error) {
 	var resp BuildPrepareResponse
 	if cerr := b.client.Call("Build.Prepare", new(interface{}), &resp); cerr != nil {
@@ -75,6 +85,12 @@ func (b *build) SetForce(val bool) {
 	}
 }
 
+func (b *build) SetOnError(val string) {
+	if err := b.client.Call("Build.SetOnError", val, new(interface{})); err != nil {
+		panic(err)
+	}
+}
+
 func (b *build) Cancel() {
 	if err := b.client.Call("Build.Cancel", new(interface{}), new(interface{})); err != nil {
 		panic(err)
@@ -86,6 +102,16 @@ func (b *BuildServer) Name(args *interface{}, reply *string) error {
 	return nil
 }
 
+func (b *BuildServer) BuilderType(args *interface{}, reply *string) error {
+	*reply = b.build.BuilderType()
+	return nil
+}
+
+func (b *BuildServer) UserVariables(args *interface{}, reply *map[string]string) error {
+	*reply = b.build.UserVariables()
+	return nil
+}
+
 func (b *BuildServer) Prepare(args *interface{}, resp *BuildPrepareResponse) error {
 	warnings, err := b.build.Prepare()
 	*resp = BuildPrepareResponse{
@@ -130,6 +156,11 @@ func (b *BuildServer) SetForce(val *bool, reply *interface{}) error {
 	return nil
 }
 
+func (b *BuildServer) SetOnError(val *string, reply *interface{}) error {
+	b.build.SetOnError(*val)
+	return nil
+}
+
 func (b *BuildServer) Cancel(args *interface{}, reply *interface{}) error {
 	b.build.Cancel()
 	return nil
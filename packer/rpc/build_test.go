@@ -18,6 +18,7 @@ type testBuild struct {
 	runUi           packer.Ui
 	setDebugCalled  bool
 	setForceCalled  bool
+	setOnErrorValue string
 	cancelCalled    bool
 
 	errRunResult bool
@@ -28,6 +29,14 @@ func (b *testBuild) Name() string {
 	return "name"
 }
 
+func (b *testBuild) BuilderType() string {
+	return "type"
+}
+
+func (b *testBuild) UserVariables() map[string]string {
+	return map[string]string{"foo": "bar"}
+}
+
 func (b *testBuild) Prepare() ([]string, error) {
 	b.prepareCalled = true
 	return b.prepareWarnings, nil
@@ -53,6 +62,10 @@ func (b *testBuild) SetForce(bool) {
 	b.setForceCalled = true
 }
 
+func (b *testBuild) SetOnError(val string) {
+	b.setOnErrorValue = val
+}
+
 func (b *testBuild) Cancel() {
 	b.cancelCalled = true
 }
@@ -71,6 +84,17 @@ func TestBuild(t *testing.T) {
 		t.Fatal("name should be called")
 	}
 
+	// Test BuilderType
+	if v := bClient.BuilderType(); v != "type" {
+		t.Fatalf("bad: %s", v)
+	}
+
+	// Test UserVariables
+	vars := bClient.UserVariables()
+	if !reflect.DeepEqual(vars, map[string]string{"foo": "bar"}) {
+		t.Fatalf("bad: %#v", vars)
+	}
+
 	// Test Prepare
 	bClient.Prepare()
 	if !b.prepareCalled {
@@ -116,6 +140,12 @@ func TestBuild(t *testing.T) {
 		t.Fatal("should be called")
 	}
 
+	// Test SetOnError
+	bClient.SetOnError(packer.OnErrorAbort)
+	if b.setOnErrorValue != packer.OnErrorAbort {
+		t.Fatalf("bad: %s", b.setOnErrorValue)
+	}
+
 	// Test Cancel
 	bClient.Cancel()
 	if !b.cancelCalled {
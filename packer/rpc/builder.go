@@ -36,7 +36,7 @@ func (b *builder) Prepare(config ...interface{}) ([]string, error) {
 		return nil, cerr
 	}
 
-	return resp.Warnings, resp.Error
+	return resp.Warnings, unwrapError(resp.Error)
 }
 
 func (b *builder) Run(ui packer.Ui, hook packer.Hook, cache packer.Cache) (packer.Artifact, error) {
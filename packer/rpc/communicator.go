@@ -50,6 +50,12 @@ type CommunicatorUploadDirArgs struct {
 	Exclude []string
 }
 
+type CommunicatorDownloadDirArgs struct {
+	Dst     string
+	Src     string
+	Exclude []string
+}
+
 func Communicator(client *rpc.Client) *communicator {
 	return &communicator{client: client}
 }
@@ -147,6 +153,22 @@ func (c *communicator) Download(path string, w io.Writer) (err error) {
 	return
 }
 
+func (c *communicator) DownloadDir(src string, dst string, exclude []string) error {
+	args := &CommunicatorDownloadDirArgs{
+		Dst:     dst,
+		Src:     src,
+		Exclude: exclude,
+	}
+
+	var reply error
+	err := c.client.Call("Communicator.DownloadDir", args, &reply)
+	if err == nil {
+		err = reply
+	}
+
+	return err
+}
+
 func (c *CommunicatorServer) Start(args *CommunicatorStartArgs, reply *interface{}) error {
 	// Build the RemoteCmd on this side so that it all pipes over
 	// to the remote side.
@@ -252,6 +274,10 @@ func (c *CommunicatorServer) Download(args *CommunicatorDownloadArgs, reply *int
 	return
 }
 
+func (c *CommunicatorServer) DownloadDir(args *CommunicatorDownloadDirArgs, reply *error) error {
+	return c.c.DownloadDir(args.Src, args.Dst, args.Exclude)
+}
+
 func serveSingleCopy(name string, mux *MuxConn, id uint32, dst io.Writer, src io.Reader) {
 	conn, err := mux.Accept(id)
 	if err != nil {
@@ -154,6 +154,24 @@ func TestCommunicatorRPC(t *testing.T) {
 	if downloadData != "download\n" {
 		t.Fatalf("bad: %s", downloadData)
 	}
+
+	// Test that we can download directories
+	err = remote.DownloadDir(dirSrc, dirDst, dirExcl)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if c.DownloadDirDst != dirDst {
+		t.Fatalf("bad: %s", c.DownloadDirDst)
+	}
+
+	if c.DownloadDirSrc != dirSrc {
+		t.Fatalf("bad: %s", c.DownloadDirSrc)
+	}
+
+	if !reflect.DeepEqual(c.DownloadDirExclude, dirExcl) {
+		t.Fatalf("bad: %#v", c.DownloadDirExclude)
+	}
 }
 
 func TestCommunicator_ImplementsCommunicator(t *testing.T) {
@@ -1,17 +1,49 @@
 package rpc
 
+import (
+	"github.com/mitchellh/packer/packer"
+)
+
 // This is a type that wraps error types so that they can be messaged
 // across RPC channels. Since "error" is an interface, we can't always
 // gob-encode the underlying structure. This is a valid error interface
 // implementer that we will push across.
 type BasicError struct {
 	Message string
+
+	// Errors are the messages of the individual errors if the original
+	// error was a packer.MultiError, so that they can be told apart.
+	Errors []string
 }
 
 func NewBasicError(err error) *BasicError {
-	return &BasicError{err.Error()}
+	result := &BasicError{Message: err.Error()}
+	if merr, ok := err.(*packer.MultiError); ok {
+		result.Errors = make([]string, len(merr.Errors))
+		for i, err := range merr.Errors {
+			result.Errors[i] = err.Error()
+		}
+	}
+
+	return result
 }
 
 func (e *BasicError) Error() string {
 	return e.Message
 }
+
+// unwrapError turns an error that was a packer.MultiError before it was
+// sent over RPC back into one.
+func unwrapError(err error) error {
+	berr, ok := err.(*BasicError)
+	if !ok || len(berr.Errors) == 0 {
+		return err
+	}
+
+	errs := make([]error, len(berr.Errors))
+	for i, msg := range berr.Errors {
+		errs[i] = &BasicError{Message: msg}
+	}
+
+	return &packer.MultiError{Errors: errs}
+}
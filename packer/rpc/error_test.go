@@ -2,6 +2,7 @@ package rpc
 
 import (
 	"errors"
+	"github.com/mitchellh/packer/packer"
 	"testing"
 )
 
@@ -17,3 +18,27 @@ func TestBasicError_MatchesMessage(t *testing.T) {
 		t.Fatalf("bad: %#v", wrapped.Error())
 	}
 }
+
+func TestBasicError_MultiError(t *testing.T) {
+	err := &packer.MultiError{
+		Errors: []error{errors.New("foo"), errors.New("bar")},
+	}
+
+	wrapped := NewBasicError(err)
+	if wrapped.Error() != err.Error() {
+		t.Fatalf("bad: %#v", wrapped.Error())
+	}
+
+	merr, ok := unwrapError(wrapped).(*packer.MultiError)
+	if !ok {
+		t.Fatalf("bad: %#v", unwrapError(wrapped))
+	}
+
+	if len(merr.Errors) != 2 || merr.Errors[1].Error() != "bar" {
+		t.Fatalf("bad: %#v", merr.Errors)
+	}
+
+	if unwrapError(NewBasicError(errors.New("foo"))).Error() != "foo" {
+		t.Fatal("single errors should be unchanged")
+	}
+}
@@ -33,10 +33,10 @@ type PostProcessorProcessResponse struct {
 func (p *postProcessor) Configure(raw ...interface{}) (err error) {
 	args := &PostProcessorConfigureArgs{Configs: raw}
 	if cerr := p.client.Call("PostProcessor.Configure", args, &err); cerr != nil {
-		err = cerr
+		return cerr
 	}
 
-	return
+	return unwrapError(err)
 }
 
 func (p *postProcessor) PostProcess(ui packer.Ui, a packer.Artifact) (packer.Artifact, bool, error) {
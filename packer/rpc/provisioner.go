@@ -27,10 +27,10 @@ type ProvisionerPrepareArgs struct {
 func (p *provisioner) Prepare(configs ...interface{}) (err error) {
 	args := &ProvisionerPrepareArgs{configs}
 	if cerr := p.client.Call("Provisioner.Prepare", args, &err); cerr != nil {
-		err = cerr
+		return cerr
 	}
 
-	return
+	return unwrapError(err)
 }
 
 func (p *provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
@@ -7,14 +7,17 @@ import (
 )
 
 // An implementation of packer.Ui where the Ui is actually executed
-// over an RPC connection.
+// over an RPC connection. Sensitive values are redacted before they
+// are sent, and again by the UiServer, since the processes on either
+// side may know about different sensitive values.
 type Ui struct {
 	client   *rpc.Client
 	endpoint string
 }
 
 // UiServer wraps a packer.Ui implementation and makes it exportable
-// as part of a Golang RPC server.
+// as part of a Golang RPC server. Sensitive values are redacted from
+// everything it receives.
 type UiServer struct {
 	ui packer.Ui
 }
@@ -26,12 +29,18 @@ type UiMachineArgs struct {
 }
 
 func (u *Ui) Ask(query string) (result string, err error) {
-	err = u.client.Call("Ui.Ask", query, &result)
+	err = u.client.Call("Ui.Ask", packer.Redact(query), &result)
 	return
 }
 
 func (u *Ui) Error(message string) {
-	if err := u.client.Call("Ui.Error", message, new(interface{})); err != nil {
+	if err := u.client.Call("Ui.Error", packer.Redact(message), new(interface{})); err != nil {
+		log.Printf("Error in Ui RPC call: %s", err)
+	}
+}
+
+func (u *Ui) Event(e *packer.Event) {
+	if err := u.client.Call("Ui.Event", redactEvent(e), new(interface{})); err != nil {
 		log.Printf("Error in Ui RPC call: %s", err)
 	}
 }
@@ -39,7 +48,7 @@ func (u *Ui) Error(message string) {
 func (u *Ui) Machine(t string, args ...string) {
 	rpcArgs := &UiMachineArgs{
 		Category: t,
-		Args:     args,
+		Args:     packer.RedactArgs(args),
 	}
 
 	if err := u.client.Call("Ui.Machine", rpcArgs, new(interface{})); err != nil {
@@ -48,45 +57,66 @@ func (u *Ui) Machine(t string, args ...string) {
 }
 
 func (u *Ui) Message(message string) {
-	if err := u.client.Call("Ui.Message", message, new(interface{})); err != nil {
+	if err := u.client.Call("Ui.Message", packer.Redact(message), new(interface{})); err != nil {
 		log.Printf("Error in Ui RPC call: %s", err)
 	}
 }
 
 func (u *Ui) Say(message string) {
-	if err := u.client.Call("Ui.Say", message, new(interface{})); err != nil {
+	if err := u.client.Call("Ui.Say", packer.Redact(message), new(interface{})); err != nil {
 		log.Printf("Error in Ui RPC call: %s", err)
 	}
 }
 
 func (u *UiServer) Ask(query string, reply *string) (err error) {
-	*reply, err = u.ui.Ask(query)
+	*reply, err = u.ui.Ask(packer.Redact(query))
 	return
 }
 
 func (u *UiServer) Error(message *string, reply *interface{}) error {
-	u.ui.Error(*message)
+	u.ui.Error(packer.Redact(*message))
+
+	*reply = nil
+	return nil
+}
+
+func (u *UiServer) Event(e *packer.Event, reply *interface{}) error {
+	u.ui.Event(redactEvent(e))
 
 	*reply = nil
 	return nil
 }
 
 func (u *UiServer) Machine(args *UiMachineArgs, reply *interface{}) error {
-	u.ui.Machine(args.Category, args.Args...)
+	u.ui.Machine(args.Category, packer.RedactArgs(args.Args)...)
 
 	*reply = nil
 	return nil
 }
 
 func (u *UiServer) Message(message *string, reply *interface{}) error {
-	u.ui.Message(*message)
+	u.ui.Message(packer.Redact(*message))
 	*reply = nil
 	return nil
 }
 
 func (u *UiServer) Say(message *string, reply *interface{}) error {
-	u.ui.Say(*message)
+	u.ui.Say(packer.Redact(*message))
 
 	*reply = nil
 	return nil
 }
+
+// redactEvent returns a copy of the event with sensitive values redacted.
+func redactEvent(e *packer.Event) *packer.Event {
+	result := *e
+	result.Error = packer.Redact(e.Error)
+	if e.Artifact != nil {
+		artifact := *e.Artifact
+		artifact.Id = packer.Redact(artifact.Id)
+		artifact.String = packer.Redact(artifact.String)
+		result.Artifact = &artifact
+	}
+
+	return &result
+}
@@ -1,8 +1,10 @@
 package rpc
 
 import (
+	"github.com/mitchellh/packer/packer"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type testUi struct {
@@ -10,6 +12,7 @@ type testUi struct {
 	askQuery       string
 	errorCalled    bool
 	errorMessage   string
+	event          *packer.Event
 	machineCalled  bool
 	machineType    string
 	machineArgs    []string
@@ -30,6 +33,10 @@ func (u *testUi) Error(message string) {
 	u.errorMessage = message
 }
 
+func (u *testUi) Event(e *packer.Event) {
+	u.event = e
+}
+
 func (u *testUi) Machine(t string, args ...string) {
 	u.machineCalled = true
 	u.machineType = t
@@ -101,4 +108,52 @@ func TestUiRPC(t *testing.T) {
 	if !reflect.DeepEqual(ui.machineArgs, expected) {
 		t.Fatalf("bad: %#v", ui.machineArgs)
 	}
+
+	event := packer.NewEvent(packer.EventStepEnd, "StepCreateVM")
+	event.Duration = 5 * time.Second
+	event.Artifact = &packer.ArtifactInfo{Id: "id", Files: []string{"a"}}
+	uiClient.Event(event)
+	if ui.event == nil {
+		t.Fatal("event should be called")
+	}
+	if ui.event.Type != packer.EventStepEnd || ui.event.Name != "StepCreateVM" {
+		t.Fatalf("bad: %#v", ui.event)
+	}
+	if ui.event.Duration != 5*time.Second || !ui.event.Time.Equal(event.Time) {
+		t.Fatalf("bad: %#v", ui.event)
+	}
+	if !reflect.DeepEqual(ui.event.Artifact, event.Artifact) {
+		t.Fatalf("bad: %#v", ui.event.Artifact)
+	}
+}
+
+func TestUiRPC_sensitive(t *testing.T) {
+	packer.AddSensitiveValue("rpc-ui-secret")
+
+	ui := new(testUi)
+	client, server := testClientServer(t)
+	defer client.Close()
+	defer server.Close()
+	server.RegisterUi(ui)
+
+	uiClient := client.Ui()
+	uiClient.Say("password rpc-ui-secret")
+	if ui.sayMessage != "password <sensitive>" {
+		t.Fatalf("bad: %#v", ui.sayMessage)
+	}
+
+	uiClient.Machine("foo", "rpc-ui-secret")
+	if !reflect.DeepEqual(ui.machineArgs, []string{"<sensitive>"}) {
+		t.Fatalf("bad: %#v", ui.machineArgs)
+	}
+
+	event := packer.NewEvent(packer.EventError, "foo")
+	event.Error = "failed with rpc-ui-secret"
+	uiClient.Event(event)
+	if ui.event.Error != "failed with <sensitive>" {
+		t.Fatalf("bad: %#v", ui.event.Error)
+	}
+	if event.Error != "failed with rpc-ui-secret" {
+		t.Fatalf("event should not be modified: %#v", event.Error)
+	}
 }
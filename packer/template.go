@@ -5,10 +5,14 @@ import (
 	"fmt"
 	"github.com/mitchellh/mapstructure"
 	jsonutil "github.com/mitchellh/packer/common/json"
+	yamlutil "github.com/mitchellh/packer/common/yaml"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
+	"strings"
 	"text/template"
 	"time"
 )
@@ -19,11 +23,20 @@ import (
 // are until we read the "type" field.
 type rawTemplate struct {
 	Description    string
+	Include        []string
 	Variables      map[string]interface{}
 	Builders       []map[string]interface{}
 	Hooks          map[string][]string
 	Provisioners   []map[string]interface{}
 	PostProcessors []interface{} `mapstructure:"post-processors"`
+
+	// Where each of the variables, builders, provisioners and
+	// post-processors above were defined, since they may come from
+	// included files.
+	variableSources      map[string]configLocation
+	builderSources       []configLocation
+	provisionerSources   []configLocation
+	postProcessorSources []configLocation
 }
 
 // The Template struct represents a parsed template, parsed into the most
@@ -35,6 +48,8 @@ type Template struct {
 	Hooks          map[string][]string
 	PostProcessors [][]RawPostProcessorConfig
 	Provisioners   []RawProvisionerConfig
+
+	path string // The absolute path of the template file, if any
 }
 
 // The RawBuilderConfig struct represents a raw, unprocessed builder
@@ -46,6 +61,8 @@ type RawBuilderConfig struct {
 	Type string
 
 	RawConfig interface{}
+
+	location configLocation
 }
 
 // RawPostProcessorConfig represents a raw, unprocessed post-processor
@@ -57,6 +74,8 @@ type RawPostProcessorConfig struct {
 	Type              string
 	KeepInputArtifact bool `mapstructure:"keep_input_artifact"`
 	RawConfig         map[string]interface{}
+
+	location configLocation
 }
 
 // RawProvisionerConfig represents a raw, unprocessed provisioner configuration.
@@ -65,13 +84,87 @@ type RawPostProcessorConfig struct {
 type RawProvisionerConfig struct {
 	TemplateOnlyExcept `mapstructure:",squash"`
 
-	Type           string
-	Override       map[string]interface{}
-	RawPauseBefore string `mapstructure:"pause_before"`
+	Type            string
+	Override        map[string]interface{}
+	RawPauseBefore  string                 `mapstructure:"pause_before"`
+	MaxRetries      int                    `mapstructure:"max_retries"`
+	RawRetryBackoff string                 `mapstructure:"retry_backoff"`
+	RawTimeout      string                 `mapstructure:"timeout"`
+	OnError         string                 `mapstructure:"on_error"`
+	ErrorCleanup    map[string]interface{} `mapstructure:"error_cleanup"`
 
 	RawConfig interface{}
 
-	pauseBefore time.Duration
+	location     configLocation
+	pauseBefore  time.Duration
+	retryBackoff time.Duration
+	timeout      time.Duration
+}
+
+// defaultRetryBackoff is how long to wait before the first retry of a
+// provisioner if retry_backoff isn't set.
+const defaultRetryBackoff = 5 * time.Second
+
+// parseErrorPolicy validates the max_retries, retry_backoff, timeout,
+// on_error and error_cleanup settings of the provisioner at loc.
+func (r *RawProvisionerConfig) parseErrorPolicy(loc configLocation) (errs []error) {
+	if r.MaxRetries < 0 {
+		errs = append(errs, loc.Key("max_retries").Errorf(
+			"max_retries can't be negative"))
+	}
+
+	r.retryBackoff = defaultRetryBackoff
+	if r.RawRetryBackoff != "" {
+		duration, err := time.ParseDuration(r.RawRetryBackoff)
+		if err != nil {
+			errs = append(errs, loc.Key("retry_backoff").Errorf(
+				"retry_backoff invalid: %s", err))
+		}
+
+		r.retryBackoff = duration
+	}
+
+	if r.RawTimeout != "" {
+		duration, err := time.ParseDuration(r.RawTimeout)
+		if err != nil {
+			errs = append(errs, loc.Key("timeout").Errorf(
+				"timeout invalid: %s", err))
+		}
+
+		r.timeout = duration
+	}
+
+	if r.OnError == "" {
+		r.OnError = ProvisionerOnErrorAbort
+		if r.ErrorCleanup != nil {
+			r.OnError = ProvisionerOnErrorCleanup
+		}
+	}
+
+	switch r.OnError {
+	case ProvisionerOnErrorAbort, ProvisionerOnErrorContinue:
+		if r.ErrorCleanup != nil {
+			errs = append(errs, loc.Key("error_cleanup").Errorf(
+				"error_cleanup can only be used when on_error is '%s'",
+				ProvisionerOnErrorCleanup))
+		}
+	case ProvisionerOnErrorCleanup:
+		if r.ErrorCleanup == nil {
+			errs = append(errs, loc.Key("on_error").Errorf(
+				"error_cleanup must be set when on_error is '%s'",
+				ProvisionerOnErrorCleanup))
+		} else if t, ok := r.ErrorCleanup["type"].(string); !ok || t == "" {
+			errs = append(errs, loc.Key("error_cleanup").Errorf(
+				"error_cleanup is missing 'type'"))
+		}
+	default:
+		errs = append(errs, loc.Key("on_error").Errorf(
+			"on_error must be one of '%s', '%s' or '%s': %s",
+			ProvisionerOnErrorAbort, ProvisionerOnErrorContinue,
+			ProvisionerOnErrorCleanup, r.OnError))
+	}
+
+	return
 }
 
 // RawVariable represents a variable configuration within a template.
@@ -80,6 +173,16 @@ type RawVariable struct {
 	Required bool   // If the variable is required or not
 	Value    string // The set value for this variable
 	HasValue bool   // True if the value was set
+
+	Type        string // The type of the variable, such as VariableTypeList
+	Description string // A description of the variable for users
+	Sensitive   bool   // If the value should be redacted from all output
+
+	// Validation rules for the value. See Parse for how they apply.
+	Pattern string   // A regular expression that the value must match
+	Allowed []string // The values that are allowed, if not empty
+	Min     *float64 // The minimum number, length or number of elements
+	Max     *float64 // The maximum number, length or number of elements
 }
 
 // ParseTemplate takes a byte slice and parses a Template from it, returning
@@ -89,43 +192,27 @@ type RawVariable struct {
 // way.
 //
 // The second parameter, vars, are the values for a set of user variables.
-func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error) {
-	var rawTplInterface interface{}
-	err = jsonutil.Unmarshal(data, &rawTplInterface)
-	if err != nil {
-		return
-	}
-
-	// Decode the raw template interface into the actual rawTemplate
-	// structure, checking for any extranneous keys along the way.
-	var md mapstructure.Metadata
-	var rawTpl rawTemplate
-	decoderConfig := &mapstructure.DecoderConfig{
-		Metadata: &md,
-		Result:   &rawTpl,
-	}
-
-	decoder, err := mapstructure.NewDecoder(decoderConfig)
-	if err != nil {
-		return
-	}
+//
+// Files included by the template are looked up relative to the current
+// working directory. Use ParseTemplateFile to look them up relative to the
+// template file itself.
+func ParseTemplate(data []byte, vars map[string]string) (*Template, error) {
+	return parseTemplate(data, "", vars, nil)
+}
 
-	err = decoder.Decode(rawTplInterface)
+func parseTemplate(data []byte, path string, vars, optionalVars map[string]string) (t *Template, err error) {
+	rawTpl, errors, err := loadRawTemplate(data, path, nil)
 	if err != nil {
 		return
 	}
 
-	errors := make([]error, 0)
-
-	if len(md.Unused) > 0 {
-		sort.Strings(md.Unused)
-		for _, unused := range md.Unused {
-			errors = append(
-				errors, fmt.Errorf("Unknown root level key in template: '%s'", unused))
+	t = &Template{}
+	if path != "" {
+		if t.path, err = filepath.Abs(path); err != nil {
+			return nil, err
 		}
 	}
 
-	t = &Template{}
 	t.Description = rawTpl.Description
 	t.Variables = make(map[string]RawVariable)
 	t.Builders = make(map[string]RawBuilderConfig)
@@ -135,25 +222,12 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 
 	// Gather all the variables
 	for k, v := range rawTpl.Variables {
-		var variable RawVariable
-		variable.Required = v == nil
-
-		// Create a new mapstructure decoder in order to decode the default
-		// value since this is the only value in the regular template that
-		// can be weakly typed.
-		decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-			Result:           &variable.Default,
-			WeaklyTypedInput: true,
-		})
-		if err != nil {
-			// This should never happen.
-			panic(err)
-		}
+		loc := rawTpl.variableSources[k]
 
-		err = decoder.Decode(v)
+		variable, err := decodeVariable(v)
 		if err != nil {
-			errors = append(errors,
-				fmt.Errorf("Error decoding default value for user var '%s': %s", k, err))
+			errors = append(errors, loc.Errorf(
+				"Error decoding user var '%s': %s", k, err))
 			continue
 		}
 
@@ -162,6 +236,27 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 			variable.HasValue = true
 			variable.Value = val
 			delete(vars, k)
+		} else if val, ok := optionalVars[k]; ok {
+			variable.HasValue = true
+			variable.Value = val
+		}
+
+		// Validate the value now if we can. Defaults that use functions
+		// such as "env" are validated once they're processed in Build.
+		value, check := variable.Value, variable.HasValue
+		if !check && !variable.Required {
+			value, check = variable.Default, !strings.Contains(variable.Default, "{{")
+		}
+
+		if variable.Sensitive && variable.HasValue {
+			addSensitiveVariable(variable.Value)
+		}
+
+		if check {
+			if _, err := variable.Parse(value); err != nil {
+				errors = append(errors, loc.Errorf(
+					"Invalid value for user var '%s': %s", k, err))
+			}
 		}
 
 		t.Variables[k] = variable
@@ -169,21 +264,23 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 
 	// Gather all the builders
 	for i, v := range rawTpl.Builders {
+		loc := rawTpl.builderSources[i]
+
 		var raw RawBuilderConfig
 		if err := mapstructure.Decode(v, &raw); err != nil {
 			if merr, ok := err.(*mapstructure.Error); ok {
 				for _, err := range merr.Errors {
-					errors = append(errors, fmt.Errorf("builder %d: %s", i+1, err))
+					errors = append(errors, wrapConfigErrors(fmt.Errorf("%s", err), loc))
 				}
 			} else {
-				errors = append(errors, fmt.Errorf("builder %d: %s", i+1, err))
+				errors = append(errors, wrapConfigErrors(err, loc))
 			}
 
 			continue
 		}
 
 		if raw.Type == "" {
-			errors = append(errors, fmt.Errorf("builder %d: missing 'type'", i+1))
+			errors = append(errors, loc.Errorf("missing 'type'"))
 			continue
 		}
 
@@ -195,8 +292,10 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 		}
 
 		// Check if we already have a builder with this name and error if so
-		if _, ok := t.Builders[raw.Name]; ok {
-			errors = append(errors, fmt.Errorf("builder with name '%s' already exists", raw.Name))
+		if other, ok := t.Builders[raw.Name]; ok {
+			errors = append(errors, loc.Errorf(
+				"builder with name '%s' already exists, defined at %s",
+				raw.Name, other.location.Position()))
 			continue
 		}
 
@@ -205,6 +304,7 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 		delete(v, "name")
 
 		raw.RawConfig = v
+		raw.location = loc
 
 		t.Builders[raw.Name] = raw
 	}
@@ -213,7 +313,8 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 	// are actually three different formats that the user can use to define
 	// a post-processor.
 	for i, rawV := range rawTpl.PostProcessors {
-		rawPP, err := parsePostProcessor(i, rawV)
+		loc := rawTpl.postProcessorSources[i]
+		rawPP, err := parsePostProcessor(loc, rawV)
 		if err != nil {
 			errors = append(errors, err...)
 			continue
@@ -221,24 +322,26 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 
 		configs := make([]RawPostProcessorConfig, 0, len(rawPP))
 		for j, pp := range rawPP {
+			ppLoc := loc
+			if _, ok := rawV.([]interface{}); ok {
+				ppLoc = loc.Index(j)
+			}
+
 			var config RawPostProcessorConfig
 			if err := mapstructure.Decode(pp, &config); err != nil {
 				if merr, ok := err.(*mapstructure.Error); ok {
 					for _, err := range merr.Errors {
-						errors = append(errors,
-							fmt.Errorf("Post-processor #%d.%d: %s", i+1, j+1, err))
+						errors = append(errors, wrapConfigErrors(fmt.Errorf("%s", err), ppLoc))
 					}
 				} else {
-					errors = append(errors,
-						fmt.Errorf("Post-processor %d.%d: %s", i+1, j+1, err))
+					errors = append(errors, wrapConfigErrors(err, ppLoc))
 				}
 
 				continue
 			}
 
 			if config.Type == "" {
-				errors = append(errors,
-					fmt.Errorf("Post-processor %d.%d: missing 'type'", i+1, j+1))
+				errors = append(errors, ppLoc.Errorf("missing 'type'"))
 				continue
 			}
 
@@ -249,14 +352,14 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 			// Verify that the only settings are good
 			if errs := config.TemplateOnlyExcept.Validate(t.Builders); len(errs) > 0 {
 				for _, err := range errs {
-					errors = append(errors,
-						fmt.Errorf("Post-processor %d.%d: %s", i+1, j+1, err))
+					errors = append(errors, wrapConfigErrors(err, ppLoc))
 				}
 
 				continue
 			}
 
 			config.RawConfig = pp
+			config.location = ppLoc
 
 			// Add it to the list of configs
 			configs = append(configs, config)
@@ -267,21 +370,22 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 
 	// Gather all the provisioners
 	for i, v := range rawTpl.Provisioners {
+		loc := rawTpl.provisionerSources[i]
 		raw := &t.Provisioners[i]
 		if err := mapstructure.Decode(v, raw); err != nil {
 			if merr, ok := err.(*mapstructure.Error); ok {
 				for _, err := range merr.Errors {
-					errors = append(errors, fmt.Errorf("provisioner %d: %s", i+1, err))
+					errors = append(errors, wrapConfigErrors(fmt.Errorf("%s", err), loc))
 				}
 			} else {
-				errors = append(errors, fmt.Errorf("provisioner %d: %s", i+1, err))
+				errors = append(errors, wrapConfigErrors(err, loc))
 			}
 
 			continue
 		}
 
 		if raw.Type == "" {
-			errors = append(errors, fmt.Errorf("provisioner %d: missing 'type'", i+1))
+			errors = append(errors, loc.Errorf("missing 'type'"))
 			continue
 		}
 
@@ -292,16 +396,15 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 		// Verify that the override keys exist...
 		for name, _ := range raw.Override {
 			if _, ok := t.Builders[name]; !ok {
-				errors = append(
-					errors, fmt.Errorf("provisioner %d: build '%s' not found for override", i+1, name))
+				errors = append(errors, loc.Key("override").Key(name).Errorf(
+					"build '%s' not found for override", name))
 			}
 		}
 
 		// Verify that the only settings are good
 		if errs := raw.TemplateOnlyExcept.Validate(t.Builders); len(errs) > 0 {
 			for _, err := range errs {
-				errors = append(errors,
-					fmt.Errorf("provisioner %d: %s", i+1, err))
+				errors = append(errors, wrapConfigErrors(err, loc))
 			}
 		}
 
@@ -309,10 +412,8 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 		if raw.RawPauseBefore != "" {
 			duration, err := time.ParseDuration(raw.RawPauseBefore)
 			if err != nil {
-				errors = append(
-					errors, fmt.Errorf(
-						"provisioner %d: pause_before invalid: %s",
-						i+1, err))
+				errors = append(errors, loc.Key("pause_before").Errorf(
+					"pause_before invalid: %s", err))
 			}
 
 			raw.pauseBefore = duration
@@ -322,7 +423,17 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 		// get template validation errors later.
 		delete(v, "pause_before")
 
+		// Setup the retry, timeout and error settings
+		errors = append(errors, raw.parseErrorPolicy(loc)...)
+
+		delete(v, "max_retries")
+		delete(v, "retry_backoff")
+		delete(v, "timeout")
+		delete(v, "on_error")
+		delete(v, "error_cleanup")
+
 		raw.RawConfig = v
+		raw.location = loc
 	}
 
 	if len(t.Builders) == 0 {
@@ -347,6 +458,15 @@ func ParseTemplate(data []byte, vars map[string]string) (t *Template, err error)
 // ParseTemplateFile takes the given template file and parses it into
 // a single template.
 func ParseTemplateFile(path string, vars map[string]string) (*Template, error) {
+	return ParseTemplateFileOptionalVars(path, vars, nil)
+}
+
+// ParseTemplateFileOptionalVars parses the template file like
+// ParseTemplateFile. The optional variables are only used for the
+// variables that the template defines and vars doesn't set, unlike vars,
+// which must all be defined. This is for values that come from sources
+// that are shared by many templates, such as the environment.
+func ParseTemplateFileOptionalVars(path string, vars, optionalVars map[string]string) (*Template, error) {
 	var data []byte
 
 	if path == "-" {
@@ -366,10 +486,186 @@ func ParseTemplateFile(path string, vars map[string]string) (*Template, error) {
 		}
 	}
 
-	return ParseTemplate(data, vars)
+	if path == "-" {
+		path = ""
+	}
+
+	return parseTemplate(data, path, vars, optionalVars)
 }
 
-func parsePostProcessor(i int, rawV interface{}) (result []map[string]interface{}, errors []error) {
+// loadRawTemplate decodes the JSON or YAML template data read from path and
+// merges the files it includes into it. Included files are merged first, in
+// the order they're listed, so the builders, provisioners and
+// post-processors of the template come after theirs and its variables
+// override theirs.
+//
+// The returned error is set if the template couldn't be decoded at all,
+// while the slice of errors contains problems that don't stop the rest of
+// the template from being parsed.
+func loadRawTemplate(data []byte, path string, parents []string) (*rawTemplate, []error, error) {
+	var rawTplInterface interface{}
+	unmarshal := jsonutil.Unmarshal
+	positions := jsonutil.Positions
+	if yamlutil.IsYAML(path, data) {
+		unmarshal = yamlutil.Unmarshal
+		positions = yamlutil.Positions
+	}
+
+	if err := unmarshal(data, &rawTplInterface); err != nil {
+		return nil, nil, err
+	}
+
+	// Record where everything is in the file, so errors can point at it.
+	// This can't fail if the data could be decoded.
+	file := &templateFile{Name: path}
+	file.Positions, _ = positions(data)
+	root := configLocation{File: file}
+
+	// Decode the raw template interface into the actual rawTemplate
+	// structure, checking for any extranneous keys along the way.
+	var md mapstructure.Metadata
+	var rawTpl rawTemplate
+	decoderConfig := &mapstructure.DecoderConfig{
+		Metadata: &md,
+		Result:   &rawTpl,
+	}
+
+	decoder, err := mapstructure.NewDecoder(decoderConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := decoder.Decode(rawTplInterface); err != nil {
+		return nil, nil, err
+	}
+
+	errors := make([]error, 0)
+	if len(md.Unused) > 0 {
+		sort.Strings(md.Unused)
+		for _, unused := range md.Unused {
+			errors = append(errors, root.Key(unused).Errorf(
+				"Unknown root level key in template: '%s'", unused))
+		}
+	}
+
+	result := &rawTemplate{
+		Description:     rawTpl.Description,
+		Variables:       make(map[string]interface{}),
+		variableSources: make(map[string]configLocation),
+	}
+
+	// Remember this file to catch include cycles. A template that isn't
+	// read from a file can't be included, but still counts as a parent.
+	abs := ""
+	if path != "" {
+		abs, err = filepath.Abs(path)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	parents = append(parents, abs)
+
+	for _, pattern := range rawTpl.Include {
+		if path != "" && !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+
+		// Glob patterns are expanded in sorted order. Anything that doesn't
+		// match is read as is, so that a missing file is reported.
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			errors = append(errors, fmt.Errorf("include '%s': %s", pattern, err))
+			continue
+		}
+
+		if len(matches) == 0 {
+			matches = []string{pattern}
+		}
+
+		for _, include := range matches {
+			included, errs, err := loadIncludedTemplate(include, parents)
+			if err != nil {
+				errors = append(errors, fmt.Errorf("%s: %s", include, err))
+				continue
+			}
+
+			errors = append(errors, errs...)
+			result.merge(included)
+		}
+	}
+
+	rawTpl.variableSources = make(map[string]configLocation)
+	for k := range rawTpl.Variables {
+		rawTpl.variableSources[k] = root.Key("variables").Key(k)
+	}
+
+	rawTpl.builderSources = make([]configLocation, len(rawTpl.Builders))
+	for i := range rawTpl.Builders {
+		rawTpl.builderSources[i] = root.Key("builders").Index(i)
+	}
+
+	rawTpl.provisionerSources = make([]configLocation, len(rawTpl.Provisioners))
+	for i := range rawTpl.Provisioners {
+		rawTpl.provisionerSources[i] = root.Key("provisioners").Index(i)
+	}
+
+	rawTpl.postProcessorSources = make([]configLocation, len(rawTpl.PostProcessors))
+	for i := range rawTpl.PostProcessors {
+		rawTpl.postProcessorSources[i] = root.Key("post-processors").Index(i)
+	}
+
+	result.merge(&rawTpl)
+	return result, errors, nil
+}
+
+// loadIncludedTemplate reads and decodes a file included by a template,
+// refusing to include a file that is already being included.
+func loadIncludedTemplate(path string, parents []string) (*rawTemplate, []error, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, parent := range parents {
+		if parent == abs {
+			return nil, nil, fmt.Errorf("include cycle, file is already included")
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return loadRawTemplate(data, path, parents)
+}
+
+// merge adds the contents of the other template to this one. Builders,
+// provisioners, post-processors and hooks are appended, while variables
+// replace any existing variables with the same name.
+func (r *rawTemplate) merge(other *rawTemplate) {
+	for k, v := range other.Variables {
+		r.Variables[k] = v
+		r.variableSources[k] = other.variableSources[k]
+	}
+
+	for k, v := range other.Hooks {
+		if r.Hooks == nil {
+			r.Hooks = make(map[string][]string)
+		}
+
+		r.Hooks[k] = append(r.Hooks[k], v...)
+	}
+
+	r.Builders = append(r.Builders, other.Builders...)
+	r.builderSources = append(r.builderSources, other.builderSources...)
+	r.Provisioners = append(r.Provisioners, other.Provisioners...)
+	r.provisionerSources = append(r.provisionerSources, other.provisionerSources...)
+	r.PostProcessors = append(r.PostProcessors, other.PostProcessors...)
+	r.postProcessorSources = append(r.postProcessorSources, other.postProcessorSources...)
+}
+
+func parsePostProcessor(loc configLocation, rawV interface{}) (result []map[string]interface{}, errors []error) {
 	switch v := rawV.(type) {
 	case string:
 		result = []map[string]interface{}{
@@ -387,11 +683,11 @@ func parsePostProcessor(i int, rawV interface{}) (result []map[string]interface{
 			case map[string]interface{}:
 				result[j] = innerV
 			case []interface{}:
-				errors = append(
-					errors,
-					fmt.Errorf("Post-processor %d.%d: sequences not allowed to be nested in sequences", i+1, j+1))
+				errors = append(errors, loc.Index(j).Errorf(
+					"sequences not allowed to be nested in sequences"))
 			default:
-				errors = append(errors, fmt.Errorf("Post-processor %d.%d is in a bad format.", i+1, j+1))
+				errors = append(errors, loc.Index(j).Errorf(
+					"Post-processor is in a bad format."))
 			}
 		}
 
@@ -400,7 +696,7 @@ func parsePostProcessor(i int, rawV interface{}) (result []map[string]interface{
 		}
 	default:
 		result = nil
-		errors = []error{fmt.Errorf("Post-processor %d is in a bad format.", i+1)}
+		errors = []error{loc.Errorf("Post-processor is in a bad format.")}
 	}
 
 	return
@@ -451,56 +747,12 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 		return
 	}
 
-	// Prepare the variable template processor, which is a bit unique
-	// because we don't allow user variable usage and we add a function
-	// to read from the environment.
-	varTpl, err := NewConfigTemplate()
-	if err != nil {
-		return nil, err
-	}
-	varTpl.Funcs(template.FuncMap{
-		"env":  templateEnv,
-		"user": templateDisableUser,
-	})
-
-	// Prepare the variables
-	var varErrors []error
-	variables := make(map[string]string)
-	for k, v := range t.Variables {
-		if v.Required && !v.HasValue {
-			varErrors = append(varErrors,
-				fmt.Errorf("Required user variable '%s' not set", k))
-		}
-
-		var val string
-		if v.HasValue {
-			val = v.Value
-		} else {
-			val, err = varTpl.Process(v.Default, nil)
-			if err != nil {
-				varErrors = append(varErrors,
-					fmt.Errorf("Error processing user variable '%s': %s'", k, err))
-			}
-		}
-
-		variables[k] = val
-	}
-
-	if len(varErrors) > 0 {
-		return nil, &MultiError{varErrors}
-	}
-
-	// Process the name
-	tpl, err := NewConfigTemplate()
-	if err != nil {
-		return nil, err
-	}
-	tpl.UserVars = variables
-
-	name, err = tpl.Process(name, nil)
+	tpl, typedVariables, err := t.buildConfigTemplate(name, components)
 	if err != nil {
 		return nil, err
 	}
+	name = tpl.BuildName
+	variables := tpl.UserVars
 
 	// Gather the Hooks
 	hooks := make(map[string][]Hook)
@@ -527,10 +779,17 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 
 	// Prepare the post-processors
 	postProcessors := make([][]coreBuildPostProcessor, 0, len(t.PostProcessors))
+	postProcessorLocations := make([][]configLocation, 0, len(t.PostProcessors))
 	for _, rawPPs := range t.PostProcessors {
 		current := make([]coreBuildPostProcessor, 0, len(rawPPs))
+		locations := make([]configLocation, 0, len(rawPPs))
 		for _, rawPP := range rawPPs {
-			if rawPP.TemplateOnlyExcept.Skip(name) {
+			skip, err := rawPP.TemplateOnlyExcept.SkipBuild(tpl)
+			if err != nil {
+				return nil, wrapConfigErrors(err, rawPP.location)
+			}
+
+			if skip {
 				continue
 			}
 
@@ -543,12 +802,15 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 				return nil, fmt.Errorf("PostProcessor type not found: %s", rawPP.Type)
 			}
 
+			config := interpolateTypedVariables(rawPP.RawConfig, typedVariables)
+
 			current = append(current, coreBuildPostProcessor{
 				processor:         pp,
 				processorType:     rawPP.Type,
-				config:            rawPP.RawConfig,
+				config:            config.(map[string]interface{}),
 				keepInputArtifact: rawPP.KeepInputArtifact,
 			})
+			locations = append(locations, rawPP.location)
 		}
 
 		// If we have no post-processors in this chain, just continue.
@@ -558,12 +820,20 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 		}
 
 		postProcessors = append(postProcessors, current)
+		postProcessorLocations = append(postProcessorLocations, locations)
 	}
 
 	// Prepare the provisioners
 	provisioners := make([]coreBuildProvisioner, 0, len(t.Provisioners))
+	provisionerLocations := make([][]configLocation, 0, len(t.Provisioners))
 	for _, rawProvisioner := range t.Provisioners {
-		if rawProvisioner.TemplateOnlyExcept.Skip(name) {
+		var skip bool
+		skip, err = rawProvisioner.TemplateOnlyExcept.SkipBuild(tpl)
+		if err != nil {
+			return nil, wrapConfigErrors(err, rawProvisioner.location)
+		}
+
+		if skip {
 			continue
 		}
 
@@ -579,11 +849,65 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 		}
 
 		configs := make([]interface{}, 1, 2)
-		configs[0] = rawProvisioner.RawConfig
+		configs[0] = interpolateTypedVariables(rawProvisioner.RawConfig, typedVariables)
+		locations := []configLocation{rawProvisioner.location}
 
 		if rawProvisioner.Override != nil {
 			if override, ok := rawProvisioner.Override[name]; ok {
-				configs = append(configs, override)
+				configs = append(configs,
+					interpolateTypedVariables(override, typedVariables))
+				locations = append(locations,
+					rawProvisioner.location.Key("override").Key(name))
+			}
+		}
+
+		var cleanup *coreBuildProvisioner
+		if rawProvisioner.ErrorCleanup != nil {
+			cleanupType := rawProvisioner.ErrorCleanup["type"].(string)
+			var cleanupProvisioner Provisioner
+			cleanupProvisioner, err = components.Provisioner(cleanupType)
+			if err != nil {
+				return
+			}
+
+			if cleanupProvisioner == nil {
+				err = fmt.Errorf("Provisioner type not found: %s", cleanupType)
+				return
+			}
+
+			cleanup = &coreBuildProvisioner{
+				provisioner:     cleanupProvisioner,
+				provisionerType: cleanupType,
+				config: []interface{}{interpolateTypedVariables(
+					rawProvisioner.ErrorCleanup, typedVariables)},
+			}
+		}
+
+		if rawProvisioner.MaxRetries > 0 {
+			provisioner = &RetriedProvisioner{
+				MaxRetries:   rawProvisioner.MaxRetries,
+				RetryBackoff: rawProvisioner.retryBackoff,
+				Provisioner:  provisioner,
+			}
+		}
+
+		if rawProvisioner.timeout > 0 {
+			provisioner = &TimeoutProvisioner{
+				Timeout:     rawProvisioner.timeout,
+				Provisioner: provisioner,
+			}
+		}
+
+		if rawProvisioner.OnError != ProvisionerOnErrorAbort {
+			var cleanupProvisioner Provisioner
+			if cleanup != nil {
+				cleanupProvisioner = cleanup.provisioner
+			}
+
+			provisioner = &OnErrorProvisioner{
+				OnError:     rawProvisioner.OnError,
+				Cleanup:     cleanupProvisioner,
+				Provisioner: provisioner,
 			}
 		}
 
@@ -594,35 +918,196 @@ func (t *Template) Build(name string, components *ComponentFinder) (b Build, err
 			}
 		}
 
-		coreProv := coreBuildProvisioner{provisioner, configs}
+		coreProv := coreBuildProvisioner{
+			provisioner:     provisioner,
+			provisionerType: rawProvisioner.Type,
+			config:          configs,
+			cleanup:         cleanup,
+		}
 		provisioners = append(provisioners, coreProv)
+		provisionerLocations = append(provisionerLocations, locations)
 	}
 
 	b = &coreBuild{
 		name:           name,
 		builder:        builder,
-		builderConfig:  builderConfig.RawConfig,
+		builderConfig:  interpolateTypedVariables(builderConfig.RawConfig, typedVariables),
 		builderType:    builderConfig.Type,
 		hooks:          hooks,
 		postProcessors: postProcessors,
 		provisioners:   provisioners,
+		templatePath:   t.path,
 		variables:      variables,
+
+		builderLocation:        builderConfig.location,
+		provisionerLocations:   provisionerLocations,
+		postProcessorLocations: postProcessorLocations,
 	}
 
 	return
 }
 
+// BuildSkips contains which provisioners and post-processors of a template
+// are skipped for a build, in the same order as in the template.
+type BuildSkips struct {
+	Provisioners   []bool
+	PostProcessors [][]bool
+}
+
+// BuildSkips returns which provisioners and post-processors are skipped
+// for the build with the given name, because of "only", "except",
+// "only_if" or "skip_if".
+func (t *Template) BuildSkips(name string, components *ComponentFinder) (*BuildSkips, error) {
+	if _, ok := t.Builders[name]; !ok {
+		return nil, fmt.Errorf("No such build found in template: %s", name)
+	}
+
+	tpl, _, err := t.buildConfigTemplate(name, components)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BuildSkips{
+		Provisioners:   make([]bool, len(t.Provisioners)),
+		PostProcessors: make([][]bool, len(t.PostProcessors)),
+	}
+
+	for i, rawProvisioner := range t.Provisioners {
+		result.Provisioners[i], err = rawProvisioner.TemplateOnlyExcept.SkipBuild(tpl)
+		if err != nil {
+			return nil, wrapConfigErrors(err, rawProvisioner.location)
+		}
+	}
+
+	for i, rawPPs := range t.PostProcessors {
+		result.PostProcessors[i] = make([]bool, len(rawPPs))
+		for j, rawPP := range rawPPs {
+			result.PostProcessors[i][j], err = rawPP.TemplateOnlyExcept.SkipBuild(tpl)
+			if err != nil {
+				return nil, wrapConfigErrors(err, rawPP.location)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// buildConfigTemplate returns the template processor for the build with
+// the given name, with the user variables and the build information set,
+// along with the typed values of the variables that aren't strings. The
+// build name of the result is the processed name of the build.
+func (t *Template) buildConfigTemplate(name string, components *ComponentFinder) (*ConfigTemplate, map[string]interface{}, error) {
+	builderConfig := t.Builders[name]
+
+	// Prepare the variable template processor, which is a bit unique
+	// because we don't allow user variable usage and we add a function
+	// to read from the environment.
+	varTpl, err := NewConfigTemplate()
+	if err != nil {
+		return nil, nil, err
+	}
+	varTpl.BuildType = builderConfig.Type
+	varTpl.TemplateDir = t.templateDir()
+	varTpl.Funcs(template.FuncMap{
+		"env":      templateEnv,
+		"secret":   templateSecret(components.Secret),
+		"user":     templateDisableUser,
+		"userlist": templateDisableUser,
+		"usermap":  templateDisableUser,
+	})
+
+	// Prepare the variables
+	var varErrors []error
+	variables := make(map[string]string)
+	typedVariables := make(map[string]interface{})
+	for k, v := range t.Variables {
+		if v.Required && !v.HasValue {
+			varErrors = append(varErrors,
+				fmt.Errorf("Required user variable '%s' not set", k))
+			continue
+		}
+
+		var val string
+		if v.HasValue {
+			val = v.Value
+		} else {
+			val, err = varTpl.Process(v.Default, nil)
+			if err != nil {
+				varErrors = append(varErrors,
+					fmt.Errorf("Error processing user variable '%s': %s'", k, err))
+				continue
+			}
+		}
+
+		if v.Sensitive {
+			addSensitiveVariable(val)
+		}
+
+		typed, err := v.Parse(val)
+		if err != nil {
+			varErrors = append(varErrors,
+				fmt.Errorf("Invalid value for user variable '%s': %s", k, err))
+			continue
+		}
+
+		variables[k] = val
+		switch v.Type {
+		case VariableTypeList, VariableTypeMap:
+			typedVariables[k] = typed
+		case VariableTypeNumber, VariableTypeBool:
+			// Components decode their configuration with weak typing,
+			// so the value as it was given works for number and bool
+			// settings, and isn't changed for strings, such as "1.10".
+			typedVariables[k] = val
+		}
+	}
+
+	if len(varErrors) > 0 {
+		return nil, nil, &MultiError{varErrors}
+	}
+
+	// Process the name
+	tpl, err := NewConfigTemplate()
+	if err != nil {
+		return nil, nil, err
+	}
+	tpl.UserVars = variables
+	tpl.BuildType = builderConfig.Type
+	tpl.TemplateDir = t.templateDir()
+
+	tpl.BuildName, err = tpl.Process(name, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tpl, typedVariables, nil
+}
+
+// templateDir returns the directory of the template file, or the empty
+// string if the template wasn't read from a file.
+func (t *Template) templateDir() string {
+	if t.path == "" {
+		return ""
+	}
+
+	return filepath.Dir(t.path)
+}
+
 // TemplateOnlyExcept contains the logic required for "only" and "except"
-// meta-parameters.
+// meta-parameters, as well as the "only_if" and "skip_if" expressions.
 type TemplateOnlyExcept struct {
 	Only   []string
 	Except []string
+	OnlyIf string `mapstructure:"only_if"`
+	SkipIf string `mapstructure:"skip_if"`
 }
 
 // Prune will prune out the used values from the raw map.
 func (t *TemplateOnlyExcept) Prune(raw map[string]interface{}) {
 	delete(raw, "except")
 	delete(raw, "only")
+	delete(raw, "only_if")
+	delete(raw, "skip_if")
 }
 
 // Skip tests if we should skip putting this item onto a build.
@@ -652,6 +1137,42 @@ func (t *TemplateOnlyExcept) Skip(name string) bool {
 	return false
 }
 
+// SkipBuild tests if we should skip putting this item onto the build
+// that the template processor is for. This checks the build name like
+// Skip, and then evaluates the only_if or skip_if expression with the
+// template processor, which must result in "true" or "false".
+func (t *TemplateOnlyExcept) SkipBuild(tpl *ConfigTemplate) (bool, error) {
+	if t.Skip(tpl.BuildName) {
+		return true, nil
+	}
+
+	if t.OnlyIf != "" {
+		result, err := evalCondition(tpl, "only_if", t.OnlyIf)
+		return !result, err
+	}
+
+	if t.SkipIf != "" {
+		return evalCondition(tpl, "skip_if", t.SkipIf)
+	}
+
+	return false, nil
+}
+
+func evalCondition(tpl *ConfigTemplate, key string, expr string) (bool, error) {
+	result, err := tpl.Process(expr, nil)
+	if err != nil {
+		return false, fmt.Errorf("Error processing %s: %s", key, err)
+	}
+
+	b, err := strconv.ParseBool(strings.TrimSpace(result))
+	if err != nil {
+		return false, fmt.Errorf(
+			"%s must result in true or false, got: %s", key, result)
+	}
+
+	return b, nil
+}
+
 // Validates the only/except parameters.
 func (t *TemplateOnlyExcept) Validate(b map[string]RawBuilderConfig) (e []error) {
 	if len(t.Only) > 0 && len(t.Except) > 0 {
@@ -675,5 +1196,33 @@ func (t *TemplateOnlyExcept) Validate(b map[string]RawBuilderConfig) (e []error)
 		}
 	}
 
+	if t.OnlyIf != "" && t.SkipIf != "" {
+		e = append(e,
+			fmt.Errorf("Only one of 'only_if' or 'skip_if' may be specified."))
+	}
+
+	conditions := []struct {
+		key  string
+		expr string
+	}{
+		{"only_if", t.OnlyIf},
+		{"skip_if", t.SkipIf},
+	}
+	for _, c := range conditions {
+		if c.expr == "" {
+			continue
+		}
+
+		tpl, err := NewConfigTemplate()
+		if err != nil {
+			e = append(e, err)
+			continue
+		}
+
+		if err := tpl.Validate(c.expr); err != nil {
+			e = append(e, fmt.Errorf("'%s' is invalid: %s", c.key, err))
+		}
+	}
+
 	return
 }
@@ -1,10 +1,15 @@
 package packer
 
 import (
+	"encoding/json"
+	"errors"
+	"github.com/mitchellh/mapstructure"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 )
@@ -60,6 +65,38 @@ func TestParseTemplateFile_basic(t *testing.T) {
 	}
 }
 
+func TestParseTemplateFileOptionalVars(t *testing.T) {
+	data := `
+	{
+		"variables": {"a": "default", "b": "default", "c": "default"},
+		"builders": [{"type": "something"}]
+	}
+	`
+
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write([]byte(data))
+	tf.Close()
+
+	vars := map[string]string{"a": "var"}
+	optional := map[string]string{"a": "optional", "b": "optional", "unknown": "x"}
+	result, err := ParseTemplateFileOptionalVars(tf.Name(), vars, optional)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := map[string]string{"a": "var", "b": "optional", "c": ""}
+	for k, v := range expected {
+		variable := result.Variables[k]
+		if variable.Value != v || variable.HasValue != (v != "") {
+			t.Fatalf("bad: %s: %#v", k, variable)
+		}
+	}
+}
+
 func TestParseTemplateFile_stdin(t *testing.T) {
 	data := `
 	{
@@ -93,6 +130,169 @@ func TestParseTemplateFile_stdin(t *testing.T) {
 	}
 }
 
+func TestParseTemplateFile_include(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	files := map[string]string{
+		"main.json": `{
+			"include": ["common/*.json"],
+			"variables": {"region": "us-west-2"},
+			"builders": [{"type": "main-builder"}],
+			"provisioners": [{"type": "main-prov"}]
+		}`,
+		"common/a.json": `{
+			"include": ["nested/c.json"],
+			"variables": {"region": "us-east-1", "size": "small"},
+			"provisioners": [{"type": "a-prov"}],
+			"post-processors": ["a-pp"]
+		}`,
+		"common/b.json": `{
+			"variables": {"size": "large"},
+			"builders": [{"type": "b-builder"}]
+		}`,
+		"common/nested/c.json": `{
+			"provisioners": [{"type": "c-prov"}]
+		}`,
+	}
+
+	for name, contents := range files {
+		path := filepath.Join(td, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	result, err := ParseTemplateFile(filepath.Join(td, "main.json"), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(result.Builders) != 2 {
+		t.Fatalf("bad: %#v", result.Builders)
+	}
+
+	provisioners := make([]string, len(result.Provisioners))
+	for i, p := range result.Provisioners {
+		provisioners[i] = p.Type
+	}
+
+	expected := []string{"c-prov", "a-prov", "main-prov"}
+	if !reflect.DeepEqual(provisioners, expected) {
+		t.Fatalf("bad: %#v", provisioners)
+	}
+
+	if len(result.PostProcessors) != 1 || result.PostProcessors[0][0].Type != "a-pp" {
+		t.Fatalf("bad: %#v", result.PostProcessors)
+	}
+
+	if result.Variables["region"].Default != "us-west-2" {
+		t.Fatalf("bad: %#v", result.Variables["region"])
+	}
+
+	if result.Variables["size"].Default != "large" {
+		t.Fatalf("bad: %#v", result.Variables["size"])
+	}
+}
+
+func TestParseTemplateFile_includeAbsolute(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	common := filepath.Join(td, "shared", "common.json")
+	os.MkdirAll(filepath.Dir(common), 0755)
+	if err := ioutil.WriteFile(common, []byte(`{"builders": [{"type": "common"}]}`), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := json.Marshal(map[string]interface{}{
+		"include": []string{common},
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	main := filepath.Join(td, "templates", "main.json")
+	os.MkdirAll(filepath.Dir(main), 0755)
+	if err := ioutil.WriteFile(main, data, 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	result, err := ParseTemplateFile(main, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, ok := result.Builders["common"]; !ok {
+		t.Fatalf("bad: %#v", result.Builders)
+	}
+
+	// Templates that aren't read from a file include absolute paths too
+	result, err = ParseTemplate(data, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if _, ok := result.Builders["common"]; !ok {
+		t.Fatalf("bad: %#v", result.Builders)
+	}
+}
+
+func TestParseTemplateFile_includeErrors(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	cases := []struct {
+		Main     string
+		Included string
+		Expected string
+	}{
+		{
+			`{"include": ["inc.json"], "builders": [{"type": "foo"}]}`,
+			`{"builders": [{"type": "bar"}, {"type": "foo"}]}`,
+			"builders[0]: builder with name 'foo' already exists, defined at " +
+				filepath.Join(td, "inc.json") + ":1:32",
+		},
+		{
+			`{"include": ["inc.json"], "builders": [{"type": "foo"}]}`,
+			`{"provisioners": [{}]}`,
+			filepath.Join(td, "inc.json") + ":1:19: provisioners[0]: missing 'type'",
+		},
+		{
+			`{"include": ["inc.json"], "builders": [{"type": "foo"}]}`,
+			`{"include": ["main.json"]}`,
+			filepath.Join(td, "main.json") + ": include cycle",
+		},
+		{
+			`{"include": ["missing.json"], "builders": [{"type": "foo"}]}`,
+			`{}`,
+			"missing.json",
+		},
+	}
+
+	for _, tc := range cases {
+		ioutil.WriteFile(filepath.Join(td, "main.json"), []byte(tc.Main), 0644)
+		ioutil.WriteFile(filepath.Join(td, "inc.json"), []byte(tc.Included), 0644)
+
+		_, err := ParseTemplateFile(filepath.Join(td, "main.json"), nil)
+		if err == nil {
+			t.Fatalf("should have error: %s", tc.Included)
+		}
+
+		if !strings.Contains(err.Error(), tc.Expected) {
+			t.Fatalf("bad: %s", err)
+		}
+	}
+}
+
 func TestParseTemplate_Basic(t *testing.T) {
 	data := `
 	{
@@ -132,6 +332,61 @@ func TestParseTemplate_Description(t *testing.T) {
 	}
 }
 
+func TestParseTemplate_YAML(t *testing.T) {
+	data := `
+# A comment
+variables:
+  foo: bar
+builders:
+  - type: something
+provisioners:
+  - type: shell
+    inline:
+      - |
+        echo one
+        echo two
+`
+
+	result, err := ParseTemplate([]byte(data), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if len(result.Builders) != 1 {
+		t.Fatalf("bad: %#v", result.Builders)
+	}
+
+	if result.Variables["foo"].Default != "bar" {
+		t.Fatalf("bad: %#v", result.Variables)
+	}
+
+	config := result.Provisioners[0].RawConfig.(map[string]interface{})
+	inline := config["inline"].([]interface{})
+	if len(inline) != 1 || inline[0] != "echo one\necho two\n" {
+		t.Fatalf("bad: %#v", inline)
+	}
+}
+
+func TestParseTemplateFile_YAMLError(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.Remove(tf.Name())
+	tf.Write([]byte("builders:\n  - type: foo\n    type: bar\n"))
+	tf.Close()
+
+	// The name doesn't have a YAML extension, so the contents decide
+	_, err = ParseTemplateFile(tf.Name(), nil)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if !strings.Contains(err.Error(), "line 3, char 5") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
 func TestParseTemplate_Invalid(t *testing.T) {
 	// Note there is an extra comma below for a purposeful
 	// syntax error in the JSON.
@@ -478,6 +733,84 @@ func TestParseTemplate_ProvisionerPauseBefore(t *testing.T) {
 	}
 }
 
+func TestParseTemplate_ProvisionerErrorPolicy(t *testing.T) {
+	data := `
+	{
+		"builders": [{"type": "foo"}],
+
+		"provisioners": [
+			{
+				"type": "shell",
+				"max_retries": 3,
+				"retry_backoff": "2s",
+				"timeout": "10m",
+				"error_cleanup": {"type": "shell-local"}
+			},
+			{
+				"type": "shell",
+				"max_retries": 1
+			}
+		]
+	}
+	`
+
+	result, err := ParseTemplate([]byte(data), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	raw := result.Provisioners[0]
+	if raw.MaxRetries != 3 || raw.retryBackoff != 2*time.Second {
+		t.Fatalf("bad: %#v", raw)
+	}
+	if raw.timeout != 10*time.Minute {
+		t.Fatalf("bad: %s", raw.timeout)
+	}
+	if raw.OnError != ProvisionerOnErrorCleanup {
+		t.Fatalf("bad: %s", raw.OnError)
+	}
+
+	config := raw.RawConfig.(map[string]interface{})
+	for _, k := range []string{"max_retries", "retry_backoff", "timeout", "error_cleanup"} {
+		if _, ok := config[k]; ok {
+			t.Fatalf("%s should be removed", k)
+		}
+	}
+
+	raw = result.Provisioners[1]
+	if raw.retryBackoff != defaultRetryBackoff {
+		t.Fatalf("bad: %s", raw.retryBackoff)
+	}
+	if raw.OnError != ProvisionerOnErrorAbort {
+		t.Fatalf("bad: %s", raw.OnError)
+	}
+}
+
+func TestParseTemplate_ProvisionerErrorPolicyInvalid(t *testing.T) {
+	cases := []string{
+		`"max_retries": -1`,
+		`"retry_backoff": "bad"`,
+		`"timeout": "bad"`,
+		`"on_error": "explode"`,
+		`"on_error": "cleanup"`,
+		`"on_error": "continue", "error_cleanup": {"type": "shell"}`,
+		`"error_cleanup": {"inline": ["rm -rf /tmp/x"]}`,
+	}
+
+	for _, tc := range cases {
+		data := `
+		{
+			"builders": [{"type": "foo"}],
+			"provisioners": [{"type": "shell", ` + tc + `}]
+		}
+		`
+
+		if _, err := ParseTemplate([]byte(data), nil); err == nil {
+			t.Fatalf("should have error: %s", tc)
+		}
+	}
+}
+
 func TestParseTemplate_Variables(t *testing.T) {
 	data := `
 	{
@@ -1354,6 +1687,212 @@ func TestTemplateBuild_onlyProv(t *testing.T) {
 	}
 }
 
+func TestTemplateBuild_onlyIfProv(t *testing.T) {
+	data := `
+	{
+		"variables": {
+			"env": "dev"
+		},
+
+		"builders": [
+			{
+				"name": "test1",
+				"type": "test-builder"
+			},
+			{
+				"name": "test2",
+				"type": "test-builder"
+			}
+		],
+
+		"provisioners": [
+			{
+				"type": "test-prov",
+				"only_if": "{{ne (user ` + "`env`" + `) ` + "`dev`" + `}}"
+			},
+			{
+				"type": "test-prov",
+				"skip_if": "{{eq build_name ` + "`test1`" + `}}"
+			}
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	cases := []struct {
+		name  string
+		vars  map[string]string
+		count int
+	}{
+		{"test1", nil, 0},
+		{"test2", nil, 1},
+		{"test1", map[string]string{"env": "prod"}, 1},
+		{"test2", map[string]string{"env": "prod"}, 2},
+	}
+
+	for _, tc := range cases {
+		template, err = ParseTemplate([]byte(data), tc.vars)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		build, err := template.Build(tc.name, testTemplateComponentFinder())
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		cbuild := build.(*coreBuild)
+		if len(cbuild.provisioners) != tc.count {
+			t.Fatalf("bad: %s %#v: %d", tc.name, tc.vars, len(cbuild.provisioners))
+		}
+	}
+}
+
+func TestTemplateBuild_skipIfPP(t *testing.T) {
+	data := `
+	{
+		"variables": {
+			"release": {"type": "bool", "default": false}
+		},
+
+		"builders": [{"type": "test-builder"}],
+
+		"post-processors": [
+			{
+				"type": "test-pp",
+				"skip_if": "{{user ` + "`release`" + `}}"
+			},
+			{
+				"type": "test-pp",
+				"only_if": "{{user ` + "`release`" + `}}"
+			}
+		]
+	}
+	`
+
+	for _, release := range []string{"false", "true"} {
+		template, err := ParseTemplate(
+			[]byte(data), map[string]string{"release": release})
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		build, err := template.Build("test-builder", testTemplateComponentFinder())
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		cbuild := build.(*coreBuild)
+		if len(cbuild.postProcessors) != 1 {
+			t.Fatalf("bad: %s: %#v", release, cbuild.postProcessors)
+		}
+
+		expected := "post-processors[0]"
+		if release == "true" {
+			expected = "post-processors[1]"
+		}
+
+		if cbuild.postProcessorLocations[0][0].Path != expected {
+			t.Fatalf("bad: %s: %#v", release, cbuild.postProcessorLocations)
+		}
+	}
+}
+
+func TestTemplateBuild_onlyIfInvalid(t *testing.T) {
+	cases := []string{
+		`"only_if": "{{eq", "type": "test-prov"`,
+		`"only_if": "true", "skip_if": "false", "type": "test-prov"`,
+	}
+
+	for _, tc := range cases {
+		data := `
+		{
+			"builders": [{"type": "test-builder"}],
+			"provisioners": [{` + tc + `}]
+		}
+		`
+
+		if _, err := ParseTemplate([]byte(data), nil); err == nil {
+			t.Fatalf("should have error: %s", tc)
+		}
+	}
+
+	// Expressions must result in true or false
+	data := `
+	{
+		"builders": [{"type": "test-builder"}],
+		"provisioners": [{"type": "test-prov", "only_if": "{{build_type}}"}]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	_, err = template.Build("test-builder", testTemplateComponentFinder())
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if !strings.Contains(err.Error(), "only_if must result in true or false") {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestTemplateBuildSkips(t *testing.T) {
+	data := `
+	{
+		"builders": [
+			{
+				"name": "test1",
+				"type": "test-builder"
+			},
+			{
+				"name": "test2",
+				"type": "test-builder"
+			}
+		],
+
+		"provisioners": [
+			{"type": "test-prov"},
+			{"type": "test-prov", "only": ["test2"]},
+			{"type": "test-prov", "skip_if": "{{eq build_name ` + "`test2`" + `}}"}
+		],
+
+		"post-processors": [
+			[{"type": "test-pp"}, {"type": "test-pp", "except": ["test1"]}]
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	skips, err := template.BuildSkips("test1", testTemplateComponentFinder())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected := &BuildSkips{
+		Provisioners:   []bool{false, true, false},
+		PostProcessors: [][]bool{[]bool{false, true}},
+	}
+	if !reflect.DeepEqual(skips, expected) {
+		t.Fatalf("bad: %#v", skips)
+	}
+
+	if _, err := template.BuildSkips("test5", testTemplateComponentFinder()); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
 func TestTemplate_Build_ProvisionerOverride(t *testing.T) {
 	data := `
 	{
@@ -1527,6 +2066,80 @@ func TestTemplateBuild_ProvisionerPauseBefore(t *testing.T) {
 	}
 }
 
+func TestTemplateBuild_ProvisionerErrorPolicy(t *testing.T) {
+	data := `
+	{
+		"builders": [{"type": "test-builder"}],
+
+		"provisioners": [
+			{
+				"type": "test-prov",
+				"max_retries": 2,
+				"timeout": "1m",
+				"pause_before": "5s",
+				"error_cleanup": {"type": "test-prov", "cleanup": true}
+			},
+			{
+				"type": "test-prov",
+				"on_error": "continue"
+			}
+		]
+	}
+	`
+
+	template, err := ParseTemplate([]byte(data), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	build, err := template.Build("test-builder", testTemplateComponentFinder())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	coreBuild := build.(*coreBuild)
+	if len(coreBuild.provisioners) != 2 {
+		t.Fatalf("bad: %#v", coreBuild.provisioners)
+	}
+
+	// The wrappers are, from the outside in: pause, error policy,
+	// timeout and retries.
+	paused := coreBuild.provisioners[0].provisioner.(*PausedProvisioner)
+	onError := paused.Provisioner.(*OnErrorProvisioner)
+	if onError.OnError != ProvisionerOnErrorCleanup || onError.Cleanup == nil {
+		t.Fatalf("bad: %#v", onError)
+	}
+
+	timeout := onError.Provisioner.(*TimeoutProvisioner)
+	if timeout.Timeout != time.Minute {
+		t.Fatalf("bad: %s", timeout.Timeout)
+	}
+
+	retried := timeout.Provisioner.(*RetriedProvisioner)
+	if retried.MaxRetries != 2 || retried.RetryBackoff != defaultRetryBackoff {
+		t.Fatalf("bad: %#v", retried)
+	}
+
+	cleanup := coreBuild.provisioners[0].cleanup
+	if cleanup == nil || cleanup.provisioner != onError.Cleanup {
+		t.Fatalf("bad: %#v", cleanup)
+	}
+
+	cleanupConfig := cleanup.config[0].(map[string]interface{})
+	if cleanupConfig["cleanup"] != true {
+		t.Fatalf("bad: %#v", cleanupConfig)
+	}
+
+	onError = coreBuild.provisioners[1].provisioner.(*OnErrorProvisioner)
+	if onError.OnError != ProvisionerOnErrorContinue {
+		t.Fatalf("bad: %#v", onError)
+	}
+
+	if _, ok := onError.Provisioner.(*MockProvisioner); !ok {
+		t.Fatalf("bad: %#v", onError.Provisioner)
+	}
+}
+
 func TestTemplateBuild_variables(t *testing.T) {
 	data := `
 	{
@@ -1590,3 +2203,294 @@ func TestTemplateBuild_variablesRequiredNotSet(t *testing.T) {
 		t.Fatal("should error")
 	}
 }
+
+func TestTemplateBuild_prepareErrorLocation(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "template.json")
+	data := `{
+  "builders": [
+    {
+      "type": "test-builder",
+      "iso_url": "foo"
+    }
+  ]
+}`
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	template, err := ParseTemplateFile(path, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	builder := &MockBuilder{
+		PrepareErr: &MultiError{
+			Errors: []error{errors.New("iso_url is not a valid URL")},
+		},
+	}
+
+	components := &ComponentFinder{
+		Builder: func(string) (Builder, error) { return builder, nil },
+	}
+
+	build, err := template.Build("test-builder", components)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	_, err = build.Prepare()
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	expected := path + ":5:7: builders[0].iso_url: iso_url is not a valid URL"
+	if !strings.Contains(err.Error(), expected) {
+		t.Fatalf("bad: %s", err)
+	}
+}
+
+func TestParseTemplate_variablesTyped(t *testing.T) {
+	data := `
+	{
+		"variables": {
+			"tags": {"type": "map", "default": {"Name": "web"}},
+			"size": {"type": "number", "default": 10, "min": 1, "max": 100},
+			"version": {"type": "number", "default": 1},
+			"debug": {"type": "bool", "default": false},
+			"env": {"allowed": ["dev", "prod"], "default": "dev"},
+			"packages": ["git"]
+		},
+
+		"builders": [{
+			"type": "test-builder",
+			"tags": "{{user ` + "`tags`" + `}}",
+			"disk_size": "{{user ` + "`size`" + `}}",
+			"image_version": "{{user ` + "`version`" + `}}",
+			"debug": "{{user ` + "`debug`" + `}}"
+		}]
+	}
+	`
+
+	vars := map[string]string{"size": "20", "version": "1.10", "debug": "true"}
+	tpl, err := ParseTemplate([]byte(data), vars)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if tpl.Variables["tags"].Type != VariableTypeMap {
+		t.Fatalf("bad: %#v", tpl.Variables["tags"])
+	}
+
+	if tpl.Variables["packages"].Default != `["git"]` {
+		t.Fatalf("bad: %#v", tpl.Variables["packages"])
+	}
+
+	builder := new(MockBuilder)
+	components := &ComponentFinder{
+		Builder: func(string) (Builder, error) { return builder, nil },
+	}
+
+	build, err := tpl.Build("test-builder", components)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := build.Prepare(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config := builder.PrepareConfig[0].(map[string]interface{})
+	expected := map[string]interface{}{"Name": "web"}
+	if !reflect.DeepEqual(config["tags"], expected) {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	// Numbers and bools are given as they were set, so that a number
+	// used for a string setting isn't reformatted
+	if config["disk_size"] != "20" || config["image_version"] != "1.10" ||
+		config["debug"] != "true" {
+		t.Fatalf("bad: %#v", config)
+	}
+
+	var decoded struct {
+		DiskSize     int    `mapstructure:"disk_size"`
+		ImageVersion string `mapstructure:"image_version"`
+		Debug        bool
+	}
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           &decoded,
+		WeaklyTypedInput: true,
+	})
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if err := decoder.Decode(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if decoded.DiskSize != 20 || decoded.ImageVersion != "1.10" || !decoded.Debug {
+		t.Fatalf("bad: %#v", decoded)
+	}
+
+	cases := []map[string]string{
+		{"size": "200"},
+		{"size": "big"},
+		{"env": "test"},
+		{"tags": "[]"},
+	}
+
+	for _, vars := range cases {
+		_, err := ParseTemplate([]byte(data), vars)
+		if err == nil {
+			t.Fatalf("should have error: %#v", vars)
+		}
+
+		if !strings.Contains(err.Error(), "variables.") {
+			t.Fatalf("error should have location: %s", err)
+		}
+	}
+}
+
+func TestTemplateBuild_variablesSensitive(t *testing.T) {
+	data := `
+	{
+		"variables": {
+			"password": {"sensitive": true, "default": "template-default-secret"},
+			"keys": {"type": "list", "sensitive": true},
+			"user": "template-not-secret"
+		},
+
+		"builders": [{"type": "test-builder"}]
+	}
+	`
+
+	vars := map[string]string{"keys": `["template-list-secret"]`}
+	tpl, err := ParseTemplate([]byte(data), vars)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if !tpl.Variables["password"].Sensitive {
+		t.Fatalf("bad: %#v", tpl.Variables["password"])
+	}
+
+	if _, err := tpl.Build("test-builder", testComponentFinder()); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	input := "template-default-secret template-list-secret template-not-secret"
+	expected := "<sensitive> <sensitive> template-not-secret"
+	if actual := Redact(input); actual != expected {
+		t.Fatalf("bad: %s", actual)
+	}
+}
+
+func TestTemplateBuild_templatePath(t *testing.T) {
+	data := `
+	{
+		"variables": {
+			"dir": "{{template_dir}}",
+			"type": "{{build_type}}"
+		},
+
+		"builders": [{"type": "test-builder"}]
+	}
+	`
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	path := filepath.Join(td, "template.json")
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	tpl, err := ParseTemplateFile(path, nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	b, err := tpl.Build("test-builder", testComponentFinder())
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	coreBuild := b.(*coreBuild)
+	if coreBuild.templatePath != path {
+		t.Fatalf("bad: %s", coreBuild.templatePath)
+	}
+
+	if coreBuild.variables["dir"] != td {
+		t.Fatalf("bad: %#v", coreBuild.variables)
+	}
+
+	if coreBuild.variables["type"] != "test-builder" {
+		t.Fatalf("bad: %#v", coreBuild.variables)
+	}
+
+	// Templates that aren't read from a file have no directory
+	tpl, err = ParseTemplate([]byte(data), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if _, err := tpl.Build("test-builder", testComponentFinder()); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestTemplateBuild_variablesSecret(t *testing.T) {
+	data := `
+	{
+		"variables": {
+			"password": "{{secret ` + "`db/password`" + `}}",
+			"url": "postgres://admin:{{secret ` + "`db/password`" + `}}@db"
+		},
+
+		"builders": [{"type": "test-builder"}]
+	}
+	`
+
+	tpl, err := ParseTemplate([]byte(data), nil)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	var paths []string
+	components := testComponentFinder()
+	components.Secret = func(path string) (string, error) {
+		paths = append(paths, path)
+		return "template-secret-value", nil
+	}
+
+	b, err := tpl.Build("test-builder", components)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	variables := b.(*coreBuild).variables
+	if variables["url"] != "postgres://admin:template-secret-value@db" {
+		t.Fatalf("bad: %#v", variables)
+	}
+
+	if len(paths) != 2 || paths[0] != "db/password" {
+		t.Fatalf("bad: %#v", paths)
+	}
+
+	if Redact(variables["url"]) != "postgres://admin:<sensitive>@db" {
+		t.Fatalf("secret should be sensitive: %s", Redact(variables["url"]))
+	}
+
+	// Without a secret function
+	if _, err := tpl.Build("test-builder", testComponentFinder()); err == nil {
+		t.Fatal("should have error")
+	}
+}
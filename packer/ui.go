@@ -2,6 +2,7 @@ package packer
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -28,13 +29,15 @@ const (
 
 // The Ui interface handles all communication for Packer with the outside
 // world. This sort of control allows us to strictly control how output
-// is formatted and various levels of output.
+// is formatted and various levels of output. Events in the lifecycle of
+// a build are sent with Event, which most UIs only log.
 type Ui interface {
 	Ask(string) (string, error)
 	Say(string)
 	Message(string)
 	Error(string)
 	Machine(string, ...string)
+	Event(*Event)
 }
 
 // ColoredUi is a UI that is colored using terminal colors.
@@ -70,6 +73,13 @@ type MachineReadableUi struct {
 	Writer io.Writer
 }
 
+// JsonUi is a UI that outputs everything, including events, to the given
+// Writer as JSON objects, one per line.
+type JsonUi struct {
+	Writer io.Writer
+	l      sync.Mutex
+}
+
 func (u *ColoredUi) Ask(query string) (string, error) {
 	return u.Ui.Ask(u.colorize(query, u.Color, true))
 }
@@ -96,6 +106,10 @@ func (u *ColoredUi) Machine(t string, args ...string) {
 	u.Ui.Machine(t, args...)
 }
 
+func (u *ColoredUi) Event(e *Event) {
+	u.Ui.Event(e)
+}
+
 func (u *ColoredUi) colorize(message string, color UiColor, bold bool) string {
 	if !u.supportsColors() {
 		return message
@@ -149,6 +163,17 @@ func (u *TargettedUi) Machine(t string, args ...string) {
 	u.Ui.Machine(fmt.Sprintf("%s,%s", u.Target, t), args...)
 }
 
+func (u *TargettedUi) Event(e *Event) {
+	// Set the build without changing the event of the caller
+	if e.Build == "" {
+		targetted := *e
+		targetted.Build = u.Target
+		e = &targetted
+	}
+
+	u.Ui.Event(e)
+}
+
 func (u *TargettedUi) prefixLines(arrow bool, message string) string {
 	arrowText := "==>"
 	if !arrow {
@@ -157,7 +182,9 @@ func (u *TargettedUi) prefixLines(arrow bool, message string) string {
 
 	var result bytes.Buffer
 
-	for _, line := range strings.Split(message, "\n") {
+	// Redact before splitting so that sensitive values that span lines,
+	// such as private keys, are still found.
+	for _, line := range strings.Split(Redact(message), "\n") {
 		result.WriteString(fmt.Sprintf("%s %s: %s\n", arrowText, u.Target, line))
 	}
 
@@ -176,6 +203,7 @@ func (rw *BasicUi) Ask(query string) (string, error) {
 	signal.Notify(sigCh, os.Interrupt)
 	defer signal.Stop(sigCh)
 
+	query = Redact(query)
 	log.Printf("ui: ask: %s", query)
 	if query != "" {
 		if _, err := fmt.Fprint(rw.Writer, query+" "); err != nil {
@@ -212,6 +240,7 @@ func (rw *BasicUi) Say(message string) {
 	rw.l.Lock()
 	defer rw.l.Unlock()
 
+	message = Redact(message)
 	log.Printf("ui: %s", message)
 	_, err := fmt.Fprint(rw.Writer, message+"\n")
 	if err != nil {
@@ -223,6 +252,7 @@ func (rw *BasicUi) Message(message string) {
 	rw.l.Lock()
 	defer rw.l.Unlock()
 
+	message = Redact(message)
 	log.Printf("ui: %s", message)
 	_, err := fmt.Fprint(rw.Writer, message+"\n")
 	if err != nil {
@@ -234,6 +264,7 @@ func (rw *BasicUi) Error(message string) {
 	rw.l.Lock()
 	defer rw.l.Unlock()
 
+	message = Redact(message)
 	log.Printf("ui error: %s", message)
 	_, err := fmt.Fprint(rw.Writer, message+"\n")
 	if err != nil {
@@ -242,7 +273,11 @@ func (rw *BasicUi) Error(message string) {
 }
 
 func (rw *BasicUi) Machine(t string, args ...string) {
-	log.Printf("machine readable: %s %#v", t, args)
+	log.Printf("machine readable: %s %#v", t, RedactArgs(args))
+}
+
+func (rw *BasicUi) Event(e *Event) {
+	log.Printf("event: %s %s %s %s", e.Build, e.Type, e.Name, Redact(e.Error))
 }
 
 func (u *MachineReadableUi) Ask(query string) (string, error) {
@@ -273,7 +308,7 @@ func (u *MachineReadableUi) Machine(category string, args ...string) {
 	}
 
 	// Prepare the args
-	for i, v := range args {
+	for i, v := range RedactArgs(args) {
 		args[i] = strings.Replace(v, ",", "%!(PACKER_COMMA)", -1)
 		args[i] = strings.Replace(args[i], "\r", "\\r", -1)
 		args[i] = strings.Replace(args[i], "\n", "\\n", -1)
@@ -285,3 +320,103 @@ func (u *MachineReadableUi) Machine(category string, args ...string) {
 		panic(err)
 	}
 }
+
+func (u *MachineReadableUi) Event(e *Event) {
+	// Events are only output by the JSON UI
+	log.Printf("event: %s %s %s %s", e.Build, e.Type, e.Name, Redact(e.Error))
+}
+
+// The JSON objects output by JsonUi.
+type jsonUiArtifact struct {
+	BuilderId string   `json:"builder_id"`
+	Id        string   `json:"id"`
+	String    string   `json:"string"`
+	Files     []string `json:"files"`
+}
+
+type jsonUiLine struct {
+	Time     string          `json:"time"`
+	Type     string          `json:"type"`
+	Build    string          `json:"build,omitempty"`
+	Name     string          `json:"name,omitempty"`
+	Duration float64         `json:"duration,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	Artifact *jsonUiArtifact `json:"artifact,omitempty"`
+	Level    string          `json:"level,omitempty"`
+	Message  string          `json:"message,omitempty"`
+	Category string          `json:"category,omitempty"`
+	Args     []string        `json:"args,omitempty"`
+}
+
+func (u *JsonUi) Ask(query string) (string, error) {
+	return "", errors.New("machine-readable UI can't ask")
+}
+
+func (u *JsonUi) Say(message string) {
+	u.write(&jsonUiLine{Type: "ui", Level: "say", Message: Redact(message)})
+}
+
+func (u *JsonUi) Message(message string) {
+	u.write(&jsonUiLine{Type: "ui", Level: "message", Message: Redact(message)})
+}
+
+func (u *JsonUi) Error(message string) {
+	u.write(&jsonUiLine{Type: "ui", Level: "error", Message: Redact(message)})
+}
+
+func (u *JsonUi) Machine(category string, args ...string) {
+	// Determine if we have a target, and set it
+	target := ""
+	commaIdx := strings.Index(category, ",")
+	if commaIdx > -1 {
+		target = category[0:commaIdx]
+		category = category[commaIdx+1:]
+	}
+
+	u.write(&jsonUiLine{
+		Type:     "machine",
+		Build:    target,
+		Category: category,
+		Args:     RedactArgs(args),
+	})
+}
+
+func (u *JsonUi) Event(e *Event) {
+	line := &jsonUiLine{
+		Time:     e.Time.UTC().Format(time.RFC3339Nano),
+		Type:     string(e.Type),
+		Build:    e.Build,
+		Name:     e.Name,
+		Duration: e.Duration.Seconds(),
+		Error:    Redact(e.Error),
+	}
+
+	if e.Artifact != nil {
+		line.Artifact = &jsonUiArtifact{
+			BuilderId: e.Artifact.BuilderId,
+			Id:        Redact(e.Artifact.Id),
+			String:    Redact(e.Artifact.String),
+			Files:     e.Artifact.Files,
+		}
+	}
+
+	u.write(line)
+}
+
+func (u *JsonUi) write(line *jsonUiLine) {
+	if line.Time == "" {
+		line.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	data, err := json.Marshal(line)
+	if err != nil {
+		panic(err)
+	}
+
+	u.l.Lock()
+	defer u.l.Unlock()
+
+	if _, err := u.Writer.Write(append(data, '\n')); err != nil {
+		panic(err)
+	}
+}
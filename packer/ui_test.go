@@ -2,9 +2,12 @@ package packer
 
 import (
 	"bytes"
+	"encoding/json"
 	"os"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func testUi() *BasicUi {
@@ -215,6 +218,111 @@ func TestMachineReadableUi(t *testing.T) {
 	}
 }
 
+func TestTargettedUi_Event(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ui := &TargettedUi{Target: "foo", Ui: &JsonUi{Writer: buf}}
+
+	event := NewEvent(EventBuildStart, "foo")
+	ui.Event(event)
+	if event.Build != "" {
+		t.Fatalf("event should not be modified: %#v", event)
+	}
+
+	lines := testJsonUiLines(t, buf)
+	if len(lines) != 1 || lines[0]["build"] != "foo" {
+		t.Fatalf("bad: %#v", lines)
+	}
+}
+
+func TestJsonUi_ImplUi(t *testing.T) {
+	var raw interface{}
+	raw = &JsonUi{}
+	if _, ok := raw.(Ui); !ok {
+		t.Fatalf("JsonUi must implement Ui")
+	}
+}
+
+func TestJsonUi(t *testing.T) {
+	buf := new(bytes.Buffer)
+	ui := &JsonUi{Writer: buf}
+
+	ui.Say("foo")
+	ui.Error("bar")
+	ui.Machine("mitchellh,foo", "bar", "baz")
+
+	event := NewEndEvent(EventStepEnd, "StepFoo", time.Now().Add(-2*time.Second), nil)
+	event.Build = "mitchellh"
+	ui.Event(event)
+
+	event = NewEvent(EventArtifact, "bid")
+	event.Artifact = &ArtifactInfo{BuilderId: "bid", Id: "id", Files: []string{"a"}}
+	ui.Event(event)
+
+	lines := testJsonUiLines(t, buf)
+	if len(lines) != 5 {
+		t.Fatalf("bad: %#v", lines)
+	}
+
+	for _, line := range lines {
+		if _, err := time.Parse(time.RFC3339Nano, line["time"].(string)); err != nil {
+			t.Fatalf("bad time: %s", err)
+		}
+		delete(line, "time")
+	}
+
+	expected := []map[string]interface{}{
+		{"type": "ui", "level": "say", "message": "foo"},
+		{"type": "ui", "level": "error", "message": "bar"},
+		{"type": "machine", "build": "mitchellh", "category": "foo",
+			"args": []interface{}{"bar", "baz"}},
+	}
+	if !reflect.DeepEqual(lines[:3], expected) {
+		t.Fatalf("bad: %#v", lines[:3])
+	}
+
+	step := lines[3]
+	if step["type"] != "step-end" || step["build"] != "mitchellh" || step["name"] != "StepFoo" {
+		t.Fatalf("bad: %#v", step)
+	}
+	if d := step["duration"].(float64); d < 2 || d > 3 {
+		t.Fatalf("bad duration: %f", d)
+	}
+	if _, ok := step["error"]; ok {
+		t.Fatalf("bad: %#v", step)
+	}
+
+	artifact := lines[4]["artifact"].(map[string]interface{})
+	if artifact["id"] != "id" || !reflect.DeepEqual(artifact["files"], []interface{}{"a"}) {
+		t.Fatalf("bad: %#v", artifact)
+	}
+}
+
+func TestUi_sensitive(t *testing.T) {
+	AddSensitiveValue("ui-secret")
+	AddSensitiveValue("ui-multi\nline-secret")
+
+	bufferUi := testUi()
+	ui := &TargettedUi{"build", bufferUi}
+	ui.Say("password is ui-secret")
+	ui.Message("key:\nui-multi\nline-secret")
+	ui.Error("ui-secret")
+
+	expected := "==> build: password is <sensitive>\n" +
+		"    build: key:\n    build: <sensitive>\n" +
+		"==> build: <sensitive>\n"
+	if result := readWriter(bufferUi); result != expected {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	buf := new(bytes.Buffer)
+	machineUi := &MachineReadableUi{Writer: buf}
+	machineUi.Machine("foo", "ui-secret")
+	data := strings.SplitN(buf.String(), ",", 2)[1]
+	if data != ",foo,<sensitive>\n" {
+		t.Fatalf("bad: %#v", data)
+	}
+}
+
 // This reads the output from the bytes.Buffer in our test object
 // and then resets the buffer.
 func readWriter(ui *BasicUi) (result string) {
@@ -223,3 +331,22 @@ func readWriter(ui *BasicUi) (result string) {
 	buffer.Reset()
 	return
 }
+
+// testJsonUiLines decodes the lines written by a JsonUi.
+func testJsonUiLines(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var result []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("bad line %q: %s", line, err)
+		}
+
+		result = append(result, v)
+	}
+
+	return result
+}
@@ -0,0 +1,397 @@
+package packer
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The types that a user variable can have. Values of all types are given
+// as strings, with lists and maps written as JSON.
+const (
+	VariableTypeString = "string"
+	VariableTypeNumber = "number"
+	VariableTypeBool   = "bool"
+	VariableTypeList   = "list"
+	VariableTypeMap    = "map"
+)
+
+// rawVariableConfig is the long form of a variable in the "variables"
+// section of a template, which is used to give the variable a type or
+// validation rules.
+type rawVariableConfig struct {
+	Type        string
+	Default     interface{}
+	Description string
+	Required    bool
+	Sensitive   bool
+	Pattern     string
+	Allowed     []string
+	Min         string
+	Max         string
+}
+
+// decodeVariable decodes a variable from the "variables" section of a
+// template. The variable is either just its default value, or a map
+// with its type, default value and validation rules.
+func decodeVariable(raw interface{}) (result RawVariable, err error) {
+	result.Type = VariableTypeString
+
+	switch v := raw.(type) {
+	case nil:
+		result.Required = true
+		return
+	case []interface{}:
+		result.Type = VariableTypeList
+		result.Default, err = encodeVariableValue(v)
+		return
+	case map[string]interface{}:
+		return decodeVariableConfig(v)
+	}
+
+	// Create a new mapstructure decoder in order to decode the default
+	// value since this is the only value in the regular template that
+	// can be weakly typed.
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           &result.Default,
+		WeaklyTypedInput: true,
+	})
+	if err != nil {
+		// This should never happen.
+		panic(err)
+	}
+
+	err = decoder.Decode(raw)
+	return
+}
+
+func decodeVariableConfig(raw map[string]interface{}) (result RawVariable, err error) {
+	var config rawVariableConfig
+	var md mapstructure.Metadata
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Metadata:         &md,
+		Result:           &config,
+		WeaklyTypedInput: true,
+	})
+	if err != nil {
+		// This should never happen.
+		panic(err)
+	}
+
+	if err = decoder.Decode(raw); err != nil {
+		return
+	}
+
+	if len(md.Unused) > 0 {
+		sort.Strings(md.Unused)
+		err = fmt.Errorf("unknown keys: %s", strings.Join(md.Unused, ", "))
+		return
+	}
+
+	result.Type = config.Type
+	if result.Type == "" {
+		result.Type = VariableTypeString
+	}
+
+	switch result.Type {
+	case VariableTypeString, VariableTypeNumber, VariableTypeBool,
+		VariableTypeList, VariableTypeMap:
+	default:
+		err = fmt.Errorf("unknown type: %s", result.Type)
+		return
+	}
+
+	result.Description = config.Description
+	result.Sensitive = config.Sensitive
+	result.Pattern = config.Pattern
+	result.Allowed = config.Allowed
+
+	if config.Pattern != "" {
+		if _, err = regexp.Compile(config.Pattern); err != nil {
+			err = fmt.Errorf("bad pattern: %s", err)
+			return
+		}
+	}
+
+	bounds := []struct {
+		raw    string
+		result **float64
+	}{
+		{config.Min, &result.Min},
+		{config.Max, &result.Max},
+	}
+	for _, b := range bounds {
+		if b.raw == "" {
+			continue
+		}
+
+		f, perr := strconv.ParseFloat(b.raw, 64)
+		if perr != nil {
+			err = fmt.Errorf("bad min or max, must be a number: %s", b.raw)
+			return
+		}
+
+		*b.result = &f
+	}
+
+	// Variables without a default are required unless they say
+	// otherwise, in which case they default to the zero value.
+	defaultValue, ok := raw["default"]
+	if !ok {
+		result.Required = true
+		if _, ok := raw["required"]; ok {
+			result.Required = config.Required
+		}
+
+		if !result.Required {
+			result.Default = variableZeroValues[result.Type]
+		}
+
+		return
+	}
+
+	result.Required = config.Required
+	if defaultValue == nil {
+		result.Default = variableZeroValues[result.Type]
+	} else {
+		result.Default, err = encodeVariableValue(defaultValue)
+	}
+
+	return
+}
+
+// addSensitiveVariable marks the value of a sensitive variable as
+// sensitive, along with each string in it if it is a list or map, since
+// those can be used on their own.
+func addSensitiveVariable(value string) {
+	AddSensitiveValue(value)
+
+	var raw interface{}
+	if err := json.Unmarshal([]byte(value), &raw); err != nil {
+		return
+	}
+
+	var elements []interface{}
+	switch raw := raw.(type) {
+	case []interface{}:
+		elements = raw
+	case map[string]interface{}:
+		for _, v := range raw {
+			elements = append(elements, v)
+		}
+	}
+
+	for _, element := range elements {
+		if s, ok := element.(string); ok {
+			AddSensitiveValue(s)
+		}
+	}
+}
+
+var variableZeroValues = map[string]string{
+	VariableTypeString: "",
+	VariableTypeNumber: "0",
+	VariableTypeBool:   "false",
+	VariableTypeList:   "[]",
+	VariableTypeMap:    "{}",
+}
+
+// encodeVariableValue turns a value from a template or variable file into
+// the string form of a variable value.
+func encodeVariableValue(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// Parse parses a value for this variable into its type and checks it
+// against the validation rules of the variable. The result is a string,
+// float64, bool, []interface{} or map[string]interface{}.
+func (v *RawVariable) Parse(value string) (interface{}, error) {
+	var result interface{}
+	var err error
+	switch v.Type {
+	case VariableTypeNumber:
+		result, err = strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a number", value)
+		}
+	case VariableTypeBool:
+		result, err = strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a bool", value)
+		}
+	case VariableTypeList:
+		var list []interface{}
+		if err := json.Unmarshal([]byte(value), &list); err != nil {
+			return nil, fmt.Errorf("'%s' is not a JSON list", value)
+		}
+
+		result = list
+	case VariableTypeMap:
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &m); err != nil {
+			return nil, fmt.Errorf("'%s' is not a JSON map", value)
+		}
+
+		result = m
+	default:
+		result = value
+	}
+
+	if err := v.validate(result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// validate checks a parsed value against the validation rules. The
+// pattern and allowed values apply to each element of a list or map,
+// while min and max bound a number, or the length of anything else.
+func (v *RawVariable) validate(value interface{}) error {
+	var size float64
+	var elements []interface{}
+	switch value := value.(type) {
+	case float64:
+		size = value
+		elements = []interface{}{value}
+	case string:
+		size = float64(len(value))
+		elements = []interface{}{value}
+	case []interface{}:
+		size = float64(len(value))
+		elements = value
+	case map[string]interface{}:
+		size = float64(len(value))
+		keys := make([]string, 0, len(value))
+		for k := range value {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+		for _, k := range keys {
+			elements = append(elements, value[k])
+		}
+	case bool:
+		elements = []interface{}{value}
+	}
+
+	if v.Min != nil && size < *v.Min {
+		return fmt.Errorf("%s is less than the minimum of %s",
+			variableSizeName(value), formatVariableNumber(*v.Min))
+	}
+
+	if v.Max != nil && size > *v.Max {
+		return fmt.Errorf("%s is more than the maximum of %s",
+			variableSizeName(value), formatVariableNumber(*v.Max))
+	}
+
+	var pattern *regexp.Regexp
+	if v.Pattern != "" {
+		var err error
+		pattern, err = regexp.Compile("^(?:" + v.Pattern + ")$")
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, element := range elements {
+		s, err := encodeVariableValue(element)
+		if err != nil {
+			return err
+		}
+
+		if pattern != nil && !pattern.MatchString(s) {
+			return fmt.Errorf("'%s' doesn't match the pattern '%s'", s, v.Pattern)
+		}
+
+		if len(v.Allowed) == 0 {
+			continue
+		}
+
+		allowed := false
+		for _, a := range v.Allowed {
+			if a == s {
+				allowed = true
+				break
+			}
+		}
+
+		if !allowed {
+			return fmt.Errorf("'%s' must be one of: %s",
+				s, strings.Join(v.Allowed, ", "))
+		}
+	}
+
+	return nil
+}
+
+func variableSizeName(value interface{}) string {
+	switch value := value.(type) {
+	case float64:
+		return formatVariableNumber(value)
+	case string:
+		return "length"
+	}
+
+	return "number of elements"
+}
+
+func formatVariableNumber(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// typedVariableRe matches a string that is nothing but a use of a single
+// user variable, such as "{{user `tags`}}".
+var typedVariableRe = regexp.MustCompile(
+	"^\\{\\{\\s*user\\s+(?:`([^`]*)`|\"([^\"]*)\")\\s*\\}\\}$")
+
+// interpolateTypedVariables returns a copy of a raw configuration where
+// every string that is just the use of one of the variables in values,
+// such as "{{user `tags`}}", is replaced by its value. This lets lists and
+// maps be used for configuration that expects them. Numbers and bools are
+// given as the strings they were set to, so that their use in string
+// settings doesn't depend on how they would be formatted. Other uses of
+// the variables are left to the components.
+func interpolateTypedVariables(raw interface{}, values map[string]interface{}) interface{} {
+	switch raw := raw.(type) {
+	case string:
+		match := typedVariableRe.FindStringSubmatch(raw)
+		if match == nil {
+			return raw
+		}
+
+		name := match[1] + match[2]
+		if value, ok := values[name]; ok {
+			return value
+		}
+	case []interface{}:
+		result := make([]interface{}, len(raw))
+		for i, v := range raw {
+			result[i] = interpolateTypedVariables(v, values)
+		}
+
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(raw))
+		for k, v := range raw {
+			result[k] = interpolateTypedVariables(v, values)
+		}
+
+		return result
+	}
+
+	return raw
+}
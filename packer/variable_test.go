@@ -0,0 +1,154 @@
+package packer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeVariable(t *testing.T) {
+	cases := []struct {
+		Raw      interface{}
+		Type     string
+		Default  string
+		Required bool
+	}{
+		{nil, "string", "", true},
+		{"foo", "string", "foo", false},
+		{float64(42), "string", "42", false},
+		{[]interface{}{"a", "b"}, "list", `["a","b"]`, false},
+		{map[string]interface{}{"type": "number", "default": float64(3)}, "number", "3", false},
+		{map[string]interface{}{"type": "map"}, "map", "", true},
+		{map[string]interface{}{"type": "map", "required": false}, "map", "{}", false},
+		{map[string]interface{}{"type": "bool", "default": nil}, "bool", "false", false},
+		{map[string]interface{}{"default": "foo", "required": true}, "string", "foo", true},
+	}
+
+	for _, tc := range cases {
+		v, err := decodeVariable(tc.Raw)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if v.Type != tc.Type || v.Default != tc.Default || v.Required != tc.Required {
+			t.Fatalf("bad: %#v\n\n%#v", tc.Raw, v)
+		}
+	}
+}
+
+func TestDecodeVariable_errors(t *testing.T) {
+	cases := []map[string]interface{}{
+		{"type": "foo"},
+		{"pattern": "("},
+		{"min": "a"},
+		{"defualt": "a"},
+	}
+
+	for _, tc := range cases {
+		if _, err := decodeVariable(tc); err == nil {
+			t.Fatalf("should have error: %#v", tc)
+		}
+	}
+}
+
+func TestRawVariableParse(t *testing.T) {
+	cases := []struct {
+		Type     string
+		Value    string
+		Expected interface{}
+	}{
+		{"string", "foo", "foo"},
+		{"number", " 4.5", 4.5},
+		{"bool", "true", true},
+		{"list", `["a", 1]`, []interface{}{"a", float64(1)}},
+		{"map", `{"a": "b"}`, map[string]interface{}{"a": "b"}},
+	}
+
+	for _, tc := range cases {
+		v := &RawVariable{Type: tc.Type}
+		actual, err := v.Parse(tc.Value)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if !reflect.DeepEqual(actual, tc.Expected) {
+			t.Fatalf("bad: %s %#v", tc.Type, actual)
+		}
+	}
+
+	for _, typ := range []string{"number", "bool", "list", "map"} {
+		v := &RawVariable{Type: typ}
+		if _, err := v.Parse("foo"); err == nil {
+			t.Fatalf("should have error: %s", typ)
+		}
+	}
+}
+
+func TestRawVariableParse_validation(t *testing.T) {
+	one, three := float64(1), float64(3)
+
+	cases := []struct {
+		Variable RawVariable
+		Value    string
+		Err      string
+	}{
+		{RawVariable{Type: "string", Pattern: "[a-z]+"}, "abc", ""},
+		{RawVariable{Type: "string", Pattern: "[a-z]+"}, "abc1", "doesn't match"},
+		{RawVariable{Type: "string", Allowed: []string{"a", "b"}}, "b", ""},
+		{RawVariable{Type: "string", Allowed: []string{"a", "b"}}, "c", "must be one of: a, b"},
+		{RawVariable{Type: "string", Max: &three}, "abcd", "length is more than the maximum of 3"},
+		{RawVariable{Type: "number", Min: &one, Max: &three}, "2", ""},
+		{RawVariable{Type: "number", Min: &one}, "0.5", "0.5 is less than the minimum of 1"},
+		{RawVariable{Type: "number", Allowed: []string{"1", "2"}}, "2.0", ""},
+		{RawVariable{Type: "list", Min: &one}, "[]", "number of elements is less"},
+		{RawVariable{Type: "list", Allowed: []string{"a"}}, `["a", "b"]`, "'b' must be one of"},
+		{RawVariable{Type: "map", Pattern: "[0-9]+"}, `{"a": "1", "b": "x"}`, "'x' doesn't match"},
+	}
+
+	for _, tc := range cases {
+		_, err := tc.Variable.Parse(tc.Value)
+		if tc.Err == "" {
+			if err != nil {
+				t.Fatalf("err: %s", err)
+			}
+
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), tc.Err) {
+			t.Fatalf("bad error for %s: %s", tc.Value, err)
+		}
+	}
+}
+
+func TestInterpolateTypedVariables(t *testing.T) {
+	raw := map[string]interface{}{
+		"tags":     "{{user `tags`}}",
+		"count":    "{{ user \"count\" }}",
+		"name":     "{{user `name`}}",
+		"other":    "web-{{user `count`}}",
+		"packages": []interface{}{"{{user `tags`}}"},
+	}
+
+	values := map[string]interface{}{
+		"tags":  map[string]interface{}{"Name": "web"},
+		"count": "2",
+	}
+
+	expected := map[string]interface{}{
+		"tags":     map[string]interface{}{"Name": "web"},
+		"count":    "2",
+		"name":     "{{user `name`}}",
+		"other":    "web-{{user `count`}}",
+		"packages": []interface{}{map[string]interface{}{"Name": "web"}},
+	}
+
+	actual := interpolateTypedVariables(raw, values)
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("bad: %#v", actual)
+	}
+
+	if raw["tags"] != "{{user `tags`}}" {
+		t.Fatal("should not modify the original")
+	}
+}
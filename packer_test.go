@@ -7,7 +7,7 @@ import (
 
 func TestExtractMachineReadable(t *testing.T) {
 	var args, expected, result []string
-	var mr bool
+	var mr string
 
 	// Not
 	args = []string{"foo", "bar", "baz"}
@@ -17,8 +17,8 @@ func TestExtractMachineReadable(t *testing.T) {
 		t.Fatalf("bad: %#v", result)
 	}
 
-	if mr {
-		t.Fatal("should not be mr")
+	if mr != "" {
+		t.Fatalf("should not be mr: %s", mr)
 	}
 
 	// Yes
@@ -29,7 +29,19 @@ func TestExtractMachineReadable(t *testing.T) {
 		t.Fatalf("bad: %#v", result)
 	}
 
-	if !mr {
-		t.Fatal("should be mr")
+	if mr != "csv" {
+		t.Fatalf("bad: %s", mr)
+	}
+
+	// JSON
+	args = []string{"foo", "-machine-readable=json", "baz"}
+	result, mr = extractMachineReadable(args)
+	expected = []string{"foo", "baz"}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("bad: %#v", result)
+	}
+
+	if mr != "json" {
+		t.Fatalf("bad: %s", mr)
 	}
 }
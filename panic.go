@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"github.com/mitchellh/packer/packer"
 	"github.com/mitchellh/panicwrap"
 	"io"
 	"os"
@@ -27,8 +28,13 @@ report the crash with Packer[1] so that we can fix this.
 // within Packer. It is guaranteed to run after the resulting process has
 // exited so we can take the log file, add in the panic, and store it
 // somewhere locally.
-func panicHandler(logF *os.File) panicwrap.HandlerFunc {
+func panicHandler(logF *os.File, filter *sensitiveFilter) panicwrap.HandlerFunc {
 	return func(m string) {
+		// Make sure the log file has everything up to the panic, then
+		// redact the panic itself.
+		filter.Flush()
+		m = packer.Redact(m)
+
 		// Write away just output this thing on stderr so that it gets
 		// shown in case anything below fails.
 		fmt.Fprintf(os.Stderr, fmt.Sprintf("%s\n", m))
@@ -25,11 +25,10 @@ func (p *PostProcessor) Configure(raws ...interface{}) error {
 		return err
 	}
 
-	p.config.tpl, err = packer.NewConfigTemplate()
+	p.config.tpl, err = p.config.NewConfigTemplate()
 	if err != nil {
 		return err
 	}
-	p.config.tpl.UserVars = p.config.PackerUserVars
 
 	// Accumulate any errors
 	errs := new(packer.MultiError)
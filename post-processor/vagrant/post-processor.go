@@ -161,11 +161,10 @@ func (p *PostProcessor) configureSingle(config *Config, raws ...interface{}) err
 		return err
 	}
 
-	config.tpl, err = packer.NewConfigTemplate()
+	config.tpl, err = config.NewConfigTemplate()
 	if err != nil {
 		return err
 	}
-	config.tpl.UserVars = config.PackerUserVars
 
 	// Defaults
 	if config.OutputPath == "" {
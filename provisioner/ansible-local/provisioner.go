@@ -38,13 +38,11 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		return err
 	}
 
-	p.config.tpl, err = packer.NewConfigTemplate()
+	p.config.tpl, err = p.config.NewConfigTemplate()
 	if err != nil {
 		return err
 	}
 
-	p.config.tpl.UserVars = p.config.PackerUserVars
-
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
 
@@ -238,5 +236,5 @@ func (p *Provisioner) uploadDir(ui packer.Ui, comm packer.Communicator, dst, src
 	if src[len(src)-1] != '/' {
 		src = src + "/"
 	}
-	return comm.UploadDir(dst, src, nil)
+	return common.UploadDir(comm, dst, src, nil)
 }
@@ -74,11 +74,10 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		return err
 	}
 
-	p.config.tpl, err = packer.NewConfigTemplate()
+	p.config.tpl, err = p.config.NewConfigTemplate()
 	if err != nil {
 		return err
 	}
-	p.config.tpl.UserVars = p.config.PackerUserVars
 
 	if p.config.ExecuteCommand == "" {
 		p.config.ExecuteCommand = "{{if .Sudo}}sudo {{end}}chef-solo --no-color -c {{.ConfigPath}} -j {{.JsonPath}}"
@@ -307,7 +306,7 @@ func (p *Provisioner) uploadDirectory(ui packer.Ui, comm packer.Communicator, ds
 		src = src + "/"
 	}
 
-	return comm.UploadDir(dst, src, nil)
+	return common.UploadDir(comm, dst, src, nil)
 }
 
 func (p *Provisioner) uploadFile(ui packer.Ui, comm packer.Communicator, dst string, src string) error {
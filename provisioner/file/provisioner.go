@@ -1,22 +1,46 @@
 package file
 
 import (
+	"bufio"
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/mitchellh/packer/common"
 	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
 	"os"
+	"path"
+	"path/filepath"
+	"strings"
 )
 
 type config struct {
 	common.PackerConfig `mapstructure:",squash"`
 
-	// The local path of the file to upload.
+	// The path of the file to transfer. For uploads this is a local
+	// path, for downloads it is a path on the remote machine.
 	Source string
 
-	// The remote path where the local file will be uploaded to.
+	// Multiple paths to transfer. These may contain glob patterns.
+	Sources []string
+
+	// The path where the file will be transferred to.
 	Destination string
 
+	// The direction of the transfer, either "upload" or "download".
+	Direction string
+
+	// Patterns of paths that won't be transferred.
+	Exclude []string
+
+	// The owner, and optionally the group, that uploaded files and
+	// directories are changed to.
+	Owner string
+
+	// The mode that uploaded files are changed to. Directories keep
+	// their mode.
+	Mode string
+
 	tpl *packer.ConfigTemplate
 }
 
@@ -30,18 +54,40 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		return err
 	}
 
-	p.config.tpl, err = packer.NewConfigTemplate()
+	p.config.tpl, err = p.config.NewConfigTemplate()
 	if err != nil {
 		return err
 	}
-	p.config.tpl.UserVars = p.config.PackerUserVars
 
 	// Accumulate any errors
 	errs := common.CheckUnusedConfig(md)
 
+	if p.config.Direction == "" {
+		p.config.Direction = "upload"
+	}
+
+	if p.config.Sources == nil {
+		p.config.Sources = make([]string, 0)
+	}
+
+	if p.config.Exclude == nil {
+		p.config.Exclude = make([]string, 0)
+	}
+
+	if p.config.Source != "" && len(p.config.Sources) > 0 {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Only one of source or sources can be specified."))
+	}
+
+	if p.config.Source != "" {
+		p.config.Sources = []string{p.config.Source}
+	}
+
 	templates := map[string]*string{
-		"source":      &p.config.Source,
 		"destination": &p.config.Destination,
+		"direction":   &p.config.Direction,
+		"owner":       &p.config.Owner,
+		"mode":        &p.config.Mode,
 	}
 
 	for n, ptr := range templates {
@@ -53,14 +99,61 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		}
 	}
 
-	if _, err := os.Stat(p.config.Source); err != nil {
+	sliceTemplates := map[string][]string{
+		"sources": p.config.Sources,
+		"exclude": p.config.Exclude,
+	}
+
+	for n, slice := range sliceTemplates {
+		for i, elem := range slice {
+			var err error
+			slice[i], err = p.config.tpl.Process(elem, nil)
+			if err != nil {
+				errs = packer.MultiErrorAppend(
+					errs, fmt.Errorf("Error processing %s[%d]: %s", n, i, err))
+			}
+		}
+	}
+
+	if p.config.Direction != "upload" && p.config.Direction != "download" {
+		errs = packer.MultiErrorAppend(errs,
+			fmt.Errorf("Direction must be 'upload' or 'download', got '%s'",
+				p.config.Direction))
+	}
+
+	if len(p.config.Sources) == 0 {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Either source or sources must be specified."))
+	}
+
+	if p.config.Direction == "upload" {
+		for _, source := range p.config.Sources {
+			if _, err := localMatches(source); err != nil {
+				errs = packer.MultiErrorAppend(errs,
+					fmt.Errorf("Bad source '%s': %s", source, err))
+			}
+		}
+	}
+
+	for _, pattern := range p.config.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("Bad exclude pattern '%s': %s", pattern, err))
+		}
+	}
+
+	if p.config.Direction == "download" && (p.config.Owner != "" || p.config.Mode != "") {
 		errs = packer.MultiErrorAppend(errs,
-			fmt.Errorf("Bad source '%s': %s", p.config.Source, err))
+			errors.New("Owner and mode can only be set for uploads."))
 	}
 
 	if p.config.Destination == "" {
 		errs = packer.MultiErrorAppend(errs,
 			errors.New("Destination must be specified."))
+	} else if p.multipleSources() && !strings.HasSuffix(p.config.Destination, "/") {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Destination must be a directory ending in '/' when "+
+				"multiple sources or glob patterns are used."))
 	}
 
 	if errs != nil && len(errs.Errors) > 0 {
@@ -71,33 +164,380 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 }
 
 func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
-	ui.Say(fmt.Sprintf("Uploading %s => %s", p.config.Source, p.config.Destination))
-	info, err := os.Stat(p.config.Source)
-	if err != nil {
+	if p.config.Direction == "download" {
+		return p.provisionDownload(ui, comm)
+	}
+
+	return p.provisionUpload(ui, comm)
+}
+
+func (p *Provisioner) Cancel() {
+	// Just hard quit. It isn't a big deal if what we're doing keeps
+	// running on the other side.
+	os.Exit(0)
+}
+
+func (p *Provisioner) provisionUpload(ui packer.Ui, comm packer.Communicator) error {
+	uploaded := make([]string, 0)
+	for _, source := range p.config.Sources {
+		matches, err := localMatches(source)
+		if err != nil {
+			return err
+		}
+
+		for _, path := range matches {
+			if p.excluded(path) {
+				continue
+			}
+
+			dst := p.destinationFor(path)
+			ui.Say(fmt.Sprintf("Uploading %s => %s", path, dst))
+			info, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+
+			// If we're uploading a directory, short circuit and do that
+			if info.IsDir() {
+				if err := comm.UploadDir(dst, path, p.config.Exclude); err != nil {
+					ui.Error(fmt.Sprintf("Upload failed: %s", err))
+					return err
+				}
+
+				paths, err := p.uploadedDirPaths(dst, path)
+				if err != nil {
+					return err
+				}
+
+				uploaded = append(uploaded, paths...)
+				continue
+			}
+
+			// We're uploading a file...
+			err = func() error {
+				f, err := os.Open(path)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				return comm.Upload(dst, f)
+			}()
+			if err != nil {
+				ui.Error(fmt.Sprintf("Upload failed: %s", err))
+				return err
+			}
+
+			uploaded = append(uploaded, dst)
+		}
+	}
+
+	if err := p.setAttributes(ui, comm, uploaded); err != nil {
+		ui.Error(fmt.Sprintf("Upload failed: %s", err))
 		return err
 	}
 
-	// If we're uploading a directory, short circuit and do that
-	if info.IsDir() {
-		return comm.UploadDir(p.config.Destination, p.config.Source, nil)
+	return nil
+}
+
+// uploadedDirPaths returns the remote paths that UploadDir created for a
+// local directory. If the directory ends in a slash, only its contents were
+// uploaded, so the destination itself isn't included.
+func (p *Provisioner) uploadedDirPaths(dst string, dir string) ([]string, error) {
+	if !strings.HasSuffix(dir, "/") {
+		return []string{path.Join(dst, filepath.Base(dir))}, nil
 	}
 
-	// We're uploading a file...
-	f, err := os.Open(p.config.Source)
+	entries, err := ioutil.ReadDir(dir)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if p.excluded(entry.Name()) {
+			continue
+		}
+
+		result = append(result, path.Join(dst, entry.Name()))
+	}
+
+	return result, nil
+}
+
+// setAttributes changes the owner and mode of the uploaded paths, if they
+// are configured.
+func (p *Provisioner) setAttributes(ui packer.Ui, comm packer.Communicator, paths []string) error {
+	if len(paths) == 0 {
+		return nil
+	}
+
+	quoted := make([]string, len(paths))
+	for i, path := range paths {
+		quoted[i] = common.ShellQuote(path)
+	}
+
+	commands := make([]string, 0, 2)
+	if p.config.Owner != "" {
+		commands = append(commands, fmt.Sprintf(
+			"chown -R %s %s",
+			common.ShellQuote(p.config.Owner), strings.Join(quoted, " ")))
+	}
+
+	if p.config.Mode != "" {
+		commands = append(commands, fmt.Sprintf(
+			"find %s -type f -exec chmod %s {} +",
+			strings.Join(quoted, " "), common.ShellQuote(p.config.Mode)))
+	}
+
+	for _, command := range commands {
+		var stderr bytes.Buffer
+		cmd := &packer.RemoteCmd{
+			Command: command,
+			Stderr:  &stderr,
+		}
+
+		ui.Message(fmt.Sprintf("Running: %s", command))
+		if err := comm.Start(cmd); err != nil {
+			return err
+		}
+
+		cmd.Wait()
+		if cmd.ExitStatus != 0 {
+			return fmt.Errorf(
+				"'%s' failed with exit status %d: %s",
+				command, cmd.ExitStatus, strings.TrimSpace(stderr.String()))
+		}
+	}
+
+	return nil
+}
+
+func (p *Provisioner) provisionDownload(ui packer.Ui, comm packer.Communicator) error {
+	if strings.HasSuffix(p.config.Destination, "/") {
+		if err := os.MkdirAll(p.config.Destination, 0755); err != nil {
+			return err
+		}
+	}
+
+	for _, source := range p.config.Sources {
+		entries, err := remoteMatches(comm, source)
+		if err != nil {
+			ui.Error(fmt.Sprintf("Download failed: %s", err))
+			return err
+		}
+
+		if len(entries) == 0 {
+			err := fmt.Errorf("No remote files match '%s'", source)
+			ui.Error(fmt.Sprintf("Download failed: %s", err))
+			return err
+		}
+
+		for _, entry := range entries {
+			if p.excluded(entry.Path) {
+				continue
+			}
+
+			if entry.IsDir {
+				ui.Say(fmt.Sprintf("Downloading %s => %s", entry.Path, p.config.Destination))
+				err := comm.DownloadDir(entry.Path, p.config.Destination, p.config.Exclude)
+				if err != nil {
+					ui.Error(fmt.Sprintf("Download failed: %s", err))
+					return err
+				}
+
+				continue
+			}
+
+			dst := p.destinationFor(entry.Path)
+			ui.Say(fmt.Sprintf("Downloading %s => %s", entry.Path, dst))
+			err := func() error {
+				f, err := os.Create(dst)
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+
+				return comm.Download(entry.Path, f)
+			}()
+			if err != nil {
+				ui.Error(fmt.Sprintf("Download failed: %s", err))
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// destinationFor returns the destination path for a single transferred
+// file. If the destination is a directory, the file keeps its name.
+func (p *Provisioner) destinationFor(path string) string {
+	if !strings.HasSuffix(p.config.Destination, "/") {
+		return p.config.Destination
+	}
+
+	return p.config.Destination + filepath.Base(path)
+}
+
+// excluded returns true if the path, or its base name, matches any of
+// the configured exclude patterns.
+func (p *Provisioner) excluded(path string) bool {
+	path = strings.TrimSuffix(path, "/")
+	for _, pattern := range p.config.Exclude {
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// multipleSources returns true if more than one path may be transferred,
+// in which case the destination has to be a directory.
+func (p *Provisioner) multipleSources() bool {
+	if len(p.config.Sources) > 1 {
+		return true
+	}
+
+	for _, source := range p.config.Sources {
+		if isGlob(source) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteEntry is a single path on the remote machine matched by a source.
+type remoteEntry struct {
+	Path  string
+	IsDir bool
+}
+
+// remoteMatches expands a source on the remote machine, using the
+// remote shell for glob patterns, and reports whether each match is a
+// directory.
+func remoteMatches(comm packer.Communicator, source string) ([]remoteEntry, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := &packer.RemoteCmd{
+		Command: fmt.Sprintf(
+			"for f in %s; do if [ -d \"$f\" ]; then echo \"d $f\"; "+
+				"elif [ -e \"$f\" ]; then echo \"f $f\"; fi; done",
+			shellGlob(source)),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	}
+
+	if err := comm.Start(cmd); err != nil {
+		return nil, err
+	}
+
+	cmd.Wait()
+	if cmd.ExitStatus != 0 {
+		return nil, fmt.Errorf(
+			"Listing '%s' failed with exit status %d: %s",
+			source, cmd.ExitStatus, strings.TrimSpace(stderr.String()))
+	}
+
+	result := make([]remoteEntry, 0)
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(line) < 3 {
+			continue
+		}
+
+		result = append(result, remoteEntry{
+			Path:  line[2:],
+			IsDir: line[0] == 'd',
+		})
+	}
+
+	return result, scanner.Err()
+}
+
+// localMatches expands a local source into the paths it refers to. Sources
+// without glob patterns are returned as-is so that a trailing slash is
+// preserved.
+func localMatches(source string) ([]string, error) {
+	if !isGlob(source) {
+		if _, err := os.Stat(source); err != nil {
+			return nil, err
+		}
+
+		return []string{source}, nil
 	}
-	defer f.Close()
 
-	err = comm.Upload(p.config.Destination, f)
+	matches, err := filepath.Glob(source)
 	if err != nil {
-		ui.Error(fmt.Sprintf("Upload failed: %s", err))
+		return nil, err
 	}
-	return err
+
+	if len(matches) == 0 {
+		return nil, errors.New("no files match the pattern")
+	}
+
+	return matches, nil
 }
 
-func (p *Provisioner) Cancel() {
-	// Just hard quit. It isn't a big deal if what we're doing keeps
-	// running on the other side.
-	os.Exit(0)
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// shellGlob quotes a glob pattern for sh so that only its glob characters,
+// and a leading "~/", are special to the shell.
+func shellGlob(pattern string) string {
+	var buf bytes.Buffer
+	if strings.HasPrefix(pattern, "~/") {
+		buf.WriteString("~/")
+		pattern = pattern[2:]
+	}
+
+	literal := ""
+	flush := func() {
+		if literal != "" {
+			buf.WriteString(common.ShellQuote(literal))
+			literal = ""
+		}
+	}
+
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*', '?':
+			flush()
+			buf.WriteByte(c)
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end == -1 {
+				literal += string(c)
+				continue
+			}
+
+			// Within a bracket expression, only a leading negation and
+			// the dash of a range are left unquoted.
+			flush()
+			buf.WriteByte('[')
+			class := pattern[i+1 : i+1+end]
+			for j := 0; j < len(class); j++ {
+				c := class[j]
+				if (j == 0 && (c == '!' || c == '^')) || (c == '-' && j > 0 && j < len(class)-1) {
+					buf.WriteByte(c)
+				} else {
+					buf.WriteString(common.ShellQuote(string(c)))
+				}
+			}
+			buf.WriteByte(']')
+			i += end + 1
+		default:
+			literal += string(c)
+		}
+	}
+
+	flush()
+	return buf.String()
 }
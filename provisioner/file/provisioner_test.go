@@ -4,6 +4,7 @@ import (
 	"github.com/mitchellh/packer/packer"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -85,6 +86,9 @@ func (su *stubUi) Ask(string) (string, error) {
 func (su *stubUi) Error(string) {
 }
 
+func (su *stubUi) Event(*packer.Event) {
+}
+
 func (su *stubUi) Machine(string, ...string) {
 }
 
@@ -139,3 +143,322 @@ func TestProvisionerProvision_SendsFile(t *testing.T) {
 		t.Fatalf("should upload with source file's data")
 	}
 }
+
+func TestProvisionerPrepare_Direction(t *testing.T) {
+	var p Provisioner
+	config := testConfig()
+	config["source"] = "/tmp/remote"
+	config["direction"] = "download"
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("should not require local source for downloads: %s", err)
+	}
+
+	p = Provisioner{}
+	config["direction"] = "sideways"
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerPrepare_SourceAndSources(t *testing.T) {
+	var p Provisioner
+	config := testConfig()
+	config["source"] = "/tmp/foo"
+	config["sources"] = []string{"/tmp/bar"}
+	config["direction"] = "download"
+
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerPrepare_MultipleSourcesDestination(t *testing.T) {
+	var p Provisioner
+	config := testConfig()
+	config["sources"] = []string{"/tmp/*.log"}
+	config["direction"] = "download"
+
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should require a directory destination")
+	}
+
+	p = Provisioner{}
+	config["destination"] = "logs/"
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+}
+
+func TestProvisionerPrepare_InvalidGlob(t *testing.T) {
+	var p Provisioner
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	config := testConfig()
+	config["sources"] = []string{filepath.Join(td, "*.txt")}
+	config["destination"] = "dir/"
+
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should require the glob to match")
+	}
+}
+
+func TestProvisionerProvision_SendsGlob(t *testing.T) {
+	var p Provisioner
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	for _, name := range []string{"a.txt", "b.log"} {
+		err := ioutil.WriteFile(filepath.Join(td, name), []byte(name), 0644)
+		if err != nil {
+			t.Fatalf("err: %s", err)
+		}
+	}
+
+	config := map[string]interface{}{
+		"sources":     []string{filepath.Join(td, "*")},
+		"exclude":     []string{"*.log"},
+		"destination": "/tmp/",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &stubUi{}
+	comm := &packer.MockCommunicator{}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("should successfully provision: %s", err)
+	}
+
+	if comm.UploadPath != "/tmp/a.txt" {
+		t.Fatalf("bad: %s", comm.UploadPath)
+	}
+
+	if comm.UploadData != "a.txt" {
+		t.Fatalf("bad: %s", comm.UploadData)
+	}
+
+	if strings.Contains(ui.sayMessages, "b.log") {
+		t.Fatalf("should exclude b.log: %s", ui.sayMessages)
+	}
+}
+
+func TestProvisionerProvision_DownloadsFile(t *testing.T) {
+	var p Provisioner
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	config := map[string]interface{}{
+		"source":      "/var/log/build.log",
+		"destination": filepath.Join(td, "build.log"),
+		"direction":   "download",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	ui := &stubUi{}
+	comm := &packer.MockCommunicator{
+		StartStdout:  "f /var/log/build.log\n",
+		DownloadData: "hello",
+	}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("should successfully provision: %s", err)
+	}
+
+	if comm.DownloadPath != "/var/log/build.log" {
+		t.Fatalf("bad: %s", comm.DownloadPath)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(td, "build.log"))
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if string(data) != "hello" {
+		t.Fatalf("bad: %s", data)
+	}
+}
+
+func TestProvisionerProvision_DownloadsDir(t *testing.T) {
+	var p Provisioner
+	config := map[string]interface{}{
+		"sources":     []string{"/var/log/*"},
+		"exclude":     []string{"*.gz"},
+		"destination": "logs/",
+		"direction":   "download",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+	p.config.Destination = td + "/"
+
+	ui := &stubUi{}
+	comm := &packer.MockCommunicator{
+		StartStdout: "d /var/log/apt\nf /var/log/old.gz\n",
+	}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("should successfully provision: %s", err)
+	}
+
+	if comm.DownloadDirSrc != "/var/log/apt" {
+		t.Fatalf("bad: %s", comm.DownloadDirSrc)
+	}
+
+	if comm.DownloadDirDst != td+"/" {
+		t.Fatalf("bad: %s", comm.DownloadDirDst)
+	}
+
+	if comm.DownloadCalled {
+		t.Fatal("excluded file should not be downloaded")
+	}
+}
+
+func TestProvisionerPrepare_OwnerAndMode(t *testing.T) {
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("error tempfile: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	var p Provisioner
+	config := testConfig()
+	config["source"] = tf.Name()
+	config["owner"] = "root:root"
+	config["mode"] = "0755"
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	config["direction"] = "download"
+	p = Provisioner{}
+	if err := p.Prepare(config); err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerProvision_OwnerAndMode(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	os.MkdirAll(filepath.Join(td, "hooks"), 0755)
+	ioutil.WriteFile(filepath.Join(td, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(td, "b.tmp"), []byte("b"), 0644)
+
+	cases := []struct {
+		Destination string
+		Owner       string
+		Mode        string
+		Expected    string
+	}{
+		{"/opt", "app", "", "chown -R 'app' '/opt/a.txt' '/opt/hooks'"},
+		{"/opt", "", "u+x", "find '/opt/a.txt' '/opt/hooks' -type f -exec chmod 'u+x' {} +"},
+		{"/opt/my app", "app", "", "chown -R 'app' '/opt/my app/a.txt' '/opt/my app/hooks'"},
+	}
+
+	for _, tc := range cases {
+		config := map[string]interface{}{
+			"source":      td + "/",
+			"destination": tc.Destination,
+			"exclude":     []string{"*.tmp"},
+			"owner":       tc.Owner,
+			"mode":        tc.Mode,
+		}
+
+		var p Provisioner
+		if err := p.Prepare(config); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		comm := &packer.MockCommunicator{}
+		if err := p.Provision(&stubUi{}, comm); err != nil {
+			t.Fatalf("err: %s", err)
+		}
+
+		if comm.StartCmd == nil || comm.StartCmd.Command != tc.Expected {
+			t.Fatalf("bad: %#v", comm.StartCmd)
+		}
+
+		comm = &packer.MockCommunicator{StartExitStatus: 1}
+		if err := p.Provision(&stubUi{}, comm); err == nil {
+			t.Fatal("should have error")
+		}
+	}
+}
+
+func TestProvisionerProvision_DownloadsQuotedGlob(t *testing.T) {
+	var p Provisioner
+	config := map[string]interface{}{
+		"source":      "/var/log/my logs/$x*.log",
+		"destination": "logs/",
+		"direction":   "download",
+	}
+
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+	p.config.Destination = td + "/"
+
+	ui := &stubUi{}
+	comm := &packer.MockCommunicator{
+		StartStdout: "f /var/log/my logs/$x1.log\n",
+	}
+	if err := p.Provision(ui, comm); err != nil {
+		t.Fatalf("should successfully provision: %s", err)
+	}
+
+	if !strings.HasPrefix(comm.StartCmd.Command, "for f in '/var/log/my logs/$x'*'.log'; ") {
+		t.Fatalf("bad: %s", comm.StartCmd.Command)
+	}
+
+	if comm.DownloadPath != "/var/log/my logs/$x1.log" {
+		t.Fatalf("bad: %s", comm.DownloadPath)
+	}
+}
+
+func TestShellGlob(t *testing.T) {
+	cases := map[string]string{
+		"/var/log/*":        "'/var/log/'*",
+		"/tmp/a b?.txt":     "'/tmp/a b'?'.txt'",
+		"~/logs/*.log":      "~/'logs/'*'.log'",
+		"/tmp/[!a-c]":       "'/tmp/'[!'a'-'c']",
+		"/tmp/[ ;]":         "'/tmp/'[' '';']",
+		"/tmp/[abc":         "'/tmp/[abc'",
+		"/tmp/`rm -rf /`/*": "'/tmp/`rm -rf /`/'*",
+		"/tmp/it's/*":       "'/tmp/it'\\''s/'*",
+	}
+
+	for input, expected := range cases {
+		if actual := shellGlob(input); actual != expected {
+			t.Fatalf("bad: %s => %s", input, actual)
+		}
+	}
+}
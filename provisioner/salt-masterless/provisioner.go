@@ -44,11 +44,10 @@ func (p *Provisioner) Prepare(raws ...interface{}) error {
 		return err
 	}
 
-	p.config.tpl, err = packer.NewConfigTemplate()
+	p.config.tpl, err = p.config.NewConfigTemplate()
 	if err != nil {
 		return err
 	}
-	p.config.tpl.UserVars = p.config.PackerUserVars
 
 	if p.config.TempConfigDir == "" {
 		p.config.TempConfigDir = DefaultTempConfigDir
@@ -144,7 +143,7 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 	}
 
 	ui.Message(fmt.Sprintf("Uploading local state tree: %s", p.config.LocalStateTree))
-	if err = comm.UploadDir(fmt.Sprintf("%s/states", p.config.TempConfigDir),
+	if err = common.UploadDir(comm, fmt.Sprintf("%s/states", p.config.TempConfigDir),
 		p.config.LocalStateTree, []string{".git"}); err != nil {
 		return fmt.Errorf("Error uploading local state tree to remote: %s", err)
 	}
@@ -161,7 +160,7 @@ func (p *Provisioner) Provision(ui packer.Ui, comm packer.Communicator) error {
 
 	if p.config.LocalPillarRoots != "" {
 		ui.Message(fmt.Sprintf("Uploading local pillar roots: %s", p.config.LocalPillarRoots))
-		if err = comm.UploadDir(fmt.Sprintf("%s/pillar", p.config.TempConfigDir),
+		if err = common.UploadDir(comm, fmt.Sprintf("%s/pillar", p.config.TempConfigDir),
 			p.config.LocalPillarRoots, []string{".git"}); err != nil {
 			return fmt.Errorf("Error uploading local pillar roots to remote: %s", err)
 		}
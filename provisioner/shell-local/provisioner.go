@@ -0,0 +1,268 @@
+// This package implements a provisioner for Packer that executes
+// shell scripts on the machine running Packer.
+package shelllocal
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/mitchellh/packer/common"
+	"github.com/mitchellh/packer/communicator/local"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+type config struct {
+	common.PackerConfig `mapstructure:",squash"`
+
+	// An inline script to execute. Multiple strings are all executed
+	// in the context of a single shell.
+	Inline []string
+
+	// The shebang value used when running inline scripts.
+	InlineShebang string `mapstructure:"inline_shebang"`
+
+	// The path of the shell script to execute.
+	Script string
+
+	// An array of multiple scripts to run.
+	Scripts []string
+
+	// An array of environment variables that will be injected before
+	// your command(s) are executed.
+	Vars []string `mapstructure:"environment_vars"`
+
+	// The command used to execute the script. The '{{ .Path }}' variable
+	// should be used to specify where the script goes. The environment
+	// variables are already set for the command, but {{ .Vars }} can be
+	// used to pass them on explicitly, for example through sudo.
+	ExecuteCommand string `mapstructure:"execute_command"`
+
+	tpl *packer.ConfigTemplate
+}
+
+type Provisioner struct {
+	config config
+}
+
+type ExecuteCommandTemplate struct {
+	Vars string
+	Path string
+}
+
+func (p *Provisioner) Prepare(raws ...interface{}) error {
+	md, err := common.DecodeConfig(&p.config, raws...)
+	if err != nil {
+		return err
+	}
+
+	p.config.tpl, err = p.config.NewConfigTemplate()
+	if err != nil {
+		return err
+	}
+
+	// Accumulate any errors
+	errs := common.CheckUnusedConfig(md)
+
+	if p.config.ExecuteCommand == "" {
+		p.config.ExecuteCommand = "chmod +x {{.Path}}; {{.Path}}"
+	}
+
+	if p.config.Inline != nil && len(p.config.Inline) == 0 {
+		p.config.Inline = nil
+	}
+
+	if p.config.InlineShebang == "" {
+		p.config.InlineShebang = "/bin/sh"
+	}
+
+	if p.config.Scripts == nil {
+		p.config.Scripts = make([]string, 0)
+	}
+
+	if p.config.Vars == nil {
+		p.config.Vars = make([]string, 0)
+	}
+
+	if p.config.Script != "" && len(p.config.Scripts) > 0 {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Only one of script or scripts can be specified."))
+	}
+
+	if p.config.Script != "" {
+		p.config.Scripts = []string{p.config.Script}
+	}
+
+	templates := map[string]*string{
+		"inline_shebang": &p.config.InlineShebang,
+		"script":         &p.config.Script,
+	}
+
+	for n, ptr := range templates {
+		var err error
+		*ptr, err = p.config.tpl.Process(*ptr, nil)
+		if err != nil {
+			errs = packer.MultiErrorAppend(
+				errs, fmt.Errorf("Error processing %s: %s", n, err))
+		}
+	}
+
+	sliceTemplates := map[string][]string{
+		"inline":           p.config.Inline,
+		"scripts":          p.config.Scripts,
+		"environment_vars": p.config.Vars,
+	}
+
+	for n, slice := range sliceTemplates {
+		for i, elem := range slice {
+			var err error
+			slice[i], err = p.config.tpl.Process(elem, nil)
+			if err != nil {
+				errs = packer.MultiErrorAppend(
+					errs, fmt.Errorf("Error processing %s[%d]: %s", n, i, err))
+			}
+		}
+	}
+
+	if len(p.config.Scripts) == 0 && p.config.Inline == nil {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Either a script file or inline script must be specified."))
+	} else if len(p.config.Scripts) > 0 && p.config.Inline != nil {
+		errs = packer.MultiErrorAppend(errs,
+			errors.New("Only a script file or an inline script can be specified, not both."))
+	}
+
+	for _, path := range p.config.Scripts {
+		if _, err := os.Stat(path); err != nil {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("Bad script '%s': %s", path, err))
+		}
+	}
+
+	// Do a check for bad environment variables, such as '=foo', 'foobar'
+	for _, kv := range p.config.Vars {
+		vs := strings.SplitN(kv, "=", 2)
+		if len(vs) != 2 || vs[0] == "" {
+			errs = packer.MultiErrorAppend(errs,
+				fmt.Errorf("Environment variable not in format 'key=value': %s", kv))
+		}
+	}
+
+	if errs != nil && len(errs.Errors) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func (p *Provisioner) Provision(ui packer.Ui, _ packer.Communicator) error {
+	scripts := make([]string, len(p.config.Scripts))
+	copy(scripts, p.config.Scripts)
+
+	// If we have an inline script, then turn that into a temporary
+	// shell script and use that.
+	if p.config.Inline != nil {
+		tf, err := ioutil.TempFile("", "packer-shell")
+		if err != nil {
+			return fmt.Errorf("Error preparing shell script: %s", err)
+		}
+		defer os.Remove(tf.Name())
+
+		// Set the path to the temporary file
+		scripts = append(scripts, tf.Name())
+
+		// Write our contents to it
+		writer := bufio.NewWriter(tf)
+		writer.WriteString(fmt.Sprintf("#!%s\n", p.config.InlineShebang))
+		for _, command := range p.config.Inline {
+			if _, err := writer.WriteString(command + "\n"); err != nil {
+				return fmt.Errorf("Error preparing shell script: %s", err)
+			}
+		}
+
+		if err := writer.Flush(); err != nil {
+			return fmt.Errorf("Error preparing shell script: %s", err)
+		}
+
+		tf.Close()
+	}
+
+	// Build our variables up by adding in the build name and builder type
+	envVars := make([]string, len(p.config.Vars)+2)
+	envVars[0] = "PACKER_BUILD_NAME=" + p.config.PackerBuildName
+	envVars[1] = "PACKER_BUILDER_TYPE=" + p.config.PackerBuilderType
+	copy(envVars[2:], p.config.Vars)
+
+	// The commands run on this machine, not the one being built, so the
+	// communicator given to us isn't used.
+	comm := &local.Communicator{Env: envVars}
+
+	for _, path := range scripts {
+		ui.Say(fmt.Sprintf("Provisioning with local shell script: %s", path))
+		if err := p.runScript(ui, comm, path, envVars); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (p *Provisioner) Cancel() {
+	// Just hard quit. It isn't a big deal if what we're doing keeps
+	// running.
+	os.Exit(0)
+}
+
+// runScript copies the script to a temporary path, so that making it
+// executable doesn't modify the original, and executes it.
+func (p *Provisioner) runScript(ui packer.Ui, comm packer.Communicator, path string, envVars []string) error {
+	log.Printf("Opening %s for reading", path)
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error opening shell script: %s", err)
+	}
+	defer f.Close()
+
+	tf, err := ioutil.TempFile("", "packer-shell-local")
+	if err != nil {
+		return fmt.Errorf("Error preparing shell script: %s", err)
+	}
+	tf.Close()
+	defer os.Remove(tf.Name())
+
+	if err := comm.Upload(tf.Name(), f); err != nil {
+		return fmt.Errorf("Error copying script: %s", err)
+	}
+
+	// The variables are set through the communicator, but are also
+	// available to the command, quoted so that their values are single
+	// words for the shell.
+	vars := make([]string, len(envVars))
+	for i, kv := range envVars {
+		parts := strings.SplitN(kv, "=", 2)
+		vars[i] = parts[0] + "=" + common.ShellQuote(parts[1])
+	}
+
+	// Compile the command
+	command, err := p.config.tpl.Process(p.config.ExecuteCommand, &ExecuteCommandTemplate{
+		Vars: strings.Join(vars, " "),
+		Path: tf.Name(),
+	})
+	if err != nil {
+		return fmt.Errorf("Error processing command: %s", err)
+	}
+
+	cmd := &packer.RemoteCmd{Command: command}
+	if err := cmd.StartWithUi(comm, ui); err != nil {
+		return fmt.Errorf("Error executing script: %s", err)
+	}
+
+	if cmd.ExitStatus != 0 {
+		return fmt.Errorf("Script exited with non-zero exit status: %d", cmd.ExitStatus)
+	}
+
+	return nil
+}
@@ -0,0 +1,237 @@
+package shelllocal
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"inline": []interface{}{"foo", "bar"},
+	}
+}
+
+func testUi() *packer.BasicUi {
+	return &packer.BasicUi{
+		Reader: new(bytes.Buffer),
+		Writer: new(bytes.Buffer),
+	}
+}
+
+func TestProvisioner_Impl(t *testing.T) {
+	var raw interface{}
+	raw = &Provisioner{}
+	if _, ok := raw.(packer.Provisioner); !ok {
+		t.Fatalf("must be a Provisioner")
+	}
+}
+
+func TestProvisionerPrepare_Defaults(t *testing.T) {
+	var p Provisioner
+	config := testConfig()
+
+	err := p.Prepare(config)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if p.config.InlineShebang != "/bin/sh" {
+		t.Errorf("bad inline shebang: %s", p.config.InlineShebang)
+	}
+
+	if p.config.ExecuteCommand != "chmod +x {{.Path}}; {{.Path}}" {
+		t.Errorf("bad execute command: %s", p.config.ExecuteCommand)
+	}
+}
+
+func TestProvisionerPrepare_InvalidKey(t *testing.T) {
+	var p Provisioner
+	config := testConfig()
+
+	// Add a random key
+	config["i_should_not_be_valid"] = true
+	err := p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerPrepare_Script(t *testing.T) {
+	config := testConfig()
+	delete(config, "inline")
+
+	config["script"] = "/this/should/not/exist"
+	p := new(Provisioner)
+	err := p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test with a good one
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("error tempfile: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	config["script"] = tf.Name()
+	p = new(Provisioner)
+	err = p.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+}
+
+func TestProvisionerPrepare_ScriptAndInline(t *testing.T) {
+	var p Provisioner
+	config := testConfig()
+
+	delete(config, "inline")
+	delete(config, "script")
+	err := p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test with both
+	tf, err := ioutil.TempFile("", "packer")
+	if err != nil {
+		t.Fatalf("error tempfile: %s", err)
+	}
+	defer os.Remove(tf.Name())
+
+	config["inline"] = []interface{}{"foo"}
+	config["script"] = tf.Name()
+	err = p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+}
+
+func TestProvisionerPrepare_EnvironmentVars(t *testing.T) {
+	config := testConfig()
+
+	// Test with a bad case
+	config["environment_vars"] = []string{"badvar", "good=var"}
+	p := new(Provisioner)
+	err := p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test with a trickier case
+	config["environment_vars"] = []string{"=bad"}
+	p = new(Provisioner)
+	err = p.Prepare(config)
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	// Test with a good case
+	config["environment_vars"] = []string{"FOO=bar", "baz=", "URL=a=b"}
+	p = new(Provisioner)
+	err = p.Prepare(config)
+	if err != nil {
+		t.Fatalf("should not have error: %s", err)
+	}
+}
+
+func TestProvisionerProvision_Inline(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	output := filepath.Join(td, "output")
+	config := map[string]interface{}{
+		"inline": []interface{}{
+			"echo $PACKER_BUILD_NAME $PACKER_BUILDER_TYPE $FOO > " + output,
+		},
+		"environment_vars":    []string{"FOO=bar"},
+		"packer_build_name":   "vbox",
+		"packer_builder_type": "virtualbox-iso",
+	}
+
+	p := new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	// The remote communicator should never be used
+	comm := new(packer.MockCommunicator)
+	if err := p.Provision(testUi(), comm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if comm.StartCalled || comm.UploadCalled {
+		t.Fatal("remote communicator should not be used")
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if strings.TrimSpace(string(data)) != "vbox virtualbox-iso bar" {
+		t.Fatalf("bad: %s", data)
+	}
+}
+
+func TestProvisionerProvision_Vars(t *testing.T) {
+	td, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	defer os.RemoveAll(td)
+
+	output := filepath.Join(td, "output")
+	config := map[string]interface{}{
+		"inline":           []interface{}{"echo \"$FOO\" > " + output},
+		"environment_vars": []string{"FOO=it's $HOME; exit 1"},
+		"execute_command":  "chmod +x {{.Path}}; env -i {{.Vars}} {{.Path}}",
+	}
+
+	p := new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if err := p.Provision(testUi(), new(packer.MockCommunicator)); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if strings.TrimSpace(string(data)) != "it's $HOME; exit 1" {
+		t.Fatalf("bad: %s", data)
+	}
+}
+
+func TestProvisionerProvision_Failure(t *testing.T) {
+	config := map[string]interface{}{
+		"inline": []interface{}{"exit 2"},
+	}
+
+	p := new(Provisioner)
+	if err := p.Prepare(config); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	err := p.Provision(testUi(), new(packer.MockCommunicator))
+	if err == nil {
+		t.Fatal("should have error")
+	}
+
+	if !strings.Contains(err.Error(), "exit status: 2") {
+		t.Fatalf("bad: %s", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"github.com/mitchellh/packer/packer"
+	"io"
+	"sync"
+)
+
+// sensitiveFilter is the writer that the output of the wrapped process
+// goes through. It collects the sensitive values that are announced and
+// redacts them from the lines that are written, so that they don't end
+// up in the logs or crash logs.
+type sensitiveFilter struct {
+	Writer io.Writer
+
+	buf bytes.Buffer
+	l   sync.Mutex
+}
+
+func (f *sensitiveFilter) Write(p []byte) (int, error) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.buf.Write(p)
+	for {
+		idx := bytes.IndexByte(f.buf.Bytes(), '\n')
+		if idx == -1 {
+			break
+		}
+
+		line := string(f.buf.Next(idx + 1))
+		if v, ok := packer.ParseSensitiveValueLine(line); ok {
+			packer.AddSensitiveValue(v)
+			continue
+		}
+
+		if _, err := io.WriteString(f.Writer, packer.Redact(line)); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Flush writes out the last line if it wasn't ended by a newline.
+func (f *sensitiveFilter) Flush() error {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	if f.buf.Len() == 0 {
+		return nil
+	}
+
+	_, err := io.WriteString(f.Writer, packer.Redact(f.buf.String()))
+	f.buf.Reset()
+	return err
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSensitiveFilter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	f := &sensitiveFilter{Writer: buf}
+
+	// "filter-secret" in base64, split across writes
+	f.Write([]byte("before filter-secret\n[packer-sensitive-value] ZmlsdGVy"))
+	f.Write([]byte("LXNlY3JldA==\nafter filter-"))
+	f.Write([]byte("secret\nlast filter-secret"))
+
+	expected := "before filter-secret\nafter <sensitive>\n"
+	if buf.String() != expected {
+		t.Fatalf("bad: %#v", buf.String())
+	}
+
+	if err := f.Flush(); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	expected += "last <sensitive>"
+	if buf.String() != expected {
+		t.Fatalf("bad: %#v", buf.String())
+	}
+}